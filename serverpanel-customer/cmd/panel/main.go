@@ -3,10 +3,18 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/asergenalkan/serverpanel/internal/api"
 	"github.com/asergenalkan/serverpanel/internal/config"
 	"github.com/asergenalkan/serverpanel/internal/database"
+	"github.com/asergenalkan/serverpanel/internal/services/backup"
+	"github.com/asergenalkan/serverpanel/internal/services/bandwidth"
+	"github.com/asergenalkan/serverpanel/internal/services/dns"
+	"github.com/asergenalkan/serverpanel/internal/services/quota"
+	"github.com/asergenalkan/serverpanel/internal/services/scheduler"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -25,6 +33,28 @@ func main() {
 	}
 	defer db.Close()
 
+	// Admin overrides of the built-in vhost templates
+	webserver.SetTemplateDir(filepath.Join(cfg.DataDir, "templates", "vhost"))
+
+	// Apply backup retention rules and free unused chunks every 6 hours
+	backup.NewService(db).StartPruneWorker(6 * time.Hour)
+
+	// Run scheduled jobs (backup policies, ...) when they are due
+	scheduler.NewService(db).Start(30 * time.Second)
+
+	// Sample disk usage of every account for quota accounting
+	quota.NewService(db).StartSampler(quota.SampleInterval)
+
+	// Count web traffic from the access logs and act on bandwidth overages
+	bandwidth.NewService(db).StartWorker(15 * time.Minute)
+
+	// Roll over DNSSEC zone signing keys and re-sign zones before their
+	// signatures expire
+	dns.NewService(db).StartKeyWorker(12 * time.Hour)
+
+	// Push queued and failed zone changes to the DNS cluster peers
+	dns.NewService(db).StartClusterWorker(time.Minute)
+
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
 		AppName:               "ServerPanel v1.0.0",
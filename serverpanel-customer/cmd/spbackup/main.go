@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+	"github.com/asergenalkan/serverpanel/internal/database"
+	"github.com/asergenalkan/serverpanel/internal/services/backup"
+)
+
+/*
+spbackup - ServerPanel backup tool
+
+	spbackup info FILE
+	spbackup verify [-passphrase-file F] FILE
+	spbackup decrypt [-passphrase-file F] FILE OUT
+	spbackup rotate-key
+
+verify and decrypt use the server master keys (~/.serverpanel/keys)
+unless a passphrase is given, so customers can open downloaded backups
+on their own machine. The passphrase is read from -passphrase-file or
+the SPBACKUP_PASSPHRASE environment variable, never from the command line.
+*/
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  spbackup info FILE
+  spbackup verify [-passphrase-file F] [-keys DIR] FILE
+  spbackup decrypt [-passphrase-file F] [-keys DIR] FILE OUT
+  spbackup rotate-key`)
+	os.Exit(2)
+}
+
+func fatal(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "spbackup: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "info":
+		runInfo(args)
+	case "verify":
+		runVerify(args)
+	case "decrypt":
+		runDecrypt(args)
+	case "rotate-key":
+		runRotateKey(args)
+	default:
+		usage()
+	}
+}
+
+// unlockFlags registers the key source flags of a subcommand
+func unlockFlags(fs *flag.FlagSet) func() backup.Unlocker {
+	passFile := fs.String("passphrase-file", "", "file holding the backup passphrase")
+	keyDir := fs.String("keys", "", "master key directory (default ~/.serverpanel/keys)")
+
+	return func() backup.Unlocker {
+		passphrase := os.Getenv("SPBACKUP_PASSPHRASE")
+		if *passFile != "" {
+			data, err := os.ReadFile(*passFile)
+			if err != nil {
+				fatal("%v", err)
+			}
+			passphrase = strings.TrimRight(string(data), "\r\n")
+		}
+		if passphrase != "" {
+			return backup.PassphraseUnlocker(passphrase)
+		}
+
+		dir := *keyDir
+		if dir == "" {
+			dir = filepath.Join(config.Load().DataDir, "keys")
+		}
+		return backup.MasterKeyUnlocker(dir)
+	}
+}
+
+func runInfo(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	h, err := backup.ReadEncryptionHeader(args[0])
+	if err == backup.ErrNotEncrypted {
+		fmt.Println("encrypted: no")
+		return
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	fmt.Println("encrypted: yes")
+	fmt.Printf("algorithm: %s (chunk size %d)\n", h.Algorithm, h.ChunkSize)
+	for _, wk := range h.Keys {
+		switch wk.Type {
+		case backup.KeyTypeMaster:
+			fmt.Printf("key:       master %s\n", wk.KeyID)
+		case backup.KeyTypePassphrase:
+			if wk.KDF == nil {
+				fmt.Println("key:       passphrase")
+				continue
+			}
+			fmt.Printf("key:       passphrase (%s, t=%d, m=%d KiB)\n", wk.KDF.Algorithm, wk.KDF.Time, wk.KDF.Memory)
+		}
+	}
+}
+
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	unlocker := unlockFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	result, err := backup.VerifyArchive(fs.Arg(0), unlocker())
+	if err != nil {
+		fatal("%v", err)
+	}
+
+	if result.Encrypted {
+		fmt.Printf("decrypted:     %v\n", result.Decrypted)
+	}
+	fmt.Printf("manifest:      %v\n", result.ManifestOK)
+	fmt.Printf("files checked: %d\n", result.FilesChecked)
+	for _, m := range result.Mismatches {
+		fmt.Printf("  %s\n", m)
+	}
+	if !result.Valid {
+		fatal("verification failed: %s", result.Error)
+	}
+	fmt.Println("OK")
+}
+
+func runDecrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	unlocker := unlockFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+
+	if err := backup.DecryptArchive(fs.Arg(0), fs.Arg(1), unlocker()); err != nil {
+		fatal("%v", err)
+	}
+	fmt.Printf("decrypted to %s\n", fs.Arg(1))
+}
+
+func runRotateKey(args []string) {
+	if len(args) != 0 {
+		usage()
+	}
+
+	cfg := config.Load()
+	db, err := database.Initialize(cfg.DatabasePath)
+	if err != nil {
+		fatal("database: %v", err)
+	}
+	defer db.Close()
+
+	result, err := backup.NewService(db).RotateMasterKey(func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+	})
+	if err != nil {
+		fatal("%v", err)
+	}
+	if result.Failed > 0 {
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "  %s\n", e)
+		}
+		db.Close()
+		fatal("%d item(s) could not be re-wrapped; they remain readable with the old key", result.Failed)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+	"github.com/asergenalkan/serverpanel/internal/database"
+	"github.com/asergenalkan/serverpanel/internal/dnsserver"
+)
+
+/*
+spdns - ServerPanel authoritative DNS server
+
+	spdns [-listen ADDR] [-refresh DURATION] [-notify IP[:PORT],...]
+
+spdns answers for the active domains of the panel straight from the
+dns_records table over UDP and TCP, in place of BIND, along with the
+zones served for DNS cluster peers. The database is read every -refresh;
+when the records of a zone change its SOA serial is bumped and the
+secondaries are sent a NOTIFY. Secondaries come from the
+dns_secondaries server setting and -notify, and may transfer zones with
+AXFR (or IXFR, answered with the whole zone). Localhost may always
+transfer.
+
+Run the panel with DNS_SERVER=native so it stops reloading BIND.
+
+Trying it on localhost:
+
+	spdns -listen 127.0.0.1:5353
+	dig @127.0.0.1 -p 5353 example.com SOA
+	dig @127.0.0.1 -p 5353 example.com AXFR
+*/
+
+func main() {
+	listen := flag.String("listen", ":53", "UDP and TCP address to answer on")
+	refresh := flag.Duration("refresh", 5*time.Second, "how often to read the zones from the database")
+	notify := flag.String("notify", "", "comma separated secondaries in addition to the dns_secondaries setting")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: spdns [-listen ADDR] [-refresh DURATION] [-notify IP[:PORT],...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var secondaries []string
+	for _, sec := range strings.Split(*notify, ",") {
+		if sec = strings.TrimSpace(sec); sec == "" {
+			continue
+		}
+		if !dnsserver.ValidSecondary(sec) {
+			log.Fatalf("spdns: invalid secondary %q, expected IP or IP:port", sec)
+		}
+		secondaries = append(secondaries, sec)
+	}
+
+	cfg := config.Load()
+	db, err := database.Initialize(cfg.DatabasePath)
+	if err != nil {
+		log.Fatalf("spdns: %v", err)
+	}
+	defer db.Close()
+
+	server := dnsserver.NewServer(db, dnsserver.Config{
+		Listen:      *listen,
+		Refresh:     *refresh,
+		Secondaries: secondaries,
+		ServerIP:    cfg.ServerIP,
+	})
+	if err := server.Start(); err != nil {
+		log.Fatalf("spdns: %v", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	log.Println("🛑 DNS server stopping")
+	server.Close()
+}
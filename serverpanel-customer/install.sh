@@ -36,6 +36,9 @@ RELEASE_URL="https://github.com/${GITHUB_REPO}/releases/download/v${VERSION}"
 # PHP Sürümü (OS'a göre belirlenir)
 PHP_VERSION=""
 
+# DNS sunucusu: "bind" veya "native" (spdns, bölgeleri veritabanından yanıtlar)
+DNS_SERVER="${DNS_SERVER:-bind}"
+
 # Sayaçlar
 STEP_CURRENT=0
 STEP_TOTAL=15
@@ -1916,6 +1919,7 @@ Environment="MYSQL_ROOT_PASSWORD=${MYSQL_ROOT_PASSWORD}"
 Environment="SERVER_IP=${SERVER_IP}"
 Environment="PHP_VERSION=${PHP_VERSION}"
 Environment="WEB_SERVER=apache"
+Environment="DNS_SERVER=${DNS_SERVER}"
 
 [Install]
 WantedBy=multi-user.target
@@ -1991,6 +1995,28 @@ configure_mail_queue() {
         fi
     fi
     
+    # Backup aracı derle (verify / decrypt / rotate-key)
+    if [[ -d "${INSTALL_DIR}/cmd/spbackup" ]]; then
+        CGO_ENABLED=1 /usr/local/go/bin/go build -o "${INSTALL_DIR}/bin/spbackup" ./cmd/spbackup 2>/dev/null
+        if [[ -f "${INSTALL_DIR}/bin/spbackup" ]]; then
+            chmod +x "${INSTALL_DIR}/bin/spbackup"
+            log_done "spbackup derlendi"
+        else
+            log_warn "spbackup derlenemedi"
+        fi
+    fi
+    
+    # DNS sunucusu derle (DNS_SERVER=native)
+    if [[ -d "${INSTALL_DIR}/cmd/spdns" ]]; then
+        CGO_ENABLED=1 /usr/local/go/bin/go build -o "${INSTALL_DIR}/bin/spdns" ./cmd/spdns 2>/dev/null
+        if [[ -f "${INSTALL_DIR}/bin/spdns" ]]; then
+            chmod +x "${INSTALL_DIR}/bin/spdns"
+            log_done "spdns derlendi"
+        else
+            log_warn "spdns derlenemedi"
+        fi
+    fi
+    
     # Queue processor systemd service
     log_progress "Queue processor servisi oluşturuluyor"
     cat > /etc/systemd/system/serverpanel-queue.service << 'QUEUEEOF'
@@ -2010,6 +2036,31 @@ WorkingDirectory=/opt/serverpanel
 WantedBy=multi-user.target
 QUEUEEOF
     
+    # spdns systemd service (53. portu BIND ile paylaşamaz, yalnızca native modda açılır)
+    if [[ -f "${INSTALL_DIR}/bin/spdns" ]]; then
+        log_progress "DNS sunucusu servisi oluşturuluyor"
+        local SERVER_IP=$(curl -s ifconfig.me 2>/dev/null || hostname -I | awk '{print $1}')
+        cat > /etc/systemd/system/serverpanel-dns.service << DNSEOF
+[Unit]
+Description=ServerPanel Native DNS Server
+After=network.target serverpanel.service
+Conflicts=bind9.service named.service
+
+[Service]
+Type=simple
+ExecStart=${INSTALL_DIR}/bin/spdns
+Restart=always
+RestartSec=10
+User=root
+WorkingDirectory=${INSTALL_DIR}
+Environment="ENVIRONMENT=production"
+Environment="SERVER_IP=${SERVER_IP}"
+
+[Install]
+WantedBy=multi-user.target
+DNSEOF
+    fi
+    
     # Postfix policy service yapılandırması
     log_progress "Postfix policy daemon yapılandırılıyor"
     
@@ -2048,6 +2099,18 @@ POLICYEOF
         fi
     fi
     
+    if [[ "$DNS_SERVER" == "native" ]] && [[ -f "${INSTALL_DIR}/bin/spdns" ]]; then
+        systemctl disable --now bind9 > /dev/null 2>&1 || true
+        systemctl enable serverpanel-dns > /dev/null 2>&1
+        systemctl start serverpanel-dns > /dev/null 2>&1
+        
+        if systemctl is-active --quiet serverpanel-dns; then
+            log_info "DNS sunucusu (spdns): aktif ✓"
+        else
+            log_warn "DNS sunucusu (spdns) başlatılamadı"
+        fi
+    fi
+    
     # Postfix'i yeniden başlat
     systemctl restart postfix > /dev/null 2>&1
     log_done "Mail queue daemon yapılandırıldı"
@@ -2056,7 +2119,9 @@ POLICYEOF
 health_check() {
     log_step "Sistem Sağlık Kontrolü"
     
-    local services=("mysql" "apache2" "php${PHP_VERSION}-fpm" "bind9" "pure-ftpd" "postfix" "dovecot" "opendkim" "spamassassin" "serverpanel" "serverpanel-queue")
+    local dns_service="bind9"
+    [[ "$DNS_SERVER" == "native" ]] && dns_service="serverpanel-dns"
+    local services=("mysql" "apache2" "php${PHP_VERSION}-fpm" "$dns_service" "pure-ftpd" "postfix" "dovecot" "opendkim" "spamassassin" "serverpanel" "serverpanel-queue")
     for svc in "${services[@]}"; do
         if systemctl is-active --quiet "$svc"; then
             log_info "$svc: aktif ✓"
@@ -1,18 +1,32 @@
 package api
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/asergenalkan/serverpanel/internal/models"
 	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
 	"github.com/gofiber/fiber/v2"
 )
 
-// ListAccounts returns all hosting accounts (Admin only)
+// ListAccounts returns all hosting accounts (admin) or the accounts
+// below a reseller
 func (h *Handler) ListAccounts(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	resellerID := int64(c.QueryInt("reseller_id", 0))
+	if role == models.RoleReseller {
+		resellerID = userID
+	}
+
 	svc := account.NewService(h.db)
 
-	accounts, err := svc.ListAccounts()
+	accounts, err := svc.ListAccounts(resellerID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
 			Success: false,
@@ -26,8 +40,12 @@ func (h *Handler) ListAccounts(c *fiber.Ctx) error {
 	})
 }
 
-// CreateAccount creates a new hosting account (Admin only)
+// CreateAccount creates a new hosting account. Resellers create accounts
+// below themselves with their own packages.
 func (h *Handler) CreateAccount(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
 	var req account.CreateAccountRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
@@ -46,14 +64,30 @@ func (h *Handler) CreateAccount(c *fiber.Ctx) error {
 
 	svc := account.NewService(h.db)
 
+	if role == models.RoleReseller {
+		req.ParentID = userID
+		if !svc.CanUsePackage(userID, req.PackageID) {
+			return c.Status(fiber.StatusForbidden).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Package not available",
+			})
+		}
+	}
+
 	acc, err := svc.CreateAccount(req)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+		status := fiber.StatusBadRequest
+		if errors.Is(err, webserver.ErrConfigTest) {
+			status = fiber.StatusUnprocessableEntity
+		}
+		return c.Status(status).JSON(models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 	}
 
+	h.logActivity(userID, "account_create", fmt.Sprintf("Created account %s (%s)", acc.Username, acc.Domain), c.IP())
+
 	return c.Status(fiber.StatusCreated).JSON(models.APIResponse{
 		Success: true,
 		Data:    acc,
@@ -69,6 +103,12 @@ func (h *Handler) GetAccount(c *fiber.Ctx) error {
 			Error:   "Invalid account ID",
 		})
 	}
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Account not found",
+		})
+	}
 
 	var acc struct {
 		ID          int64  `json:"id"`
@@ -79,19 +119,24 @@ func (h *Handler) GetAccount(c *fiber.Ctx) error {
 		CreatedAt   string `json:"created_at"`
 		Domain      string `json:"domain"`
 		PackageName string `json:"package_name"`
+		// Set while the account is suspended
+		SuspendReason string `json:"suspend_reason,omitempty"`
+		SuspendedAt   string `json:"suspended_at,omitempty"`
 	}
 
 	err = h.db.QueryRow(`
 		SELECT u.id, u.username, u.email, u.role, u.active, u.created_at,
 			   COALESCE(d.name, '') as domain,
-			   COALESCE(p.name, 'No Package') as package_name
+			   COALESCE(p.name, 'No Package') as package_name,
+			   COALESCE(s.reason, ''), COALESCE(s.suspended_at, '')
 		FROM users u
+		LEFT JOIN account_suspensions s ON s.user_id = u.id
 		LEFT JOIN domains d ON d.user_id = u.id
 		LEFT JOIN user_packages up ON up.user_id = u.id
 		LEFT JOIN packages p ON p.id = up.package_id
 		WHERE u.id = ?
 	`, id).Scan(&acc.ID, &acc.Username, &acc.Email, &acc.Role, &acc.Active,
-		&acc.CreatedAt, &acc.Domain, &acc.PackageName)
+		&acc.CreatedAt, &acc.Domain, &acc.PackageName, &acc.SuspendReason, &acc.SuspendedAt)
 
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
@@ -115,6 +160,12 @@ func (h *Handler) DeleteAccount(c *fiber.Ctx) error {
 			Error:   "Invalid account ID",
 		})
 	}
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Account not found",
+		})
+	}
 
 	svc := account.NewService(h.db)
 
@@ -131,7 +182,8 @@ func (h *Handler) DeleteAccount(c *fiber.Ctx) error {
 	})
 }
 
-// SuspendAccount suspends an account
+// SuspendAccount suspends an account: its sites show the suspended page
+// and mail, FTP, cron and shell access stop until it is unsuspended
 func (h *Handler) SuspendAccount(c *fiber.Ctx) error {
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
@@ -140,23 +192,55 @@ func (h *Handler) SuspendAccount(c *fiber.Ctx) error {
 			Error:   "Invalid account ID",
 		})
 	}
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Account not found",
+		})
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Invalid request body",
+			})
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		req.Reason = "Suspended by " + c.Locals("username").(string)
+	}
 
 	svc := account.NewService(h.db)
 
-	if err := svc.SuspendAccount(id); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+	if err := svc.SuspendAccount(id, req.Reason, c.Locals("user_id").(int64)); err != nil {
+		status := fiber.StatusInternalServerError
+		switch err {
+		case account.ErrAccountNotFound:
+			status = fiber.StatusNotFound
+		case account.ErrAlreadySuspended:
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 	}
 
+	h.logActivity(c.Locals("user_id").(int64), "account_suspend", fmt.Sprintf("Suspended account %d: %s", id, req.Reason), c.IP())
+
 	return c.JSON(models.APIResponse{
 		Success: true,
-		Data:    map[string]string{"message": "Account suspended"},
+		Data:    map[string]string{"message": "Account suspended", "reason": req.Reason},
 	})
 }
 
-// UnsuspendAccount unsuspends an account
+// UnsuspendAccount unsuspends an account and restores everything the
+// suspension disabled
 func (h *Handler) UnsuspendAccount(c *fiber.Ctx) error {
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
@@ -165,18 +249,112 @@ func (h *Handler) UnsuspendAccount(c *fiber.Ctx) error {
 			Error:   "Invalid account ID",
 		})
 	}
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Account not found",
+		})
+	}
 
 	svc := account.NewService(h.db)
 
 	if err := svc.UnsuspendAccount(id); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+		status := fiber.StatusInternalServerError
+		if err == account.ErrAccountNotFound {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(models.APIResponse{
 			Success: false,
 			Error:   err.Error(),
 		})
 	}
 
+	h.logActivity(c.Locals("user_id").(int64), "account_unsuspend", fmt.Sprintf("Unsuspended account %d", id), c.IP())
+
 	return c.JSON(models.APIResponse{
 		Success: true,
 		Data:    map[string]string{"message": "Account unsuspended"},
 	})
 }
+
+// ChangeAccountPackage moves an account to another package and re-applies
+// its limits. With dry_run the usage check is returned without changes.
+func (h *Handler) ChangeAccountPackage(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid account ID",
+		})
+	}
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Account not found",
+		})
+	}
+
+	var req struct {
+		PackageID int64 `json:"package_id"`
+		DryRun    bool  `json:"dry_run"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.PackageID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "package_id is required",
+		})
+	}
+
+	svc := account.NewService(h.db)
+
+	if role == models.RoleReseller && !svc.CanUsePackage(userID, req.PackageID) {
+		return c.Status(fiber.StatusForbidden).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Package not available",
+		})
+	}
+
+	change, err := svc.ChangePackage(id, req.PackageID, req.DryRun)
+	if change == nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, sql.ErrNoRows) {
+			status = fiber.StatusNotFound
+			err = errors.New("Account not found")
+		} else if errors.Is(err, account.ErrPackageNotFound) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, account.ErrUsageExceedsPackage) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+			Data:    change,
+		})
+	}
+
+	if req.DryRun {
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Data:    change,
+		})
+	}
+
+	h.logActivity(userID, "package_change", fmt.Sprintf("Changed package of %s: %s → %s", change.Username, change.OldPackageName, change.NewPackageName), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Package changed",
+		Data:    change,
+	})
+}
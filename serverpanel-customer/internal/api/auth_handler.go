@@ -37,6 +37,18 @@ func (h *Handler) Login(c *fiber.Ctx) error {
 	)
 
 	if err == sql.ErrNoRows {
+		// Tell the owner of a suspended account why it cannot log in
+		var reason string
+		if h.db.QueryRow(`
+			SELECT u.password, s.reason FROM users u
+			JOIN account_suspensions s ON s.user_id = u.id
+			WHERE u.username = ? AND u.active = 0
+		`, req.Username).Scan(&password, &reason) == nil && auth.CheckPassword(req.Password, password) {
+			return c.Status(fiber.StatusForbidden).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Account suspended: " + reason,
+			})
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(models.APIResponse{
 			Success: false,
 			Error:   "Invalid credentials",
@@ -0,0 +1,900 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/backup"
+	"github.com/gofiber/fiber/v2"
+)
+
+// backupOptionsFromComponents builds backup options from a component list.
+// An empty list means a full backup.
+func backupOptionsFromComponents(components []string) (backup.Options, error) {
+	if len(components) == 0 {
+		return backup.FullOptions(), nil
+	}
+
+	var opts backup.Options
+	for _, c := range components {
+		switch c {
+		case "files":
+			opts.IncludeFiles = true
+		case "databases":
+			opts.IncludeDatabases = true
+		case "email":
+			opts.IncludeEmail = true
+		case "dns":
+			opts.IncludeDNS = true
+		case "ftp":
+			opts.IncludeFTP = true
+		default:
+			return opts, fmt.Errorf("unknown backup component: %s", c)
+		}
+	}
+	return opts, nil
+}
+
+// getBackupJob loads a backup job and checks that the caller may access it
+func (h *Handler) getBackupJob(c *fiber.Ctx, svc *backup.Service) (*backup.Job, error) {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid backup ID",
+		})
+	}
+
+	job, err := svc.GetJob(id)
+	if err != nil || (role != models.RoleAdmin && job.UserID != userID) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Backup not found",
+		})
+	}
+
+	return job, nil
+}
+
+// ListBackups returns backups (admin: all or ?user_id=, user: own)
+func (h *Handler) ListBackups(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	filterUserID := userID
+	if role == models.RoleAdmin {
+		filterUserID = int64(c.QueryInt("user_id", 0))
+	}
+
+	svc := backup.NewService(h.db)
+	jobs, err := svc.ListJobs(filterUserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to fetch backups",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    jobs,
+	})
+}
+
+// CreateBackup starts a backup of an account in the background
+func (h *Handler) CreateBackup(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	var req struct {
+		UserID        int64    `json:"user_id"`        // Admin only - account to back up
+		Components    []string `json:"components"`     // files, databases, email, dns, ftp (empty = full)
+		DestinationID int64    `json:"destination_id"` // Admin only - 0 = default destination
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	targetUserID := userID
+	if role == models.RoleAdmin {
+		if req.UserID == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   "user_id is required",
+			})
+		}
+		targetUserID = req.UserID
+	}
+
+	opts, err := backupOptionsFromComponents(req.Components)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	if role == models.RoleAdmin {
+		opts.DestinationID = req.DestinationID
+	}
+
+	svc := backup.NewService(h.db)
+	job, err := svc.CreateJob(targetUserID, userID, opts)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if err == backup.ErrAccountNotFound || err == backup.ErrDestinationNotFound {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	go svc.RunJob(job.ID)
+
+	h.logActivity(userID, "backup_create", fmt.Sprintf("Backup started for %s (job %d)", job.Username, job.ID), c.IP())
+
+	return c.Status(fiber.StatusAccepted).JSON(models.APIResponse{
+		Success: true,
+		Message: "Backup started",
+		Data:    job,
+	})
+}
+
+// GetBackup returns a single backup job with its manifest
+func (h *Handler) GetBackup(c *fiber.Ctx) error {
+	svc := backup.NewService(h.db)
+	job, err := h.getBackupJob(c, svc)
+	if job == nil {
+		return err
+	}
+
+	// Only a local copy is read here; remote-only archives are fetched
+	// back on restore or download, not on every view
+	data := fiber.Map{"job": job}
+	if job.Status == backup.StatusCompleted && job.BackupPath != "" {
+		if manifest, err := backup.ReadManifest(job.BackupPath); err == nil {
+			data["manifest"] = manifest
+		}
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// DownloadBackup streams a completed backup archive
+func (h *Handler) DownloadBackup(c *fiber.Ctx) error {
+	svc := backup.NewService(h.db)
+	job, err := h.getBackupJob(c, svc)
+	if job == nil {
+		return err
+	}
+
+	path, err := svc.GetArchivePath(job.ID)
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	if job.Encrypted {
+		c.Set("Content-Type", "application/octet-stream")
+	} else {
+		c.Set("Content-Type", "application/gzip")
+	}
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", job.FileName))
+	return c.SendFile(path)
+}
+
+// DeleteBackup removes a backup job and its archive
+func (h *Handler) DeleteBackup(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	svc := backup.NewService(h.db)
+	job, err := h.getBackupJob(c, svc)
+	if job == nil {
+		return err
+	}
+
+	if err := svc.DeleteJob(job.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "backup_delete", fmt.Sprintf("Backup %d of %s deleted", job.ID, job.Username), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Backup deleted",
+	})
+}
+
+// RestoreBackupItem restores a single item from a backup: a path under the
+// home directory, one database, one mailbox or one DNS zone
+func (h *Handler) RestoreBackupItem(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	svc := backup.NewService(h.db)
+	job, err := h.getBackupJob(c, svc)
+	if job == nil {
+		return err
+	}
+
+	var req struct {
+		Type string `json:"type"` // file, database, mailbox, dns
+		Item string `json:"item"` // path, database name, email address or domain
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	var result *backup.RestoreResult
+	switch req.Type {
+	case backup.RestoreFile:
+		// Paths are always relative to the backed up account's home
+		basePath := filepath.Join(h.cfg.HomeBaseDir, job.Username)
+		fullPath, pathErr := h.validatePath(basePath, req.Item)
+		if pathErr != nil {
+			return c.Status(fiber.StatusForbidden).JSON(models.APIResponse{
+				Success: false,
+				Error:   pathErr.Error(),
+			})
+		}
+		result, err = svc.RestoreFile(job.ID, strings.TrimPrefix(fullPath, basePath))
+	case backup.RestoreDatabase:
+		result, err = svc.RestoreDatabase(job.ID, req.Item)
+	case backup.RestoreMailbox:
+		result, err = svc.RestoreMailbox(job.ID, req.Item)
+	case backup.RestoreDNSZone:
+		result, err = svc.RestoreDNSZone(job.ID, req.Item)
+		if err == nil {
+			if zoneErr := h.updateZoneFile(result.Item); zoneErr != nil {
+				log.Printf("Warning: Could not update zone file: %v", zoneErr)
+			}
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "type must be one of: file, database, mailbox, dns",
+		})
+	}
+
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		switch err {
+		case backup.ErrItemNotInBackup:
+			status = fiber.StatusNotFound
+		case backup.ErrNotOwner:
+			status = fiber.StatusForbidden
+		case backup.ErrJobNotReady:
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "backup_restore", fmt.Sprintf("Restored %s %s from backup %d of %s", result.Type, result.Item, job.ID, job.Username), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Restore completed",
+		Data:    result,
+	})
+}
+
+// getBackupSnapshot loads a snapshot and checks that the caller may access it
+func (h *Handler) getBackupSnapshot(c *fiber.Ctx, svc *backup.Service) (*backup.Snapshot, error) {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid snapshot ID",
+		})
+	}
+
+	snap, err := svc.GetSnapshot(id)
+	if err != nil || (role != models.RoleAdmin && snap.UserID != userID) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Snapshot not found",
+		})
+	}
+
+	return snap, nil
+}
+
+// ListBackupSnapshots returns incremental snapshots (admin: all or ?user_id=, user: own)
+func (h *Handler) ListBackupSnapshots(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	filterUserID := userID
+	if role == models.RoleAdmin {
+		filterUserID = int64(c.QueryInt("user_id", 0))
+	}
+
+	svc := backup.NewService(h.db)
+	snapshots, err := svc.ListSnapshots(filterUserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to fetch snapshots",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    snapshots,
+	})
+}
+
+// CreateBackupSnapshot starts an incremental home directory backup in the background
+func (h *Handler) CreateBackupSnapshot(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	var req struct {
+		UserID int64 `json:"user_id"` // Admin only - account to back up
+	}
+	c.BodyParser(&req)
+
+	targetUserID := userID
+	if role == models.RoleAdmin {
+		if req.UserID == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   "user_id is required",
+			})
+		}
+		targetUserID = req.UserID
+	}
+
+	svc := backup.NewService(h.db)
+	snap, err := svc.CreateSnapshot(targetUserID, userID)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if err == backup.ErrAccountNotFound {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	go svc.RunSnapshot(snap.ID)
+
+	h.logActivity(userID, "backup_snapshot_create", fmt.Sprintf("Incremental backup started for %s (snapshot %d)", snap.Username, snap.ID), c.IP())
+
+	return c.Status(fiber.StatusAccepted).JSON(models.APIResponse{
+		Success: true,
+		Message: "Incremental backup started",
+		Data:    snap,
+	})
+}
+
+// GetBackupSnapshot returns a single snapshot
+func (h *Handler) GetBackupSnapshot(c *fiber.Ctx) error {
+	snap, err := h.getBackupSnapshot(c, backup.NewService(h.db))
+	if snap == nil {
+		return err
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    snap,
+	})
+}
+
+// DeleteBackupSnapshot removes a snapshot; its chunks are freed on the next prune
+func (h *Handler) DeleteBackupSnapshot(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	svc := backup.NewService(h.db)
+	snap, err := h.getBackupSnapshot(c, svc)
+	if snap == nil {
+		return err
+	}
+
+	if err := svc.DeleteSnapshot(snap.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "backup_snapshot_delete", fmt.Sprintf("Snapshot %d of %s deleted", snap.ID, snap.Username), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Snapshot deleted",
+	})
+}
+
+// RestoreBackupSnapshot restores a path from a snapshot into the account's home
+func (h *Handler) RestoreBackupSnapshot(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	svc := backup.NewService(h.db)
+	snap, err := h.getBackupSnapshot(c, svc)
+	if snap == nil {
+		return err
+	}
+
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	basePath := filepath.Join(h.cfg.HomeBaseDir, snap.Username)
+	fullPath, err := h.validatePath(basePath, req.Path)
+	if err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	result, err := svc.RestoreSnapshotPath(snap.ID, strings.TrimPrefix(fullPath, basePath))
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		switch err {
+		case backup.ErrItemNotInBackup:
+			status = fiber.StatusNotFound
+		case backup.ErrJobNotReady:
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "backup_restore", fmt.Sprintf("Restored %s from snapshot %d of %s", result.Item, snap.ID, snap.Username), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Restore completed",
+		Data:    result,
+	})
+}
+
+// PruneBackups applies package retention rules and frees unused chunks
+func (h *Handler) PruneBackups(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	result, err := backup.NewService(h.db).Prune()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "backup_prune", fmt.Sprintf("Pruned %d snapshots, %d chunks", result.SnapshotsDeleted, result.ChunksDeleted), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    result,
+	})
+}
+
+// ========== BACKUP DESTINATIONS (admin only) ==========
+
+type backupDestinationRequest struct {
+	Name        string                         `json:"name"`
+	Type        string                         `json:"type"`
+	Config      backup.DestinationConfig       `json:"config"`
+	Credentials *backup.DestinationCredentials `json:"credentials"` // Omit on update to keep the stored ones
+	KeepLocal   bool                           `json:"keep_local"`
+	IsDefault   bool                           `json:"is_default"`
+}
+
+// ListBackupDestinations returns the configured destinations
+func (h *Handler) ListBackupDestinations(c *fiber.Ctx) error {
+	destinations, err := backup.NewService(h.db).ListDestinations()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to list backup destinations",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    destinations,
+	})
+}
+
+// GetBackupDestination returns a single destination (credentials are never returned)
+func (h *Handler) GetBackupDestination(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid destination ID",
+		})
+	}
+
+	d, err := backup.NewService(h.db).GetDestination(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Backup destination not found",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    d,
+	})
+}
+
+// saveBackupDestination handles create (id == 0) and update
+func (h *Handler) saveBackupDestination(c *fiber.Ctx, id int64) error {
+	userID := c.Locals("user_id").(int64)
+
+	var req backupDestinationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	d, err := backup.NewService(h.db).SaveDestination(&backup.DestinationRecord{
+		ID:        id,
+		Name:      strings.TrimSpace(req.Name),
+		Type:      req.Type,
+		Config:    req.Config,
+		KeepLocal: req.KeepLocal,
+		IsDefault: req.IsDefault,
+	}, req.Credentials)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, backup.ErrInvalidDestination) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, backup.ErrDestinationNotFound) {
+			status = fiber.StatusNotFound
+		} else if strings.Contains(err.Error(), "UNIQUE") {
+			status = fiber.StatusConflict
+			err = fmt.Errorf("a destination named %s already exists", req.Name)
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	action := "backup_destination_update"
+	status := fiber.StatusOK
+	if id == 0 {
+		action = "backup_destination_create"
+		status = fiber.StatusCreated
+	}
+	h.logActivity(userID, action, fmt.Sprintf("Backup destination %s (%s)", d.Name, d.Type), c.IP())
+
+	return c.Status(status).JSON(models.APIResponse{
+		Success: true,
+		Data:    d,
+	})
+}
+
+// CreateBackupDestination adds a destination
+func (h *Handler) CreateBackupDestination(c *fiber.Ctx) error {
+	return h.saveBackupDestination(c, 0)
+}
+
+// UpdateBackupDestination changes a destination
+func (h *Handler) UpdateBackupDestination(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid destination ID",
+		})
+	}
+	return h.saveBackupDestination(c, id)
+}
+
+// DeleteBackupDestination removes a destination that holds no backups
+func (h *Handler) DeleteBackupDestination(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid destination ID",
+		})
+	}
+
+	if err := backup.NewService(h.db).DeleteDestination(id); err != nil {
+		status := fiber.StatusInternalServerError
+		if err == backup.ErrDestinationNotFound {
+			status = fiber.StatusNotFound
+		} else if err == backup.ErrDestinationInUse {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "backup_destination_delete", fmt.Sprintf("Backup destination %d deleted", id), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Backup destination deleted",
+	})
+}
+
+// TestBackupDestination writes and removes a small file on the destination
+func (h *Handler) TestBackupDestination(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid destination ID",
+		})
+	}
+
+	if err := backup.NewService(h.db).TestDestination(id); err != nil {
+		status := fiber.StatusBadGateway
+		if err == backup.ErrDestinationNotFound {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Connection successful",
+	})
+}
+
+// ========== BACKUP ENCRYPTION ==========
+
+// VerifyBackup proves that a backup decrypts and matches its manifest
+// checksums. With a passphrase the customer key is tested instead of the
+// server master key.
+func (h *Handler) VerifyBackup(c *fiber.Ctx) error {
+	svc := backup.NewService(h.db)
+	job, err := h.getBackupJob(c, svc)
+	if job == nil {
+		return err
+	}
+
+	var req struct {
+		Passphrase string `json:"passphrase"`
+	}
+	c.BodyParser(&req)
+
+	result, err := svc.VerifyJob(job.ID, req.Passphrase)
+	if err != nil {
+		return c.Status(fiber.StatusConflict).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: result.Valid,
+		Data:    result,
+		Error:   result.Error,
+	})
+}
+
+// backupEncryptionTarget returns the account an encryption request is
+// about: admins pass user_id, customers always get their own account
+func (h *Handler) backupEncryptionTarget(c *fiber.Ctx, requested int64) (int64, error) {
+	userID := c.Locals("user_id").(int64)
+	if c.Locals("role").(string) != models.RoleAdmin {
+		return userID, nil
+	}
+
+	var count int
+	h.db.QueryRow("SELECT COUNT(*) FROM users WHERE id = ? AND role = 'user'", requested).Scan(&count)
+	if count == 0 {
+		return 0, c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "user_id is required",
+		})
+	}
+	return requested, nil
+}
+
+// GetBackupEncryption returns the encryption state of an account, plus the
+// master key ring for admins
+func (h *Handler) GetBackupEncryption(c *fiber.Ctx) error {
+	svc := backup.NewService(h.db)
+	data := fiber.Map{}
+
+	if c.Locals("role").(string) == models.RoleAdmin {
+		keys, err := svc.ListMasterKeys()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		}
+		data["keys"] = keys
+		if c.QueryInt("user_id", 0) == 0 {
+			return c.JSON(models.APIResponse{Success: true, Data: data})
+		}
+	}
+
+	target, err := h.backupEncryptionTarget(c, int64(c.QueryInt("user_id", 0)))
+	if target == 0 {
+		return err
+	}
+	data["user_id"] = target
+	data["enabled"] = svc.EncryptionEnabled(target)
+	data["has_passphrase"] = svc.HasPassphrase(target)
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    data,
+	})
+}
+
+// startBackupRewrapTask re-wraps backup keys in the background and
+// streams the progress to the task log
+func (h *Handler) startBackupRewrapTask(userID int64, taskType, taskName string, run func(logf func(string, ...interface{})) (*backup.RewrapResult, error)) string {
+	taskID := fmt.Sprintf("%s-%d", taskType, time.Now().UnixNano())
+	taskManager.createTask(taskID, taskType, taskName)
+
+	go func() {
+		logf := func(format string, args ...interface{}) {
+			taskManager.addLog(taskID, fmt.Sprintf(format, args...))
+		}
+		logf("🚀 %s başlatılıyor...", taskName)
+		logf("")
+
+		result, err := run(logf)
+		if err == nil && result.Failed > 0 {
+			err = fmt.Errorf("%d item(s) could not be re-wrapped", result.Failed)
+		}
+		logf("")
+		if err != nil {
+			logf("❌ Hata: %s", err.Error())
+		} else {
+			logf("✅ %s başarıyla tamamlandı!", taskName)
+		}
+		if result != nil {
+			h.logActivity(userID, taskType, fmt.Sprintf("%s: %d backups re-wrapped, %d failed", taskName, result.Rewrapped, result.Failed), "")
+		}
+		taskManager.completeTask(taskID, err == nil)
+	}()
+
+	return taskID
+}
+
+// SetBackupPassphrase sets the backup passphrase of an account and adds it
+// to the account's existing encrypted backups
+func (h *Handler) SetBackupPassphrase(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	var req struct {
+		UserID     int64  `json:"user_id"` // Admin only
+		Passphrase string `json:"passphrase"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	target, err := h.backupEncryptionTarget(c, req.UserID)
+	if target == 0 {
+		return err
+	}
+
+	svc := backup.NewService(h.db)
+	if err := svc.SetPassphrase(target, req.Passphrase); err != nil {
+		status := fiber.StatusInternalServerError
+		if err == backup.ErrWeakPassphrase {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	taskID := h.startBackupRewrapTask(userID, "backup_passphrase", "Backup passphrase update", func(logf func(string, ...interface{})) (*backup.RewrapResult, error) {
+		return svc.RewrapJobs(target, logf)
+	})
+
+	h.logActivity(userID, "backup_passphrase_set", fmt.Sprintf("Backup passphrase set for user %d", target), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Backup passphrase saved",
+		Data:    fiber.Map{"task_id": taskID},
+	})
+}
+
+// RemoveBackupPassphrase removes the backup passphrase of an account; its
+// backups stay readable with the server master key
+func (h *Handler) RemoveBackupPassphrase(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	target, err := h.backupEncryptionTarget(c, int64(c.QueryInt("user_id", 0)))
+	if target == 0 {
+		return err
+	}
+
+	svc := backup.NewService(h.db)
+	if err := svc.RemovePassphrase(target); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	taskID := h.startBackupRewrapTask(userID, "backup_passphrase", "Backup passphrase removal", func(logf func(string, ...interface{})) (*backup.RewrapResult, error) {
+		return svc.RewrapJobs(target, logf)
+	})
+
+	h.logActivity(userID, "backup_passphrase_remove", fmt.Sprintf("Backup passphrase removed for user %d", target), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Backup passphrase removed",
+		Data:    fiber.Map{"task_id": taskID},
+	})
+}
+
+// RotateBackupKey creates a new master key and re-wraps every encrypted
+// backup and stored secret with it (admin only)
+func (h *Handler) RotateBackupKey(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	svc := backup.NewService(h.db)
+	taskID := h.startBackupRewrapTask(userID, "backup_key_rotate", "Backup master key rotation", svc.RotateMasterKey)
+
+	h.logActivity(userID, "backup_key_rotate", "Backup master key rotation started", c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Key rotation started",
+		Data:    fiber.Map{"task_id": taskID},
+	})
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/bandwidth"
+	"github.com/gofiber/fiber/v2"
+)
+
+var periodPattern = regexp.MustCompile(`^\d{4}-\d{2}$`)
+
+// bandwidthPeriod returns the ?period=YYYY-MM query, the current month by
+// default
+func bandwidthPeriod(c *fiber.Ctx) (string, bool) {
+	period := c.Query("period", bandwidth.CurrentPeriod())
+	return period, periodPattern.MatchString(period)
+}
+
+// bandwidthResponse returns the traffic of an account in a period
+func (h *Handler) bandwidthResponse(c *fiber.Ctx, userID int64) error {
+	period, ok := bandwidthPeriod(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "period must be YYYY-MM",
+		})
+	}
+
+	usage, err := bandwidth.NewService(h.db).AccountUsage(userID, period)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if err == bandwidth.ErrAccountNotFound {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    usage,
+	})
+}
+
+// GetMyBandwidth returns the traffic of the caller's account
+func (h *Handler) GetMyBandwidth(c *fiber.Ctx) error {
+	return h.bandwidthResponse(c, c.Locals("user_id").(int64))
+}
+
+// GetAccountBandwidth returns the traffic of an account
+func (h *Handler) GetAccountBandwidth(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid account ID",
+		})
+	}
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Account not found",
+		})
+	}
+	return h.bandwidthResponse(c, id)
+}
+
+// GetBandwidthReport returns the traffic of every account (admin) or of
+// the reseller's accounts in a period
+func (h *Handler) GetBandwidthReport(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	period, ok := bandwidthPeriod(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "period must be YYYY-MM",
+		})
+	}
+
+	var resellerID int64
+	if role == models.RoleReseller {
+		resellerID = userID
+	}
+
+	report, err := bandwidth.NewService(h.db).Report(period, resellerID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to build bandwidth report",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    report,
+	})
+}
+
+// ListBandwidthOverages returns the accounts that went over their
+// bandwidth in a period (Admin only)
+func (h *Handler) ListBandwidthOverages(c *fiber.Ctx) error {
+	period, ok := bandwidthPeriod(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "period must be YYYY-MM",
+		})
+	}
+
+	svc := bandwidth.NewService(h.db)
+	overages, err := svc.ListOverages(period)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to list overages",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data: fiber.Map{
+			"overages": overages,
+			"settings": svc.GetSettings(),
+		},
+	})
+}
+
+// IngestBandwidth reads the access logs and enforces the bandwidth limits
+// now instead of waiting for the worker (Admin only)
+func (h *Handler) IngestBandwidth(c *fiber.Ctx) error {
+	svc := bandwidth.NewService(h.db)
+
+	result, err := svc.Ingest()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	overages, err := svc.Enforce()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data: fiber.Map{
+			"ingest":   result,
+			"overages": overages,
+		},
+	})
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/asergenalkan/serverpanel/internal/services/cpanel"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ImportCPanelAccount imports a cpmove-USER.tar.gz (admin only).
+// The archive is either uploaded as "file" or referenced by a server "path".
+// With dry_run the import plan and its conflicts are returned and nothing
+// is changed; otherwise the import runs as a task with live logs.
+func (h *Handler) ImportCPanelAccount(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	var req struct {
+		Path         string `json:"path" form:"path"`
+		Username     string `json:"username" form:"username"`
+		PackageID    int64  `json:"package_id" form:"package_id"`
+		Password     string `json:"password" form:"password"`
+		ContactEmail string `json:"contact_email" form:"contact_email"`
+		ReplaceIP    bool   `json:"replace_ip" form:"replace_ip"`
+		DryRun       bool   `json:"dry_run" form:"dry_run"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	if req.PackageID == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "package_id is required",
+		})
+	}
+
+	// Uploaded archives are kept under the data dir so a dry run can be
+	// followed by the real import without uploading again
+	archivePath := req.Path
+	if file, err := c.FormFile("file"); err == nil {
+		importDir := filepath.Join(h.cfg.DataDir, "imports")
+		if err := os.MkdirAll(importDir, 0700); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Failed to prepare import directory",
+			})
+		}
+		archivePath = filepath.Join(importDir, fmt.Sprintf("%d-%s", time.Now().Unix(), filepath.Base(file.Filename)))
+		if err := c.SaveFile(file, archivePath); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Failed to save uploaded archive",
+			})
+		}
+	}
+	if archivePath == "" || !filepath.IsAbs(archivePath) {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Upload a cpmove archive as 'file' or give its absolute 'path'",
+		})
+	}
+	if info, err := os.Stat(archivePath); err != nil || !info.Mode().IsRegular() {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Archive not found",
+		})
+	}
+
+	arc, err := cpanel.Parse(archivePath)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	svc := cpanel.NewService(h.db)
+	report, err := svc.Plan(arc, cpanel.Options{
+		Username:     req.Username,
+		PackageID:    req.PackageID,
+		Password:     req.Password,
+		ContactEmail: req.ContactEmail,
+		ReplaceIP:    req.ReplaceIP,
+		DryRun:       req.DryRun,
+	})
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, cpanel.ErrPackageNotFound) {
+			status = fiber.StatusBadRequest
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	if req.DryRun {
+		return c.JSON(models.APIResponse{
+			Success: true,
+			Message: "Dry run, nothing was changed",
+			Data:    fiber.Map{"path": archivePath, "report": report},
+		})
+	}
+
+	if !report.CanImport {
+		return c.Status(fiber.StatusConflict).JSON(models.APIResponse{
+			Success: false,
+			Error:   cpanel.ErrCannotImport.Error(),
+			Data:    fiber.Map{"path": archivePath, "report": report},
+		})
+	}
+
+	taskID := fmt.Sprintf("cpanel-import-%s-%d", report.Username, time.Now().UnixNano())
+	taskName := fmt.Sprintf("cPanel import: %s", report.Username)
+	taskManager.createTask(taskID, "cpanel-import", taskName)
+
+	go func() {
+		taskManager.addLog(taskID, fmt.Sprintf("🚀 %s başlatılıyor...", taskName))
+		taskManager.addLog(taskID, "")
+
+		err := h.runCPanelImport(taskID, svc, report)
+		if err != nil {
+			taskManager.addLog(taskID, "")
+			taskManager.addLog(taskID, fmt.Sprintf("❌ Hata: %s", err.Error()))
+		} else {
+			taskManager.addLog(taskID, "")
+			taskManager.addLog(taskID, fmt.Sprintf("✅ %s başarıyla tamamlandı!", taskName))
+			h.logActivity(userID, "cpanel_import", fmt.Sprintf("Imported cPanel account %s as %s (%s)", report.SourceUsername, report.Username, report.MainDomain), "")
+		}
+		taskManager.completeTask(taskID, err == nil)
+	}()
+
+	h.logActivity(userID, "cpanel_import_started", fmt.Sprintf("Started import of %s from %s", report.Username, filepath.Base(archivePath)), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Import started",
+		Data:    fiber.Map{"task_id": taskID, "report": report},
+	})
+}
+
+// runCPanelImport reconstructs the account from an import plan using the
+// same creation paths as the domain, database and email handlers
+func (h *Handler) runCPanelImport(taskID string, svc *cpanel.Service, r *cpanel.Report) error {
+	logf := func(format string, args ...interface{}) {
+		taskManager.addLog(taskID, fmt.Sprintf(format, args...))
+	}
+
+	// 1. Account with its main domain
+	logf("👤 Creating account %s (%s)", r.Username, r.MainDomain)
+	acc, err := account.NewService(h.db).CreateAccount(account.CreateAccountRequest{
+		Username:  r.Username,
+		Email:     r.Email,
+		Password:  r.Password,
+		Domain:    r.MainDomain,
+		PackageID: r.PackageID,
+	})
+	if err != nil {
+		return fmt.Errorf("account creation failed: %w", err)
+	}
+	if r.DocumentRoot != filepath.Join(acc.HomeDir, "public_html") {
+		h.db.Exec("UPDATE domains SET document_root = ? WHERE name = ?", r.DocumentRoot, r.MainDomain)
+	}
+
+	domainIDs := map[string]int64{}
+	var mainID int64
+	h.db.QueryRow("SELECT id FROM domains WHERE name = ?", r.MainDomain).Scan(&mainID)
+	domainIDs[r.MainDomain] = mainID
+
+	// 2. Addon and parked domains
+	for _, d := range r.AddonDomains {
+		result, err := h.db.Exec(`
+			INSERT INTO domains (user_id, name, domain_type, document_root, active)
+			VALUES (?, ?, 'addon', ?, 1)
+		`, acc.ID, d.Domain, d.DocumentRoot)
+		if err != nil {
+			logf("⚠️ Addon domain %s: %v", d.Domain, err)
+			continue
+		}
+		domainIDs[d.Domain], _ = result.LastInsertId()
+		h.createDomainResources(r.Username, d.Domain, d.DocumentRoot)
+		logf("🌐 Addon domain %s -> %s", d.Domain, d.DocumentRoot)
+	}
+	for _, name := range r.ParkedDomains {
+		result, err := h.db.Exec(`
+			INSERT INTO domains (user_id, name, domain_type, parent_domain_id, document_root, active)
+			VALUES (?, ?, 'alias', ?, ?, 1)
+		`, acc.ID, name, mainID, r.DocumentRoot)
+		if err != nil {
+			logf("⚠️ Parked domain %s: %v", name, err)
+			continue
+		}
+		domainIDs[name], _ = result.LastInsertId()
+		h.createDomainResources(r.Username, name, r.DocumentRoot)
+		logf("🌐 Parked domain %s", name)
+	}
+
+	// 3. Subdomains
+	for _, sd := range r.SubDomains {
+		domainID, ok := domainIDs[sd.Domain]
+		if !ok {
+			logf("⚠️ Subdomain %s: parent domain %s was not created", sd.FullName, sd.Domain)
+			continue
+		}
+		_, err := h.db.Exec(`
+			INSERT INTO subdomains (user_id, domain_id, name, full_name, document_root, redirect_url, redirect_type, active)
+			VALUES (?, ?, ?, ?, ?, NULL, NULL, 1)
+		`, acc.ID, domainID, sd.Name, sd.FullName, sd.DocumentRoot)
+		if err != nil {
+			logf("⚠️ Subdomain %s: %v", sd.FullName, err)
+			continue
+		}
+		h.createSubdomainResources(r.Username, sd.FullName, sd.DocumentRoot, "", "")
+		logf("🌐 Subdomain %s -> %s", sd.FullName, sd.DocumentRoot)
+	}
+
+	// 4. Home directory and Maildirs
+	logf("📦 Extracting home directory (%d files, %d MB)", r.HomedirFiles, r.HomedirSizeMB)
+	files, size, err := svc.ExtractFiles(r)
+	if err != nil {
+		return fmt.Errorf("file extraction failed: %w", err)
+	}
+	logf("   %d files, %d bytes written", files, size)
+
+	// 5. Databases and MySQL users
+	if len(r.Databases) > 0 {
+		logf("🗄️ Importing %d databases", len(r.Databases))
+		if err := svc.ImportDatabases(r, logf); err != nil {
+			return err
+		}
+		dbIDs := map[string]int64{}
+		for _, db := range r.Databases {
+			result, err := h.db.Exec(`
+				INSERT INTO databases (user_id, name, type, size)
+				VALUES (?, ?, 'mysql', ?)
+			`, acc.ID, db.Name, db.Size)
+			if err != nil {
+				logf("⚠️ Database %s: %v", db.Name, err)
+				continue
+			}
+			dbIDs[db.Name], _ = result.LastInsertId()
+		}
+		// Passwords are only known as MySQL hashes, so none is stored
+		for _, u := range r.DatabaseUsers {
+			for _, db := range u.Databases {
+				if id, ok := dbIDs[db]; ok {
+					h.db.Exec(`
+						INSERT INTO database_users (user_id, database_id, db_username, password, host)
+						VALUES (?, ?, ?, '', 'localhost')
+					`, acc.ID, id, u.Username)
+				}
+			}
+		}
+	}
+
+	// 6. Email accounts keep their crypt(3) hashes
+	for _, e := range r.EmailAccounts {
+		domainID, ok := domainIDs[e.Domain]
+		if !ok {
+			logf("⚠️ Mailbox %s: domain was not created", e.Email)
+			continue
+		}
+
+		// Plan generated a password where the hash can not be used
+		hash := e.PasswordHash
+		if e.Password != "" {
+			hashed, _ := bcrypt.GenerateFromPassword([]byte(e.Password), bcrypt.DefaultCost)
+			hash = string(hashed)
+		}
+
+		_, err := h.db.Exec(`
+			INSERT INTO email_accounts (user_id, domain_id, email, password_hash, quota_mb, active)
+			VALUES (?, ?, ?, ?, ?, 1)
+		`, acc.ID, domainID, e.Email, hash, e.QuotaMB)
+		if err != nil {
+			logf("⚠️ Mailbox %s: %v", e.Email, err)
+			continue
+		}
+
+		if e.Password != "" {
+			h.createMailbox(e.Email, e.Password, e.QuotaMB, e.Domain)
+			logf("📧 Mailbox %s (new password)", e.Email)
+		} else {
+			h.provisionMailbox(e.Email, "{CRYPT}"+hash, e.Domain)
+			logf("📧 Mailbox %s", e.Email)
+		}
+	}
+
+	// 7. Forwarders
+	for _, f := range r.Forwarders {
+		parts := strings.SplitN(f.Source, "@", 2)
+		domainID, ok := domainIDs[parts[len(parts)-1]]
+		if !ok {
+			logf("⚠️ Forwarder %s: domain is not part of this account", f.Source)
+			continue
+		}
+		_, err := h.db.Exec(`
+			INSERT INTO email_forwarders (user_id, domain_id, source, destination, active)
+			VALUES (?, ?, ?, ?, 1)
+		`, acc.ID, domainID, f.Source, f.Destination)
+		if err != nil {
+			logf("⚠️ Forwarder %s: %v", f.Source, err)
+			continue
+		}
+		h.createForwarder(f.Source, f.Destination)
+		logf("↪️ Forwarder %s -> %s", f.Source, f.Destination)
+	}
+
+	// 8. DNS zones replace the default records
+	for _, z := range r.ZoneRecords(h.cfg.ServerIP) {
+		domainID, ok := domainIDs[z.Domain]
+		if !ok {
+			continue
+		}
+		h.db.Exec("DELETE FROM dns_records WHERE domain_id = ?", domainID)
+		imported := 0
+		for _, rec := range z.Records {
+			if !isValidRecordType(rec.Type) {
+				logf("⚠️ %s: %s record %s skipped (unsupported type)", z.Domain, rec.Type, rec.Name)
+				continue
+			}
+			_, err := h.db.Exec(`
+				INSERT INTO dns_records (domain_id, name, type, content, ttl, priority, active)
+				VALUES (?, ?, ?, ?, ?, ?, 1)
+			`, domainID, rec.Name, rec.Type, rec.Content, rec.TTL, rec.Priority)
+			if err == nil {
+				imported++
+			}
+		}
+		if err := h.updateZoneFile(z.Domain); err != nil {
+			logf("⚠️ Could not update zone file for %s: %v", z.Domain, err)
+		}
+		logf("🧭 DNS zone %s (%d records)", z.Domain, imported)
+	}
+
+	// Uploaded archives are no longer needed once imported
+	importDir := filepath.Join(h.cfg.DataDir, "imports")
+	if strings.HasPrefix(r.ArchivePath(), importDir+string(os.PathSeparator)) {
+		os.Remove(r.ArchivePath())
+	}
+
+	return nil
+}
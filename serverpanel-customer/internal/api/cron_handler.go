@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -678,6 +679,12 @@ func (h *Handler) SyncUserCrontabFromDB(userID int64, username string) error {
 	// Ensure directory exists
 	os.MkdirAll(cronDir, 0755)
 
+	// Jobs of a suspended account stay commented out until it is unsuspended
+	prefix := ""
+	if account.NewService(h.db).IsSuspended(userID) {
+		prefix = account.SuspendedCronPrefix
+	}
+
 	// Get active cron jobs for user
 	rows, err := h.db.Query(`
 		SELECT schedule, command FROM cron_jobs 
@@ -705,7 +712,7 @@ func (h *Handler) SyncUserCrontabFromDB(userID int64, username string) error {
 		if err := rows.Scan(&schedule, &command); err != nil {
 			continue
 		}
-		content.WriteString(fmt.Sprintf("%s %s\n", schedule, command))
+		content.WriteString(fmt.Sprintf("%s%s %s\n", prefix, schedule, command))
 	}
 
 	// Write to temp file first
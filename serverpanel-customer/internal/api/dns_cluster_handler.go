@@ -0,0 +1,236 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/services/dns"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListDNSClusterPeers returns the DNS cluster peers with their sync counts
+func (h *Handler) ListDNSClusterPeers(c *fiber.Ctx) error {
+	peers, err := dns.NewService(h.db).Peers()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	return c.JSON(peers)
+}
+
+// AddDNSClusterPeer registers a panel server of the DNS cluster and pushes
+// every zone to it. The token (generated when empty) has to be registered
+// for this server on the peer too.
+func (h *Handler) AddDNSClusterPeer(c *fiber.Ctx) error {
+	var req struct {
+		Name  string `json:"name"`
+		URL   string `json:"url"`
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Geçersiz istek"})
+	}
+
+	svc := dns.NewService(h.db)
+	peer, err := svc.AddPeer(req.Name, req.URL, strings.TrimSpace(req.Token))
+	if err != nil {
+		switch {
+		case errors.Is(err, dns.ErrInvalidPeer):
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, dns.ErrPeerExists):
+			return c.Status(409).JSON(fiber.Map{"error": "Bu isimde bir DNS küme sunucusu zaten var"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	go svc.SyncCluster()
+
+	h.logActivity(c.Locals("user_id").(int64), "dns_cluster", "Added DNS cluster peer "+peer.Name, c.IP())
+	return c.Status(201).JSON(fiber.Map{
+		"message": "DNS küme sunucusu eklendi; token'ı karşı sunucuya da bu sunucu için girin",
+		"peer":    peer,
+	})
+}
+
+// dnsClusterPeerID parses the peer ID in :id
+func dnsClusterPeerID(c *fiber.Ctx) (int64, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, c.Status(400).JSON(fiber.Map{"error": "Geçersiz sunucu ID"})
+	}
+	return id, nil
+}
+
+// GetDNSClusterPeer returns a peer with the sync state of every zone on it
+func (h *Handler) GetDNSClusterPeer(c *fiber.Ctx) error {
+	id, err := dnsClusterPeerID(c)
+	if id == 0 {
+		return err
+	}
+
+	svc := dns.NewService(h.db)
+	peer, err := svc.Peer(id)
+	if errors.Is(err, dns.ErrPeerNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "DNS küme sunucusu bulunamadı"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	zones, err := svc.ZoneSyncs(id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	return c.JSON(fiber.Map{"peer": peer, "zones": zones})
+}
+
+// DeleteDNSClusterPeer removes a peer and the zones served for it
+func (h *Handler) DeleteDNSClusterPeer(c *fiber.Ctx) error {
+	id, err := dnsClusterPeerID(c)
+	if id == 0 {
+		return err
+	}
+
+	domains, err := dns.NewService(h.db).DeletePeer(id)
+	if errors.Is(err, dns.ErrPeerNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "DNS küme sunucusu bulunamadı"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+
+	dnsManager := dns.NewManager(h.cfg.SimulateMode, h.cfg.SimulateBasePath)
+	for _, domain := range domains {
+		if err := dnsManager.DeleteZone(domain); err != nil {
+			log.Printf("Warning: Could not delete zone %s: %v", domain, err)
+		}
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "dns_cluster", "Removed DNS cluster peer "+strconv.FormatInt(id, 10), c.IP())
+	return c.JSON(fiber.Map{"message": "DNS küme sunucusu silindi"})
+}
+
+// SyncDNSClusterPeer pushes every zone to a peer again
+func (h *Handler) SyncDNSClusterPeer(c *fiber.Ctx) error {
+	id, err := dnsClusterPeerID(c)
+	if id == 0 {
+		return err
+	}
+
+	svc := dns.NewService(h.db)
+	if err := svc.ResyncPeer(id); err != nil {
+		if errors.Is(err, dns.ErrPeerNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": "DNS küme sunucusu bulunamadı"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	go svc.SyncCluster()
+
+	return c.JSON(fiber.Map{"message": "Zone'lar senkronizasyon için sıraya alındı"})
+}
+
+// ListDNSClusterConflicts returns the domains claimed by more than one
+// server of the cluster
+func (h *Handler) ListDNSClusterConflicts(c *fiber.Ctx) error {
+	conflicts, err := dns.NewService(h.db).Conflicts()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	return c.JSON(conflicts)
+}
+
+// clusterPeer authenticates a request of a cluster peer by its token;
+// otherwise the error response has been sent and the peer is nil
+func (h *Handler) clusterPeer(c *fiber.Ctx) (*dns.ClusterPeer, error) {
+	peer, err := dns.NewService(h.db).PeerByToken(c.Get(dns.ClusterTokenHeader))
+	if errors.Is(err, dns.ErrPeerNotFound) {
+		return nil, c.Status(401).JSON(fiber.Map{"error": "Geçersiz küme token'ı"})
+	}
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	return peer, nil
+}
+
+// ReceiveDNSClusterZone stores a zone pushed by a peer and serves it
+func (h *Handler) ReceiveDNSClusterZone(c *fiber.Ctx) error {
+	peer, err := h.clusterPeer(c)
+	if peer == nil {
+		return err
+	}
+
+	var zone dns.ClusterZone
+	if err := c.BodyParser(&zone); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Geçersiz istek"})
+	}
+	zone.Domain = strings.ToLower(c.Params("domain"))
+	if !isValidDomain(zone.Domain) {
+		return c.Status(400).JSON(fiber.Map{"error": "Geçersiz domain adı"})
+	}
+	for i, r := range zone.Records {
+		if !isValidRecordType(r.Type) {
+			return c.Status(400).JSON(fiber.Map{"error": "Geçersiz kayıt tipi: " + r.Type})
+		}
+		zone.Records[i].Name = sanitizeDNSName(r.Name)
+		zone.Records[i].Content = sanitizeDNSContent(r.Type, r.Content)
+		if err := validateDNSRecord(r.Type, zone.Records[i].Name, zone.Records[i].Content, r.Priority); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": r.Type + " " + r.Name + ": " + err.Error()})
+		}
+	}
+
+	svc := dns.NewService(h.db)
+	if err := svc.ReceiveZone(peer, zone); err != nil {
+		if errors.Is(err, dns.ErrZoneConflict) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+
+	if err := h.writeClusterZoneFile(zone.Domain); err != nil {
+		log.Printf("Warning: Could not write zone file of %s: %v", zone.Domain, err)
+	}
+	return c.JSON(fiber.Map{"message": "Zone kaydedildi"})
+}
+
+// RemoveDNSClusterZone stops serving a zone a peer removed
+func (h *Handler) RemoveDNSClusterZone(c *fiber.Ctx) error {
+	peer, err := h.clusterPeer(c)
+	if peer == nil {
+		return err
+	}
+
+	domain := strings.ToLower(c.Params("domain"))
+	removed, err := dns.NewService(h.db).RemoveZone(peer, domain)
+	if err != nil {
+		if errors.Is(err, dns.ErrZoneConflict) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	if removed {
+		dnsManager := dns.NewManager(h.cfg.SimulateMode, h.cfg.SimulateBasePath)
+		if err := dnsManager.DeleteZone(domain); err != nil {
+			log.Printf("Warning: Could not delete zone %s: %v", domain, err)
+		}
+	}
+	return c.JSON(fiber.Map{"message": "Zone silindi"})
+}
+
+// writeClusterZoneFile writes the zone file of a zone served for a peer
+func (h *Handler) writeClusterZoneFile(domain string) error {
+	stored, err := dns.NewService(h.db).ClusterZoneRecords(domain)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	records := make([]zoneFileRecord, len(stored))
+	for i, r := range stored {
+		records[i] = zoneFileRecord(r)
+	}
+
+	dnsManager := dns.NewManager(h.cfg.SimulateMode, h.cfg.SimulateBasePath)
+	return dnsManager.WriteZone(domain, h.generateZoneFileContent(domain, records))
+}
@@ -38,7 +38,7 @@ type DNSZone struct {
 }
 
 // Supported DNS record types
-var supportedRecordTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT", "NS", "SRV", "CAA"}
+var supportedRecordTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT", "NS", "SRV", "CAA", "PTR", "NAPTR", "TLSA", "SSHFP", "HTTPS", "SVCB", "DS"}
 
 // ListDNSZones returns all DNS zones for the user
 func (h *Handler) ListDNSZones(c *fiber.Ctx) error {
@@ -150,6 +150,7 @@ func (h *Handler) GetDNSZone(c *fiber.Ctx) error {
 		if err != nil {
 			log.Printf("Warning: Could not create default DNS records: %v", err)
 		}
+		h.syncDNSCluster(domain.Name, dns.ClusterPush)
 	}
 
 	serverIP := os.Getenv("SERVER_IP")
@@ -213,10 +214,20 @@ func (h *Handler) CreateDNSRecord(c *fiber.Ctx) error {
 
 	// Validate and sanitize input
 	req.Name = sanitizeDNSName(req.Name)
-	if err := validateDNSRecord(req.Type, req.Name, req.Content); err != nil {
+	req.Content = sanitizeDNSContent(req.Type, req.Content)
+	if err := validateDNSRecord(req.Type, req.Name, req.Content, req.Priority); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	// A CNAME can not share its name with other records
+	types, err := h.recordTypesAt(req.DomainID, req.Name, 0)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	if err := cnameConflict(req.Name, req.Type, types); err != nil {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	// Set default TTL
 	if req.TTL <= 0 {
 		req.TTL = 3600
@@ -299,10 +310,20 @@ func (h *Handler) UpdateDNSRecord(c *fiber.Ctx) error {
 
 	// Validate and sanitize input
 	req.Name = sanitizeDNSName(req.Name)
-	if err := validateDNSRecord(req.Type, req.Name, req.Content); err != nil {
+	req.Content = sanitizeDNSContent(req.Type, req.Content)
+	if err := validateDNSRecord(req.Type, req.Name, req.Content, req.Priority); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 	}
 
+	// A CNAME can not share its name with other records
+	types, err := h.recordTypesAt(record.DomainID, req.Name, recordID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	if err := cnameConflict(req.Name, req.Type, types); err != nil {
+		return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+	}
+
 	// Set default TTL
 	if req.TTL <= 0 {
 		req.TTL = 3600
@@ -498,40 +519,13 @@ func (h *Handler) createDefaultDNSRecords(domainID int64, domainName string) ([]
 }
 
 func (h *Handler) updateZoneFile(domainName string) error {
+	h.syncDNSCluster(domainName, dns.ClusterPush)
+
 	// Get all records for this domain
-	rows, err := h.db.Query(`
-		SELECT name, type, content, ttl, priority
-		FROM dns_records
-		WHERE domain_id = (SELECT id FROM domains WHERE name = ?)
-		AND active = 1
-		ORDER BY type, name
-	`, domainName)
+	records, err := h.zoneFileRecords(domainName)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-
-	var records []struct {
-		Name     string
-		Type     string
-		Content  string
-		TTL      int
-		Priority int
-	}
-
-	for rows.Next() {
-		var r struct {
-			Name     string
-			Type     string
-			Content  string
-			TTL      int
-			Priority int
-		}
-		if err := rows.Scan(&r.Name, &r.Type, &r.Content, &r.TTL, &r.Priority); err != nil {
-			continue
-		}
-		records = append(records, r)
-	}
 
 	// Generate zone file content
 	zoneContent := h.generateZoneFileContent(domainName, records)
@@ -545,17 +539,83 @@ func (h *Handler) updateZoneFile(domainName string) error {
 		return fmt.Errorf("failed to write zone file: %w", err)
 	}
 
+	// Sign the zone if DNSSEC is enabled for it
+	if err := dns.NewService(h.db).Sign(domainName); err != nil {
+		return err
+	}
+
 	// Reload BIND
 	return dnsManager.Reload()
 }
 
-func (h *Handler) generateZoneFileContent(domain string, records []struct {
+// recordTypesAt returns the types of the active records of a name, except
+// the record being updated
+func (h *Handler) recordTypesAt(domainID int64, name string, excludeID int64) ([]string, error) {
+	rows, err := h.db.Query(`
+		SELECT type FROM dns_records
+		WHERE domain_id = ? AND name = ? AND id != ? AND active = 1
+	`, domainID, name, excludeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err == nil {
+			types = append(types, t)
+		}
+	}
+	return types, rows.Err()
+}
+
+// zoneFileRecord is a record as written to a zone file
+type zoneFileRecord = struct {
 	Name     string
 	Type     string
 	Content  string
 	TTL      int
 	Priority int
-}) string {
+}
+
+// zoneFileRecords returns the active records of a domain for its zone file
+func (h *Handler) zoneFileRecords(domainName string) ([]zoneFileRecord, error) {
+	rows, err := h.db.Query(`
+		SELECT name, type, content, ttl, priority
+		FROM dns_records
+		WHERE domain_id = (SELECT id FROM domains WHERE name = ?)
+		AND active = 1
+		ORDER BY type, name
+	`, domainName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []zoneFileRecord
+	for rows.Next() {
+		var r zoneFileRecord
+		if err := rows.Scan(&r.Name, &r.Type, &r.Content, &r.TTL, &r.Priority); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// syncDNSCluster queues a zone change for the DNS cluster peers and pushes
+// it in the background
+func (h *Handler) syncDNSCluster(domainName, action string) {
+	svc := dns.NewService(h.db)
+	if err := svc.QueueZone(domainName, action); err != nil {
+		log.Printf("Warning: Could not queue DNS cluster sync: %v", err)
+		return
+	}
+	go svc.SyncCluster()
+}
+
+func (h *Handler) generateZoneFileContent(domain string, records []zoneFileRecord) string {
 	serial := time.Now().Format("2006010215")
 
 	zone := fmt.Sprintf(`; Zone file for %s
@@ -572,20 +632,14 @@ $TTL 3600
 `, domain, time.Now().Format("2006-01-02 15:04:05"), domain, serial)
 
 	// Group records by type
-	recordsByType := make(map[string][]struct {
-		Name     string
-		Type     string
-		Content  string
-		TTL      int
-		Priority int
-	})
+	recordsByType := make(map[string][]zoneFileRecord)
 
 	for _, r := range records {
 		recordsByType[r.Type] = append(recordsByType[r.Type], r)
 	}
 
-	// Write records in order: NS, A, AAAA, CNAME, MX, TXT, SRV, CAA
-	typeOrder := []string{"NS", "A", "AAAA", "CNAME", "MX", "TXT", "SRV", "CAA"}
+	// Write records in order: NS, DS, A, AAAA, CNAME, MX, TXT, SRV, CAA, ...
+	typeOrder := []string{"NS", "DS", "A", "AAAA", "CNAME", "MX", "TXT", "SRV", "CAA", "PTR", "NAPTR", "TLSA", "SSHFP", "HTTPS", "SVCB"}
 
 	for _, recordType := range typeOrder {
 		recs, ok := recordsByType[recordType]
@@ -602,18 +656,18 @@ $TTL 3600
 
 			switch r.Type {
 			case "MX":
-				zone += fmt.Sprintf("%-8s%d\tIN\t%s\t%d\t%s\n", name, r.TTL, r.Type, r.Priority, r.Content)
-			case "SRV":
-				zone += fmt.Sprintf("%-8s%d\tIN\t%s\t%d\t%s\n", name, r.TTL, r.Type, r.Priority, r.Content)
+				zone += fmt.Sprintf("%-7s %d\tIN\t%s\t%d\t%s\n", name, r.TTL, r.Type, r.Priority, r.Content)
+			case "SRV", "HTTPS", "SVCB":
+				zone += fmt.Sprintf("%-7s %d\tIN\t%s\t%d\t%s\n", name, r.TTL, r.Type, r.Priority, r.Content)
 			case "TXT":
-				// Ensure TXT content is quoted
+				// Quote TXT content, split into strings of 255 characters
 				content := r.Content
-				if !strings.HasPrefix(content, "\"") {
-					content = "\"" + content + "\""
+				if fields, err := dnsFields(content); err != nil || !strings.HasPrefix(content, "\"") || !allQuoted(fields) {
+					content = quoteTXT(content)
 				}
-				zone += fmt.Sprintf("%-8s%d\tIN\t%s\t%s\n", name, r.TTL, r.Type, content)
+				zone += fmt.Sprintf("%-7s %d\tIN\t%s\t%s\n", name, r.TTL, r.Type, content)
 			default:
-				zone += fmt.Sprintf("%-8s%d\tIN\t%s\t%s\n", name, r.TTL, r.Type, r.Content)
+				zone += fmt.Sprintf("%-7s %d\tIN\t%s\t%s\n", name, r.TTL, r.Type, r.Content)
 			}
 		}
 		zone += "\n"
@@ -642,11 +696,21 @@ func sanitizeDNSName(name string) string {
 	return name
 }
 
-func validateDNSRecord(recordType, name, content string) error {
+func validateDNSRecord(recordType, name, content string, priority int) error {
+	if !isValidRecordName(name) {
+		return fmt.Errorf("geçersiz kayıt adı")
+	}
 	if content == "" {
 		return fmt.Errorf("içerik boş olamaz")
 	}
 
+	switch recordType {
+	case "MX", "SRV", "HTTPS", "SVCB":
+		if priority < 0 || priority > 65535 {
+			return fmt.Errorf("öncelik 0-65535 arasında olmalı")
+		}
+	}
+
 	switch recordType {
 	case "A":
 		ip := net.ParseIP(content)
@@ -658,28 +722,46 @@ func validateDNSRecord(recordType, name, content string) error {
 		if ip == nil || ip.To4() != nil {
 			return fmt.Errorf("geçersiz IPv6 adresi")
 		}
-	case "CNAME", "NS", "MX":
-		// Should be a valid hostname
+	case "CNAME":
+		if name == "@" {
+			return fmt.Errorf("zone kökünde CNAME kaydı olamaz")
+		}
+		if !isValidTargetName(content) {
+			return fmt.Errorf("geçersiz hostname")
+		}
+	case "PTR":
+		if !isValidTargetName(content) {
+			return fmt.Errorf("geçersiz hostname")
+		}
+	case "NS":
 		if !isValidHostname(content) {
 			return fmt.Errorf("geçersiz hostname")
 		}
-	case "TXT":
-		// TXT records can contain almost anything
-		if len(content) > 255 {
-			return fmt.Errorf("TXT kaydı 255 karakterden uzun olamaz")
+	case "MX":
+		// "." with priority 0 is a null MX (RFC 7505)
+		if content == "." {
+			if priority != 0 {
+				return fmt.Errorf("null MX (.) kaydının önceliği 0 olmalı")
+			}
+		} else if !isValidHostname(content) {
+			return fmt.Errorf("geçersiz hostname")
 		}
+	case "TXT":
+		return validateTXT(content)
 	case "SRV":
-		// Format: weight port target
-		parts := strings.Fields(content)
-		if len(parts) < 3 {
-			return fmt.Errorf("SRV kaydı formatı: weight port target")
-		}
+		return validateSRV(name, content)
 	case "CAA":
-		// Format: flags tag value
-		parts := strings.Fields(content)
-		if len(parts) < 3 {
-			return fmt.Errorf("CAA kaydı formatı: flags tag value")
-		}
+		return validateCAA(content)
+	case "DS":
+		return validateDS(name, content)
+	case "TLSA":
+		return validateTLSA(name, content)
+	case "SSHFP":
+		return validateSSHFP(content)
+	case "NAPTR":
+		return validateNAPTR(content)
+	case "HTTPS", "SVCB":
+		return validateSVCB(recordType, priority, content)
 	}
 
 	return nil
@@ -0,0 +1,499 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTXTLength bounds the value of a TXT record. Longer values than 255
+// characters are split into several strings in the zone; 2048 leaves room
+// for 4096-bit DKIM keys while the answer still fits a UDP response.
+const maxTXTLength = 2048
+
+var (
+	dnsLabelPattern = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_\-]{0,61}[a-z0-9_])?$`)
+	caaTagPattern   = regexp.MustCompile(`^[a-zA-Z0-9]{1,15}$`)
+	naptrFlags      = regexp.MustCompile(`^[a-zA-Z0-9]*$`)
+	svcKeyPattern   = regexp.MustCompile(`^key[0-9]{1,5}$`)
+)
+
+// svcParamKeys are the SvcParamKeys of HTTPS and SVCB records (RFC 9460)
+var svcParamKeys = []string{"mandatory", "alpn", "no-default-alpn", "port", "ipv4hint", "ech", "ipv6hint"}
+
+// sanitizeDNSContent normalizes the content of a record before it is
+// validated and stored: TXT values given as quoted strings are joined into
+// one value (the zone file splits them again), hex data split over several
+// fields is joined and CAA values are quoted.
+func sanitizeDNSContent(recordType, content string) string {
+	content = strings.TrimSpace(content)
+
+	switch recordType {
+	case "TXT":
+		if strings.HasPrefix(content, "\"") {
+			if strs, err := dnsFields(content); err == nil && allQuoted(strs) {
+				var sb strings.Builder
+				for _, s := range strs {
+					sb.WriteString(unquoteDNSString(s))
+				}
+				return sb.String()
+			}
+		}
+	case "CNAME", "NS", "MX", "PTR":
+		return strings.ToLower(content)
+	case "DS", "TLSA", "SSHFP":
+		// Fixed numeric fields followed by hex data, which may be split
+		fixed := map[string]int{"DS": 3, "TLSA": 3, "SSHFP": 2}[recordType]
+		fields := strings.Fields(content)
+		if len(fields) > fixed+1 {
+			fields = append(fields[:fixed], strings.Join(fields[fixed:], ""))
+		}
+		return strings.Join(fields, " ")
+	case "CAA":
+		fields := strings.SplitN(content, " ", 3)
+		if len(fields) == 3 {
+			value := strings.TrimSpace(fields[2])
+			if !strings.HasPrefix(value, "\"") {
+				value = "\"" + value + "\""
+			}
+			return fields[0] + " " + strings.ToLower(fields[1]) + " " + value
+		}
+	}
+	return content
+}
+
+// isValidRecordName checks the owner name of a record relative to the zone:
+// "@", or labels of letters, digits, "-" and "_", optionally below a
+// leading "*" wildcard label
+func isValidRecordName(name string) bool {
+	if name == "@" {
+		return true
+	}
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for i, label := range strings.Split(name, ".") {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if !dnsLabelPattern.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidTargetName checks a name a record points at. Unlike hostnames,
+// CNAME and PTR targets may have underscore labels (_domainkey).
+func isValidTargetName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !dnsLabelPattern.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// isServiceName reports whether name starts with _service._proto labels
+func isServiceName(name string) bool {
+	labels := strings.Split(name, ".")
+	return len(labels) >= 2 && len(labels[0]) > 1 && len(labels[1]) > 1 &&
+		strings.HasPrefix(labels[0], "_") && strings.HasPrefix(labels[1], "_")
+}
+
+// parseUint parses a decimal field of the given bit size
+func parseUint(field string, bits int) (uint64, bool) {
+	v, err := strconv.ParseUint(field, 10, bits)
+	return v, err == nil
+}
+
+// isHexData reports whether data is hex of the given length (any even
+// length when size is 0)
+func isHexData(data string, size int) bool {
+	if _, err := hex.DecodeString(data); err != nil || data == "" {
+		return false
+	}
+	return size == 0 || len(data) == size
+}
+
+func validateTXT(content string) error {
+	if len(content) > maxTXTLength {
+		return fmt.Errorf("TXT kaydı %d karakterden uzun olamaz", maxTXTLength)
+	}
+	for _, ch := range content {
+		if ch < 0x20 || ch == 0x7f {
+			return fmt.Errorf("TXT kaydı kontrol karakteri içeremez")
+		}
+	}
+	return nil
+}
+
+// validateSRV checks "weight port target"; the priority has its own field
+func validateSRV(name, content string) error {
+	if !isServiceName(name) {
+		return fmt.Errorf("SRV kaydı adı _servis._protokol biçiminde olmalı (örn. _sip._tcp)")
+	}
+	parts := strings.Fields(content)
+	if len(parts) != 3 {
+		return fmt.Errorf("SRV kaydı formatı: weight port target")
+	}
+	if _, ok := parseUint(parts[0], 16); !ok {
+		return fmt.Errorf("SRV weight 0-65535 arasında olmalı")
+	}
+	if _, ok := parseUint(parts[1], 16); !ok {
+		return fmt.Errorf("SRV port 0-65535 arasında olmalı")
+	}
+	if parts[2] != "." && !isValidHostname(parts[2]) {
+		return fmt.Errorf("geçersiz SRV hedefi")
+	}
+	return nil
+}
+
+// validateCAA checks "flags tag value" (RFC 8659)
+func validateCAA(content string) error {
+	parts := strings.SplitN(content, " ", 3)
+	if len(parts) < 3 {
+		return fmt.Errorf("CAA kaydı formatı: flags tag value")
+	}
+	if _, ok := parseUint(parts[0], 8); !ok {
+		return fmt.Errorf("CAA flags 0-255 arasında olmalı")
+	}
+	tag := strings.ToLower(parts[1])
+	if !caaTagPattern.MatchString(tag) {
+		return fmt.Errorf("geçersiz CAA etiketi")
+	}
+	value := unquoteDNSString(strings.TrimSpace(parts[2]))
+
+	switch tag {
+	case "issue", "issuewild", "issuemail":
+		// "ca.example; key=value" or ";" to forbid issuance
+		domain := strings.TrimSpace(strings.SplitN(value, ";", 2)[0])
+		if domain != "" && !isValidHostname(domain) {
+			return fmt.Errorf("geçersiz CAA sertifika sağlayıcısı: %s", domain)
+		}
+	case "iodef":
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "mailto" && u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("CAA iodef değeri mailto: veya http(s):// adresi olmalı")
+		}
+	}
+	return nil
+}
+
+// validateDS checks "keytag algorithm digesttype digest" (RFC 4034)
+func validateDS(name, content string) error {
+	if name == "@" {
+		return fmt.Errorf("DS kaydı zone kökünde olamaz; üst zone'a eklenmelidir")
+	}
+	parts := strings.Fields(content)
+	if len(parts) != 4 {
+		return fmt.Errorf("DS kaydı formatı: keytag algorithm digesttype digest")
+	}
+	if _, ok := parseUint(parts[0], 16); !ok {
+		return fmt.Errorf("DS keytag 0-65535 arasında olmalı")
+	}
+	if alg, ok := parseUint(parts[1], 8); !ok || alg == 0 {
+		return fmt.Errorf("geçersiz DS algoritması")
+	}
+	sizes := map[string]int{"1": 40, "2": 64, "4": 96}
+	size, ok := sizes[parts[2]]
+	if !ok {
+		return fmt.Errorf("DS digest tipi 1 (SHA-1), 2 (SHA-256) veya 4 (SHA-384) olmalı")
+	}
+	if !isHexData(parts[3], size) {
+		return fmt.Errorf("DS digest %d karakterlik hex olmalı", size)
+	}
+	return nil
+}
+
+// validateTLSA checks "usage selector matchingtype data" (RFC 6698) on a
+// _port._proto name
+func validateTLSA(name, content string) error {
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 || !strings.HasPrefix(labels[0], "_") {
+		return fmt.Errorf("TLSA kaydı adı _port._protokol biçiminde olmalı (örn. _443._tcp)")
+	}
+	if _, ok := parseUint(strings.TrimPrefix(labels[0], "_"), 16); !ok {
+		return fmt.Errorf("TLSA kaydı adı _port._protokol biçiminde olmalı (örn. _443._tcp)")
+	}
+	if labels[1] != "_tcp" && labels[1] != "_udp" && labels[1] != "_sctp" {
+		return fmt.Errorf("TLSA protokolü _tcp, _udp veya _sctp olmalı")
+	}
+
+	parts := strings.Fields(content)
+	if len(parts) != 4 {
+		return fmt.Errorf("TLSA kaydı formatı: usage selector matchingtype data")
+	}
+	if usage, ok := parseUint(parts[0], 8); !ok || usage > 3 {
+		return fmt.Errorf("TLSA usage 0-3 arasında olmalı")
+	}
+	if selector, ok := parseUint(parts[1], 8); !ok || selector > 1 {
+		return fmt.Errorf("TLSA selector 0 veya 1 olmalı")
+	}
+	sizes := map[string]int{"0": 0, "1": 64, "2": 128}
+	size, ok := sizes[parts[2]]
+	if !ok {
+		return fmt.Errorf("TLSA matching type 0-2 arasında olmalı")
+	}
+	if !isHexData(parts[3], size) {
+		if size == 0 {
+			return fmt.Errorf("TLSA verisi hex olmalı")
+		}
+		return fmt.Errorf("TLSA verisi %d karakterlik hex olmalı", size)
+	}
+	return nil
+}
+
+// validateSSHFP checks "algorithm fptype fingerprint" (RFC 4255)
+func validateSSHFP(content string) error {
+	parts := strings.Fields(content)
+	if len(parts) != 3 {
+		return fmt.Errorf("SSHFP kaydı formatı: algorithm fptype fingerprint")
+	}
+	switch parts[0] {
+	case "1", "2", "3", "4", "6":
+	default:
+		return fmt.Errorf("SSHFP algoritması 1 (RSA), 2 (DSA), 3 (ECDSA), 4 (Ed25519) veya 6 (Ed448) olmalı")
+	}
+	sizes := map[string]int{"1": 40, "2": 64}
+	size, ok := sizes[parts[1]]
+	if !ok {
+		return fmt.Errorf("SSHFP parmak izi tipi 1 (SHA-1) veya 2 (SHA-256) olmalı")
+	}
+	if !isHexData(parts[2], size) {
+		return fmt.Errorf("SSHFP parmak izi %d karakterlik hex olmalı", size)
+	}
+	return nil
+}
+
+// validateNAPTR checks "order preference flags service regexp replacement"
+// (RFC 3403)
+func validateNAPTR(content string) error {
+	parts, err := dnsFields(content)
+	if err != nil || len(parts) != 6 {
+		return fmt.Errorf(`NAPTR kaydı formatı: order preference "flags" "service" "regexp" replacement`)
+	}
+	if _, ok := parseUint(parts[0], 16); !ok {
+		return fmt.Errorf("NAPTR order 0-65535 arasında olmalı")
+	}
+	if _, ok := parseUint(parts[1], 16); !ok {
+		return fmt.Errorf("NAPTR preference 0-65535 arasında olmalı")
+	}
+	if !allQuoted(parts[2:5]) {
+		return fmt.Errorf("NAPTR flags, service ve regexp tırnak içinde olmalı")
+	}
+	if !naptrFlags.MatchString(unquoteDNSString(parts[2])) {
+		return fmt.Errorf("NAPTR flags yalnızca harf ve rakam içerebilir")
+	}
+	regex := unquoteDNSString(parts[4])
+	if parts[5] != "." && !isValidTargetName(parts[5]) {
+		return fmt.Errorf("geçersiz NAPTR replacement")
+	}
+	if regex != "" && parts[5] != "." {
+		return fmt.Errorf("NAPTR regexp ve replacement birlikte kullanılamaz; replacement \".\" olmalı")
+	}
+	return nil
+}
+
+// validateSVCB checks "target [key=value ...]" of HTTPS and SVCB records
+// (RFC 9460); the SvcPriority is in the priority field and 0 is the alias
+// form, which takes no parameters
+func validateSVCB(recordType string, priority int, content string) error {
+	parts, err := dnsFields(content)
+	if err != nil || len(parts) == 0 {
+		return fmt.Errorf("%s kaydı formatı: target [key=value ...]", recordType)
+	}
+	if parts[0] != "." && !isValidTargetName(parts[0]) {
+		return fmt.Errorf("geçersiz %s hedefi", recordType)
+	}
+	params := parts[1:]
+	if priority == 0 && len(params) > 0 {
+		return fmt.Errorf("önceliği 0 olan %s kaydı (alias) parametre alamaz", recordType)
+	}
+
+	seen := map[string]bool{}
+	var mandatory []string
+	for _, param := range params {
+		key, value, hasValue := strings.Cut(param, "=")
+		value = unquoteDNSString(value)
+		if seen[key] {
+			return fmt.Errorf("%s parametresi birden fazla kez kullanılamaz", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "mandatory":
+			mandatory = strings.Split(value, ",")
+			for _, k := range mandatory {
+				if k == "mandatory" || (!isSvcParamKey(k) && !svcKeyPattern.MatchString(k)) {
+					return fmt.Errorf("geçersiz mandatory anahtarı: %s", k)
+				}
+			}
+		case "alpn":
+			for _, id := range strings.Split(value, ",") {
+				if id == "" || len(id) > 255 {
+					return fmt.Errorf("geçersiz alpn değeri")
+				}
+			}
+		case "no-default-alpn":
+			if hasValue {
+				return fmt.Errorf("no-default-alpn değer almaz")
+			}
+		case "port":
+			if _, ok := parseUint(value, 16); !ok {
+				return fmt.Errorf("port 0-65535 arasında olmalı")
+			}
+		case "ipv4hint":
+			for _, addr := range strings.Split(value, ",") {
+				if ip := net.ParseIP(addr); ip == nil || ip.To4() == nil {
+					return fmt.Errorf("geçersiz ipv4hint adresi: %s", addr)
+				}
+			}
+		case "ipv6hint":
+			for _, addr := range strings.Split(value, ",") {
+				if ip := net.ParseIP(addr); ip == nil || ip.To4() != nil {
+					return fmt.Errorf("geçersiz ipv6hint adresi: %s", addr)
+				}
+			}
+		case "ech":
+			if _, err := base64.StdEncoding.DecodeString(value); err != nil || value == "" {
+				return fmt.Errorf("ech değeri base64 olmalı")
+			}
+		default:
+			if !svcKeyPattern.MatchString(key) {
+				return fmt.Errorf("bilinmeyen %s parametresi: %s", recordType, key)
+			}
+			if n, ok := parseUint(strings.TrimPrefix(key, "key"), 16); !ok || n == 65535 {
+				return fmt.Errorf("bilinmeyen %s parametresi: %s", recordType, key)
+			}
+		}
+	}
+	for _, k := range mandatory {
+		if !seen[k] {
+			return fmt.Errorf("mandatory listesindeki %s parametresi eksik", k)
+		}
+	}
+	if seen["no-default-alpn"] && !seen["alpn"] {
+		return fmt.Errorf("no-default-alpn için alpn parametresi gerekli")
+	}
+	return nil
+}
+
+func isSvcParamKey(key string) bool {
+	for _, k := range svcParamKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// cnameConflict checks that a CNAME is the only record of its name
+// (RFC 1034 section 3.6.2). types are the other records of the name.
+func cnameConflict(name, recordType string, types []string) error {
+	for _, t := range types {
+		if recordType == "CNAME" {
+			return fmt.Errorf("%s adında başka kayıtlar varken CNAME eklenemez", name)
+		}
+		if t == "CNAME" {
+			return fmt.Errorf("%s adında CNAME kaydı varken başka kayıt eklenemez", name)
+		}
+	}
+	return nil
+}
+
+// dnsFields splits record content into fields, keeping quoted strings
+// (with their quotes) as single fields
+func dnsFields(content string) ([]string, error) {
+	var fields []string
+	var buf strings.Builder
+	inQuote, quoted := false, false
+
+	flush := func() {
+		if buf.Len() > 0 || quoted {
+			fields = append(fields, buf.String())
+			buf.Reset()
+		}
+		quoted = false
+	}
+
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+		switch {
+		case inQuote:
+			buf.WriteByte(ch)
+			if ch == '\\' && i+1 < len(content) {
+				i++
+				buf.WriteByte(content[i])
+			} else if ch == '"' {
+				inQuote = false
+			}
+		case ch == '"':
+			inQuote, quoted = true, true
+			buf.WriteByte(ch)
+		case ch == ' ' || ch == '\t':
+			flush()
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("kapanmamış tırnak")
+	}
+	flush()
+	return fields, nil
+}
+
+// allQuoted reports whether every field is a quoted string
+func allQuoted(fields []string) bool {
+	for _, f := range fields {
+		if len(f) < 2 || f[0] != '"' || f[len(f)-1] != '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// unquoteDNSString removes the quotes and escapes of a quoted string
+func unquoteDNSString(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(s, `\"`, `"`), `\\`, `\`)
+}
+
+// quoteTXT splits a TXT value into quoted strings of at most 255 bytes,
+// without cutting a UTF-8 character in two
+func quoteTXT(content string) string {
+	var strs []string
+	for {
+		chunk := content
+		if len(chunk) > 255 {
+			n := 255
+			for n > 0 && !utf8.RuneStart(chunk[n]) {
+				n--
+			}
+			if n == 0 {
+				n = 255 // not UTF-8
+			}
+			chunk = chunk[:n]
+		}
+		content = content[len(chunk):]
+		chunk = strings.ReplaceAll(strings.ReplaceAll(chunk, `\`, `\\`), `"`, `\"`)
+		strs = append(strs, "\""+chunk+"\"")
+		if content == "" {
+			break
+		}
+	}
+	return strings.Join(strs, " ")
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestValidateDNSRecord(t *testing.T) {
+	sha256 := strings.Repeat("ab", 32)
+	tests := []struct {
+		recordType, name, content string
+		priority                  int
+		ok                        bool
+	}{
+		{"A", "@", "192.0.2.1", 0, true},
+		{"A", "www", "2001:db8::1", 0, false},
+		{"A", "www", "192.0.2", 0, false},
+		{"AAAA", "www", "2001:db8::1", 0, true},
+		{"AAAA", "www", "192.0.2.1", 0, false},
+		{"A", "*.dev", "192.0.2.1", 0, true},
+		{"A", "a.*", "192.0.2.1", 0, false},
+		{"A", "-bad", "192.0.2.1", 0, false},
+		{"A", "", "192.0.2.1", 0, false},
+		{"A", "www", "", 0, false},
+		{"CNAME", "www", "example.com.", 0, true},
+		{"CNAME", "sel._domainkey", "sel._domainkey.mail.example.net", 0, true},
+		{"CNAME", "@", "example.com", 0, false},
+		{"CNAME", "www", "exa mple.com", 0, false},
+		{"MX", "@", "mail.example.com", 10, true},
+		{"MX", "@", ".", 0, true},
+		{"MX", "@", ".", 10, false},
+		{"MX", "@", "mail.example.com", 65536, false},
+		{"MX", "@", "mail_server.example.com", 10, false},
+		{"NS", "sub", "ns1.example.com", 0, true},
+		{"TXT", "@", "v=spf1 include:_spf.example.com ~all", 0, true},
+		{"TXT", "@", "çok güzel", 0, true},
+		{"TXT", "@", "line\nbreak", 0, false},
+		{"TXT", "@", strings.Repeat("a", maxTXTLength+1), 0, false},
+		{"SRV", "_sip._tcp", "5 5060 sip.example.com", 10, true},
+		{"SRV", "_sip._tcp", "5 5060 .", 10, true},
+		{"SRV", "sip", "5 5060 sip.example.com", 10, false},
+		{"SRV", "_sip._tcp", "5 70000 sip.example.com", 10, false},
+		{"SRV", "_sip._tcp", "5060 sip.example.com", 10, false},
+		{"CAA", "@", `0 issue "letsencrypt.org"`, 0, true},
+		{"CAA", "@", `0 issue ";"`, 0, true},
+		{"CAA", "@", `0 issuewild "ca.example.net; account=1"`, 0, true},
+		{"CAA", "@", `0 iodef "mailto:security@example.com"`, 0, true},
+		{"CAA", "@", `0 iodef "ftp://example.com"`, 0, false},
+		{"CAA", "@", `256 issue "letsencrypt.org"`, 0, false},
+		{"CAA", "@", `0 is-sue "letsencrypt.org"`, 0, false},
+		{"CAA", "@", `0 issue "bad host!"`, 0, false},
+		{"CAA", "@", `0 issue`, 0, false},
+		{"DS", "sub", "12345 13 2 " + sha256, 0, true},
+		{"DS", "@", "12345 13 2 " + sha256, 0, false},
+		{"DS", "sub", "12345 13 2 abcd", 0, false},
+		{"DS", "sub", "12345 13 3 " + sha256, 0, false},
+		{"TLSA", "_443._tcp", "3 1 1 " + sha256, 0, true},
+		{"TLSA", "_443._tcp.www", "3 1 0 3059", 0, true},
+		{"TLSA", "_443._icmp", "3 1 1 " + sha256, 0, false},
+		{"TLSA", "www", "3 1 1 " + sha256, 0, false},
+		{"TLSA", "_443._tcp", "4 1 1 " + sha256, 0, false},
+		{"SSHFP", "host", "4 2 " + sha256, 0, true},
+		{"SSHFP", "host", "5 2 " + sha256, 0, false},
+		{"SSHFP", "host", "4 2 " + sha256[:40], 0, false},
+		{"NAPTR", "@", `100 10 "S" "SIP+D2U" "" _sip._udp.example.com`, 0, true},
+		{"NAPTR", "@", `100 10 "U" "E2U+sip" "!^.*$!sip:info@example.com!" .`, 0, true},
+		{"NAPTR", "@", `100 10 "U" "E2U+sip" "!^.*$!sip:info@example.com!" example.com`, 0, false},
+		{"NAPTR", "@", `100 10 S SIP+D2U "" .`, 0, false},
+		{"HTTPS", "@", `. alpn=h2,h3 ipv4hint=192.0.2.1`, 1, true},
+		{"HTTPS", "@", `cdn.example.net.`, 0, true},
+		{"HTTPS", "@", `cdn.example.net. alpn=h2`, 0, false},
+		{"HTTPS", "@", `. alpn=h2 alpn=h3`, 1, false},
+		{"HTTPS", "@", `. ipv4hint=2001:db8::1`, 1, false},
+		{"HTTPS", "@", `. ipv6hint=2001:db8::1 port=8443`, 1, true},
+		{"HTTPS", "@", `. no-default-alpn`, 1, false},
+		{"HTTPS", "@", `. mandatory=alpn alpn=h2`, 1, true},
+		{"HTTPS", "@", `. mandatory=port alpn=h2`, 1, false},
+		{"SVCB", "_dns", `dns.example.net. alpn=dot key7=abc`, 1, true},
+		{"SVCB", "_dns", `dns.example.net. key65535=abc`, 1, false},
+		{"SVCB", "_dns", `dns.example.net. color=blue`, 1, false},
+		{"SVCB", "_dns", `dns.example.net. ech=!!!`, 1, false},
+	}
+	for _, tt := range tests {
+		content := sanitizeDNSContent(tt.recordType, tt.content)
+		err := validateDNSRecord(tt.recordType, tt.name, content, tt.priority)
+		if tt.ok && err != nil {
+			t.Errorf("%s %s %q: %v", tt.recordType, tt.name, tt.content, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s %s %q was accepted", tt.recordType, tt.name, tt.content)
+		}
+	}
+}
+
+func TestSanitizeDNSContent(t *testing.T) {
+	tests := []struct {
+		recordType, content, want string
+	}{
+		{"TXT", `  v=spf1 -all  `, "v=spf1 -all"},
+		{"TXT", `"v=DKIM1; k=rsa; " "p=MIGf"`, "v=DKIM1; k=rsa; p=MIGf"},
+		{"TXT", `"say \"hi\" \\o/"`, `say "hi" \o/`},
+		{"TXT", `"unterminated`, `"unterminated`},
+		{"TXT", `"a" b`, `"a" b`},
+		{"CNAME", "WWW.Example.COM.", "www.example.com."},
+		{"MX", "Mail.Example.com", "mail.example.com"},
+		{"DS", "12345 13 2 ABCD EF01", "12345 13 2 ABCDEF01"},
+		{"TLSA", "3  1 1 ab cd", "3 1 1 abcd"},
+		{"SSHFP", "4 2 ab cd ef", "4 2 abcdef"},
+		{"CAA", "0 ISSUE letsencrypt.org", `0 issue "letsencrypt.org"`},
+		{"CAA", `0 issue "letsencrypt.org"`, `0 issue "letsencrypt.org"`},
+		{"A", " 192.0.2.1 ", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeDNSContent(tt.recordType, tt.content); got != tt.want {
+			t.Errorf("sanitizeDNSContent(%s, %q) = %q, want %q", tt.recordType, tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestCNAMEConflict(t *testing.T) {
+	tests := []struct {
+		recordType string
+		types      []string
+		ok         bool
+	}{
+		{"CNAME", nil, true},
+		{"A", []string{"A", "TXT"}, true},
+		{"CNAME", []string{"TXT"}, false},
+		{"CNAME", []string{"CNAME"}, false},
+		{"A", []string{"CNAME"}, false},
+		{"TXT", []string{"A", "CNAME"}, false},
+	}
+	for _, tt := range tests {
+		err := cnameConflict("www", tt.recordType, tt.types)
+		if (err == nil) != tt.ok {
+			t.Errorf("cnameConflict(%s, %v) = %v, want ok=%v", tt.recordType, tt.types, err, tt.ok)
+		}
+	}
+}
+
+func TestQuoteTXT(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		strings int
+	}{
+		{"short", "v=spf1 -all", 1},
+		{"quotes and backslashes", `say "hi" \o/`, 1},
+		{"exactly 255 bytes", strings.Repeat("a", 255), 1},
+		{"256 bytes", strings.Repeat("a", 256), 2},
+		{"DKIM key", "v=DKIM1; k=rsa; p=" + strings.Repeat("M", 700), 3},
+		// 2-byte characters: byte 255 falls inside "ş"
+		{"non-ASCII", strings.Repeat("ş", 200), 2},
+		// 3 and 4 byte characters at every offset
+		{"mixed width", strings.Repeat("a€😀", 60), 2},
+	}
+	for _, tt := range tests {
+		quoted := quoteTXT(tt.content)
+		fields, err := dnsFields(quoted)
+		if err != nil || !allQuoted(fields) {
+			t.Errorf("%s: %q does not split into quoted strings: %v", tt.name, quoted, err)
+			continue
+		}
+		if len(fields) != tt.strings {
+			t.Errorf("%s: %d strings, want %d", tt.name, len(fields), tt.strings)
+		}
+		for _, f := range fields {
+			s := unquoteDNSString(f)
+			if len(s) > 255 {
+				t.Errorf("%s: string of %d bytes", tt.name, len(s))
+			}
+			if !utf8.ValidString(s) {
+				t.Errorf("%s: string cuts a character: %q", tt.name, s)
+			}
+		}
+		// The zone value reads back as the stored one
+		if got := sanitizeDNSContent("TXT", quoted); got != tt.content {
+			t.Errorf("%s: reads back as %q", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/services/dns"
+	"github.com/gofiber/fiber/v2"
+)
+
+// zoneRecordChange is an imported record replacing the TTL of an existing one
+type zoneRecordChange struct {
+	ID     int64          `json:"id"`
+	Record dns.ZoneRecord `json:"record"`
+	OldTTL int            `json:"old_ttl"`
+}
+
+// zoneRecordSkip is an imported record that can not be stored
+type zoneRecordSkip struct {
+	Record dns.ZoneRecord `json:"record"`
+	Reason string         `json:"reason"`
+}
+
+// zoneImport is a zone file compared with the records of a domain
+type zoneImport struct {
+	Mode      string             `json:"mode"`
+	SOA       *dns.SOARecord     `json:"soa,omitempty"`
+	Add       []dns.ZoneRecord   `json:"add"`
+	Update    []zoneRecordChange `json:"update"`
+	Remove    []DNSRecord        `json:"remove"`
+	Unchanged int                `json:"unchanged"`
+	Skipped   []zoneRecordSkip   `json:"skipped"`
+	Warnings  []string           `json:"warnings"`
+}
+
+// ExportDNSZone downloads the zone file of a domain
+func (h *Handler) ExportDNSZone(c *fiber.Ctx) error {
+	domainID, domainName, err := h.managedZone(c)
+	if domainID == 0 {
+		return err
+	}
+
+	records, err := h.zoneFileRecords(domainName)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+
+	c.Set("Content-Type", "text/plain; charset=utf-8")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"db.%s\"", domainName))
+	return c.SendString(h.generateZoneFileContent(domainName, records))
+}
+
+// PreviewDNSZoneImport shows what importing a zone file would change
+func (h *Handler) PreviewDNSZoneImport(c *fiber.Ctx) error {
+	domainID, domainName, err := h.managedZone(c)
+	if domainID == 0 {
+		return err
+	}
+
+	diff, err := h.zoneImportRequest(c, domainID, domainName)
+	if diff == nil {
+		return err
+	}
+	return c.JSON(diff)
+}
+
+// ImportDNSZone replaces (or with mode "merge", extends) the records of a
+// domain with the ones of a BIND zone file
+func (h *Handler) ImportDNSZone(c *fiber.Ctx) error {
+	domainID, domainName, err := h.managedZone(c)
+	if domainID == 0 {
+		return err
+	}
+
+	diff, err := h.zoneImportRequest(c, domainID, domainName)
+	if diff == nil {
+		return err
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	defer tx.Rollback()
+
+	for _, r := range diff.Remove {
+		if _, err := tx.Exec("DELETE FROM dns_records WHERE id = ?", r.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Kayıtlar silinemedi"})
+		}
+	}
+	for _, u := range diff.Update {
+		if _, err := tx.Exec("UPDATE dns_records SET ttl = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", u.Record.TTL, u.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Kayıtlar güncellenemedi"})
+		}
+	}
+	for _, r := range diff.Add {
+		_, err := tx.Exec(`
+			INSERT INTO dns_records (domain_id, name, type, content, ttl, priority, active)
+			VALUES (?, ?, ?, ?, ?, ?, 1)
+		`, domainID, r.Name, r.Type, r.Content, r.TTL, r.Priority)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Kayıt oluşturulamadı"})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+
+	if err := h.updateZoneFile(domainName); err != nil {
+		log.Printf("Warning: Could not update zone file: %v", err)
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "dns_import",
+		fmt.Sprintf("Imported zone file for %s (%d added, %d updated, %d removed)", domainName, len(diff.Add), len(diff.Update), len(diff.Remove)),
+		c.IP())
+
+	return c.JSON(fiber.Map{
+		"message": "Zone dosyası içe aktarıldı",
+		"import":  diff,
+	})
+}
+
+// zoneImportRequest parses the zone file of the request and compares it with
+// the records of the domain; otherwise the error response has been sent and
+// the result is nil
+func (h *Handler) zoneImportRequest(c *fiber.Ctx, domainID int64, domainName string) (*zoneImport, error) {
+	var req struct {
+		Zone string `json:"zone"`
+		Mode string `json:"mode"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Geçersiz istek"})
+	}
+	if req.Mode == "" {
+		req.Mode = "replace"
+	}
+	if req.Mode != "replace" && req.Mode != "merge" {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Mod replace veya merge olmalı"})
+	}
+	if strings.TrimSpace(req.Zone) == "" {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Zone dosyası boş olamaz"})
+	}
+
+	parsed, err := dns.ParseZone(req.Zone, domainName)
+	if err != nil {
+		return nil, c.Status(400).JSON(fiber.Map{"error": "Zone dosyası okunamadı: " + err.Error()})
+	}
+
+	diff, err := h.diffZoneImport(domainID, parsed, req.Mode == "replace")
+	if err != nil {
+		return nil, c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	diff.Mode = req.Mode
+	if len(diff.Add)+len(diff.Update)+diff.Unchanged == 0 {
+		return nil, c.Status(400).JSON(fiber.Map{
+			"error":  "Zone dosyasında içe aktarılabilecek kayıt yok",
+			"import": diff,
+		})
+	}
+	return diff, nil
+}
+
+// diffZoneImport compares the records of a parsed zone file with the ones
+// of a domain. Records match on name, type, content and priority; a
+// different TTL is an update. With replace the records missing from the
+// file are removed, except the apex NS records when the file has none.
+func (h *Handler) diffZoneImport(domainID int64, parsed *dns.ParsedZone, replace bool) (*zoneImport, error) {
+	rows, err := h.db.Query(`
+		SELECT id, domain_id, name, type, content, ttl, priority, active, created_at, updated_at
+		FROM dns_records
+		WHERE domain_id = ?
+		ORDER BY type, name
+	`, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var current []DNSRecord
+	existing := map[string]int{}
+	for rows.Next() {
+		var r DNSRecord
+		var active int
+		if err := rows.Scan(&r.ID, &r.DomainID, &r.Name, &r.Type, &r.Content, &r.TTL, &r.Priority, &active, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			continue
+		}
+		r.Active = active == 1
+		existing[zoneRecordKey(r.Name, r.Type, r.Content, r.Priority)] = len(current)
+		current = append(current, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	diff := &zoneImport{
+		SOA:      parsed.SOA,
+		Add:      []dns.ZoneRecord{},
+		Update:   []zoneRecordChange{},
+		Remove:   []DNSRecord{},
+		Skipped:  []zoneRecordSkip{},
+		Warnings: parsed.Warnings,
+	}
+	if diff.Warnings == nil {
+		diff.Warnings = []string{}
+	}
+	if parsed.SOA != nil {
+		diff.Warnings = append(diff.Warnings, "SOA kaydı içe aktarılmadı; panel kendi SOA kaydını kullanır")
+	}
+
+	kept := make([]bool, len(current))
+	seen := map[string]bool{}
+	apexNS := false
+	var adds []dns.ZoneRecord
+	for _, r := range parsed.Records {
+		r.Name = sanitizeDNSName(r.Name)
+		if !isValidRecordType(r.Type) {
+			diff.Skipped = append(diff.Skipped, zoneRecordSkip{Record: r, Reason: "desteklenmeyen kayıt tipi"})
+			continue
+		}
+		r.Content = sanitizeDNSContent(r.Type, r.Content)
+		if err := validateDNSRecord(r.Type, r.Name, r.Content, r.Priority); err != nil {
+			diff.Skipped = append(diff.Skipped, zoneRecordSkip{Record: r, Reason: err.Error()})
+			continue
+		}
+		if r.TTL <= 0 {
+			r.TTL = 3600
+		}
+
+		key := zoneRecordKey(r.Name, r.Type, r.Content, r.Priority)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if r.Type == "NS" && r.Name == "@" {
+			apexNS = true
+		}
+
+		i, ok := existing[key]
+		switch {
+		case !ok:
+			adds = append(adds, r)
+			continue
+		case current[i].TTL != r.TTL:
+			diff.Update = append(diff.Update, zoneRecordChange{ID: current[i].ID, Record: r, OldTTL: current[i].TTL})
+		default:
+			diff.Unchanged++
+		}
+		kept[i] = true
+	}
+
+	// A CNAME can not share its name with the records that stay or with
+	// the ones added before it
+	types := map[string][]string{}
+	for i, r := range current {
+		if replace && !kept[i] && !(r.Type == "NS" && r.Name == "@" && !apexNS) {
+			diff.Remove = append(diff.Remove, r)
+			continue
+		}
+		if r.Active {
+			types[r.Name] = append(types[r.Name], r.Type)
+		}
+	}
+	for _, r := range adds {
+		if err := cnameConflict(r.Name, r.Type, types[r.Name]); err != nil {
+			diff.Skipped = append(diff.Skipped, zoneRecordSkip{Record: r, Reason: err.Error()})
+			continue
+		}
+		types[r.Name] = append(types[r.Name], r.Type)
+		diff.Add = append(diff.Add, r)
+	}
+	return diff, nil
+}
+
+// zoneRecordKey identifies a record when comparing zones. Content compares
+// sanitized, so hostnames match case-insensitively with or without the
+// trailing dot and TXT values with or without quotes.
+func zoneRecordKey(name, recordType, content string, priority int) string {
+	content = sanitizeDNSContent(recordType, content)
+	switch recordType {
+	case "CNAME", "NS", "MX", "PTR", "SRV":
+		content = strings.TrimSuffix(strings.ToLower(content), ".")
+	}
+	switch recordType {
+	case "MX", "SRV", "HTTPS", "SVCB":
+	default:
+		priority = 0
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%d", sanitizeDNSName(name), recordType, content, priority)
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+
+	"github.com/asergenalkan/serverpanel/internal/services/dns"
+	"github.com/gofiber/fiber/v2"
+)
+
+// managedZone returns the ID and name of the zone in :id if the caller owns
+// it; otherwise the error response has been sent and the ID is 0
+func (h *Handler) managedZone(c *fiber.Ctx) (int64, string, error) {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+	domainID, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, "", c.Status(400).JSON(fiber.Map{"error": "Geçersiz domain ID"})
+	}
+
+	var name string
+	var ownerID int64
+	err = h.db.QueryRow("SELECT name, user_id FROM domains WHERE id = ?", domainID).Scan(&name, &ownerID)
+	if err == sql.ErrNoRows {
+		return 0, "", c.Status(404).JSON(fiber.Map{"error": "Domain bulunamadı"})
+	}
+	if err != nil {
+		return 0, "", c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	if role != "admin" && ownerID != userID {
+		return 0, "", c.Status(403).JSON(fiber.Map{"error": "Bu domain'e erişim yetkiniz yok"})
+	}
+	return domainID, name, nil
+}
+
+// dnssecStatus responds with the keys and DS records of a zone
+func (h *Handler) dnssecStatus(c *fiber.Ctx, domainID int64, message string) error {
+	svc := dns.NewService(h.db)
+	keys, err := svc.Keys(domainID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+	ds, err := svc.DSRecords(domainID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Veritabanı hatası"})
+	}
+
+	resp := fiber.Map{
+		"enabled":    len(keys) > 0,
+		"keys":       keys,
+		"ds_records": ds,
+	}
+	if message != "" {
+		resp["message"] = message
+	}
+	return c.JSON(resp)
+}
+
+// GetDNSSEC returns the DNSSEC keys of a zone and the DS records to submit
+// to the registrar
+func (h *Handler) GetDNSSEC(c *fiber.Ctx) error {
+	domainID, _, err := h.managedZone(c)
+	if domainID == 0 {
+		return err
+	}
+	return h.dnssecStatus(c, domainID, "")
+}
+
+// EnableDNSSEC creates the keys of a zone and signs it
+func (h *Handler) EnableDNSSEC(c *fiber.Ctx) error {
+	domainID, domainName, err := h.managedZone(c)
+	if domainID == 0 {
+		return err
+	}
+
+	svc := dns.NewService(h.db)
+	if _, err := svc.Enable(domainID); err != nil {
+		switch {
+		case errors.Is(err, dns.ErrDNSSECEnabled):
+			return c.Status(409).JSON(fiber.Map{"error": "DNSSEC bu zone için zaten etkin"})
+		case errors.Is(err, dns.ErrDNSSECNative):
+			return c.Status(409).JSON(fiber.Map{"error": "Dahili DNS sunucusu DNSSEC desteklemiyor"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := h.updateZoneFile(domainName); err != nil {
+		// Leave the zone unsigned rather than half-signed
+		svc.Disable(domainID)
+		return c.Status(500).JSON(fiber.Map{"error": "Zone imzalanamadı: " + err.Error()})
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "dnssec", "Enabled DNSSEC for "+domainName, c.IP())
+	return h.dnssecStatus(c, domainID, "DNSSEC etkinleştirildi; DS kaydını domain kayıt firmanıza girin")
+}
+
+// DisableDNSSEC removes the keys of a zone and serves it unsigned
+func (h *Handler) DisableDNSSEC(c *fiber.Ctx) error {
+	domainID, domainName, err := h.managedZone(c)
+	if domainID == 0 {
+		return err
+	}
+
+	if err := dns.NewService(h.db).Disable(domainID); err != nil {
+		if errors.Is(err, dns.ErrDNSSECDisabled) {
+			return c.Status(409).JSON(fiber.Map{"error": "DNSSEC bu zone için etkin değil"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.updateZoneFile(domainName); err != nil {
+		log.Printf("Warning: Could not update zone file: %v", err)
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "dnssec", "Disabled DNSSEC for "+domainName, c.IP())
+	return c.JSON(fiber.Map{"message": "DNSSEC devre dışı bırakıldı"})
+}
+
+// RolloverDNSSECZSK starts a ZSK rollover of a zone ahead of schedule
+func (h *Handler) RolloverDNSSECZSK(c *fiber.Ctx) error {
+	domainID, domainName, err := h.managedZone(c)
+	if domainID == 0 {
+		return err
+	}
+
+	if _, err := dns.NewService(h.db).RolloverZSK(domainID); err != nil {
+		switch {
+		case errors.Is(err, dns.ErrDNSSECDisabled):
+			return c.Status(409).JSON(fiber.Map{"error": "DNSSEC bu zone için etkin değil"})
+		case errors.Is(err, dns.ErrRolloverPending):
+			return c.Status(409).JSON(fiber.Map{"error": "ZSK değişimi zaten sürüyor"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := h.updateZoneFile(domainName); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Zone imzalanamadı: " + err.Error()})
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "dnssec", "Started ZSK rollover for "+domainName, c.IP())
+	return h.dnssecStatus(c, domainID, "ZSK değişimi başlatıldı")
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/asergenalkan/serverpanel/internal/config"
 	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
 	dnsService "github.com/asergenalkan/serverpanel/internal/services/dns"
 	"github.com/gofiber/fiber/v2"
 )
@@ -211,6 +212,14 @@ func (h *Handler) CreateDomain(c *fiber.Ctx) error {
 		})
 	}
 
+	// A domain served for another server of the DNS cluster belongs there
+	if peer, ok := dnsService.NewService(h.db).ClusterOwner(req.Name); ok {
+		return c.Status(fiber.StatusConflict).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Bu domain DNS kümesindeki başka bir sunucuda kayıtlı: " + peer,
+		})
+	}
+
 	// Set document root
 	if req.DocumentRoot == "" {
 		req.DocumentRoot = fmt.Sprintf("/home/%s/public_html/%s", username, req.Name)
@@ -416,8 +425,14 @@ func (h *Handler) DeleteDomain(c *fiber.Ctx) error {
 		})
 	}
 
+	h.db.Exec("DELETE FROM vhost_directives WHERE name = ? OR name LIKE ?", domainName, "%."+domainName)
+
 	// Remove system resources
 	go h.removeDomainResources(username, domainName)
+	h.syncDNSCluster(domainName, dnsService.ClusterDelete)
+
+	// Remove the PHP-FPM pool of the domain, if it had its own
+	go account.NewService(h.db).PrunePHPPools(domainUserID)
 
 	// Delete document root if requested
 	if deleteFiles && documentRoot != "" {
@@ -651,6 +666,8 @@ func (h *Handler) DeleteSubdomain(c *fiber.Ctx) error {
 		})
 	}
 
+	h.db.Exec("DELETE FROM vhost_directives WHERE name = ?", fullName)
+
 	// Remove system resources
 	go h.removeSubdomainResources(username, fullName)
 
@@ -716,88 +733,24 @@ func (h *Handler) createDomainResources(username, domain, documentRoot string) {
 		exec.Command("chown", "-R", fmt.Sprintf("%s:%s", username, username), documentRoot).Run()
 	}
 
-	// Create self-signed SSL certificate for immediate HTTPS support (Cloudflare compatible)
-	sslDir := fmt.Sprintf("/etc/ssl/serverpanel/%s", domain)
-	if err := os.MkdirAll(sslDir, 0755); err != nil {
-		log.Printf("⚠️ SSL dizini oluşturulamadı: %v", err)
-	}
-
-	certPath := filepath.Join(sslDir, "cert.pem")
-	keyPath := filepath.Join(sslDir, "key.pem")
-
-	// Generate self-signed certificate (valid for 10 years)
-	opensslCmd := exec.Command("openssl", "req", "-x509", "-nodes", "-days", "3650",
-		"-newkey", "rsa:2048",
-		"-keyout", keyPath,
-		"-out", certPath,
-		"-subj", fmt.Sprintf("/CN=%s/O=ServerPanel/C=TR", domain),
-		"-addext", fmt.Sprintf("subjectAltName=DNS:%s,DNS:www.%s", domain, domain),
-	)
-	if err := opensslCmd.Run(); err != nil {
+	// Create the vhost, HTTPS with a self-signed certificate (Cloudflare
+	// compatible) unless the web server obtains certificates itself; a
+	// config the web server rejects is rolled back
+	svc := account.NewService(h.db)
+	var vhostErr error
+	if h.webDriver().ManagesCertificates() {
+		vhostErr = svc.RebuildVhost(domain)
+	} else if certPath, keyPath, err := createSelfSignedCertificate(domain, "www."+domain); err != nil {
 		log.Printf("⚠️ Self-signed SSL oluşturulamadı: %v", err)
+		vhostErr = svc.RebuildVhost(domain)
 	} else {
 		log.Printf("✅ Self-signed SSL oluşturuldu: %s", domain)
+		vhostErr = svc.InstallCertificate(domain, certPath, keyPath)
 	}
-
-	// Create Apache vhost (HTTP + HTTPS)
-	vhostContent := fmt.Sprintf(`# HTTP VirtualHost
-<VirtualHost *:80>
-    ServerName %s
-    ServerAlias www.%s
-    DocumentRoot %s
-    
-    <Directory %s>
-        AllowOverride All
-        Require all granted
-    </Directory>
-    
-    <FilesMatch \.php$>
-        SetHandler "proxy:unix:/run/php/php%s-fpm.sock|fcgi://localhost"
-    </FilesMatch>
-    
-    ErrorLog ${APACHE_LOG_DIR}/%s-error.log
-    CustomLog ${APACHE_LOG_DIR}/%s-access.log combined
-</VirtualHost>
-
-# HTTPS VirtualHost (Self-Signed - Cloudflare Full SSL Compatible)
-<VirtualHost *:443>
-    ServerName %s
-    ServerAlias www.%s
-    DocumentRoot %s
-    
-    SSLEngine on
-    SSLCertificateFile %s
-    SSLCertificateKeyFile %s
-    
-    <Directory %s>
-        AllowOverride All
-        Require all granted
-    </Directory>
-    
-    <FilesMatch \.php$>
-        SetHandler "proxy:unix:/run/php/php%s-fpm.sock|fcgi://localhost"
-    </FilesMatch>
-    
-    # Security Headers
-    Header always set X-Frame-Options "SAMEORIGIN"
-    Header always set X-Content-Type-Options "nosniff"
-    
-    ErrorLog ${APACHE_LOG_DIR}/%s-ssl-error.log
-    CustomLog ${APACHE_LOG_DIR}/%s-ssl-access.log combined
-</VirtualHost>
-`, domain, domain, documentRoot, documentRoot, cfg.PHPVersion, domain, domain,
-		domain, domain, documentRoot, certPath, keyPath, documentRoot, cfg.PHPVersion, domain, domain)
-
-	vhostPath := fmt.Sprintf("/etc/apache2/sites-available/%s.conf", domain)
-	if err := os.WriteFile(vhostPath, []byte(vhostContent), 0644); err != nil {
-		log.Printf("❌ Vhost oluşturulamadı: %v", err)
+	if vhostErr != nil {
+		log.Printf("❌ Vhost oluşturulamadı: %v", vhostErr)
 	} else {
-		// Enable SSL module
-		exec.Command("a2enmod", "ssl").Run()
-		exec.Command("a2enmod", "headers").Run()
-		exec.Command("a2ensite", domain+".conf").Run()
-		exec.Command("systemctl", "reload", "apache2").Run()
-		log.Printf("✅ Apache vhost oluşturuldu (HTTP+HTTPS): %s", domain)
+		log.Printf("✅ Vhost oluşturuldu: %s", domain)
 	}
 
 	// Create DNS zone
@@ -890,64 +843,6 @@ func (h *Handler) createSubdomainResources(username, fullName, documentRoot, red
 			exec.Command("chown", "-R", fmt.Sprintf("%s:%s", username, username), documentRoot).Run()
 		}
 
-		// Create self-signed SSL for subdomain (Cloudflare compatible)
-		sslDir := fmt.Sprintf("/etc/ssl/serverpanel/%s", fullName)
-		os.MkdirAll(sslDir, 0755)
-		certPath := filepath.Join(sslDir, "cert.pem")
-		keyPath := filepath.Join(sslDir, "key.pem")
-
-		opensslCmd := exec.Command("openssl", "req", "-x509", "-nodes", "-days", "3650",
-			"-newkey", "rsa:2048",
-			"-keyout", keyPath,
-			"-out", certPath,
-			"-subj", fmt.Sprintf("/CN=%s/O=ServerPanel/C=TR", fullName),
-		)
-		if err := opensslCmd.Run(); err != nil {
-			log.Printf("⚠️ Subdomain SSL oluşturulamadı: %v", err)
-		}
-
-		vhostContent = fmt.Sprintf(`# HTTP VirtualHost
-<VirtualHost *:80>
-    ServerName %s
-    DocumentRoot %s
-    
-    <Directory %s>
-        AllowOverride All
-        Require all granted
-    </Directory>
-    
-    <FilesMatch \.php$>
-        SetHandler "proxy:unix:/run/php/php%s-fpm.sock|fcgi://localhost"
-    </FilesMatch>
-    
-    ErrorLog ${APACHE_LOG_DIR}/%s-error.log
-    CustomLog ${APACHE_LOG_DIR}/%s-access.log combined
-</VirtualHost>
-
-# HTTPS VirtualHost (Self-Signed - Cloudflare Full SSL Compatible)
-<VirtualHost *:443>
-    ServerName %s
-    DocumentRoot %s
-    
-    SSLEngine on
-    SSLCertificateFile %s
-    SSLCertificateKeyFile %s
-    
-    <Directory %s>
-        AllowOverride All
-        Require all granted
-    </Directory>
-    
-    <FilesMatch \.php$>
-        SetHandler "proxy:unix:/run/php/php%s-fpm.sock|fcgi://localhost"
-    </FilesMatch>
-    
-    ErrorLog ${APACHE_LOG_DIR}/%s-ssl-error.log
-    CustomLog ${APACHE_LOG_DIR}/%s-ssl-access.log combined
-</VirtualHost>
-`, fullName, documentRoot, documentRoot, cfg.PHPVersion, fullName, fullName,
-			fullName, documentRoot, certPath, keyPath, documentRoot, cfg.PHPVersion, fullName, fullName)
-
 		// Create welcome page (same design as main domain)
 		welcomeHTML := fmt.Sprintf(`<!DOCTYPE html>
 <html lang="tr">
@@ -999,13 +894,34 @@ func (h *Handler) createSubdomainResources(username, fullName, documentRoot, red
 		exec.Command("chown", fmt.Sprintf("%s:%s", username, username), indexPath).Run()
 	}
 
-	vhostPath := fmt.Sprintf("/etc/apache2/sites-available/%s.conf", fullName)
-	if err := os.WriteFile(vhostPath, []byte(vhostContent), 0644); err != nil {
-		log.Printf("❌ Subdomain vhost oluşturulamadı: %v", err)
+	if redirectURL != "" {
+		vhostPath := fmt.Sprintf("/etc/apache2/sites-available/%s.conf", fullName)
+		if err := os.WriteFile(vhostPath, []byte(vhostContent), 0644); err != nil {
+			log.Printf("❌ Subdomain vhost oluşturulamadı: %v", err)
+		} else {
+			exec.Command("a2ensite", fullName+".conf").Run()
+			exec.Command("systemctl", "reload", "apache2").Run()
+			log.Printf("✅ Subdomain vhost oluşturuldu: %s", fullName)
+		}
 	} else {
-		exec.Command("a2ensite", fullName+".conf").Run()
-		exec.Command("systemctl", "reload", "apache2").Run()
-		log.Printf("✅ Subdomain vhost oluşturuldu: %s", fullName)
+		// Site subdomains get the templated vhost, HTTPS with a self-signed
+		// certificate (Cloudflare compatible) unless the web server obtains
+		// certificates itself; rolled back when the web server rejects it
+		svc := account.NewService(h.db)
+		var vhostErr error
+		if h.webDriver().ManagesCertificates() {
+			vhostErr = svc.RebuildVhost(fullName)
+		} else if certPath, keyPath, err := createSelfSignedCertificate(fullName); err != nil {
+			log.Printf("⚠️ Subdomain SSL oluşturulamadı: %v", err)
+			vhostErr = svc.RebuildVhost(fullName)
+		} else {
+			vhostErr = svc.InstallCertificate(fullName, certPath, keyPath)
+		}
+		if vhostErr != nil {
+			log.Printf("❌ Subdomain vhost oluşturulamadı: %v", vhostErr)
+		} else {
+			log.Printf("✅ Subdomain vhost oluşturuldu: %s", fullName)
+		}
 	}
 
 	// Add DNS A record for subdomain
@@ -1036,3 +952,31 @@ func (h *Handler) removeSubdomainResources(username, fullName string) {
 
 	log.Printf("✅ Subdomain kaynakları silindi: %s", fullName)
 }
+
+// createSelfSignedCertificate creates a 10 year self-signed certificate for
+// a name (and extra SANs) under /etc/ssl/serverpanel/<name>
+func createSelfSignedCertificate(name string, altNames ...string) (string, string, error) {
+	sslDir := fmt.Sprintf("/etc/ssl/serverpanel/%s", name)
+	if err := os.MkdirAll(sslDir, 0755); err != nil {
+		return "", "", err
+	}
+
+	certPath := filepath.Join(sslDir, "cert.pem")
+	keyPath := filepath.Join(sslDir, "key.pem")
+
+	san := "subjectAltName=DNS:" + name
+	for _, alt := range altNames {
+		san += ",DNS:" + alt
+	}
+	opensslCmd := exec.Command("openssl", "req", "-x509", "-nodes", "-days", "3650",
+		"-newkey", "rsa:2048",
+		"-keyout", keyPath,
+		"-out", certPath,
+		"-subj", fmt.Sprintf("/CN=%s/O=ServerPanel/C=TR", name),
+		"-addext", san,
+	)
+	if output, err := opensslCmd.CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("%s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return certPath, keyPath, nil
+}
@@ -998,6 +998,24 @@ func (h *Handler) createMailbox(email, password string, quotaMB int, domain stri
 		return
 	}
 
+	// Generate Dovecot-compatible password hash using doveadm
+	out, err := exec.Command("doveadm", "pw", "-s", "BLF-CRYPT", "-p", password).Output()
+	if err != nil {
+		log.Printf("⚠️ doveadm pw hatası: %v", err)
+		return
+	}
+
+	h.provisionMailbox(email, strings.TrimSpace(string(out)), domain)
+}
+
+// provisionMailbox creates the Maildir and Postfix/Dovecot entries for a
+// mailbox whose password is already hashed in a Dovecot scheme
+func (h *Handler) provisionMailbox(email, dovecotHash, domain string) {
+	if config.IsDevelopment() {
+		log.Printf("🔧 [DEV] Mailbox oluşturulacak: %s", email)
+		return
+	}
+
 	parts := strings.Split(email, "@")
 	if len(parts) != 2 {
 		return
@@ -1033,16 +1051,6 @@ func (h *Handler) createMailbox(email, password string, quotaMB int, domain stri
 		exec.Command("postmap", virtualMailboxFile).Run()
 	}
 
-	// Generate Dovecot-compatible password hash using doveadm
-	dovecotHash := ""
-	out, err := exec.Command("doveadm", "pw", "-s", "BLF-CRYPT", "-p", password).Output()
-	if err == nil {
-		dovecotHash = strings.TrimSpace(string(out))
-	} else {
-		log.Printf("⚠️ doveadm pw hatası: %v", err)
-		return
-	}
-
 	// Add to Dovecot passwd file
 	passwdFile := "/etc/dovecot/users"
 	f, err = os.OpenFile(passwdFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
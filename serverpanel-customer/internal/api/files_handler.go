@@ -210,6 +210,15 @@ func (h *Handler) WriteFile(c *fiber.Ctx) error {
 		return c.Status(403).JSON(fiber.Map{"success": false, "error": err.Error()})
 	}
 
+	// Only the growth of the file counts against the quota
+	addBytes := int64(len(req.Content))
+	if info, err := os.Stat(fullPath); err == nil {
+		addBytes -= info.Size()
+	}
+	if err := h.checkDiskQuota(c, fullPath, addBytes); err != nil {
+		return c.Status(507).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+
 	// Create parent directories if needed
 	dir := filepath.Dir(fullPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -484,6 +493,14 @@ func (h *Handler) UploadFiles(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"success": false, "error": "No files uploaded"})
 	}
 
+	var uploadSize int64
+	for _, file := range files {
+		uploadSize += file.Size
+	}
+	if err := h.checkDiskQuota(c, destPath, uploadSize); err != nil {
+		return c.Status(507).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+
 	var uploaded []string
 	var errors []string
 
@@ -654,6 +671,14 @@ func (h *Handler) ExtractFiles(c *fiber.Ctx) error {
 	}
 	defer r.Close()
 
+	var extractSize int64
+	for _, f := range r.File {
+		extractSize += int64(f.UncompressedSize64)
+	}
+	if err := h.checkDiskQuota(c, destPath, extractSize); err != nil {
+		return c.Status(507).JSON(fiber.Map{"success": false, "error": err.Error()})
+	}
+
 	for _, f := range r.File {
 		fPath := filepath.Join(destPath, f.Name)
 
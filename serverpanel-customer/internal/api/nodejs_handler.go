@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,6 +15,7 @@ import (
 	"github.com/asergenalkan/serverpanel/internal/config"
 	"github.com/asergenalkan/serverpanel/internal/database"
 	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 )
@@ -272,9 +274,9 @@ server.listen(PORT, () => {
 
 	appID, _ := result.LastInsertId()
 
-	// Create Apache proxy config if domain/subdomain URL is specified
+	// Serve the domain/subdomain through a proxy to the app
 	if req.AppURL != "" {
-		h.createApacheProxyConfig(appID, port, req.AppURL)
+		h.publishNodejsApp(req.AppURL)
 	}
 
 	return c.JSON(models.APIResponse{
@@ -454,7 +456,7 @@ func (h *Handler) DeleteNodejsApp(c *fiber.Ctx) error {
 
 	// Get app
 	var app NodejsApp
-	err := h.db.QueryRow("SELECT id, user_id, name, port FROM nodejs_apps WHERE id = ?", appID).Scan(&app.ID, &app.UserID, &app.Name, &app.Port)
+	err := h.db.QueryRow("SELECT id, user_id, name, port, COALESCE(app_url, '') FROM nodejs_apps WHERE id = ?", appID).Scan(&app.ID, &app.UserID, &app.Name, &app.Port, &app.AppURL)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
 			Success: false,
@@ -484,12 +486,13 @@ func (h *Handler) DeleteNodejsApp(c *fiber.Ctx) error {
 	cmd.Env = append(os.Environ(), "HOME=/root")
 	cmd.Run()
 
-	// Remove Apache proxy config
-	h.removeApacheProxyConfig(app.ID)
-
 	// Delete from database
 	h.db.Exec("DELETE FROM nodejs_apps WHERE id = ?", appID)
 
+	// Serve the domain without the proxy again
+	h.removeLegacyProxyConfig(app.ID, app.AppURL)
+	h.publishNodejsApp(app.AppURL)
+
 	return c.JSON(models.APIResponse{
 		Success: true,
 		Message: "Uygulama silindi",
@@ -1021,137 +1024,41 @@ func (h *Handler) getPM2AppID(name string) int {
 	return id
 }
 
-func (h *Handler) createApacheProxyConfig(appID int64, port int, appURL string) {
-	// appURL is the domain/subdomain name (e.g., "demonode.sergenalkan.com")
+// publishNodejsApp rebuilds the vhost of the app's domain/subdomain (e.g.
+// "demonode.sergenalkan.com"), which proxies to the app while an app is
+// published on it and serves PHP again once none is
+func (h *Handler) publishNodejsApp(appURL string) {
 	domain := strings.TrimSpace(appURL)
 	if domain == "" {
 		return
 	}
 
-	// Check if vhost exists for this domain
-	vhostPath := fmt.Sprintf("/etc/apache2/sites-available/%s.conf", domain)
-	if _, err := os.Stat(vhostPath); os.IsNotExist(err) {
-		return
-	}
-
-	// Create Node.js optimized vhost (replaces PHP vhost)
-	vhostContent := fmt.Sprintf(`# Node.js Application - App ID: %d
-# Auto-generated by ServerPanel
-<VirtualHost *:80>
-    ServerName %s
-    
-    # Node.js Reverse Proxy
-    ProxyPreserveHost On
-    ProxyPass / http://127.0.0.1:%d/
-    ProxyPassReverse / http://127.0.0.1:%d/
-    
-    # WebSocket support
-    RewriteEngine On
-    RewriteCond %%{HTTP:Upgrade} websocket [NC]
-    RewriteCond %%{HTTP:Connection} upgrade [NC]
-    RewriteRule ^/?(.*) "ws://127.0.0.1:%d/$1" [P,L]
-    
-    ErrorLog ${APACHE_LOG_DIR}/%s-error.log
-    CustomLog ${APACHE_LOG_DIR}/%s-access.log combined
-</VirtualHost>
-`, appID, domain, port, port, port, domain, domain)
-
-	// Backup original vhost
-	backupPath := vhostPath + ".php-backup"
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-		content, _ := os.ReadFile(vhostPath)
-		os.WriteFile(backupPath, content, 0644)
-	}
-
-	// Write new Node.js vhost
-	os.WriteFile(vhostPath, []byte(vhostContent), 0644)
-
-	// Check for SSL vhost and update it too
-	sslVhostPath := fmt.Sprintf("/etc/apache2/sites-available/%s-ssl.conf", domain)
-	if _, err := os.Stat(sslVhostPath); err == nil {
-		// Read SSL cert paths from existing config
-		sslContent, _ := os.ReadFile(sslVhostPath)
-		sslContentStr := string(sslContent)
-
-		// Extract SSL certificate paths
-		certFile := "/etc/letsencrypt/live/" + domain + "/fullchain.pem"
-		keyFile := "/etc/letsencrypt/live/" + domain + "/privkey.pem"
-
-		// Check if cert paths exist in original
-		if strings.Contains(sslContentStr, "SSLCertificateFile") {
-			// Create SSL Node.js vhost
-			sslVhostContent := fmt.Sprintf(`# Node.js Application SSL - App ID: %d
-# Auto-generated by ServerPanel
-<VirtualHost *:443>
-    ServerName %s
-    
-    SSLEngine on
-    SSLCertificateFile %s
-    SSLCertificateKeyFile %s
-    
-    # Node.js Reverse Proxy
-    ProxyPreserveHost On
-    ProxyPass / http://127.0.0.1:%d/
-    ProxyPassReverse / http://127.0.0.1:%d/
-    
-    # WebSocket support
-    RewriteEngine On
-    RewriteCond %%{HTTP:Upgrade} websocket [NC]
-    RewriteCond %%{HTTP:Connection} upgrade [NC]
-    RewriteRule ^/?(.*) "ws://127.0.0.1:%d/$1" [P,L]
-    
-    ErrorLog ${APACHE_LOG_DIR}/%s-ssl-error.log
-    CustomLog ${APACHE_LOG_DIR}/%s-ssl-access.log combined
-</VirtualHost>
-`, appID, domain, certFile, keyFile, port, port, port, domain, domain)
-
-			// Backup original SSL vhost
-			sslBackupPath := sslVhostPath + ".php-backup"
-			if _, err := os.Stat(sslBackupPath); os.IsNotExist(err) {
-				os.WriteFile(sslBackupPath, sslContent, 0644)
-			}
-
-			os.WriteFile(sslVhostPath, []byte(sslVhostContent), 0644)
-		}
+	if err := account.NewService(h.db).RebuildVhost(domain); err != nil {
+		log.Printf("⚠️ Node.js vhost for %s: %v", domain, err)
 	}
-
-	// Reload Apache
-	exec.Command("systemctl", "reload", "apache2").Run()
 }
 
-func (h *Handler) removeApacheProxyConfig(appID int64) {
-	// Get app URL from database to find the vhost
-	var appURL string
-	h.db.QueryRow("SELECT app_url FROM nodejs_apps WHERE id = ?", appID).Scan(&appURL)
-
-	if appURL != "" {
-		domain := strings.TrimSpace(appURL)
+// removeLegacyProxyConfig cleans up the proxy configs written by older
+// versions: the PHP vhost backups and per-app Apache confs
+func (h *Handler) removeLegacyProxyConfig(appID int64, appURL string) {
+	if domain := strings.TrimSpace(appURL); domain != "" {
 		vhostPath := fmt.Sprintf("/etc/apache2/sites-available/%s.conf", domain)
-		backupPath := vhostPath + ".php-backup"
+		os.Remove(vhostPath + ".php-backup")
 
-		// Restore original PHP vhost if backup exists
-		if _, err := os.Stat(backupPath); err == nil {
-			content, _ := os.ReadFile(backupPath)
-			os.WriteFile(vhostPath, content, 0644)
-			os.Remove(backupPath)
-		}
-
-		// Restore SSL vhost too
+		// The SSL vhost was overwritten in place, put the PHP one back
 		sslVhostPath := fmt.Sprintf("/etc/apache2/sites-available/%s-ssl.conf", domain)
 		sslBackupPath := sslVhostPath + ".php-backup"
-		if _, err := os.Stat(sslBackupPath); err == nil {
-			content, _ := os.ReadFile(sslBackupPath)
+		if content, err := os.ReadFile(sslBackupPath); err == nil {
 			os.WriteFile(sslVhostPath, content, 0644)
 			os.Remove(sslBackupPath)
 		}
 	}
 
-	// Remove old style conf file if exists
 	configPath := fmt.Sprintf("/etc/apache2/conf-available/nodejs-app-%d.conf", appID)
-	exec.Command("a2disconf", fmt.Sprintf("nodejs-app-%d", appID)).Run()
-	os.Remove(configPath)
-
-	exec.Command("systemctl", "reload", "apache2").Run()
+	if _, err := os.Stat(configPath); err == nil {
+		exec.Command("a2disconf", fmt.Sprintf("nodejs-app-%d", appID)).Run()
+		os.Remove(configPath)
+	}
 }
 
 // UpdateNodejsApp updates a Node.js application
@@ -1,10 +1,17 @@
 package api
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/asergenalkan/serverpanel/internal/services/quota"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
 	"github.com/gofiber/fiber/v2"
+	"github.com/mattn/go-sqlite3"
 )
 
 // Package represents a hosting package with all limits
@@ -20,22 +27,123 @@ type Package struct {
 	MaxPHPMemory        string `json:"max_php_memory"`
 	MaxPHPUpload        string `json:"max_php_upload"`
 	MaxPHPExecutionTime int    `json:"max_php_execution_time"`
+	PHPProcessManager   string `json:"php_pm"`              // static, dynamic or ondemand
+	PHPMaxChildren      int    `json:"php_max_children"`    // PHP-FPM processes per pool
+	PHPRequestTimeout   int    `json:"php_request_timeout"` // request_terminate_timeout, 0 = off
+	PHPSlowlogTimeout   int    `json:"php_slowlog_timeout"` // request_slowlog_timeout, 0 = off
+	PHPPoolPerDomain    bool   `json:"php_pool_per_domain"` // a PHP-FPM pool per domain
 	MaxEmailsPerHour    int    `json:"max_emails_per_hour"`
 	MaxEmailsPerDay     int    `json:"max_emails_per_day"`
+	BackupKeepDaily     int    `json:"backup_keep_daily"`
+	BackupKeepWeekly    int    `json:"backup_keep_weekly"`
+	OwnerID             int64  `json:"owner_id,omitempty"` // Reseller owning the package, 0 = admin
 	CreatedAt           string `json:"created_at"`
 	UserCount           int    `json:"user_count,omitempty"`
 }
 
+// poolLimits returns the limits of a package that are drawn from a
+// reseller pool
+func (p *Package) poolLimits() account.PackageLimits {
+	return account.PackageLimits{
+		DiskQuota:      int64(p.DiskQuota),
+		BandwidthQuota: int64(p.BandwidthQuota),
+		MaxDomains:     p.MaxDomains,
+		MaxDatabases:   p.MaxDatabases,
+		MaxEmails:      p.MaxEmails,
+		MaxFTP:         p.MaxFTP,
+	}
+}
+
+// validatePHPPool fills in and checks the PHP-FPM process settings of a
+// package
+func (p *Package) validatePHPPool() error {
+	if p.PHPProcessManager == "" {
+		p.PHPProcessManager = webserver.PMDynamic
+	}
+	if p.PHPMaxChildren == 0 {
+		p.PHPMaxChildren = 5
+	}
+	if !webserver.ValidProcessManager(p.PHPProcessManager) {
+		return fmt.Errorf("php_pm must be static, dynamic or ondemand")
+	}
+	if p.PHPMaxChildren < 1 || p.PHPMaxChildren > 200 {
+		return fmt.Errorf("php_max_children must be between 1 and 200")
+	}
+	if p.PHPRequestTimeout < 0 || p.PHPSlowlogTimeout < 0 {
+		return fmt.Errorf("PHP timeouts cannot be negative")
+	}
+	if p.PHPRequestTimeout > 0 && p.PHPSlowlogTimeout >= p.PHPRequestTimeout {
+		return fmt.Errorf("php_slowlog_timeout must be shorter than php_request_timeout")
+	}
+	return nil
+}
+
+// phpSettings returns the settings of a package that go into its PHP-FPM
+// pools
+func (p *Package) phpSettings() string {
+	return fmt.Sprint(p.MaxPHPMemory, p.MaxPHPUpload, p.MaxPHPExecutionTime, p.PHPProcessManager,
+		p.PHPMaxChildren, p.PHPRequestTimeout, p.PHPSlowlogTimeout, p.PHPPoolPerDomain)
+}
+
+// packageOwner returns the owner_id value to store for a package (NULL
+// for admin packages). Owners must be resellers and a reseller package
+// must fit in the reseller's pool.
+func (h *Handler) packageOwner(pkg *Package) (interface{}, error) {
+	if pkg.OwnerID == 0 {
+		return nil, nil
+	}
+	svc := account.NewService(h.db)
+	if _, err := svc.GetResellerLimits(pkg.OwnerID); err != nil {
+		return nil, fmt.Errorf("package owner must be a reseller")
+	}
+	if err := svc.CheckPackageFitsPool(pkg.OwnerID, pkg.poolLimits()); err != nil {
+		return nil, err
+	}
+	return pkg.OwnerID, nil
+}
+
+// isUniqueViolation reports whether a write failed on a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	var e sqlite3.Error
+	return errors.As(err, &e) && e.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// packageOwnedByCaller reports whether the caller may change a package:
+// admins all packages, resellers their own
+func (h *Handler) packageOwnedByCaller(c *fiber.Ctx, id int64) bool {
+	if c.Locals("role").(string) == models.RoleAdmin {
+		return true
+	}
+	var count int
+	h.db.QueryRow("SELECT COUNT(*) FROM packages WHERE id = ? AND owner_id = ?", id, c.Locals("user_id").(int64)).Scan(&count)
+	return count > 0
+}
+
+// ListPackages returns all packages (admin) or a reseller's own packages
 func (h *Handler) ListPackages(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	where := ""
+	var args []interface{}
+	if role == models.RoleReseller {
+		where = "WHERE p.owner_id = ?"
+		args = append(args, userID)
+	}
+
 	rows, err := h.db.Query(`
 		SELECT p.id, p.name, p.disk_quota, p.bandwidth_quota, p.max_domains, 
 		       p.max_databases, p.max_emails, p.max_ftp, 
 		       p.max_php_memory, p.max_php_upload, p.max_php_execution_time,
+		       COALESCE(p.php_pm, 'dynamic'), COALESCE(p.php_max_children, 5),
+		       COALESCE(p.php_request_timeout, 0), COALESCE(p.php_slowlog_timeout, 0),
+		       COALESCE(p.php_pool_per_domain, 0),
 		       COALESCE(p.max_emails_per_hour, 100), COALESCE(p.max_emails_per_day, 500),
-		       p.created_at,
+		       COALESCE(p.backup_keep_daily, 7), COALESCE(p.backup_keep_weekly, 4),
+		       COALESCE(p.owner_id, 0), p.created_at,
 		       (SELECT COUNT(*) FROM user_packages WHERE package_id = p.id) as user_count
-		FROM packages p ORDER BY p.name
-	`)
+		FROM packages p `+where+` ORDER BY p.name
+	`, args...)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
 			Success: false,
@@ -50,8 +158,11 @@ func (h *Handler) ListPackages(c *fiber.Ctx) error {
 		if err := rows.Scan(&p.ID, &p.Name, &p.DiskQuota, &p.BandwidthQuota, &p.MaxDomains,
 			&p.MaxDatabases, &p.MaxEmails, &p.MaxFTP,
 			&p.MaxPHPMemory, &p.MaxPHPUpload, &p.MaxPHPExecutionTime,
+			&p.PHPProcessManager, &p.PHPMaxChildren, &p.PHPRequestTimeout, &p.PHPSlowlogTimeout,
+			&p.PHPPoolPerDomain,
 			&p.MaxEmailsPerHour, &p.MaxEmailsPerDay,
-			&p.CreatedAt, &p.UserCount); err != nil {
+			&p.BackupKeepDaily, &p.BackupKeepWeekly,
+			&p.OwnerID, &p.CreatedAt, &p.UserCount); err != nil {
 			continue
 		}
 		packages = append(packages, p)
@@ -72,19 +183,12 @@ func (h *Handler) GetPackage(c *fiber.Ctx) error {
 		})
 	}
 
-	var p Package
-	err = h.db.QueryRow(`
-		SELECT id, name, disk_quota, bandwidth_quota, max_domains, 
-		       max_databases, max_emails, max_ftp,
-		       max_php_memory, max_php_upload, max_php_execution_time,
-		       COALESCE(max_emails_per_hour, 100), COALESCE(max_emails_per_day, 500),
-		       created_at
-		FROM packages WHERE id = ?
-	`, id).Scan(&p.ID, &p.Name, &p.DiskQuota, &p.BandwidthQuota, &p.MaxDomains,
-		&p.MaxDatabases, &p.MaxEmails, &p.MaxFTP,
-		&p.MaxPHPMemory, &p.MaxPHPUpload, &p.MaxPHPExecutionTime,
-		&p.MaxEmailsPerHour, &p.MaxEmailsPerDay, &p.CreatedAt)
+	p, err := h.loadPackage(id)
 
+	// Resellers only see their own packages
+	if err == nil && c.Locals("role").(string) == models.RoleReseller && p.OwnerID != c.Locals("user_id").(int64) {
+		err = sql.ErrNoRows
+	}
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
 			Success: false,
@@ -98,6 +202,35 @@ func (h *Handler) GetPackage(c *fiber.Ctx) error {
 	})
 }
 
+// loadPackage reads a package with the defaults of unset columns
+func (h *Handler) loadPackage(id int64) (*Package, error) {
+	var p Package
+	err := h.db.QueryRow(`
+		SELECT id, name, disk_quota, bandwidth_quota, max_domains, 
+		       max_databases, max_emails, max_ftp,
+		       max_php_memory, max_php_upload, max_php_execution_time,
+		       COALESCE(php_pm, 'dynamic'), COALESCE(php_max_children, 5),
+		       COALESCE(php_request_timeout, 0), COALESCE(php_slowlog_timeout, 0),
+		       COALESCE(php_pool_per_domain, 0),
+		       COALESCE(max_emails_per_hour, 100), COALESCE(max_emails_per_day, 500),
+		       COALESCE(backup_keep_daily, 7), COALESCE(backup_keep_weekly, 4),
+		       COALESCE(owner_id, 0), created_at
+		FROM packages WHERE id = ?
+	`, id).Scan(&p.ID, &p.Name, &p.DiskQuota, &p.BandwidthQuota, &p.MaxDomains,
+		&p.MaxDatabases, &p.MaxEmails, &p.MaxFTP,
+		&p.MaxPHPMemory, &p.MaxPHPUpload, &p.MaxPHPExecutionTime,
+		&p.PHPProcessManager, &p.PHPMaxChildren, &p.PHPRequestTimeout, &p.PHPSlowlogTimeout,
+		&p.PHPPoolPerDomain,
+		&p.MaxEmailsPerHour, &p.MaxEmailsPerDay,
+		&p.BackupKeepDaily, &p.BackupKeepWeekly, &p.OwnerID, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// CreatePackage creates a package. Resellers create packages of their
+// own, carved out of their pool.
 func (h *Handler) CreatePackage(c *fiber.Ctx) error {
 	var pkg Package
 	if err := c.BodyParser(&pkg); err != nil {
@@ -106,6 +239,9 @@ func (h *Handler) CreatePackage(c *fiber.Ctx) error {
 			Error:   "Invalid request body",
 		})
 	}
+	if c.Locals("role").(string) == models.RoleReseller {
+		pkg.OwnerID = c.Locals("user_id").(int64)
+	}
 
 	if pkg.Name == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
@@ -148,16 +284,45 @@ func (h *Handler) CreatePackage(c *fiber.Ctx) error {
 	if pkg.MaxEmailsPerDay == 0 {
 		pkg.MaxEmailsPerDay = 500
 	}
+	if pkg.BackupKeepDaily == 0 {
+		pkg.BackupKeepDaily = 7
+	}
+	if pkg.BackupKeepWeekly == 0 {
+		pkg.BackupKeepWeekly = 4
+	}
 
-	result, err := h.db.Exec(`
-		INSERT INTO packages (name, disk_quota, bandwidth_quota, max_domains, max_databases, max_emails, max_ftp, max_php_memory, max_php_upload, max_php_execution_time, max_emails_per_hour, max_emails_per_day)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, pkg.Name, pkg.DiskQuota, pkg.BandwidthQuota, pkg.MaxDomains, pkg.MaxDatabases, pkg.MaxEmails, pkg.MaxFTP, pkg.MaxPHPMemory, pkg.MaxPHPUpload, pkg.MaxPHPExecutionTime, pkg.MaxEmailsPerHour, pkg.MaxEmailsPerDay)
+	if err := pkg.validatePHPPool(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
 
+	owner, err := h.packageOwner(&pkg)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
 			Success: false,
-			Error:   "Package name already exists",
+			Error:   err.Error(),
+		})
+	}
+
+	result, err := h.db.Exec(`
+		INSERT INTO packages (name, disk_quota, bandwidth_quota, max_domains, max_databases, max_emails, max_ftp, max_php_memory, max_php_upload, max_php_execution_time, php_pm, php_max_children, php_request_timeout, php_slowlog_timeout, php_pool_per_domain, max_emails_per_hour, max_emails_per_day, backup_keep_daily, backup_keep_weekly, owner_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, pkg.Name, pkg.DiskQuota, pkg.BandwidthQuota, pkg.MaxDomains, pkg.MaxDatabases, pkg.MaxEmails, pkg.MaxFTP, pkg.MaxPHPMemory, pkg.MaxPHPUpload, pkg.MaxPHPExecutionTime, pkg.PHPProcessManager, pkg.PHPMaxChildren, pkg.PHPRequestTimeout, pkg.PHPSlowlogTimeout, pkg.PHPPoolPerDomain, pkg.MaxEmailsPerHour, pkg.MaxEmailsPerDay, pkg.BackupKeepDaily, pkg.BackupKeepWeekly, owner)
+
+	if err != nil {
+		// Names are unique per owner, so a clash is always with a package
+		// the caller can already see
+		if isUniqueViolation(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Package name already exists",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to create package",
 		})
 	}
 
@@ -179,29 +344,104 @@ func (h *Handler) UpdatePackage(c *fiber.Ctx) error {
 		})
 	}
 
-	var pkg Package
+	if !h.packageOwnedByCaller(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Package not found",
+		})
+	}
+
+	// The request is merged over the stored package: fields it leaves out,
+	// such as the owner and the PHP-FPM settings the edit form does not
+	// send, keep their values
+	old, err := h.loadPackage(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Package not found",
+		})
+	}
+	pkg := *old
 	if err := c.BodyParser(&pkg); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
 			Success: false,
 			Error:   "Invalid request body",
 		})
 	}
+	if c.Locals("role").(string) == models.RoleReseller {
+		pkg.OwnerID = c.Locals("user_id").(int64)
+	}
+
+	if err := pkg.validatePHPPool(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	owner, err := h.packageOwner(&pkg)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	// The accounts already on the package take the new limits, and a new
+	// owner takes them into its pool
+	svc := account.NewService(h.db)
+	err = svc.CheckPackageChange(id, pkg.poolLimits())
+	if err == nil && pkg.OwnerID != old.OwnerID {
+		err = svc.CheckPackageOwnerChange(id, old.OwnerID, pkg.OwnerID, pkg.poolLimits())
+	}
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, account.ErrResellerPool) {
+			status = fiber.StatusBadRequest
+		} else if errors.Is(err, account.ErrPackageInUse) {
+			status = fiber.StatusConflict
+		} else if errors.Is(err, account.ErrPackageNotFound) {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
 
 	_, err = h.db.Exec(`
 		UPDATE packages SET name = ?, disk_quota = ?, bandwidth_quota = ?, 
 		max_domains = ?, max_databases = ?, max_emails = ?, max_ftp = ?,
 		max_php_memory = ?, max_php_upload = ?, max_php_execution_time = ?,
-		max_emails_per_hour = ?, max_emails_per_day = ?
+		php_pm = ?, php_max_children = ?, php_request_timeout = ?,
+		php_slowlog_timeout = ?, php_pool_per_domain = ?,
+		max_emails_per_hour = ?, max_emails_per_day = ?,
+		backup_keep_daily = ?, backup_keep_weekly = ?, owner_id = ?
 		WHERE id = ?
-	`, pkg.Name, pkg.DiskQuota, pkg.BandwidthQuota, pkg.MaxDomains, pkg.MaxDatabases, pkg.MaxEmails, pkg.MaxFTP, pkg.MaxPHPMemory, pkg.MaxPHPUpload, pkg.MaxPHPExecutionTime, pkg.MaxEmailsPerHour, pkg.MaxEmailsPerDay, id)
+	`, pkg.Name, pkg.DiskQuota, pkg.BandwidthQuota, pkg.MaxDomains, pkg.MaxDatabases, pkg.MaxEmails, pkg.MaxFTP, pkg.MaxPHPMemory, pkg.MaxPHPUpload, pkg.MaxPHPExecutionTime, pkg.PHPProcessManager, pkg.PHPMaxChildren, pkg.PHPRequestTimeout, pkg.PHPSlowlogTimeout, pkg.PHPPoolPerDomain, pkg.MaxEmailsPerHour, pkg.MaxEmailsPerDay, pkg.BackupKeepDaily, pkg.BackupKeepWeekly, owner, id)
 
 	if err != nil {
+		if isUniqueViolation(err) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Package name already exists",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
 			Success: false,
 			Error:   "Failed to update package",
 		})
 	}
 
+	// The PHP-FPM pools of the accounts are rendered again when the PHP
+	// settings change, their filesystem quotas when the disk quota does
+	if old.phpSettings() != pkg.phpSettings() {
+		go account.NewService(h.db).RebuildPackagePHPPools(id)
+	}
+	if old.DiskQuota != pkg.DiskQuota {
+		go quota.NewService(h.db).ApplyPackage(id)
+	}
+
 	return c.JSON(models.APIResponse{
 		Success: true,
 		Message: "Package updated successfully",
@@ -217,6 +457,13 @@ func (h *Handler) DeletePackage(c *fiber.Ctx) error {
 		})
 	}
 
+	if !h.packageOwnedByCaller(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Package not found",
+		})
+	}
+
 	// Check if package is in use
 	var count int
 	h.db.QueryRow("SELECT COUNT(*) FROM user_packages WHERE package_id = ?", id).Scan(&count)
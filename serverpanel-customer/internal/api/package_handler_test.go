@@ -0,0 +1,192 @@
+package api
+
+import (
+	"database/sql"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+	"github.com/asergenalkan/serverpanel/internal/database"
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMain(m *testing.M) {
+	// The panel config lives below HOME, keep it out of the real one
+	home, err := os.MkdirTemp("", "serverpanel-api")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// testHandler returns a handler on a fresh panel database
+func testHandler(t *testing.T) *Handler {
+	t.Helper()
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Handler{db: db, cfg: config.Get()}
+}
+
+// testUser inserts a user and returns its id
+func testUser(t *testing.T, db *database.DB, username, role string, parent int64) int64 {
+	t.Helper()
+	var parentID interface{}
+	if parent > 0 {
+		parentID = parent
+	}
+	res, err := db.Exec("INSERT INTO users (username, email, password, role, parent_id) VALUES (?, ?, 'x', ?, ?)",
+		username, username+"@example.com", role, parentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+// updatePackage sends a package edit as the given user
+func updatePackage(t *testing.T, h *Handler, role string, userID, id int64, body string) int {
+	t.Helper()
+	app := fiber.New()
+	app.Put("/packages/:id", func(c *fiber.Ctx) error {
+		c.Locals("role", role)
+		c.Locals("user_id", userID)
+		return h.UpdatePackage(c)
+	})
+	req := httptest.NewRequest("PUT", "/packages/"+strconv.FormatInt(id, 10), strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func packageOwnerID(t *testing.T, db *database.DB, id int64) int64 {
+	t.Helper()
+	var owner sql.NullInt64
+	if err := db.QueryRow("SELECT owner_id FROM packages WHERE id = ?", id).Scan(&owner); err != nil {
+		t.Fatal(err)
+	}
+	return owner.Int64
+}
+
+// editForm is what the package edit form of the admin UI sends
+const editForm = `{"name": "Reseller Plus", "disk_quota": 1024, "bandwidth_quota": 10240, "max_domains": 2,
+	"max_databases": 1, "max_emails": 5, "max_ftp": 1, "max_php_memory": "256M", "max_php_upload": "64M",
+	"max_php_execution_time": 300, "max_emails_per_hour": 100, "max_emails_per_day": 500,
+	"backup_keep_daily": 7, "backup_keep_weekly": 4}`
+
+func TestUpdatePackageOwner(t *testing.T) {
+	h := testHandler(t)
+	var admin int64
+	h.db.QueryRow("SELECT id FROM users WHERE role = 'admin'").Scan(&admin)
+	first := testUser(t, h.db, "first", models.RoleReseller, 0)
+	second := testUser(t, h.db, "second", models.RoleReseller, 0)
+	full := testUser(t, h.db, "full", models.RoleReseller, 0)
+	h.db.Exec("INSERT INTO reseller_limits (user_id, max_accounts) VALUES (?, 1)", full)
+
+	res, err := h.db.Exec("INSERT INTO packages (name, owner_id) VALUES ('Reseller', ?)", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, _ := res.LastInsertId()
+
+	// The admin edit form does not send owner_id
+	form := editForm
+	if status := updatePackage(t, h, models.RoleAdmin, admin, pkg, form); status != fiber.StatusOK {
+		t.Fatalf("admin edit: status %d", status)
+	}
+	if owner := packageOwnerID(t, h.db, pkg); owner != first {
+		t.Errorf("owner after an edit without owner_id = %d, want %d", owner, first)
+	}
+	if status := updatePackage(t, h, models.RoleReseller, first, pkg, form); status != fiber.StatusOK {
+		t.Fatalf("reseller edit: status %d", status)
+	}
+	if owner := packageOwnerID(t, h.db, pkg); owner != first {
+		t.Errorf("owner after a reseller edit = %d, want %d", owner, first)
+	}
+
+	move := func(owner int64) string {
+		return strings.Replace(form, `"name"`, `"owner_id": `+strconv.FormatInt(owner, 10)+`, "name"`, 1)
+	}
+
+	// The first reseller's accounts keep it from losing the package
+	customer := testUser(t, h.db, "customer", models.RoleUser, first)
+	h.db.Exec("INSERT INTO user_packages (user_id, package_id) VALUES (?, ?)", customer, pkg)
+	if status := updatePackage(t, h, models.RoleAdmin, admin, pkg, move(second)); status != fiber.StatusConflict {
+		t.Errorf("move with the old owner's accounts on it: status %d, want 409", status)
+	}
+	h.db.Exec("DELETE FROM user_packages WHERE user_id = ?", customer)
+
+	// Other accounts on the package count against the new owner's pool
+	direct := testUser(t, h.db, "direct", models.RoleUser, 0)
+	h.db.Exec("INSERT INTO user_packages (user_id, package_id) VALUES (?, ?)", direct, pkg)
+	testUser(t, h.db, "fullcustomer", models.RoleUser, full)
+	if status := updatePackage(t, h, models.RoleAdmin, admin, pkg, move(full)); status != fiber.StatusBadRequest {
+		t.Errorf("move into a full pool: status %d, want 400", status)
+	}
+	if owner := packageOwnerID(t, h.db, pkg); owner != first {
+		t.Errorf("owner after refused moves = %d, want %d", owner, first)
+	}
+
+	if status := updatePackage(t, h, models.RoleAdmin, admin, pkg, move(second)); status != fiber.StatusOK {
+		t.Fatalf("move: status %d", status)
+	}
+	if owner := packageOwnerID(t, h.db, pkg); owner != second {
+		t.Errorf("owner after a move = %d, want %d", owner, second)
+	}
+	if status := updatePackage(t, h, models.RoleAdmin, admin, pkg, move(0)); status != fiber.StatusOK {
+		t.Fatalf("move to admin: status %d", status)
+	}
+	if owner := packageOwnerID(t, h.db, pkg); owner != 0 {
+		t.Errorf("owner after a move to the admin = %d, want none", owner)
+	}
+}
+
+func TestUpdatePackageKeepsPHPPool(t *testing.T) {
+	h := testHandler(t)
+	var admin int64
+	h.db.QueryRow("SELECT id FROM users WHERE role = 'admin'").Scan(&admin)
+	res, err := h.db.Exec(`INSERT INTO packages (name, php_pm, php_max_children, php_request_timeout,
+		php_slowlog_timeout, php_pool_per_domain) VALUES ('Tuned', 'static', 12, 60, 10, 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+
+	if status := updatePackage(t, h, models.RoleAdmin, admin, id, editForm); status != fiber.StatusOK {
+		t.Fatalf("edit: status %d", status)
+	}
+	p, err := h.loadPackage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Reseller Plus" || p.MaxDomains != 2 {
+		t.Errorf("edited fields not stored: %+v", p)
+	}
+	if p.PHPProcessManager != "static" || p.PHPMaxChildren != 12 || p.PHPRequestTimeout != 60 ||
+		p.PHPSlowlogTimeout != 10 || !p.PHPPoolPerDomain {
+		t.Errorf("PHP-FPM settings lost on an edit without them: %+v", p)
+	}
+
+	// Fields the request does set still change
+	body := `{"php_pm": "ondemand", "php_pool_per_domain": false}`
+	if status := updatePackage(t, h, models.RoleAdmin, admin, id, body); status != fiber.StatusOK {
+		t.Fatalf("edit: status %d", status)
+	}
+	p, _ = h.loadPackage(id)
+	if p.PHPProcessManager != "ondemand" || p.PHPPoolPerDomain || p.PHPMaxChildren != 12 || p.Name != "Reseller Plus" {
+		t.Errorf("partial edit stored %+v", p)
+	}
+}
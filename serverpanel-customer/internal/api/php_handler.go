@@ -1,15 +1,17 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/asergenalkan/serverpanel/internal/config"
 	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -228,31 +230,27 @@ func (h *Handler) UpdateDomainPHPVersion(c *fiber.Ctx) error {
 		})
 	}
 
-	// Update database
-	_, err = h.db.Exec("UPDATE domains SET php_version = ? WHERE id = ?", req.PHPVersion, domainID)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+	// Create the pool under the new version and switch the vhosts to it
+	if err := account.NewService(h.db).SwitchPHPVersion(domain, req.PHPVersion); err != nil {
+		status := fiber.StatusInternalServerError
+		switch {
+		case err == account.ErrPHPVersionNotInstalled:
+			status = fiber.StatusBadRequest
+		case err == account.ErrAccountSuspended:
+			status = fiber.StatusConflict
+		case errors.Is(err, webserver.ErrConfigTest):
+			// The web server or PHP-FPM rejected the config, which was rolled back
+			status = fiber.StatusUnprocessableEntity
+		case errors.Is(err, webserver.ErrPoolSocketDown):
+			status = fiber.StatusServiceUnavailable
+		}
+		return c.Status(status).JSON(models.APIResponse{
 			Success: false,
-			Error:   "Failed to update PHP version",
+			Error:   "Failed to update PHP version: " + err.Error(),
 		})
 	}
 
-	// Update Apache vhost and PHP-FPM pool
-	cfg := config.Get()
-	if !config.IsDevelopment() {
-		// Update Apache vhost to use new PHP version
-		if err := h.updateApacheVhostPHP(domain, username, documentRoot, req.PHPVersion); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
-				Success: false,
-				Error:   "Failed to update web server config: " + err.Error(),
-			})
-		}
-
-		// Reload Apache
-		exec.Command("systemctl", "reload", "apache2").Run()
-	} else {
-		_ = cfg // suppress unused warning in dev mode
-	}
+	h.logActivity(userID, "php_version", fmt.Sprintf("Switched %s to PHP %s", domain, req.PHPVersion), c.IP())
 
 	return c.JSON(models.APIResponse{
 		Success: true,
@@ -359,18 +357,21 @@ func (h *Handler) UpdateDomainPHPSettings(c *fiber.Ctx) error {
 		})
 	}
 
-	// Update PHP-FPM pool config
-	if !config.IsDevelopment() {
-		homeDir := filepath.Join("/home", username)
-		if err := h.updatePHPFPMPoolSettings(username, homeDir, phpVersion, req); err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
-				Success: false,
-				Error:   "Failed to update PHP-FPM config: " + err.Error(),
-			})
-		}
+	// The form replaces what the php.ini editor set for the same directives
+	svc := account.NewService(h.db)
+	svc.ResetPHPIni(domainID, "memory_limit", "max_execution_time", "max_input_time", "post_max_size",
+		"upload_max_filesize", "max_file_uploads", "display_errors", "error_reporting")
 
-		// Reload PHP-FPM
-		exec.Command("systemctl", "reload", fmt.Sprintf("php%s-fpm", phpVersion)).Run()
+	// Render the PHP-FPM pool of the domain with the new settings
+	if _, err := svc.RebuildPHPPools(ownerID); err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, webserver.ErrConfigTest) {
+			status = fiber.StatusUnprocessableEntity
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to update PHP-FPM config: " + err.Error(),
+		})
 	}
 
 	return c.JSON(models.APIResponse{
@@ -381,85 +382,6 @@ func (h *Handler) UpdateDomainPHPSettings(c *fiber.Ctx) error {
 
 // Helper functions
 
-func (h *Handler) updateApacheVhostPHP(domain, username, documentRoot, phpVersion string) error {
-	vhostPath := filepath.Join("/etc/apache2/sites-available", domain+".conf")
-
-	// Read current vhost
-	content, err := os.ReadFile(vhostPath)
-	if err != nil {
-		return err
-	}
-
-	// Replace PHP version in SetHandler directive
-	lines := strings.Split(string(content), "\n")
-	for i, line := range lines {
-		if strings.Contains(line, "SetHandler") && strings.Contains(line, "php") {
-			// Update the PHP-FPM socket path
-			lines[i] = fmt.Sprintf(`        SetHandler "proxy:unix:/run/php/php%s-fpm-%s.sock|fcgi://localhost"`, phpVersion, username)
-		}
-	}
-
-	// Write updated vhost
-	return os.WriteFile(vhostPath, []byte(strings.Join(lines, "\n")), 0644)
-}
-
-func (h *Handler) updatePHPFPMPoolSettings(username, homeDir, phpVersion string, settings PHPSettings) error {
-	poolPath := fmt.Sprintf("/etc/php/%s/fpm/pool.d/%s.conf", phpVersion, username)
-
-	displayErrors := "off"
-	if settings.DisplayErrors {
-		displayErrors = "on"
-	}
-
-	socketPath := fmt.Sprintf("/run/php/php%s-fpm-%s.sock", phpVersion, username)
-
-	poolConfig := fmt.Sprintf(`[%s]
-; Pool for user %s
-
-user = %s
-group = %s
-
-listen = %s
-listen.owner = www-data
-listen.group = www-data
-listen.mode = 0660
-
-pm = dynamic
-pm.max_children = 5
-pm.start_servers = 2
-pm.min_spare_servers = 1
-pm.max_spare_servers = 3
-pm.max_requests = 500
-
-; Logging
-php_admin_value[error_log] = %s/logs/php-error.log
-php_admin_flag[log_errors] = on
-
-; Security
-php_admin_value[open_basedir] = %s:/tmp:/usr/share/php
-php_admin_value[upload_tmp_dir] = %s/tmp
-php_admin_value[session.save_path] = %s/tmp
-
-; Custom Settings
-php_admin_value[memory_limit] = %s
-php_admin_value[max_execution_time] = %d
-php_admin_value[max_input_time] = %d
-php_admin_value[post_max_size] = %s
-php_admin_value[upload_max_filesize] = %s
-php_admin_value[max_file_uploads] = %d
-php_admin_flag[display_errors] = %s
-php_admin_value[error_reporting] = %s
-`,
-		username, username, username, username, socketPath,
-		homeDir, homeDir, homeDir, homeDir,
-		settings.MemoryLimit, settings.MaxExecutionTime, settings.MaxInputTime,
-		settings.PostMaxSize, settings.UploadMaxFilesize, settings.MaxFileUploads,
-		displayErrors, settings.ErrorReporting,
-	)
-
-	return os.WriteFile(poolPath, []byte(poolConfig), 0644)
-}
-
 // isValidMemoryLimit checks if a memory limit is within allowed range
 func isValidMemoryLimit(value, maxAllowed string) bool {
 	parseSize := func(s string) int64 {
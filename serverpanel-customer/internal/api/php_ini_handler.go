@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetPHPIniCatalog returns the directives of the php.ini editor and whether
+// the caller may change them
+func (h *Handler) GetPHPIniCatalog(c *fiber.Ctx) error {
+	svc := account.NewService(h.db)
+	isAdmin := c.Locals("role").(string) == models.RoleAdmin
+
+	type entry struct {
+		webserver.PHPIniDirective
+		Allowed bool `json:"allowed"`
+	}
+	catalog := []entry{}
+	for _, d := range webserver.PHPIniCatalog() {
+		catalog = append(catalog, entry{d, isAdmin || svc.PHPIniAllowed(d.Name)})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    catalog,
+	})
+}
+
+// managedDomainID parses the :id of a domain the caller may manage
+func (h *Handler) managedDomainID(c *fiber.Ctx) (int64, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid domain ID",
+		})
+	}
+
+	var ownerID int64
+	err = h.db.QueryRow("SELECT user_id FROM domains WHERE id = ?", id).Scan(&ownerID)
+	if err != nil || (ownerID != c.Locals("user_id").(int64) && !h.canManageUser(c, ownerID)) {
+		return 0, c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Domain not found",
+		})
+	}
+	return id, nil
+}
+
+// GetDomainPHPIni returns the php.ini directives of a domain
+func (h *Handler) GetDomainPHPIni(c *fiber.Ctx) error {
+	id, err := h.managedDomainID(c)
+	if id == 0 {
+		return err
+	}
+
+	settings, err := account.NewService(h.db).GetPHPIni(id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to fetch php.ini directives",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    settings,
+	})
+}
+
+// UpdateDomainPHPIni sets php.ini directives of a domain; an empty value
+// removes a directive
+func (h *Handler) UpdateDomainPHPIni(c *fiber.Ctx) error {
+	id, err := h.managedDomainID(c)
+	if id == 0 {
+		return err
+	}
+
+	var req struct {
+		Directives map[string]string `json:"directives"`
+	}
+	if err := c.BodyParser(&req); err != nil || len(req.Directives) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "directives is required",
+		})
+	}
+
+	isAdmin := c.Locals("role").(string) == models.RoleAdmin
+	if err := account.NewService(h.db).SetPHPIni(id, req.Directives, isAdmin); err != nil {
+		status := fiber.StatusInternalServerError
+		switch {
+		case errors.Is(err, webserver.ErrInvalidPHPIni):
+			status = fiber.StatusBadRequest
+		case errors.Is(err, account.ErrPHPIniNotAllowed):
+			status = fiber.StatusForbidden
+		case errors.Is(err, webserver.ErrConfigTest):
+			// PHP-FPM rejected the pool, the previous directives are back
+			status = fiber.StatusUnprocessableEntity
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "php_ini", "Updated php.ini directives of domain "+c.Params("id"), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "php.ini directives updated",
+	})
+}
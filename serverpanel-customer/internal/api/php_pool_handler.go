@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
+	"github.com/gofiber/fiber/v2"
+)
+
+// phpPoolsResponse returns the PHP-FPM pools of an account with their
+// status pages
+func (h *Handler) phpPoolsResponse(c *fiber.Ctx, userID int64) error {
+	pools, err := account.NewService(h.db).GetPHPPools(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to fetch PHP-FPM pools",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    pools,
+	})
+}
+
+// GetMyPHPPools returns the PHP-FPM pools of the caller's account
+func (h *Handler) GetMyPHPPools(c *fiber.Ctx) error {
+	return h.phpPoolsResponse(c, c.Locals("user_id").(int64))
+}
+
+// managedAccountID parses the :id of an account the caller may manage
+func (h *Handler) managedAccountID(c *fiber.Ctx) (int64, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return 0, c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid account ID",
+		})
+	}
+	if !h.canManageUser(c, id) {
+		return 0, c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Account not found",
+		})
+	}
+	return id, nil
+}
+
+// GetAccountPHPPools returns the PHP-FPM pools of an account
+func (h *Handler) GetAccountPHPPools(c *fiber.Ctx) error {
+	id, err := h.managedAccountID(c)
+	if id == 0 {
+		return err
+	}
+	return h.phpPoolsResponse(c, id)
+}
+
+// RebuildAccountPHPPools renders the PHP-FPM pools of an account from its
+// package again
+func (h *Handler) RebuildAccountPHPPools(c *fiber.Ctx) error {
+	id, err := h.managedAccountID(c)
+	if id == 0 {
+		return err
+	}
+
+	pools, err := account.NewService(h.db).RebuildPHPPools(id)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, webserver.ErrConfigTest) {
+			// PHP-FPM rejected the pools, which were rolled back
+			status = fiber.StatusUnprocessableEntity
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "php_pools", "Rebuilt PHP-FPM pools of account "+c.Params("id"), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "PHP-FPM pools rebuilt",
+		Data:    fiber.Map{"pools": pools},
+	})
+}
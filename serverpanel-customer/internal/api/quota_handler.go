@@ -0,0 +1,97 @@
+package api
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/quota"
+	"github.com/gofiber/fiber/v2"
+)
+
+// checkDiskQuota reports whether addBytes may be written to fullPath. For
+// admins the account is the first directory below HomeBaseDir.
+func (h *Handler) checkDiskQuota(c *fiber.Ctx, fullPath string, addBytes int64) error {
+	username, role := getUserFromContext(c)
+	if role == models.RoleAdmin {
+		rel, err := filepath.Rel(h.cfg.HomeBaseDir, fullPath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return nil
+		}
+		username = strings.Split(rel, string(filepath.Separator))[0]
+	}
+	return quota.NewService(h.db).CheckWrite(username, addBytes)
+}
+
+// diskUsageResponse returns the current usage and history of an account
+func (h *Handler) diskUsageResponse(c *fiber.Ctx, userID int64) error {
+	svc := quota.NewService(h.db)
+
+	var usage *quota.Usage
+	var err error
+	if c.QueryBool("refresh") {
+		usage, err = svc.Sample(userID)
+	} else {
+		usage, err = svc.Current(userID)
+	}
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if err == quota.ErrAccountNotFound {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	history, _ := svc.History(userID, c.QueryInt("limit", 100))
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data: fiber.Map{
+			"usage":   usage,
+			"history": history,
+		},
+	})
+}
+
+// GetMyDiskUsage returns the disk usage of the caller's account
+func (h *Handler) GetMyDiskUsage(c *fiber.Ctx) error {
+	return h.diskUsageResponse(c, c.Locals("user_id").(int64))
+}
+
+// GetAccountDiskUsage returns the disk usage of an account
+func (h *Handler) GetAccountDiskUsage(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid account ID",
+		})
+	}
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Account not found",
+		})
+	}
+	return h.diskUsageResponse(c, id)
+}
+
+// SampleDiskUsage samples every account now (Admin only)
+func (h *Handler) SampleDiskUsage(c *fiber.Ctx) error {
+	usages, err := quota.NewService(h.db).SampleAll()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to sample disk usage",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    usages,
+	})
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/gofiber/fiber/v2"
+)
+
+// canManageUser reports whether the caller may manage a user: admins
+// manage everyone, resellers the users below them
+func (h *Handler) canManageUser(c *fiber.Ctx, targetID int64) bool {
+	userID := c.Locals("user_id").(int64)
+	switch c.Locals("role").(string) {
+	case models.RoleAdmin:
+		return true
+	case models.RoleReseller:
+		return account.NewService(h.db).IsDescendant(userID, targetID)
+	}
+	return false
+}
+
+// ListResellers reports the pool utilization of every reseller (Admin only)
+func (h *Handler) ListResellers(c *fiber.Ctx) error {
+	resellers, err := account.NewService(h.db).ListResellers()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to fetch resellers",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    resellers,
+	})
+}
+
+// GetReseller returns the limits and usage of a reseller. Resellers may
+// only read their own.
+func (h *Handler) GetReseller(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid reseller ID",
+		})
+	}
+	if role != models.RoleAdmin && id != userID {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Reseller not found",
+		})
+	}
+
+	usage, err := account.NewService(h.db).GetResellerUsage(id)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Reseller not found",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    usage,
+	})
+}
+
+// UpdateResellerLimits sets the resource pool of a reseller (Admin only)
+func (h *Handler) UpdateResellerLimits(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid reseller ID",
+		})
+	}
+
+	var limits account.ResellerLimits
+	if err := c.BodyParser(&limits); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+	limits.UserID = id
+
+	svc := account.NewService(h.db)
+	if err := svc.SetResellerLimits(limits); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "reseller_limits", fmt.Sprintf("Reseller %d pool: %d accounts, %d MB disk, %d MB bandwidth", id, limits.MaxAccounts, limits.MaxDiskQuota, limits.MaxBandwidth), c.IP())
+
+	usage, _ := svc.GetResellerUsage(id)
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Reseller pool updated",
+		Data:    usage,
+	})
+}
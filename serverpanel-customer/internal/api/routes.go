@@ -22,6 +22,10 @@ func SetupRoutes(router fiber.Router, db *database.DB) {
 	router.Get("/health", h.Health)
 	router.Get("/internal/pma-credentials", h.GetPhpMyAdminCredentials)
 
+	// DNS cluster peers push zones here, authenticated by the peer token
+	router.Put("/dns/cluster/zones/:domain", h.ReceiveDNSClusterZone)
+	router.Delete("/dns/cluster/zones/:domain", h.RemoveDNSClusterZone)
+
 	// Protected routes
 	protected := router.Group("/", middleware.AuthMiddleware(cfg.JWTSecret))
 
@@ -35,28 +39,51 @@ func SetupRoutes(router fiber.Router, db *database.DB) {
 
 	// Admin middleware for admin-only routes
 	admin := middleware.RoleMiddleware(models.RoleAdmin)
-
-	// Users (admin only)
-	protected.Get("/users", admin, h.ListUsers)
-	protected.Post("/users", admin, h.CreateUser)
-	protected.Get("/users/:id", admin, h.GetUser)
-	protected.Put("/users/:id", admin, h.UpdateUser)
-	protected.Delete("/users/:id", admin, h.DeleteUser)
-
-	// Packages (admin only)
-	protected.Get("/packages", admin, h.ListPackages)
-	protected.Get("/packages/:id", admin, h.GetPackage)
-	protected.Post("/packages", admin, h.CreatePackage)
-	protected.Put("/packages/:id", admin, h.UpdatePackage)
-	protected.Delete("/packages/:id", admin, h.DeletePackage)
-
-	// Accounts - Hosting hesapları (admin only)
-	protected.Get("/accounts", admin, h.ListAccounts)
-	protected.Post("/accounts", admin, h.CreateAccount)
-	protected.Get("/accounts/:id", admin, h.GetAccount)
-	protected.Delete("/accounts/:id", admin, h.DeleteAccount)
-	protected.Post("/accounts/:id/suspend", admin, h.SuspendAccount)
-	protected.Post("/accounts/:id/unsuspend", admin, h.UnsuspendAccount)
+	// Staff middleware for routes resellers share with admins; handlers
+	// scope resellers to the users below them
+	staff := middleware.RoleMiddleware(models.RoleAdmin, models.RoleReseller)
+
+	// Users (admin: all, reseller: own customers)
+	protected.Get("/users", staff, h.ListUsers)
+	protected.Post("/users", staff, h.CreateUser)
+	protected.Get("/users/:id", staff, h.GetUser)
+	protected.Put("/users/:id", staff, h.UpdateUser)
+	protected.Delete("/users/:id", staff, h.DeleteUser)
+
+	// Packages (admin: all, reseller: own packages within its pool)
+	protected.Get("/packages", staff, h.ListPackages)
+	protected.Get("/packages/:id", staff, h.GetPackage)
+	protected.Post("/packages", staff, h.CreatePackage)
+	protected.Put("/packages/:id", staff, h.UpdatePackage)
+	protected.Delete("/packages/:id", staff, h.DeletePackage)
+
+	// Accounts - Hosting hesapları (admin: all, reseller: own customers)
+	protected.Get("/accounts", staff, h.ListAccounts)
+	protected.Post("/accounts", staff, h.CreateAccount)
+	protected.Get("/accounts/:id", staff, h.GetAccount)
+	protected.Delete("/accounts/:id", staff, h.DeleteAccount)
+	protected.Post("/accounts/:id/suspend", staff, h.SuspendAccount)
+	protected.Post("/accounts/:id/unsuspend", staff, h.UnsuspendAccount)
+	protected.Post("/accounts/:id/package", staff, h.ChangeAccountPackage)
+	protected.Get("/accounts/:id/disk-usage", staff, h.GetAccountDiskUsage)
+	protected.Get("/accounts/:id/bandwidth", staff, h.GetAccountBandwidth)
+	protected.Get("/accounts/:id/php-pools", staff, h.GetAccountPHPPools)
+	protected.Post("/accounts/:id/php-pools/rebuild", staff, h.RebuildAccountPHPPools)
+
+	// Disk usage (own account, sampling all accounts is admin only)
+	protected.Get("/disk-usage", h.GetMyDiskUsage)
+	protected.Post("/disk-usage/sample", admin, h.SampleDiskUsage)
+
+	// Bandwidth (own account; report for staff, overages and ingestion admin only)
+	protected.Get("/bandwidth", h.GetMyBandwidth)
+	protected.Get("/bandwidth/report", staff, h.GetBandwidthReport)
+	protected.Get("/bandwidth/overages", admin, h.ListBandwidthOverages)
+	protected.Post("/bandwidth/ingest", admin, h.IngestBandwidth)
+
+	// Resellers (pools set by admin, resellers read their own)
+	protected.Get("/resellers", admin, h.ListResellers) // pool utilization report
+	protected.Get("/resellers/:id", staff, h.GetReseller)
+	protected.Put("/resellers/:id/limits", admin, h.UpdateResellerLimits)
 
 	// Domains (all authenticated users)
 	protected.Get("/domains", h.ListDomains)
@@ -65,11 +92,15 @@ func SetupRoutes(router fiber.Router, db *database.DB) {
 	protected.Get("/domains/:id", h.GetDomain)
 	protected.Put("/domains/:id", h.UpdateDomain)
 	protected.Delete("/domains/:id", h.DeleteDomain)
+	protected.Get("/domains/:id/directives", h.GetDomainDirectives)
+	protected.Put("/domains/:id/directives", h.UpdateDomainDirectives)
 
 	// Subdomains (all authenticated users)
 	protected.Get("/subdomains", h.ListSubdomains)
 	protected.Post("/subdomains", h.CreateSubdomain)
 	protected.Delete("/subdomains/:id", h.DeleteSubdomain)
+	protected.Get("/subdomains/:id/directives", h.GetSubdomainDirectives)
+	protected.Put("/subdomains/:id/directives", h.UpdateSubdomainDirectives)
 
 	// Databases (all authenticated users)
 	protected.Get("/databases", h.ListDatabases)
@@ -101,6 +132,10 @@ func SetupRoutes(router fiber.Router, db *database.DB) {
 	protected.Get("/php/domains/:id", h.GetDomainPHPSettings)
 	protected.Put("/php/domains/:id/version", h.UpdateDomainPHPVersion)
 	protected.Put("/php/domains/:id/settings", h.UpdateDomainPHPSettings)
+	protected.Get("/php/pools", h.GetMyPHPPools)
+	protected.Get("/php/ini/catalog", h.GetPHPIniCatalog)
+	protected.Get("/php/domains/:id/ini", h.GetDomainPHPIni)
+	protected.Put("/php/domains/:id/ini", h.UpdateDomainPHPIni)
 
 	// FTP Management (all authenticated users)
 	protected.Get("/ftp/accounts", h.ListFTPAccounts)
@@ -122,6 +157,19 @@ func SetupRoutes(router fiber.Router, db *database.DB) {
 	protected.Put("/dns/records/:id", h.UpdateDNSRecord)
 	protected.Delete("/dns/records/:id", h.DeleteDNSRecord)
 	protected.Post("/dns/zones/:id/reset", h.ResetDNSZone)
+	protected.Get("/dns/zones/:id/export", h.ExportDNSZone)
+	protected.Post("/dns/zones/:id/import/preview", h.PreviewDNSZoneImport)
+	protected.Post("/dns/zones/:id/import", h.ImportDNSZone)
+	protected.Get("/dns/zones/:id/dnssec", h.GetDNSSEC)
+	protected.Post("/dns/zones/:id/dnssec", h.EnableDNSSEC)
+	protected.Delete("/dns/zones/:id/dnssec", h.DisableDNSSEC)
+	protected.Post("/dns/zones/:id/dnssec/rollover", h.RolloverDNSSECZSK)
+	protected.Get("/dns/cluster/peers", admin, h.ListDNSClusterPeers)
+	protected.Post("/dns/cluster/peers", admin, h.AddDNSClusterPeer)
+	protected.Get("/dns/cluster/peers/:id", admin, h.GetDNSClusterPeer)
+	protected.Delete("/dns/cluster/peers/:id", admin, h.DeleteDNSClusterPeer)
+	protected.Post("/dns/cluster/peers/:id/sync", admin, h.SyncDNSClusterPeer)
+	protected.Get("/dns/cluster/conflicts", admin, h.ListDNSClusterConflicts)
 
 	// Email Management (all authenticated users)
 	protected.Get("/email/accounts", h.ListEmailAccounts)
@@ -209,6 +257,13 @@ func SetupRoutes(router fiber.Router, db *database.DB) {
 	protected.Get("/settings/server", admin, h.GetServerSettings)
 	protected.Put("/settings/server", admin, h.UpdateServerSettings)
 
+	// Vhost templates (admin only)
+	protected.Get("/vhost-templates", admin, h.ListVhostTemplates)
+	protected.Post("/vhost-templates/rebuild", admin, h.RebuildVhosts)
+	protected.Get("/vhost-templates/:name", admin, h.GetVhostTemplate)
+	protected.Put("/vhost-templates/:name", admin, h.UpdateVhostTemplate)
+	protected.Delete("/vhost-templates/:name", admin, h.ResetVhostTemplate)
+
 	// Server Features (all users - read only)
 	protected.Get("/server/features", h.GetServerFeatures)
 	protected.Get("/php/allowed-versions", h.GetAllowedPHPVersions)
@@ -299,6 +354,44 @@ func SetupRoutes(router fiber.Router, db *database.DB) {
 	protected.Get("/security/modsecurity/cms-exclusions", admin, h.GetCMSExclusions)
 	protected.Post("/security/modsecurity/cms-exclusions", admin, h.ToggleCMSExclusion)
 
+	// Backups (all authenticated users - users see only their own)
+	// Snapshot, destination and encryption routes come first so they are not matched as :id
+	protected.Get("/backups/snapshots", h.ListBackupSnapshots)
+	protected.Post("/backups/snapshots", h.CreateBackupSnapshot)
+	protected.Get("/backups/snapshots/:id", h.GetBackupSnapshot)
+	protected.Delete("/backups/snapshots/:id", h.DeleteBackupSnapshot)
+	protected.Post("/backups/snapshots/:id/restore", h.RestoreBackupSnapshot)
+	protected.Post("/backups/prune", admin, h.PruneBackups)
+	protected.Get("/backups/destinations", admin, h.ListBackupDestinations)
+	protected.Post("/backups/destinations", admin, h.CreateBackupDestination)
+	protected.Get("/backups/destinations/:id", admin, h.GetBackupDestination)
+	protected.Put("/backups/destinations/:id", admin, h.UpdateBackupDestination)
+	protected.Delete("/backups/destinations/:id", admin, h.DeleteBackupDestination)
+	protected.Post("/backups/destinations/:id/test", admin, h.TestBackupDestination)
+	protected.Get("/backups/encryption", h.GetBackupEncryption)
+	protected.Put("/backups/passphrase", h.SetBackupPassphrase)
+	protected.Delete("/backups/passphrase", h.RemoveBackupPassphrase)
+	protected.Post("/backups/keys/rotate", admin, h.RotateBackupKey)
+	protected.Get("/backups", h.ListBackups)
+	protected.Post("/backups", h.CreateBackup)
+	protected.Get("/backups/:id", h.GetBackup)
+	protected.Get("/backups/:id/download", h.DownloadBackup)
+	protected.Delete("/backups/:id", h.DeleteBackup)
+	protected.Post("/backups/:id/restore", h.RestoreBackupItem)
+	protected.Post("/backups/:id/verify", h.VerifyBackup)
+
+	// Scheduled Jobs (admin sees all, user manages own backup schedules)
+	protected.Get("/schedules", h.ListSchedules)
+	protected.Post("/schedules", h.CreateSchedule)
+	protected.Get("/schedules/:id", h.GetSchedule)
+	protected.Put("/schedules/:id", h.UpdateSchedule)
+	protected.Delete("/schedules/:id", h.DeleteSchedule)
+	protected.Get("/schedules/:id/runs", h.ListScheduleRuns)
+	protected.Post("/schedules/:id/run", h.RunSchedule)
+
+	// Account Imports (admin only)
+	protected.Post("/imports/cpanel", admin, h.ImportCPanelAccount)
+
 	// System Updates (admin only)
 	protected.Get("/system/updates/check", admin, h.CheckForUpdates)
 	protected.Get("/system/updates/status", admin, h.GetUpdateStatus)
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/backup"
+	"github.com/asergenalkan/serverpanel/internal/services/scheduler"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Customers may not schedule jobs more often than this
+const minUserScheduleInterval = time.Hour
+
+func init() {
+	// Stream every scheduled run to the task log so it can be followed
+	// over /tasks/:task_id and the task WebSocket
+	scheduler.SetTaskHook(func(job *scheduler.Job, run *scheduler.Run) (string, scheduler.LogFunc, func(bool)) {
+		taskID := fmt.Sprintf("schedule-%d-run-%d", job.ID, run.ID)
+		taskName := job.Name
+		taskManager.createTask(taskID, "schedule", taskName)
+
+		logf := func(format string, args ...interface{}) {
+			taskManager.addLog(taskID, fmt.Sprintf(format, args...))
+		}
+		logf("🚀 %s başlatılıyor...", taskName)
+		logf("")
+
+		return taskID, logf, func(success bool) {
+			if success {
+				logf("")
+				logf("✅ %s başarıyla tamamlandı!", taskName)
+			}
+			taskManager.completeTask(taskID, success)
+		}
+	})
+}
+
+type scheduleRequest struct {
+	Kind           string          `json:"kind"`
+	Name           string          `json:"name"`
+	UserID         int64           `json:"user_id"` // Admin only, 0 = server wide
+	CronExpression string          `json:"cron_expression"`
+	Enabled        *bool           `json:"enabled"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// resolveScheduleCron accepts the cron page presets ("daily", ...) as well
+// as cron expressions
+func resolveScheduleCron(expr string) string {
+	if preset, ok := cronPresets[expr]; ok && expr != "custom" {
+		return strings.Join([]string{preset.Minute, preset.Hour, preset.Day, preset.Month, preset.Weekday}, " ")
+	}
+	return expr
+}
+
+// applyScheduleRequest copies a request onto a job and enforces what
+// customers are allowed to schedule
+func (h *Handler) applyScheduleRequest(c *fiber.Ctx, job *scheduler.Job, req *scheduleRequest) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	if req.Name != "" {
+		job.Name = strings.TrimSpace(req.Name)
+	}
+	if req.CronExpression != "" {
+		job.CronExpression = resolveScheduleCron(strings.TrimSpace(req.CronExpression))
+	}
+	if req.Enabled != nil {
+		job.Enabled = *req.Enabled
+	}
+	if len(req.Payload) > 0 {
+		job.Payload = req.Payload
+	}
+	if job.Name == "" || job.CronExpression == "" {
+		return errors.New("Name and cron_expression are required")
+	}
+
+	expr, err := scheduler.ParseCron(job.CronExpression)
+	if err != nil {
+		return err
+	}
+
+	if role == models.RoleAdmin {
+		return nil
+	}
+
+	// Customers schedule backups of their own account to the panel's
+	// local storage only
+	if job.Kind != backup.KindBackup {
+		return errors.New("Only backup schedules are allowed")
+	}
+	job.UserID = userID
+	if len(job.Payload) > 0 {
+		policy, err := backup.ParseSchedulePolicy(job.Payload)
+		if err != nil {
+			return err
+		}
+		policy.DestinationID = 0
+		job.Payload, _ = json.Marshal(policy)
+	}
+	if interval := expr.MinInterval(time.Now(), 48); interval > 0 && interval < minUserScheduleInterval {
+		return errors.New("Schedules can run at most once an hour")
+	}
+	return nil
+}
+
+// getAccessibleSchedule loads a scheduled job the caller may manage
+func (h *Handler) getAccessibleSchedule(c *fiber.Ctx) (*scheduler.Job, error) {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid schedule ID",
+		})
+	}
+
+	job, err := scheduler.NewService(h.db).GetJob(id)
+	if err != nil || (role != models.RoleAdmin && job.UserID != userID) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Schedule not found",
+		})
+	}
+	return job, nil
+}
+
+// ListSchedules returns scheduled jobs (admin sees all, user sees own)
+func (h *Handler) ListSchedules(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	filterUser := int64(c.QueryInt("user_id", 0))
+	if role != models.RoleAdmin {
+		filterUser = userID
+	}
+
+	jobs, err := scheduler.NewService(h.db).ListJobs(c.Query("kind"), filterUser)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to list schedules",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data: fiber.Map{
+			"schedules": jobs,
+			"kinds":     scheduler.Kinds(),
+		},
+	})
+}
+
+// CreateSchedule creates a scheduled job
+func (h *Handler) CreateSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	var req scheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	job := &scheduler.Job{
+		Kind:      req.Kind,
+		UserID:    req.UserID,
+		Enabled:   true,
+		CreatedBy: userID,
+	}
+	if job.Kind == "" {
+		job.Kind = backup.KindBackup
+	}
+	if err := h.applyScheduleRequest(c, job, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	job, err := scheduler.NewService(h.db).SaveJob(job)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "schedule_create", fmt.Sprintf("Created schedule %s (%s, %s)", job.Name, job.Kind, job.CronExpression), c.IP())
+
+	return c.Status(fiber.StatusCreated).JSON(models.APIResponse{
+		Success: true,
+		Message: "Schedule created",
+		Data:    job,
+	})
+}
+
+// GetSchedule returns a scheduled job with its latest runs
+func (h *Handler) GetSchedule(c *fiber.Ctx) error {
+	job, err := h.getAccessibleSchedule(c)
+	if job == nil {
+		return err
+	}
+
+	runs, _ := scheduler.NewService(h.db).ListRuns(job.ID, 10)
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data: fiber.Map{
+			"schedule": job,
+			"runs":     runs,
+		},
+	})
+}
+
+// UpdateSchedule changes a scheduled job
+func (h *Handler) UpdateSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	job, err := h.getAccessibleSchedule(c)
+	if job == nil {
+		return err
+	}
+
+	var req scheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+	if c.Locals("role").(string) == models.RoleAdmin && req.UserID > 0 {
+		job.UserID = req.UserID
+	}
+	if err := h.applyScheduleRequest(c, job, &req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	job, err = scheduler.NewService(h.db).SaveJob(job)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "schedule_update", fmt.Sprintf("Updated schedule %s (%s)", job.Name, job.CronExpression), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Schedule updated",
+		Data:    job,
+	})
+}
+
+// DeleteSchedule deletes a scheduled job and its run history. Backups it
+// made are kept.
+func (h *Handler) DeleteSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	job, err := h.getAccessibleSchedule(c)
+	if job == nil {
+		return err
+	}
+
+	if err := scheduler.NewService(h.db).DeleteJob(job.ID); err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrAlreadyRunning) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "schedule_delete", fmt.Sprintf("Deleted schedule %s", job.Name), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Schedule deleted",
+	})
+}
+
+// ListScheduleRuns returns the run history of a scheduled job
+func (h *Handler) ListScheduleRuns(c *fiber.Ctx) error {
+	job, err := h.getAccessibleSchedule(c)
+	if job == nil {
+		return err
+	}
+
+	runs, err := scheduler.NewService(h.db).ListRuns(job.ID, c.QueryInt("limit", 50))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to list runs",
+		})
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data:    runs,
+	})
+}
+
+// RunSchedule runs a scheduled job now; the returned task id streams
+// its progress
+func (h *Handler) RunSchedule(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+
+	job, err := h.getAccessibleSchedule(c)
+	if job == nil {
+		return err
+	}
+
+	run, err := scheduler.NewService(h.db).RunNow(job.ID)
+	if err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, scheduler.ErrAlreadyRunning) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(userID, "schedule_run", fmt.Sprintf("Started schedule %s manually", job.Name), c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Schedule started",
+		Data: fiber.Map{
+			"run":     run,
+			"task_id": run.TaskID,
+		},
+	})
+}
@@ -2,9 +2,13 @@ package api
 
 import (
 	"os/exec"
+	"strconv"
 	"strings"
 
+	"github.com/asergenalkan/serverpanel/internal/dnsserver"
 	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/bandwidth"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -15,6 +19,21 @@ type ServerSettings struct {
 	AllowedPHPVersions []string `json:"allowed_php_versions"`
 	DomainBasedPHP     bool     `json:"domain_based_php"`
 	NodejsEnabled      bool     `json:"nodejs_enabled"`
+	BackupEncryption   bool     `json:"backup_encryption"`
+
+	// Action on accounts over their monthly bandwidth: notify, throttle
+	// (to BandwidthThrottleRate KB/s) or suspend
+	BandwidthOverageAction string `json:"bandwidth_overage_action"`
+	BandwidthThrottleRate  int    `json:"bandwidth_throttle_rate"`
+
+	// php.ini directives customers may change; an empty allow list
+	// allows the whole catalog, the deny list wins over it
+	PHPIniAllowed []string `json:"php_ini_allowed"`
+	PHPIniDenied  []string `json:"php_ini_denied"`
+
+	// Secondary name servers (IP or IP:port) the built-in DNS server
+	// notifies and lets transfer zones
+	DNSSecondaries []string `json:"dns_secondaries"`
 }
 
 // GetServerSettings returns server settings (admin only)
@@ -24,6 +43,14 @@ func (h *Handler) GetServerSettings(c *fiber.Ctx) error {
 		DefaultPHPVersion:  "8.1",
 		AllowedPHPVersions: []string{"7.4", "8.0", "8.1", "8.2", "8.3"},
 		DomainBasedPHP:     true,
+
+		BandwidthOverageAction: bandwidth.ActionNotify,
+		BandwidthThrottleRate:  256,
+
+		PHPIniAllowed: []string{},
+		PHPIniDenied:  []string{},
+
+		DNSSecondaries: []string{},
 	}
 
 	// Load from database
@@ -44,6 +71,18 @@ func (h *Handler) GetServerSettings(c *fiber.Ctx) error {
 				settings.DomainBasedPHP = value == "true"
 			case "nodejs_enabled":
 				settings.NodejsEnabled = value == "true"
+			case "backup_encryption":
+				settings.BackupEncryption = value == "true"
+			case "bandwidth_overage_action":
+				settings.BandwidthOverageAction = value
+			case "bandwidth_throttle_rate":
+				settings.BandwidthThrottleRate, _ = strconv.Atoi(value)
+			case "php_ini_allowed":
+				settings.PHPIniAllowed = splitSettingList(value)
+			case "php_ini_denied":
+				settings.PHPIniDenied = splitSettingList(value)
+			case "dns_secondaries":
+				settings.DNSSecondaries = splitSettingList(value)
 			}
 		}
 	}
@@ -76,6 +115,38 @@ func (h *Handler) UpdateServerSettings(c *fiber.Ctx) error {
 		req.DefaultPHPVersion = req.AllowedPHPVersions[0]
 	}
 
+	switch req.BandwidthOverageAction {
+	case bandwidth.ActionNotify, bandwidth.ActionThrottle, bandwidth.ActionSuspend:
+	case "":
+		req.BandwidthOverageAction = bandwidth.ActionNotify
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "bandwidth_overage_action must be notify, throttle or suspend",
+		})
+	}
+	if req.BandwidthThrottleRate <= 0 {
+		req.BandwidthThrottleRate = 256
+	}
+
+	for _, name := range append(append([]string{}, req.PHPIniAllowed...), req.PHPIniDenied...) {
+		if _, ok := webserver.LookupPHPIni(name); !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Unknown php.ini directive: " + name,
+			})
+		}
+	}
+
+	for _, sec := range req.DNSSecondaries {
+		if !dnsserver.ValidSecondary(sec) {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Invalid DNS secondary (IP or IP:port): " + sec,
+			})
+		}
+	}
+
 	// Update settings
 	updates := map[string]string{
 		"multiphp_enabled":     boolToString(req.MultiPHPEnabled),
@@ -83,6 +154,15 @@ func (h *Handler) UpdateServerSettings(c *fiber.Ctx) error {
 		"allowed_php_versions": strings.Join(req.AllowedPHPVersions, ","),
 		"domain_based_php":     boolToString(req.DomainBasedPHP),
 		"nodejs_enabled":       boolToString(req.NodejsEnabled),
+		"backup_encryption":    boolToString(req.BackupEncryption),
+
+		"bandwidth_overage_action": req.BandwidthOverageAction,
+		"bandwidth_throttle_rate":  strconv.Itoa(req.BandwidthThrottleRate),
+
+		"php_ini_allowed": strings.Join(req.PHPIniAllowed, ","),
+		"php_ini_denied":  strings.Join(req.PHPIniDenied, ","),
+
+		"dns_secondaries": strings.Join(req.DNSSecondaries, ","),
 	}
 
 	for key, value := range updates {
@@ -245,6 +325,18 @@ func (h *Handler) isCommandAvailable(cmd string) bool {
 	return checkCmd.Run() == nil
 }
 
+// splitSettingList splits a comma separated setting, empty giving an empty
+// list
+func splitSettingList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func boolToString(b bool) string {
 	if b {
 		return "true"
@@ -3,7 +3,9 @@ package api
 import (
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,6 +14,8 @@ import (
 	"time"
 
 	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -29,6 +33,7 @@ type SSLCertificate struct {
 	ValidFrom    time.Time `json:"valid_from"`
 	ValidUntil   time.Time `json:"valid_until"`
 	AutoRenew    bool      `json:"auto_renew"`
+	ManagedBy    string    `json:"managed_by,omitempty"` // web server issuing the certificate itself
 	CertPath     string    `json:"cert_path,omitempty"`
 	KeyPath      string    `json:"key_path,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
@@ -155,6 +160,9 @@ func (h *Handler) buildSSLCertificate(domainID, subdomainID int64, fqdn, domainT
 		ParentDomain: parentDomain,
 		AutoRenew:    true,
 	}
+	if driver := h.webDriver(); driver.ManagesCertificates() && domainType != "mail" && domainType != "ftp" {
+		cert.ManagedBy = driver.Name()
+	}
 
 	// Check if certificate exists for this FQDN
 	certInfo := h.getCertificateInfo(fqdn)
@@ -201,9 +209,12 @@ func (h *Handler) buildSSLCertificate(domainID, subdomainID int64, fqdn, domainT
 
 // isCoveredByCert checks if a subdomain is covered by parent's certificate
 func (h *Handler) isCoveredByCert(subdomain, parentDomain string) bool {
-	certPath := filepath.Join("/etc/letsencrypt/live", parentDomain, "fullchain.pem")
+	parent := h.getCertificateInfo(parentDomain)
+	if parent == nil {
+		return false
+	}
 
-	certPEM, err := os.ReadFile(certPath)
+	certPEM, err := os.ReadFile(parent.CertPath)
 	if err != nil {
 		return false
 	}
@@ -332,6 +343,11 @@ func (h *Handler) IssueSSLCertificate(c *fiber.Ctx) error {
 		})
 	}
 
+	// Caddy obtains the certificate itself once it serves the domain
+	if h.webDriver().ManagesCertificates() {
+		return h.managedCertificate(c, h.buildSSLCertificate(domainID, 0, domain, "domain", ""), domain)
+	}
+
 	// Determine webroot
 	webRoot := filepath.Join("/home", username, "public_html")
 	if _, err := os.Stat(webRoot); os.IsNotExist(err) {
@@ -356,8 +372,10 @@ func (h *Handler) IssueSSLCertificate(c *fiber.Ctx) error {
 
 	// Update Apache/Nginx config to use SSL
 	if err := h.configureSSLVhost(domain, username, certInfo); err != nil {
-		// Log but don't fail - certificate was issued
-		// log.Printf("Warning: Failed to configure SSL vhost: %v", err)
+		return c.Status(sslVhostErrorStatus(err)).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Certificate issued but the SSL vhost was not applied: " + err.Error(),
+		})
 	}
 
 	return c.JSON(models.APIResponse{
@@ -425,6 +443,17 @@ func (h *Handler) IssueSSLForFQDN(c *fiber.Ctx) error {
 		})
 	}
 
+	// Caddy obtains the certificates of the sites it serves itself; www
+	// is an alias in the parent's site
+	if h.webDriver().ManagesCertificates() && (req.DomainType == "subdomain" || req.DomainType == "www") {
+		site := req.FQDN
+		if req.DomainType == "www" {
+			site = strings.TrimPrefix(req.FQDN, "www.")
+		}
+		cert := h.buildSSLCertificate(req.DomainID, 0, req.FQDN, req.DomainType, parentDomain)
+		return h.managedCertificate(c, cert, site)
+	}
+
 	// Determine webroot based on domain type
 	var webRoot string
 	switch req.DomainType {
@@ -470,7 +499,12 @@ func (h *Handler) IssueSSLForFQDN(c *fiber.Ctx) error {
 	// Configure SSL vhost based on domain type
 	switch req.DomainType {
 	case "subdomain":
-		h.configureSSLVhostForFQDN(req.FQDN, username, webRoot, certInfo)
+		if err := h.configureSSLVhostForFQDN(req.FQDN, username, webRoot, certInfo); err != nil {
+			return c.Status(sslVhostErrorStatus(err)).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Certificate issued but the SSL vhost was not applied: " + err.Error(),
+			})
+		}
 	case "webmail":
 		h.configureSSLVhostForWebmail(req.FQDN, certInfo)
 	case "mail":
@@ -532,6 +566,13 @@ func (h *Handler) RenewSSLCertificate(c *fiber.Ctx) error {
 		})
 	}
 
+	if driver := h.webDriver(); driver.ManagesCertificates() {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Certificates of %s sites are renewed by %s automatically", driver.Name(), driver.Name()),
+		})
+	}
+
 	// Renew certificate
 	if err := h.renewCertificate(domain); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
@@ -582,6 +623,13 @@ func (h *Handler) RevokeSSLCertificate(c *fiber.Ctx) error {
 		})
 	}
 
+	if driver := h.webDriver(); driver.ManagesCertificates() {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Certificates of %s sites are managed by %s", driver.Name(), driver.Name()),
+		})
+	}
+
 	// Revoke certificate
 	if err := h.revokeCertificate(domain); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
@@ -591,7 +639,9 @@ func (h *Handler) RevokeSSLCertificate(c *fiber.Ctx) error {
 	}
 
 	// Remove SSL from vhost
-	h.removeSSLVhost(domain, username)
+	if err := h.removeSSLVhost(domain, username); err != nil {
+		log.Printf("⚠️ Removing SSL from vhost of %s: %v", domain, err)
+	}
 
 	return c.JSON(models.APIResponse{
 		Success: true,
@@ -601,6 +651,46 @@ func (h *Handler) RevokeSSLCertificate(c *fiber.Ctx) error {
 
 // Helper functions
 
+// webDriver returns the driver of the configured web server
+func (h *Handler) webDriver() webserver.Driver {
+	return webserver.NewDriver(webserver.DriverType(h.cfg.WebServer), h.cfg.SimulateMode, h.cfg.SimulateBasePath)
+}
+
+// managedCertificate answers an issue request for a site whose web server
+// obtains certificates itself: the site's vhost is (re)applied so the web
+// server serves it, and the certificate follows without certbot
+func (h *Handler) managedCertificate(c *fiber.Ctx, cert SSLCertificate, site string) error {
+	if err := account.NewService(h.db).RebuildVhost(site); err != nil {
+		return c.Status(sslVhostErrorStatus(err)).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Failed to apply the vhost: " + err.Error(),
+		})
+	}
+
+	if cert.Status != "active" {
+		cert.Status = "pending"
+		cert.StatusDetail = fmt.Sprintf("%s sertifikayı otomatik olarak alıyor", cert.ManagedBy)
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("SSL certificate for %s is obtained and renewed by %s", cert.Domain, cert.ManagedBy),
+		Data:    cert,
+	})
+}
+
+// sslVhostErrorStatus maps a failed SSL vhost change to a status code
+func sslVhostErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, webserver.ErrConfigTest):
+		return fiber.StatusUnprocessableEntity
+	case err == account.ErrAccountSuspended:
+		return fiber.StatusConflict
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
 type certInfo struct {
 	Issuer     string
 	ValidFrom  time.Time
@@ -609,10 +699,22 @@ type certInfo struct {
 	KeyPath    string
 }
 
+// Where Caddy keeps the certificates it obtained, per ACME issuer
+const caddyCertificatesDir = "/var/lib/caddy/.local/share/caddy/certificates"
+
 func (h *Handler) getCertificateInfo(domain string) *certInfo {
 	certPath := filepath.Join("/etc/letsencrypt/live", domain, "fullchain.pem")
 	keyPath := filepath.Join("/etc/letsencrypt/live", domain, "privkey.pem")
 
+	if h.webDriver().ManagesCertificates() {
+		matches, _ := filepath.Glob(filepath.Join(caddyCertificatesDir, "*", domain, domain+".crt"))
+		if len(matches) == 0 {
+			return nil
+		}
+		certPath = matches[0]
+		keyPath = strings.TrimSuffix(certPath, ".crt") + ".key"
+	}
+
 	// Check if certificate exists
 	if _, err := os.Stat(certPath); os.IsNotExist(err) {
 		return nil
@@ -718,65 +820,15 @@ func (h *Handler) revokeCertificate(domain string) error {
 	return nil
 }
 
+// configureSSLVhost moves the domain's vhost to HTTPS with the issued
+// certificate, as one transaction with the web server config test
 func (h *Handler) configureSSLVhost(domain, username string, cert *certInfo) error {
-	vhostPath := filepath.Join("/etc/apache2/sites-available", domain+"-ssl.conf")
-
-	docRoot := filepath.Join("/home", username, "public_html")
-	phpVersion := "8.1" // Default PHP version
-
-	vhostContent := fmt.Sprintf(`<VirtualHost *:443>
-    ServerName %s
-    ServerAlias www.%s
-    DocumentRoot %s
-
-    SSLEngine on
-    SSLCertificateFile %s
-    SSLCertificateKeyFile %s
-
-    <Directory %s>
-        Options -Indexes +FollowSymLinks
-        AllowOverride All
-        Require all granted
-    </Directory>
-
-    <FilesMatch \.php$>
-        SetHandler "proxy:unix:/run/php/php%s-fpm-%s.sock|fcgi://localhost"
-    </FilesMatch>
-
-    ErrorLog ${APACHE_LOG_DIR}/%s-ssl-error.log
-    CustomLog ${APACHE_LOG_DIR}/%s-ssl-access.log combined
-</VirtualHost>
-`, domain, domain, docRoot, cert.CertPath, cert.KeyPath, docRoot, phpVersion, username, domain, domain)
-
-	if err := os.WriteFile(vhostPath, []byte(vhostContent), 0644); err != nil {
-		return err
-	}
-
-	// Enable site
-	exec.Command("a2ensite", domain+"-ssl").Run()
-
-	// Enable SSL module
-	exec.Command("a2enmod", "ssl").Run()
-
-	// Reload Apache
-	exec.Command("systemctl", "reload", "apache2").Run()
-
-	return nil
+	return account.NewService(h.db).InstallCertificate(domain, cert.CertPath, cert.KeyPath)
 }
 
+// removeSSLVhost serves the domain over plain HTTP again
 func (h *Handler) removeSSLVhost(domain, username string) error {
-	vhostPath := filepath.Join("/etc/apache2/sites-available", domain+"-ssl.conf")
-
-	// Disable site
-	exec.Command("a2dissite", domain+"-ssl").Run()
-
-	// Remove config file
-	os.Remove(vhostPath)
-
-	// Reload Apache
-	exec.Command("systemctl", "reload", "apache2").Run()
-
-	return nil
+	return account.NewService(h.db).RemoveCertificate(domain)
 }
 
 // issueCertificateForFQDN issues certificate for any FQDN (subdomain, www, mail)
@@ -811,43 +863,7 @@ func (h *Handler) issueCertificateForFQDN(fqdn, webRoot, email string) (*certInf
 
 // configureSSLVhostForFQDN configures SSL vhost for subdomain
 func (h *Handler) configureSSLVhostForFQDN(fqdn, username, docRoot string, cert *certInfo) error {
-	vhostPath := filepath.Join("/etc/apache2/sites-available", fqdn+"-ssl.conf")
-	phpVersion := "8.1"
-
-	vhostContent := fmt.Sprintf(`<VirtualHost *:443>
-    ServerName %s
-    DocumentRoot %s
-
-    SSLEngine on
-    SSLCertificateFile %s
-    SSLCertificateKeyFile %s
-
-    <Directory %s>
-        Options -Indexes +FollowSymLinks
-        AllowOverride All
-        Require all granted
-    </Directory>
-
-    <FilesMatch \.php$>
-        SetHandler "proxy:unix:/run/php/php%s-fpm-%s.sock|fcgi://localhost"
-    </FilesMatch>
-
-    ErrorLog ${APACHE_LOG_DIR}/%s-ssl-error.log
-    CustomLog ${APACHE_LOG_DIR}/%s-ssl-access.log combined
-</VirtualHost>
-`, fqdn, docRoot, cert.CertPath, cert.KeyPath, docRoot, phpVersion, username, fqdn, fqdn)
-
-	if err := os.WriteFile(vhostPath, []byte(vhostContent), 0644); err != nil {
-		return err
-	}
-
-	// Enable site
-	exec.Command("a2ensite", fqdn+"-ssl").Run()
-
-	// Reload Apache
-	exec.Command("systemctl", "reload", "apache2").Run()
-
-	return nil
+	return account.NewService(h.db).InstallCertificate(fqdn, cert.CertPath, cert.KeyPath)
 }
 
 // configureSSLVhostForWebmail configures SSL vhost for webmail subdomain
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 
 	"github.com/asergenalkan/serverpanel/internal/auth"
 	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -13,9 +16,29 @@ type CreateUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	Role     string `json:"role"`
+	ParentID int64  `json:"parent_id"` // Admin only, owning reseller
 }
 
+// ListUsers returns all users (admin) or the users below a reseller
 func (h *Handler) ListUsers(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
+	var visible map[int64]bool
+	if role == models.RoleReseller {
+		ids, err := account.NewService(h.db).DescendantIDs(userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Failed to fetch users",
+			})
+		}
+		visible = make(map[int64]bool, len(ids))
+		for _, id := range ids {
+			visible[id] = true
+		}
+	}
+
 	rows, err := h.db.Query(`
 		SELECT id, username, email, role, parent_id, active, created_at, updated_at
 		FROM users ORDER BY created_at DESC
@@ -34,6 +57,9 @@ func (h *Handler) ListUsers(c *fiber.Ctx) error {
 		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &u.ParentID, &u.Active, &u.CreatedAt, &u.UpdatedAt); err != nil {
 			continue
 		}
+		if visible != nil && !visible[u.ID] {
+			continue
+		}
 		users = append(users, u)
 	}
 
@@ -43,7 +69,12 @@ func (h *Handler) ListUsers(c *fiber.Ctx) error {
 	})
 }
 
+// CreateUser creates a panel user. Resellers create customers below
+// themselves within their account limit.
 func (h *Handler) CreateUser(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(int64)
+	role := c.Locals("role").(string)
+
 	var req CreateUserRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
@@ -70,6 +101,38 @@ func (h *Handler) CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if role == models.RoleReseller {
+		if req.Role != models.RoleUser {
+			return c.Status(fiber.StatusForbidden).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Resellers can only create customer accounts",
+			})
+		}
+		req.ParentID = userID
+	}
+
+	var parentID interface{}
+	if req.ParentID > 0 {
+		svc := account.NewService(h.db)
+		if _, err := svc.GetResellerLimits(req.ParentID); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   "Parent must be a reseller",
+			})
+		}
+		newAccounts := 0
+		if req.Role == models.RoleUser {
+			newAccounts = 1
+		}
+		if err := svc.CheckResellerCapacity(req.ParentID, newAccounts, account.PackageLimits{}); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+		}
+		parentID = req.ParentID
+	}
+
 	// Hash password
 	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
@@ -80,9 +143,9 @@ func (h *Handler) CreateUser(c *fiber.Ctx) error {
 	}
 
 	result, err := h.db.Exec(`
-		INSERT INTO users (username, email, password, role, active)
-		VALUES (?, ?, ?, ?, 1)
-	`, req.Username, req.Email, hashedPassword, req.Role)
+		INSERT INTO users (username, email, password, role, parent_id, active)
+		VALUES (?, ?, ?, ?, ?, 1)
+	`, req.Username, req.Email, hashedPassword, req.Role, parentID)
 
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
@@ -92,6 +155,7 @@ func (h *Handler) CreateUser(c *fiber.Ctx) error {
 	}
 
 	id, _ := result.LastInsertId()
+	h.logActivity(userID, "user_create", fmt.Sprintf("Created user %s (%s)", req.Username, req.Role), c.IP())
 
 	return c.Status(fiber.StatusCreated).JSON(models.APIResponse{
 		Success: true,
@@ -108,6 +172,12 @@ func (h *Handler) GetUser(c *fiber.Ctx) error {
 			Error:   "Invalid user ID",
 		})
 	}
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "User not found",
+		})
+	}
 
 	var user models.User
 	err = h.db.QueryRow(`
@@ -137,6 +207,13 @@ func (h *Handler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "User not found",
+		})
+	}
+
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -151,6 +228,14 @@ func (h *Handler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Resellers can not promote their customers
+	if req.Role != "" && c.Locals("role").(string) != models.RoleAdmin {
+		return c.Status(fiber.StatusForbidden).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Insufficient permissions",
+		})
+	}
+
 	// Build update query dynamically
 	updates := []string{}
 	args := []interface{}{}
@@ -211,6 +296,13 @@ func (h *Handler) DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if !h.canManageUser(c, id) {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "User not found",
+		})
+	}
+
 	// Don't allow deleting the last admin
 	var adminCount int
 	h.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'admin'").Scan(&adminCount)
@@ -225,6 +317,17 @@ func (h *Handler) DeleteUser(c *fiber.Ctx) error {
 		}
 	}
 
+	if err := account.NewService(h.db).CheckResellerDelete(id); err != nil {
+		status := fiber.StatusInternalServerError
+		if errors.Is(err, account.ErrResellerHasPackages) {
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
 	_, err = h.db.Exec("DELETE FROM users WHERE id = ?", id)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(models.APIResponse{
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/asergenalkan/serverpanel/internal/models"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
+	"github.com/gofiber/fiber/v2"
+)
+
+// directivesTarget resolves the vhost name of a domain or subdomain the
+// caller may configure
+func (h *Handler) directivesTarget(c *fiber.Ctx, subdomain bool) (string, error) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return "", c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid ID",
+		})
+	}
+
+	query := "SELECT name, user_id FROM domains WHERE id = ?"
+	if subdomain {
+		query = "SELECT full_name, user_id FROM subdomains WHERE id = ?"
+	}
+	var name string
+	var ownerID int64
+	if err := h.db.QueryRow(query, id).Scan(&name, &ownerID); err != nil {
+		return "", c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Domain not found",
+		})
+	}
+
+	if ownerID != c.Locals("user_id").(int64) && !h.canManageUser(c, ownerID) {
+		return "", c.Status(fiber.StatusForbidden).JSON(models.APIResponse{
+			Success: false,
+			Error:   "You cannot configure this domain",
+		})
+	}
+	return name, nil
+}
+
+func (h *Handler) getDirectives(c *fiber.Ctx, subdomain bool) error {
+	name, err := h.directivesTarget(c, subdomain)
+	if name == "" {
+		return err
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data: fiber.Map{
+			"domain":     name,
+			"directives": account.NewService(h.db).GetVhostDirectives(name),
+			"web_server": h.cfg.WebServer,
+			"max":        webserver.MaxDirectives,
+		},
+	})
+}
+
+func (h *Handler) updateDirectives(c *fiber.Ctx, subdomain bool) error {
+	name, err := h.directivesTarget(c, subdomain)
+	if name == "" {
+		return err
+	}
+
+	var req struct {
+		Directives []string `json:"directives"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "Invalid request body",
+		})
+	}
+
+	svc := account.NewService(h.db)
+	if err := svc.SetVhostDirectives(name, req.Directives); err != nil {
+		status := fiber.StatusInternalServerError
+		switch {
+		case errors.Is(err, webserver.ErrInvalidDirective):
+			status = fiber.StatusBadRequest
+		case errors.Is(err, webserver.ErrConfigTest):
+			// The web server rejected the config, which was rolled back
+			status = fiber.StatusUnprocessableEntity
+		case err == account.ErrVhostNotFound:
+			status = fiber.StatusNotFound
+		case err == account.ErrAccountSuspended:
+			status = fiber.StatusConflict
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "vhost_directives", "Updated custom directives of "+name, c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Directives applied",
+		Data: fiber.Map{
+			"domain":     name,
+			"directives": svc.GetVhostDirectives(name),
+		},
+	})
+}
+
+// GetDomainDirectives returns the custom vhost directives of a domain
+func (h *Handler) GetDomainDirectives(c *fiber.Ctx) error {
+	return h.getDirectives(c, false)
+}
+
+// UpdateDomainDirectives validates and applies the custom vhost
+// directives of a domain
+func (h *Handler) UpdateDomainDirectives(c *fiber.Ctx) error {
+	return h.updateDirectives(c, false)
+}
+
+// GetSubdomainDirectives returns the custom vhost directives of a
+// subdomain
+func (h *Handler) GetSubdomainDirectives(c *fiber.Ctx) error {
+	return h.getDirectives(c, true)
+}
+
+// UpdateSubdomainDirectives validates and applies the custom vhost
+// directives of a subdomain
+func (h *Handler) UpdateSubdomainDirectives(c *fiber.Ctx) error {
+	return h.updateDirectives(c, true)
+}
+
+// ListVhostTemplates returns every vhost template with its current text
+// (Admin only)
+func (h *Handler) ListVhostTemplates(c *fiber.Ctx) error {
+	templates := []webserver.TemplateInfo{}
+	for _, name := range webserver.TemplateNames() {
+		if info, err := webserver.GetTemplate(name); err == nil {
+			templates = append(templates, *info)
+		}
+	}
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data: fiber.Map{
+			"templates":  templates,
+			"directory":  webserver.TemplateDir(),
+			"web_server": h.cfg.WebServer,
+		},
+	})
+}
+
+// GetVhostTemplate returns a vhost template and its built-in default
+// (Admin only)
+func (h *Handler) GetVhostTemplate(c *fiber.Ctx) error {
+	info, err := webserver.GetTemplate(c.Params("name"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+	def, _ := webserver.DefaultTemplate(info.Name)
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Data: fiber.Map{
+			"template": info,
+			"default":  def,
+		},
+	})
+}
+
+// UpdateVhostTemplate stores an override of a vhost template. Existing
+// vhosts keep their config until they are rebuilt. (Admin only)
+func (h *Handler) UpdateVhostTemplate(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if _, err := webserver.DefaultTemplate(name); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&req); err != nil || req.Content == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   "content is required",
+		})
+	}
+
+	if err := webserver.SaveTemplate(name, req.Content); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "vhost_template", "Customized vhost template "+name, c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Template saved",
+	})
+}
+
+// ResetVhostTemplate goes back to the built-in text of a vhost template
+// (Admin only)
+func (h *Handler) ResetVhostTemplate(c *fiber.Ctx) error {
+	name := c.Params("name")
+	if err := webserver.ResetTemplate(name); err != nil {
+		status := fiber.StatusInternalServerError
+		if err == webserver.ErrTemplateNotFound {
+			status = fiber.StatusNotFound
+		}
+		return c.Status(status).JSON(models.APIResponse{
+			Success: false,
+			Error:   err.Error(),
+		})
+	}
+
+	h.logActivity(c.Locals("user_id").(int64), "vhost_template", "Reset vhost template "+name, c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: true,
+		Message: "Template reset",
+	})
+}
+
+// RebuildVhosts renders every domain and subdomain vhost from the current
+// templates (Admin only)
+func (h *Handler) RebuildVhosts(c *fiber.Ctx) error {
+	rebuilt, failures := account.NewService(h.db).RebuildAllVhosts()
+
+	h.logActivity(c.Locals("user_id").(int64), "vhost_rebuild", "Rebuilt vhosts from templates", c.IP())
+
+	return c.JSON(models.APIResponse{
+		Success: len(failures) == 0,
+		Data: fiber.Map{
+			"rebuilt":  rebuilt,
+			"failures": failures,
+		},
+	})
+}
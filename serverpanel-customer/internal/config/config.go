@@ -14,9 +14,10 @@ type Config struct {
 	DataDir          string
 	HomeBaseDir      string // /home on Linux, simulated on Mac
 	SimulateBasePath string // Base path for simulation files
-	WebServer        string // "apache" or "nginx" - default: apache
+	WebServer        string // "apache", "nginx", "nginx-apache" or "caddy" - default: apache
 	PHPVersion       string // e.g., "8.2"
 	ServerIP         string // Server IP address
+	DNSServer        string // "bind" or "native" (spdns answers from the database) - default: bind
 	IsLinux          bool
 	SimulateMode     bool // true if running in simulation mode
 }
@@ -47,6 +48,7 @@ func Load() *Config {
 		os.MkdirAll(homeBaseDir, 0755)
 		os.MkdirAll(filepath.Join(simulateBasePath, "apache"), 0755)
 		os.MkdirAll(filepath.Join(simulateBasePath, "nginx"), 0755)
+		os.MkdirAll(filepath.Join(simulateBasePath, "caddy"), 0755)
 		os.MkdirAll(filepath.Join(simulateBasePath, "php-fpm"), 0755)
 	} else {
 		// Production mode - use real paths
@@ -68,6 +70,7 @@ func Load() *Config {
 		WebServer:        webServer,
 		PHPVersion:       getEnv("PHP_VERSION", "8.2"),
 		ServerIP:         getEnv("SERVER_IP", "127.0.0.1"),
+		DNSServer:        getEnv("DNS_SERVER", "bind"),
 		IsLinux:          isLinux,
 		SimulateMode:     simulateMode,
 	}
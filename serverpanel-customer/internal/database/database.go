@@ -1,8 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"log"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
@@ -51,9 +54,11 @@ func (db *DB) migrate() error {
 		)`,
 
 		// Packages table
+		// Package names are unique per owner (admin packages have no owner,
+		// see idx_packages_admin_name)
 		`CREATE TABLE IF NOT EXISTS packages (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT UNIQUE NOT NULL,
+			name TEXT NOT NULL,
 			disk_quota INTEGER DEFAULT 1024,
 			bandwidth_quota INTEGER DEFAULT 10240,
 			max_domains INTEGER DEFAULT 1,
@@ -63,7 +68,9 @@ func (db *DB) migrate() error {
 			max_php_memory TEXT DEFAULT '256M',
 			max_php_upload TEXT DEFAULT '64M',
 			max_php_execution_time INTEGER DEFAULT 300,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			owner_id INTEGER REFERENCES users(id) ON DELETE RESTRICT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(owner_id, name)
 		)`,
 
 		// User packages (assignment)
@@ -383,6 +390,308 @@ func (db *DB) migrate() error {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_nodejs_apps_user_id ON nodejs_apps(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_nodejs_apps_status ON nodejs_apps(status)`,
+
+		// Backup jobs table - one row per .spbackup archive
+		`CREATE TABLE IF NOT EXISTS backup_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			created_by INTEGER,
+			type TEXT NOT NULL DEFAULT 'full',
+			status TEXT NOT NULL DEFAULT 'pending',
+			include_files INTEGER DEFAULT 1,
+			include_databases INTEGER DEFAULT 1,
+			include_email INTEGER DEFAULT 1,
+			include_dns INTEGER DEFAULT 1,
+			include_ftp INTEGER DEFAULT 1,
+			backup_path TEXT,
+			backup_size INTEGER DEFAULT 0,
+			file_count INTEGER DEFAULT 0,
+			started_at DATETIME,
+			completed_at DATETIME,
+			duration_seconds INTEGER DEFAULT 0,
+			error_message TEXT,
+			storage_backend TEXT DEFAULT 'local',
+			encrypted INTEGER DEFAULT 0,
+			encryption_key_id TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_backup_jobs_user_id ON backup_jobs(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_backup_jobs_status ON backup_jobs(status)`,
+		`CREATE INDEX IF NOT EXISTS idx_backup_jobs_created ON backup_jobs(created_at)`,
+
+		// Incremental home directory snapshots backed by a content-addressed chunk store
+		`CREATE TABLE IF NOT EXISTS backup_snapshots (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			created_by INTEGER,
+			base_snapshot_id INTEGER,
+			status TEXT NOT NULL DEFAULT 'pending',
+			file_count INTEGER DEFAULT 0,
+			total_size INTEGER DEFAULT 0,
+			changed_files INTEGER DEFAULT 0,
+			new_chunks INTEGER DEFAULT 0,
+			new_bytes INTEGER DEFAULT 0,
+			started_at DATETIME,
+			completed_at DATETIME,
+			duration_seconds INTEGER DEFAULT 0,
+			error_message TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_backup_snapshots_user_id ON backup_snapshots(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_backup_snapshots_created ON backup_snapshots(created_at)`,
+
+		// Files of a snapshot - chunks is a comma separated list of chunk hashes
+		`CREATE TABLE IF NOT EXISTS backup_snapshot_files (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			snapshot_id INTEGER NOT NULL,
+			path TEXT NOT NULL,
+			type TEXT NOT NULL DEFAULT 'file',
+			mode INTEGER DEFAULT 0,
+			size INTEGER DEFAULT 0,
+			mtime INTEGER DEFAULT 0,
+			link_target TEXT,
+			chunks TEXT,
+			FOREIGN KEY (snapshot_id) REFERENCES backup_snapshots(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_backup_snapshot_files_snapshot ON backup_snapshot_files(snapshot_id, path)`,
+
+		// Chunk store index (chunks live under DataDir/backups/chunks/<hh>/<hash>)
+		`CREATE TABLE IF NOT EXISTS backup_chunks (
+			hash TEXT PRIMARY KEY,
+			size INTEGER NOT NULL,
+			stored_size INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Remote backup destinations (local path, SFTP, FTP, S3)
+		// credentials are sealed with the master key in DataDir/keys
+		`CREATE TABLE IF NOT EXISTS backup_destinations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			type TEXT NOT NULL,
+			config TEXT NOT NULL DEFAULT '{}',
+			credentials TEXT,
+			keep_local INTEGER DEFAULT 0,
+			is_default INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Per-account backup passphrases. kdf holds the Argon2id parameters,
+		// wrapped_key the derived key sealed with the master key so the
+		// server can wrap new backups without knowing the passphrase.
+		`CREATE TABLE IF NOT EXISTS backup_encryption_keys (
+			user_id INTEGER PRIMARY KEY,
+			kdf TEXT NOT NULL,
+			wrapped_key TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+
+		// Scheduled jobs run by the panel's internal scheduler. kind selects
+		// the runner (e.g. "backup"), payload holds its settings and
+		// user_id is NULL for server wide jobs. next_run_at is UTC.
+		`CREATE TABLE IF NOT EXISTS scheduled_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind TEXT NOT NULL,
+			name TEXT NOT NULL,
+			user_id INTEGER,
+			cron_expression TEXT NOT NULL,
+			payload TEXT NOT NULL DEFAULT '{}',
+			enabled INTEGER DEFAULT 1,
+			last_run_at DATETIME,
+			last_status TEXT,
+			next_run_at DATETIME,
+			created_by INTEGER,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_jobs_next_run ON scheduled_jobs(enabled, next_run_at)`,
+
+		// One row per scheduled job run (history)
+		`CREATE TABLE IF NOT EXISTS scheduled_job_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL,
+			trigger TEXT NOT NULL,
+			status TEXT NOT NULL,
+			task_id TEXT,
+			started_at DATETIME NOT NULL,
+			completed_at DATETIME,
+			duration_seconds INTEGER DEFAULT 0,
+			items INTEGER DEFAULT 0,
+			failed INTEGER DEFAULT 0,
+			size INTEGER DEFAULT 0,
+			summary TEXT,
+			error_message TEXT,
+			FOREIGN KEY (job_id) REFERENCES scheduled_jobs(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_job_runs_job ON scheduled_job_runs(job_id, id)`,
+
+		// Aggregate limits of a reseller over all accounts below it
+		// (disk in MB, 0 = unlimited)
+		`CREATE TABLE IF NOT EXISTS reseller_limits (
+			user_id INTEGER PRIMARY KEY,
+			max_accounts INTEGER DEFAULT 0,
+			max_disk_quota INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_users_parent ON users(parent_id)`,
+
+		// Disk usage samples per account (MB), the latest row is the
+		// current usage
+		`CREATE TABLE IF NOT EXISTS disk_usage_samples (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			home_mb INTEGER DEFAULT 0,
+			mail_mb INTEGER DEFAULT 0,
+			database_mb INTEGER DEFAULT 0,
+			used_mb INTEGER DEFAULT 0,
+			quota_mb INTEGER DEFAULT 0,
+			sampled_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_disk_usage_samples_user ON disk_usage_samples(user_id, id)`,
+
+		// Web traffic per domain and day (bytes), ingested from access logs
+		`CREATE TABLE IF NOT EXISTS bandwidth_usage (
+			domain_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			day TEXT NOT NULL,
+			bytes INTEGER DEFAULT 0,
+			requests INTEGER DEFAULT 0,
+			PRIMARY KEY (domain_id, day),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_bandwidth_usage_user ON bandwidth_usage(user_id, day)`,
+
+		// Read position of each access log; fingerprint is a checksum of the
+		// first bytes to notice rotated logs
+		`CREATE TABLE IF NOT EXISTS bandwidth_log_offsets (
+			path TEXT PRIMARY KEY,
+			fingerprint INTEGER DEFAULT 0,
+			offset INTEGER DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Accounts that went over their monthly bandwidth (period YYYY-MM)
+		// and the action taken
+		`CREATE TABLE IF NOT EXISTS bandwidth_overages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			period TEXT NOT NULL,
+			action TEXT NOT NULL,
+			used_mb INTEGER DEFAULT 0,
+			quota_mb INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			resolved_at DATETIME,
+			UNIQUE (user_id, period),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		// Suspended accounts; state holds what the suspension changed
+		// (vhosts, mail/FTP entries, crontab, system user) to restore it
+		`CREATE TABLE IF NOT EXISTS account_suspensions (
+			user_id INTEGER PRIMARY KEY,
+			reason TEXT NOT NULL DEFAULT '',
+			suspended_by INTEGER DEFAULT 0,
+			state TEXT NOT NULL DEFAULT '{}',
+			suspended_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		// Vetted custom directives of a domain or subdomain vhost, one per line
+		`CREATE TABLE IF NOT EXISTS vhost_directives (
+			name TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			directives TEXT NOT NULL DEFAULT '',
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		// php.ini directives of a domain set through the php.ini editor,
+		// rendered into its PHP-FPM pool over php_settings
+		`CREATE TABLE IF NOT EXISTS php_ini_directives (
+			domain_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (domain_id, name),
+			FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE
+		)`,
+		// DNSSEC signing keys of a zone (flags 257 = KSK, 256 = ZSK); the
+		// timing drives publication and ZSK rollover
+		`CREATE TABLE IF NOT EXISTS dnssec_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			domain_id INTEGER NOT NULL,
+			flags INTEGER NOT NULL,
+			algorithm INTEGER NOT NULL,
+			key_tag INTEGER NOT NULL,
+			public_key TEXT NOT NULL,
+			private_key TEXT NOT NULL,
+			publish_at DATETIME NOT NULL,
+			activate_at DATETIME NOT NULL,
+			inactive_at DATETIME,
+			delete_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE
+		)`,
+
+		// SOA serials of the built-in DNS server (spdns), bumped when the
+		// hash of a zone's records changes
+		`CREATE TABLE IF NOT EXISTS dns_zone_serials (
+			domain_id INTEGER PRIMARY KEY,
+			serial INTEGER NOT NULL,
+			records_hash TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (domain_id) REFERENCES domains(id) ON DELETE CASCADE
+		)`,
+
+		// Panel servers of the DNS cluster. The token is shared by both
+		// sides: sent with the zones pushed to the peer and expected on
+		// the zones it pushes here.
+		`CREATE TABLE IF NOT EXISTS dns_cluster_peers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			url TEXT NOT NULL,
+			token TEXT NOT NULL,
+			active INTEGER DEFAULT 1,
+			last_sync_at DATETIME,
+			last_error TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		// Sync state of the zones of this server on each peer. version
+		// grows with every change so a push does not mark a newer change
+		// as synced.
+		`CREATE TABLE IF NOT EXISTS dns_cluster_sync (
+			peer_id INTEGER NOT NULL,
+			domain TEXT NOT NULL,
+			action TEXT NOT NULL DEFAULT 'push',
+			status TEXT NOT NULL DEFAULT 'pending',
+			error TEXT,
+			attempts INTEGER DEFAULT 0,
+			version INTEGER DEFAULT 0,
+			synced_at DATETIME,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (peer_id, domain),
+			FOREIGN KEY (peer_id) REFERENCES dns_cluster_peers(id) ON DELETE CASCADE
+		)`,
+
+		// Zones served here for cluster peers (records as JSON)
+		`CREATE TABLE IF NOT EXISTS dns_cluster_zones (
+			domain TEXT PRIMARY KEY,
+			peer_id INTEGER NOT NULL,
+			records TEXT NOT NULL,
+			serial INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (peer_id) REFERENCES dns_cluster_peers(id) ON DELETE CASCADE
+		)`,
 	}
 
 	for _, migration := range migrations {
@@ -402,10 +711,47 @@ func (db *DB) migrate() error {
 	db.Exec(`ALTER TABLE packages ADD COLUMN max_php_upload TEXT DEFAULT '64M'`)
 	db.Exec(`ALTER TABLE packages ADD COLUMN max_php_execution_time INTEGER DEFAULT 300`)
 
+	// Add PHP-FPM process settings to packages (timeouts in seconds, 0 = off)
+	db.Exec(`ALTER TABLE packages ADD COLUMN php_pm TEXT DEFAULT 'dynamic'`)
+	db.Exec(`ALTER TABLE packages ADD COLUMN php_max_children INTEGER DEFAULT 5`)
+	db.Exec(`ALTER TABLE packages ADD COLUMN php_request_timeout INTEGER DEFAULT 0`)
+	db.Exec(`ALTER TABLE packages ADD COLUMN php_slowlog_timeout INTEGER DEFAULT 0`)
+	db.Exec(`ALTER TABLE packages ADD COLUMN php_pool_per_domain INTEGER DEFAULT 0`)
+
 	// Add mail rate limit columns to packages
 	db.Exec(`ALTER TABLE packages ADD COLUMN max_emails_per_hour INTEGER DEFAULT 100`)
 	db.Exec(`ALTER TABLE packages ADD COLUMN max_emails_per_day INTEGER DEFAULT 500`)
 
+	// Add backup retention columns to packages (snapshots kept per day / per week)
+	db.Exec(`ALTER TABLE packages ADD COLUMN backup_keep_daily INTEGER DEFAULT 7`)
+	db.Exec(`ALTER TABLE packages ADD COLUMN backup_keep_weekly INTEGER DEFAULT 4`)
+
+	// Add remote destination columns to backup_jobs
+	db.Exec(`ALTER TABLE backup_jobs ADD COLUMN destination_id INTEGER`)
+	db.Exec(`ALTER TABLE backup_jobs ADD COLUMN remote_path TEXT`)
+
+	// Link backup_jobs to the scheduled run that created them
+	db.Exec(`ALTER TABLE backup_jobs ADD COLUMN schedule_id INTEGER`)
+	db.Exec(`ALTER TABLE backup_jobs ADD COLUMN schedule_run_id INTEGER`)
+
+	// Packages owned by a reseller (NULL = admin package). A reseller
+	// can not be deleted while it owns packages.
+	db.Exec(`ALTER TABLE packages ADD COLUMN owner_id INTEGER REFERENCES users(id) ON DELETE RESTRICT`)
+	if err := db.rebuildPackages(); err != nil {
+		return err
+	}
+	db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_packages_admin_name ON packages(name) WHERE owner_id IS NULL`)
+
+	// Add the remaining resource pool columns to reseller_limits
+	db.Exec(`ALTER TABLE reseller_limits ADD COLUMN max_bandwidth INTEGER DEFAULT 0`)
+	db.Exec(`ALTER TABLE reseller_limits ADD COLUMN max_domains INTEGER DEFAULT 0`)
+	db.Exec(`ALTER TABLE reseller_limits ADD COLUMN max_databases INTEGER DEFAULT 0`)
+	db.Exec(`ALTER TABLE reseller_limits ADD COLUMN max_emails INTEGER DEFAULT 0`)
+	db.Exec(`ALTER TABLE reseller_limits ADD COLUMN max_ftp INTEGER DEFAULT 0`)
+
+	// Response rate limit of a domain's vhost (bandwidth throttling), KB/s
+	db.Exec(`ALTER TABLE domains ADD COLUMN rate_limit_kb INTEGER DEFAULT 0`)
+
 	// Create server_settings table for admin configuration
 	db.Exec(`CREATE TABLE IF NOT EXISTS server_settings (
 		key TEXT PRIMARY KEY,
@@ -419,7 +765,13 @@ func (db *DB) migrate() error {
 		('default_php_version', '8.1'),
 		('allowed_php_versions', '7.4,8.0,8.1,8.2,8.3'),
 		('domain_based_php', 'true'),
-		('nodejs_enabled', 'false')
+		('nodejs_enabled', 'false'),
+		('backup_encryption', 'false'),
+		('bandwidth_overage_action', 'notify'),
+		('bandwidth_throttle_rate', '256'),
+		('php_ini_allowed', ''),
+		('php_ini_denied', ''),
+		('dns_secondaries', '')
 	`)
 
 	// Create default admin user if not exists
@@ -435,6 +787,93 @@ func (db *DB) migrate() error {
 	return nil
 }
 
+// rebuildPackages moves a packages table with globally unique names and
+// owner_id ON DELETE SET NULL to the per-owner unique key. SQLite can not
+// change constraints in place, so the table is copied into a new one with
+// foreign keys off, which keeps the user_packages rows pointing at it.
+func (db *DB) rebuildPackages() error {
+	var schema string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'packages'").Scan(&schema); err != nil {
+		return err
+	}
+	if strings.Contains(schema, "UNIQUE(owner_id, name)") {
+		return nil
+	}
+	log.Println("🔄 Rebuilding packages table: package names become unique per owner")
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// foreign_keys can not change inside a transaction
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const columns = `id, name, disk_quota, bandwidth_quota, max_domains, max_databases, max_emails, max_ftp,
+		max_php_memory, max_php_upload, max_php_execution_time,
+		php_pm, php_max_children, php_request_timeout, php_slowlog_timeout, php_pool_per_domain,
+		max_emails_per_hour, max_emails_per_day, backup_keep_daily, backup_keep_weekly,
+		owner_id, created_at`
+	steps := []string{
+		`CREATE TABLE packages_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			disk_quota INTEGER DEFAULT 1024,
+			bandwidth_quota INTEGER DEFAULT 10240,
+			max_domains INTEGER DEFAULT 1,
+			max_databases INTEGER DEFAULT 1,
+			max_emails INTEGER DEFAULT 5,
+			max_ftp INTEGER DEFAULT 1,
+			max_php_memory TEXT DEFAULT '256M',
+			max_php_upload TEXT DEFAULT '64M',
+			max_php_execution_time INTEGER DEFAULT 300,
+			php_pm TEXT DEFAULT 'dynamic',
+			php_max_children INTEGER DEFAULT 5,
+			php_request_timeout INTEGER DEFAULT 0,
+			php_slowlog_timeout INTEGER DEFAULT 0,
+			php_pool_per_domain INTEGER DEFAULT 0,
+			max_emails_per_hour INTEGER DEFAULT 100,
+			max_emails_per_day INTEGER DEFAULT 500,
+			backup_keep_daily INTEGER DEFAULT 7,
+			backup_keep_weekly INTEGER DEFAULT 4,
+			owner_id INTEGER REFERENCES users(id) ON DELETE RESTRICT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(owner_id, name)
+		)`,
+		`INSERT INTO packages_new (` + columns + `) SELECT ` + columns + ` FROM packages`,
+		`DROP TABLE packages`,
+		`ALTER TABLE packages_new RENAME TO packages`,
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step); err != nil {
+			return err
+		}
+	}
+
+	// Every owner must still exist
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check(packages)")
+	if err != nil {
+		return err
+	}
+	broken := rows.Next()
+	rows.Close()
+	if broken {
+		return errors.New("packages rebuild left broken foreign keys")
+	}
+	return tx.Commit()
+}
+
 func (db *DB) createDefaultAdmin() error {
 	var count int
 	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'admin'").Scan(&count)
@@ -0,0 +1,279 @@
+package dnsserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Record types, classes, opcodes and response codes the server uses
+const (
+	typeA     uint16 = 1
+	typeNS    uint16 = 2
+	typeCNAME uint16 = 5
+	typeSOA   uint16 = 6
+	typePTR   uint16 = 12
+	typeHINFO uint16 = 13
+	typeMX    uint16 = 15
+	typeTXT   uint16 = 16
+	typeAAAA  uint16 = 28
+	typeSRV   uint16 = 33
+	typeNAPTR uint16 = 35
+	typeOPT   uint16 = 41
+	typeDS    uint16 = 43
+	typeSSHFP uint16 = 44
+	typeTLSA  uint16 = 52
+	typeSVCB  uint16 = 64
+	typeHTTPS uint16 = 65
+	typeIXFR  uint16 = 251
+	typeAXFR  uint16 = 252
+	typeANY   uint16 = 255
+	typeCAA   uint16 = 257
+
+	classIN  uint16 = 1
+	classANY uint16 = 255
+
+	opQuery  = 0
+	opNotify = 4
+
+	rcodeSuccess  = 0
+	rcodeFormErr  = 1
+	rcodeServFail = 2
+	rcodeNXDomain = 3
+	rcodeNotImp   = 4
+	rcodeRefused  = 5
+)
+
+// Header flag bits
+const (
+	flagQR = 1 << 15
+	flagAA = 1 << 10
+	flagTC = 1 << 9
+	flagRD = 1 << 8
+)
+
+// rrTypes maps the types of dns_records to their codes
+var rrTypes = map[string]uint16{
+	"A": typeA, "NS": typeNS, "CNAME": typeCNAME, "SOA": typeSOA, "PTR": typePTR,
+	"MX": typeMX, "TXT": typeTXT, "AAAA": typeAAAA, "SRV": typeSRV, "CAA": typeCAA,
+	"NAPTR": typeNAPTR, "DS": typeDS, "SSHFP": typeSSHFP, "TLSA": typeTLSA,
+	"SVCB": typeSVCB, "HTTPS": typeHTTPS,
+}
+
+var errMalformed = errors.New("malformed DNS message")
+
+// rr is a resource record; Data is the uncompressed RDATA
+type rr struct {
+	Name  string // absolute, lower case, with the trailing dot
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+type question struct {
+	Name  string
+	Type  uint16
+	Class uint16
+}
+
+// message is a parsed request: the header, the question and EDNS
+type message struct {
+	ID        uint16
+	Opcode    int
+	RD        bool
+	QR        bool
+	Rcode     int
+	Questions []question
+	EDNS      bool
+	UDPSize   int
+}
+
+// parseMessage parses the header and question of a message and looks for
+// an OPT record in the additional section
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < 12 {
+		return nil, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	m := &message{
+		ID:     binary.BigEndian.Uint16(b),
+		QR:     flags&flagQR != 0,
+		Opcode: int(flags>>11) & 0xF,
+		RD:     flags&flagRD != 0,
+		Rcode:  int(flags & 0xF),
+	}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	an := int(binary.BigEndian.Uint16(b[6:]))
+	ns := int(binary.BigEndian.Uint16(b[8:]))
+	ar := int(binary.BigEndian.Uint16(b[10:]))
+
+	off := 12
+	for i := 0; i < qd; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(b) {
+			return nil, errMalformed
+		}
+		m.Questions = append(m.Questions, question{
+			Name:  name,
+			Type:  binary.BigEndian.Uint16(b[next:]),
+			Class: binary.BigEndian.Uint16(b[next+2:]),
+		})
+		off = next + 4
+	}
+
+	for i := 0; i < an+ns+ar; i++ {
+		_, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(b) {
+			return nil, errMalformed
+		}
+		rrType := binary.BigEndian.Uint16(b[next:])
+		class := binary.BigEndian.Uint16(b[next+2:])
+		rdlen := int(binary.BigEndian.Uint16(b[next+8:]))
+		if next+10+rdlen > len(b) {
+			return nil, errMalformed
+		}
+		if i >= an+ns && rrType == typeOPT {
+			m.EDNS = true
+			m.UDPSize = int(class)
+		}
+		off = next + 10 + rdlen
+	}
+	return m, nil
+}
+
+// maxNameLength is the longest name in wire format (RFC 1035)
+const maxNameLength = 255
+
+// readName reads a possibly compressed name at off and returns it with the
+// offset after it
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	length := 1
+	end := -1
+	for hops := 0; ; hops++ {
+		if off >= len(b) || hops > 127 {
+			return "", 0, errMalformed
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")) + ".", end, nil
+		case n&0xC0 == 0xC0:
+			if off+1 >= len(b) {
+				return "", 0, errMalformed
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+		case n&0xC0 != 0:
+			return "", 0, errMalformed
+		default:
+			length += 1 + n
+			if off+1+n > len(b) || length > maxNameLength {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// appendName appends a name in uncompressed wire format
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// builder writes a response, compressing owner names
+type builder struct {
+	buf   []byte
+	names map[string]int
+	count [4]uint16 // question, answer, authority, additional
+}
+
+func newBuilder(id uint16, flags uint16) *builder {
+	b := &builder{buf: make([]byte, 12, 512), names: map[string]int{}}
+	binary.BigEndian.PutUint16(b.buf, id)
+	binary.BigEndian.PutUint16(b.buf[2:], flags)
+	return b
+}
+
+// name writes an owner name, pointing at an earlier copy of a suffix when
+// there is one. Like appendName it skips empty labels and cuts labels at
+// 63 bytes, which would otherwise be read as a pointer.
+func (b *builder) name(name string) {
+	name = strings.Trim(name, ".")
+	for name != "" {
+		if off, ok := b.names[name]; ok {
+			b.buf = binary.BigEndian.AppendUint16(b.buf, uint16(0xC000|off))
+			return
+		}
+		if len(b.buf) < 0x3FFF {
+			b.names[name] = len(b.buf)
+		}
+		label, rest, _ := strings.Cut(name, ".")
+		name = strings.TrimLeft(rest, ".")
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		b.buf = append(b.buf, byte(len(label)))
+		b.buf = append(b.buf, label...)
+	}
+	b.buf = append(b.buf, 0)
+}
+
+func (b *builder) question(q question) {
+	b.name(q.Name)
+	b.buf = binary.BigEndian.AppendUint16(b.buf, q.Type)
+	b.buf = binary.BigEndian.AppendUint16(b.buf, q.Class)
+	b.count[0]++
+}
+
+// record writes a record into a section (1 answer, 2 authority,
+// 3 additional)
+func (b *builder) record(section int, r rr) {
+	b.name(r.Name)
+	b.buf = binary.BigEndian.AppendUint16(b.buf, r.Type)
+	b.buf = binary.BigEndian.AppendUint16(b.buf, r.Class)
+	b.buf = binary.BigEndian.AppendUint32(b.buf, r.TTL)
+	b.buf = binary.BigEndian.AppendUint16(b.buf, uint16(len(r.Data)))
+	b.buf = append(b.buf, r.Data...)
+	b.count[section]++
+}
+
+// opt writes the EDNS OPT record
+func (b *builder) opt(udpSize int) {
+	b.buf = append(b.buf, 0)
+	b.buf = binary.BigEndian.AppendUint16(b.buf, typeOPT)
+	b.buf = binary.BigEndian.AppendUint16(b.buf, uint16(udpSize))
+	b.buf = binary.BigEndian.AppendUint32(b.buf, 0)
+	b.buf = binary.BigEndian.AppendUint16(b.buf, 0)
+	b.count[3]++
+}
+
+func (b *builder) bytes() []byte {
+	for i, c := range b.count {
+		binary.BigEndian.PutUint16(b.buf[4+2*i:], c)
+	}
+	return b.buf
+}
@@ -0,0 +1,192 @@
+package dnsserver
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// query builds a request with one question and optionally an OPT record
+func query(name string, qtype uint16, udpSize int) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint16(b, 0x1234)
+	binary.BigEndian.PutUint16(b[2:], flagRD)
+	binary.BigEndian.PutUint16(b[4:], 1)
+	b = appendName(b, name)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	b = binary.BigEndian.AppendUint16(b, classIN)
+	if udpSize > 0 {
+		binary.BigEndian.PutUint16(b[10:], 1)
+		b = append(b, 0)
+		b = binary.BigEndian.AppendUint16(b, typeOPT)
+		b = binary.BigEndian.AppendUint16(b, uint16(udpSize))
+		b = binary.BigEndian.AppendUint32(b, 0)
+		b = binary.BigEndian.AppendUint16(b, 0)
+	}
+	return b
+}
+
+func TestParseMessage(t *testing.T) {
+	m, err := parseMessage(query("WWW.Example.COM.", typeAAAA, 1232))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 0x1234 || !m.RD || m.QR || m.Opcode != opQuery {
+		t.Errorf("header = %+v", m)
+	}
+	if len(m.Questions) != 1 || m.Questions[0] != (question{Name: "www.example.com.", Type: typeAAAA, Class: classIN}) {
+		t.Errorf("questions = %+v", m.Questions)
+	}
+	if !m.EDNS || m.UDPSize != 1232 {
+		t.Errorf("EDNS = %v, %d", m.EDNS, m.UDPSize)
+	}
+
+	valid := query("example.com.", typeA, 1232)
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"short header", valid[:11]},
+		{"question cut in the name", valid[:15]},
+		{"question cut before the type", valid[:12+13]},
+		{"question cut in the class", valid[:12+13+3]},
+		{"missing additional record", valid[:12+13+4]},
+		{"additional record cut", valid[:len(valid)-1]},
+		{"rdata longer than the message", func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.BigEndian.PutUint16(b[len(b)-2:], 10)
+			return b
+		}()},
+		{"more questions than present", func() []byte {
+			b := append([]byte(nil), valid...)
+			binary.BigEndian.PutUint16(b[4:], 2)
+			return b
+		}()},
+		{"answer count without answers", func() []byte {
+			b := query("example.com.", typeA, 0)
+			binary.BigEndian.PutUint16(b[6:], 0xFFFF)
+			return b
+		}()},
+	}
+	for _, tt := range tests {
+		if _, err := parseMessage(tt.msg); err == nil {
+			t.Errorf("%s: parsed without error", tt.name)
+		}
+	}
+
+	// Every prefix of a valid message is rejected, never a panic
+	for i := 0; i < len(valid); i++ {
+		if _, err := parseMessage(valid[:i]); err == nil {
+			t.Errorf("prefix of %d bytes parsed without error", i)
+		}
+	}
+}
+
+func TestReadName(t *testing.T) {
+	header := make([]byte, 12)
+	tests := []struct {
+		name string
+		msg  []byte
+		off  int
+		want string // "" = malformed
+		next int
+	}{
+		{"root", append(header[:12:12], 0), 12, ".", 13},
+		{"plain", append(header[:12:12], 3, 'w', 'w', 'w', 2, 'e', 'x', 0), 12, "www.ex.", 20},
+		{"lower cased", append(header[:12:12], 2, 'E', 'X', 0), 12, "ex.", 16},
+		{"compressed", append(header[:12:12], 2, 'e', 'x', 0, 3, 'w', 'w', 'w', 0xC0, 12), 16, "www.ex.", 22},
+		{"pointer chain", append(header[:12:12], 2, 'e', 'x', 0, 0xC0, 12, 1, 'a', 0xC0, 16), 18, "a.ex.", 22},
+		{"offset past the end", append(header[:12:12], 0), 13, "", 0},
+		{"label past the end", append(header[:12:12], 5, 'a', 'b'), 12, "", 0},
+		{"missing terminator", append(header[:12:12], 1, 'a'), 12, "", 0},
+		{"pointer cut", append(header[:12:12], 0xC0), 12, "", 0},
+		{"pointer past the end", append(header[:12:12], 0xC0, 0xFF), 12, "", 0},
+		{"pointer to itself", append(header[:12:12], 0xC0, 12), 12, "", 0},
+		{"pointer loop", append(header[:12:12], 1, 'a', 0xC0, 16, 1, 'b', 0xC0, 12), 12, "", 0},
+		{"reserved label type", append(header[:12:12], 0x40, 'a', 0), 12, "", 0},
+		{"extended label type", append(header[:12:12], 0x80, 'a', 0), 12, "", 0},
+	}
+	for _, tt := range tests {
+		got, next, err := readName(tt.msg, tt.off)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: read %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want || next != tt.next {
+			t.Errorf("%s: got %q, %d, %v; want %q, %d", tt.name, got, next, err, tt.want, tt.next)
+		}
+	}
+}
+
+func TestReadNameLength(t *testing.T) {
+	// 4 labels of 63 bytes are 256 bytes on the wire with the root label
+	long := func(labels int) []byte {
+		b := make([]byte, 12)
+		for i := 0; i < labels; i++ {
+			b = append(b, 63)
+			b = append(b, strings.Repeat("a", 63)...)
+		}
+		return append(b, 0)
+	}
+	if _, _, err := readName(long(3), 12); err != nil {
+		t.Errorf("192 byte name: %v", err)
+	}
+	if _, _, err := readName(long(4), 12); err == nil {
+		t.Error("256 byte name was accepted")
+	}
+
+	// Pointers can not be used to build a name longer than allowed
+	b := make([]byte, 12)
+	b = append(b, 63)
+	b = append(b, strings.Repeat("a", 63)...)
+	b = append(b, 0)
+	start := len(b)
+	for i := 0; i < 5; i++ {
+		b = append(b, 63)
+		b = append(b, strings.Repeat("b", 63)...)
+	}
+	b = append(b, 0xC0, 12)
+	if _, _, err := readName(b, start); err == nil {
+		t.Error("name longer than 255 bytes through a pointer was accepted")
+	}
+}
+
+func TestBuilderName(t *testing.T) {
+	tests := []struct {
+		name string
+		want []byte
+	}{
+		{".", []byte{0}},
+		{"", []byte{0}},
+		{"ex.", []byte{2, 'e', 'x', 0}},
+		{"a..ex", []byte{1, 'a', 2, 'e', 'x', 0}},
+		{strings.Repeat("x", 70) + ".ex.", append(append([]byte{63}, strings.Repeat("x", 63)...), 2, 'e', 'x', 0)},
+	}
+	for _, tt := range tests {
+		b := newBuilder(0, 0)
+		b.name(tt.name)
+		if got := b.buf[12:]; string(got) != string(tt.want) {
+			t.Errorf("name(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+		// The written name reads back
+		if _, _, err := readName(b.buf, 12); err != nil {
+			t.Errorf("name(%q) does not read back: %v", tt.name, err)
+		}
+	}
+
+	// A repeated suffix is written as a pointer
+	b := newBuilder(0, 0)
+	b.name("www.example.com.")
+	first := len(b.buf)
+	b.name("mail.example.com.")
+	if got := b.buf[first:]; len(got) != 1+4+2 || got[5]&0xC0 != 0xC0 {
+		t.Errorf("second name not compressed: %v", got)
+	}
+	name, _, err := readName(b.buf, first)
+	if err != nil || name != "mail.example.com." {
+		t.Errorf("compressed name reads back as %q, %v", name, err)
+	}
+}
@@ -0,0 +1,210 @@
+package dnsserver
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// svcParamKeys maps the SvcParamKeys of SVCB and HTTPS records to their
+// codes (RFC 9460); others are written as keyNNNNN
+var svcParamKeys = map[string]uint16{
+	"mandatory": 0, "alpn": 1, "no-default-alpn": 2, "port": 3,
+	"ipv4hint": 4, "ech": 5, "ipv6hint": 6,
+}
+
+// encodeHexRData encodes DS (keytag algorithm digesttype digest), TLSA
+// (usage selector matchingtype data) and SSHFP (algorithm fptype
+// fingerprint) records: numeric fields followed by hex data
+func encodeHexRData(rrType uint16, content string) (uint16, []byte, error) {
+	fields := strings.Fields(content)
+	sizes := []int{16, 8, 8} // DS
+	switch rrType {
+	case typeTLSA:
+		sizes = []int{8, 8, 8}
+	case typeSSHFP:
+		sizes = []int{8, 8}
+	}
+	if len(fields) < len(sizes)+1 {
+		return 0, nil, fmt.Errorf("needs %d fields", len(sizes)+1)
+	}
+
+	var data []byte
+	for i, bits := range sizes {
+		v, err := strconv.ParseUint(fields[i], 10, bits)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid field %q", fields[i])
+		}
+		if bits == 16 {
+			data = binary.BigEndian.AppendUint16(data, uint16(v))
+		} else {
+			data = append(data, byte(v))
+		}
+	}
+	raw, err := hex.DecodeString(strings.Join(fields[len(sizes):], ""))
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid hex data")
+	}
+	return rrType, append(data, raw...), nil
+}
+
+// encodeNAPTR encodes "order preference flags service regexp replacement"
+func encodeNAPTR(content, origin string) (uint16, []byte, error) {
+	fields := splitFields(content)
+	if len(fields) != 6 {
+		return 0, nil, fmt.Errorf("NAPTR content must be order preference flags service regexp replacement")
+	}
+	order, err1 := strconv.ParseUint(fields[0], 10, 16)
+	preference, err2 := strconv.ParseUint(fields[1], 10, 16)
+	if err1 != nil || err2 != nil {
+		return 0, nil, fmt.Errorf("invalid NAPTR order or preference")
+	}
+
+	data := binary.BigEndian.AppendUint16(nil, uint16(order))
+	data = binary.BigEndian.AppendUint16(data, uint16(preference))
+	for _, f := range fields[2:5] {
+		s := unquote(f)
+		if len(s) > 255 {
+			return 0, nil, fmt.Errorf("NAPTR string too long")
+		}
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	return typeNAPTR, appendName(data, absolute(fields[5], origin)), nil
+}
+
+// encodeSVCB encodes "target [key=value ...]" of SVCB and HTTPS records
+// with the SvcPriority from the priority column. Parameters are written in
+// the order of their keys.
+func encodeSVCB(rrType uint16, priority int, content, origin string) (uint16, []byte, error) {
+	fields := splitFields(content)
+	if len(fields) == 0 {
+		return 0, nil, fmt.Errorf("missing target")
+	}
+
+	type param struct {
+		key   uint16
+		value []byte
+	}
+	var params []param
+	for _, f := range fields[1:] {
+		name, value, _ := strings.Cut(f, "=")
+		value = unquote(value)
+		key, ok := svcParamKeys[name]
+		if !ok {
+			n, err := strconv.ParseUint(strings.TrimPrefix(name, "key"), 10, 16)
+			if !strings.HasPrefix(name, "key") || err != nil {
+				return 0, nil, fmt.Errorf("unknown parameter %q", name)
+			}
+			key = uint16(n)
+		}
+
+		var v []byte
+		switch name {
+		case "mandatory":
+			var keys []int
+			for _, k := range strings.Split(value, ",") {
+				code, ok := svcParamKeys[k]
+				if !ok {
+					n, err := strconv.ParseUint(strings.TrimPrefix(k, "key"), 10, 16)
+					if err != nil {
+						return 0, nil, fmt.Errorf("unknown mandatory key %q", k)
+					}
+					code = uint16(n)
+				}
+				keys = append(keys, int(code))
+			}
+			sort.Ints(keys)
+			for _, k := range keys {
+				v = binary.BigEndian.AppendUint16(v, uint16(k))
+			}
+		case "alpn":
+			for _, id := range strings.Split(value, ",") {
+				v = append(v, byte(len(id)))
+				v = append(v, id...)
+			}
+		case "no-default-alpn":
+		case "port":
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return 0, nil, fmt.Errorf("invalid port %q", value)
+			}
+			v = binary.BigEndian.AppendUint16(v, uint16(port))
+		case "ipv4hint", "ipv6hint":
+			for _, addr := range strings.Split(value, ",") {
+				ip := net.ParseIP(addr)
+				if ip == nil {
+					return 0, nil, fmt.Errorf("invalid address %q", addr)
+				}
+				if name == "ipv4hint" {
+					v = append(v, ip.To4()...)
+				} else {
+					v = append(v, ip.To16()...)
+				}
+			}
+		case "ech":
+			raw, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return 0, nil, fmt.Errorf("invalid ech")
+			}
+			v = raw
+		default:
+			v = []byte(value)
+		}
+		params = append(params, param{key, v})
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].key < params[j].key })
+
+	data := binary.BigEndian.AppendUint16(nil, uint16(priority))
+	data = appendName(data, absolute(fields[0], origin))
+	for _, p := range params {
+		data = binary.BigEndian.AppendUint16(data, p.key)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(p.value)))
+		data = append(data, p.value...)
+	}
+	return rrType, data, nil
+}
+
+// splitFields splits content into fields, keeping quoted strings (with
+// their quotes) in one field
+func splitFields(content string) []string {
+	var fields []string
+	var cur strings.Builder
+	inQuote := false
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+		switch {
+		case ch == '\\' && inQuote && i+1 < len(content):
+			cur.WriteByte(ch)
+			i++
+			cur.WriteByte(content[i])
+		case ch == '"':
+			inQuote = !inQuote
+			cur.WriteByte(ch)
+		case (ch == ' ' || ch == '\t') && !inQuote:
+			if cur.Len() > 0 {
+				fields = append(fields, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(ch)
+		}
+	}
+	if cur.Len() > 0 {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+// unquote removes the quotes and escapes of a quoted string
+func unquote(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(s, `\"`, `"`), `\\`, `\`)
+}
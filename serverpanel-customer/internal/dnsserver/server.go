@@ -0,0 +1,499 @@
+// Package dnsserver is an authoritative DNS server that answers for the
+// panel's domains straight from the database, without BIND.
+package dnsserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// UDP responses larger than this are truncated even if the client
+	// advertises a bigger EDNS buffer (DNS flag day 2020)
+	maxUDPSize = 1232
+
+	// Size of the messages of a zone transfer
+	transferChunk = 16 * 1024
+
+	// Queries answered at once over UDP; while every worker is busy further
+	// datagrams are dropped and the clients retry
+	udpWorkers = 64
+
+	tcpIdleTimeout = 10 * time.Second
+	notifyTimeout  = 2 * time.Second
+	notifyRetries  = 3
+)
+
+// Config configures a Server
+type Config struct {
+	Listen      string        // address of the UDP and TCP listeners, e.g. ":53"
+	Refresh     time.Duration // how often the database is read for changes
+	Secondaries []string      // notified of changes and allowed to transfer zones
+	ServerIP    string        // address of subdomains without records
+}
+
+// Server is an authoritative DNS server for the zones in the database
+type Server struct {
+	db   DB
+	conf Config
+
+	mu          sync.RWMutex
+	zones       map[string]*zone
+	secondaries []string
+	loaded      bool
+
+	udp  net.PacketConn
+	tcp  net.Listener
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewServer creates a DNS server
+func NewServer(db DB, conf Config) *Server {
+	if conf.Refresh <= 0 {
+		conf.Refresh = 5 * time.Second
+	}
+	return &Server{db: db, conf: conf, zones: map[string]*zone{}, done: make(chan struct{})}
+}
+
+// Start loads the zones and starts answering on UDP and TCP
+func (s *Server) Start() error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+
+	udp, err := net.ListenPacket("udp", s.conf.Listen)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", s.conf.Listen)
+	if err != nil {
+		udp.Close()
+		return err
+	}
+	s.udp, s.tcp = udp, tcp
+
+	s.wg.Add(3)
+	go s.serveUDP()
+	go s.serveTCP()
+	go s.refresh()
+
+	log.Printf("🌐 DNS server listening on %s (udp/tcp), %d zones", s.conf.Listen, s.zoneCount())
+	return nil
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	close(s.done)
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// Reload reads the zones from the database and notifies the secondaries of
+// the zones whose serial changed
+func (s *Server) Reload() error {
+	zones, warnings, err := loadZones(s.db, s.conf.ServerIP)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		log.Printf("⚠️ DNS record skipped: %s", w)
+	}
+	secondaries := append(append([]string{}, s.conf.Secondaries...), secondaries(s.db)...)
+
+	s.mu.Lock()
+	first := !s.loaded
+	var changed []*zone
+	for origin, z := range zones {
+		if old, ok := s.zones[origin]; !ok || old.serial != z.serial {
+			changed = append(changed, z)
+		}
+	}
+	s.zones, s.secondaries, s.loaded = zones, secondaries, true
+	s.mu.Unlock()
+
+	for _, z := range changed {
+		log.Printf("📝 DNS zone %s updated, serial %d", strings.TrimSuffix(z.origin, "."), z.serial)
+		// Secondaries check the serials on their own after a restart
+		if !first {
+			s.notify(z, secondaries)
+		}
+	}
+	return nil
+}
+
+func (s *Server) zoneCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.zones)
+}
+
+func (s *Server) refresh() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.conf.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				log.Printf("❌ DNS zones could not be loaded: %v", err)
+			}
+		}
+	}
+}
+
+// udpRequest is a datagram waiting for a UDP worker
+type udpRequest struct {
+	data []byte
+	addr net.Addr
+}
+
+// serveUDP reads datagrams and hands them to a fixed pool of workers
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+
+	queue := make(chan udpRequest, udpWorkers)
+	var workers sync.WaitGroup
+	for i := 0; i < udpWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for r := range queue {
+				ip := r.addr.(*net.UDPAddr).IP
+				for _, resp := range s.handle(r.data, ip, false) {
+					s.udp.WriteTo(resp, r.addr)
+				}
+			}
+		}()
+	}
+	defer func() {
+		close(queue)
+		workers.Wait()
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if s.closed() {
+				return
+			}
+			continue
+		}
+		select {
+		case queue <- udpRequest{data: append([]byte(nil), buf[:n]...), addr: addr}:
+		default:
+		}
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if s.closed() {
+				return
+			}
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn answers the length-prefixed queries of a TCP connection
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	ip := conn.RemoteAddr().(*net.TCPAddr).IP
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		for _, resp := range s.handle(req, ip, true) {
+			frame := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+			if _, err := conn.Write(append(frame, resp...)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// handle answers a request; zone transfers take several messages
+func (s *Server) handle(req []byte, remote net.IP, tcp bool) [][]byte {
+	m, err := parseMessage(req)
+	if err != nil {
+		if len(req) < 12 {
+			return nil
+		}
+		return [][]byte{errorResponse(binary.BigEndian.Uint16(req), 0, nil, rcodeFormErr)}
+	}
+	if m.QR {
+		return nil
+	}
+	if m.Opcode != opQuery {
+		return [][]byte{errorResponse(m.ID, m.Opcode, m.Questions, rcodeNotImp)}
+	}
+	if len(m.Questions) != 1 {
+		return [][]byte{errorResponse(m.ID, m.Opcode, m.Questions, rcodeFormErr)}
+	}
+	q := m.Questions[0]
+	if q.Class != classIN && q.Class != classANY {
+		return [][]byte{errorResponse(m.ID, m.Opcode, m.Questions, rcodeRefused)}
+	}
+
+	z := s.findZone(q.Name)
+	if z == nil {
+		return [][]byte{errorResponse(m.ID, m.Opcode, m.Questions, rcodeRefused)}
+	}
+
+	switch q.Type {
+	case typeAXFR, typeIXFR:
+		if q.Name != z.origin || !s.transferAllowed(remote) {
+			return [][]byte{errorResponse(m.ID, m.Opcode, m.Questions, rcodeRefused)}
+		}
+		if tcp {
+			// IXFR is answered with the whole zone (RFC 1995 section 4)
+			return s.transfer(m, z)
+		}
+		if q.Type == typeAXFR {
+			return [][]byte{errorResponse(m.ID, m.Opcode, m.Questions, rcodeRefused)}
+		}
+		// IXFR over UDP: the SOA tells the secondary to retry over TCP
+		return [][]byte{s.response(m, answer{aa: true, answer: []rr{z.soa}}, tcp)}
+	}
+
+	a := z.lookup(q.Name, q.Type)
+	if q.Type == typeANY && !tcp && len(a.answer) > 0 {
+		// Minimal ANY answer (RFC 8482)
+		a.answer = []rr{{Name: q.Name, Type: typeHINFO, Class: classIN, TTL: soaTTL, Data: []byte("\x07RFC8482\x00")}}
+		a.additional = nil
+	}
+	return [][]byte{s.response(m, a, tcp)}
+}
+
+// findZone returns the closest zone that contains name
+func (s *Server) findZone(name string) *zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for {
+		if z, ok := s.zones[name]; ok {
+			return z
+		}
+		if name == "." {
+			return nil
+		}
+		name = parent(name)
+	}
+}
+
+// transferAllowed allows zone transfers to the secondaries and localhost
+func (s *Server) transferAllowed(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sec := range s.secondaries {
+		if host := secondaryHost(sec); host != nil && host.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func responseFlags(opcode, rcode int, aa, rd bool) uint16 {
+	flags := uint16(flagQR) | uint16(opcode)<<11 | uint16(rcode)
+	if aa {
+		flags |= flagAA
+	}
+	if rd {
+		flags |= flagRD
+	}
+	return flags
+}
+
+func errorResponse(id uint16, opcode int, questions []question, rcode int) []byte {
+	b := newBuilder(id, responseFlags(opcode, rcode, false, false))
+	for _, q := range questions {
+		b.question(q)
+	}
+	return b.bytes()
+}
+
+// response builds the answer to a query, truncated to fit UDP
+func (s *Server) response(m *message, a answer, tcp bool) []byte {
+	limit := 65535
+	if !tcp {
+		limit = 512
+		if m.EDNS && m.UDPSize > limit {
+			limit = m.UDPSize
+			if limit > maxUDPSize {
+				limit = maxUDPSize
+			}
+		}
+	}
+
+	build := func(a answer, truncated bool) []byte {
+		flags := responseFlags(m.Opcode, a.rcode, a.aa, m.RD)
+		if truncated {
+			flags |= flagTC
+		}
+		b := newBuilder(m.ID, flags)
+		b.question(m.Questions[0])
+		for _, r := range a.answer {
+			b.record(1, r)
+		}
+		for _, r := range a.authority {
+			b.record(2, r)
+		}
+		for _, r := range a.additional {
+			b.record(3, r)
+		}
+		if m.EDNS {
+			b.opt(maxUDPSize)
+		}
+		return b.bytes()
+	}
+
+	resp := build(a, false)
+	if len(resp) <= limit {
+		return resp
+	}
+	// Additional records are optional; without them it may fit
+	a.additional = nil
+	if resp = build(a, false); len(resp) <= limit {
+		return resp
+	}
+	return build(answer{rcode: a.rcode, aa: a.aa}, true)
+}
+
+// transfer builds the messages of a zone transfer
+func (s *Server) transfer(m *message, z *zone) [][]byte {
+	var msgs [][]byte
+	flags := responseFlags(m.Opcode, rcodeSuccess, true, m.RD)
+	b := newBuilder(m.ID, flags)
+	b.question(m.Questions[0])
+	for _, r := range z.transfer() {
+		if len(b.buf) > transferChunk {
+			msgs = append(msgs, b.bytes())
+			b = newBuilder(m.ID, flags)
+		}
+		b.record(1, r)
+	}
+	log.Printf("📤 Zone transfer of %s (serial %d)", strings.TrimSuffix(z.origin, "."), z.serial)
+	return append(msgs, b.bytes())
+}
+
+// notify tells the secondaries that a zone changed (RFC 1996)
+func (s *Server) notify(z *zone, secondaries []string) {
+	for _, sec := range secondaries {
+		addr := sec
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "53")
+		}
+		go func() {
+			if err := sendNotify(addr, z); err != nil {
+				log.Printf("⚠️ DNS NOTIFY for %s to %s failed: %v", strings.TrimSuffix(z.origin, "."), addr, err)
+				return
+			}
+			log.Printf("📨 DNS NOTIFY for %s sent to %s", strings.TrimSuffix(z.origin, "."), addr)
+		}()
+	}
+}
+
+func sendNotify(addr string, z *zone) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	id := uint16(rand.Intn(1 << 16))
+	b := newBuilder(id, uint16(opNotify)<<11|flagAA)
+	b.question(question{Name: z.origin, Type: typeSOA, Class: classIN})
+	b.record(1, z.soa)
+	msg := b.bytes()
+
+	buf := make([]byte, 512)
+	for try := 0; try < notifyRetries; try++ {
+		if _, err := conn.Write(msg); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(notifyTimeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break
+			}
+			resp, err := parseMessage(buf[:n])
+			if err != nil || resp.ID != id || !resp.QR {
+				continue
+			}
+			if resp.Rcode != rcodeSuccess {
+				return errors.New("secondary answered with rcode " + rcodeName(resp.Rcode))
+			}
+			return nil
+		}
+	}
+	return errors.New("no answer")
+}
+
+func rcodeName(rcode int) string {
+	switch rcode {
+	case rcodeFormErr:
+		return "FORMERR"
+	case rcodeServFail:
+		return "SERVFAIL"
+	case rcodeNXDomain:
+		return "NXDOMAIN"
+	case rcodeNotImp:
+		return "NOTIMP"
+	case rcodeRefused:
+		return "REFUSED"
+	}
+	return "NOERROR"
+}
+
+// secondaryHost returns the IP of a secondary given as IP or IP:port
+func secondaryHost(sec string) net.IP {
+	if host, _, err := net.SplitHostPort(sec); err == nil {
+		sec = host
+	}
+	return net.ParseIP(sec)
+}
+
+// ValidSecondary reports whether sec is an IP address with an optional
+// port, the form of the dns_secondaries setting
+func ValidSecondary(sec string) bool {
+	return secondaryHost(sec) != nil
+}
@@ -0,0 +1,173 @@
+package dnsserver
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DB is the database the server reads its zones from
+type DB interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// loadZones reads the active domains with their records, and the zones
+// served for DNS cluster peers. The serial of a domain is bumped when its
+// records change.
+func loadZones(db DB, serverIP string) (map[string]*zone, []string, error) {
+	rows, err := db.Query("SELECT id, name FROM domains WHERE active = 1")
+	if err != nil {
+		return nil, nil, err
+	}
+	type domain struct {
+		id   int64
+		name string
+	}
+	var domains []domain
+	for rows.Next() {
+		var d domain
+		if err := rows.Scan(&d.id, &d.name); err == nil {
+			domains = append(domains, d)
+		}
+	}
+	rows.Close()
+
+	zones := map[string]*zone{}
+	var warnings []string
+	for _, d := range domains {
+		records, err := domainRecords(db, d.id, serverIP)
+		if err != nil {
+			return nil, nil, err
+		}
+		serial, err := zoneSerial(db, d.id, recordsHash(records))
+		if err != nil {
+			return nil, nil, err
+		}
+		z, w := buildZone(d.id, d.name, serial, records)
+		warnings = append(warnings, w...)
+		zones[z.origin] = z
+	}
+
+	// Cluster zones carry the serial kept when the peer pushed them
+	rows, err = db.Query("SELECT domain, records, serial FROM dns_cluster_zones")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var domain, data string
+		var serial uint32
+		if rows.Scan(&domain, &data, &serial) != nil {
+			continue
+		}
+		var records []Record
+		if err := json.Unmarshal([]byte(data), &records); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", domain, err))
+			continue
+		}
+		if _, local := zones[fqdn(domain)]; local {
+			warnings = append(warnings, fmt.Sprintf("%s: cluster zone shadowed by a local domain", domain))
+			continue
+		}
+		z, w := buildZone(0, domain, serial, records)
+		warnings = append(warnings, w...)
+		zones[z.origin] = z
+	}
+	return zones, warnings, nil
+}
+
+// domainRecords returns the active records of a domain. Subdomains without
+// a record of their own point at the server like in the zone files.
+func domainRecords(db DB, domainID int64, serverIP string) ([]Record, error) {
+	rows, err := db.Query(`
+		SELECT name, type, content, ttl, priority FROM dns_records
+		WHERE domain_id = ? AND active = 1
+		ORDER BY name, type, priority, content
+	`, domainID)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	names := map[string]bool{}
+	for rows.Next() {
+		var r Record
+		if err := rows.Scan(&r.Name, &r.Type, &r.Content, &r.TTL, &r.Priority); err != nil {
+			continue
+		}
+		records = append(records, r)
+		names[strings.ToLower(r.Name)] = true
+	}
+	rows.Close()
+
+	rows, err = db.Query("SELECT name FROM subdomains WHERE domain_id = ? AND active = 1 ORDER BY name", domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil && !names[strings.ToLower(name)] {
+			records = append(records, Record{Name: name, Type: "A", Content: serverIP, TTL: soaTTL})
+		}
+	}
+	return records, nil
+}
+
+func recordsHash(records []Record) string {
+	h := sha256.New()
+	for _, r := range records {
+		fmt.Fprintf(h, "%s\t%s\t%s\t%d\t%d\n", r.Name, r.Type, r.Content, r.TTL, r.Priority)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// zoneSerial returns the serial of a zone, bumping it when the hash of the
+// records differs from the stored one. Serials follow the YYYYMMDDHH form of
+// the zone files and grow by one when that would not be newer.
+func zoneSerial(db DB, domainID int64, hash string) (uint32, error) {
+	var serial uint32
+	var stored string
+	err := db.QueryRow("SELECT serial, records_hash FROM dns_zone_serials WHERE domain_id = ?", domainID).
+		Scan(&serial, &stored)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if err == nil && stored == hash {
+		return serial, nil
+	}
+
+	next := serial + 1
+	if now, _ := strconv.ParseUint(time.Now().Format("2006010215"), 10, 32); uint32(now) > next {
+		next = uint32(now)
+	}
+	_, err = db.Exec(`
+		INSERT INTO dns_zone_serials (domain_id, serial, records_hash, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(domain_id) DO UPDATE SET serial = excluded.serial,
+			records_hash = excluded.records_hash, updated_at = CURRENT_TIMESTAMP
+	`, domainID, next, hash)
+	if err != nil {
+		return 0, err
+	}
+	return next, nil
+}
+
+// secondaries returns the dns_secondaries server setting
+func secondaries(db DB) []string {
+	var value string
+	db.QueryRow("SELECT value FROM server_settings WHERE key = 'dns_secondaries'").Scan(&value)
+	var out []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,400 @@
+package dnsserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record is a row of dns_records
+type Record struct {
+	Name     string
+	Type     string
+	Content  string
+	TTL      int
+	Priority int
+}
+
+// SOA timers of panel zones, the same as in the generated zone files
+const (
+	soaTTL     = 3600
+	soaRefresh = 3600
+	soaRetry   = 1800
+	soaExpire  = 604800
+	soaMinimum = 86400
+
+	defaultPrimaryNS = "ns1.serverpanel.local."
+)
+
+// zone is a zone as the server answers it
+type zone struct {
+	id     int64
+	origin string
+	serial uint32
+	soa    rr
+	nodes  map[string][]rr
+	names  []string // owner names, sorted
+}
+
+// answer is the result of a lookup
+type answer struct {
+	rcode      int
+	aa         bool
+	answer     []rr
+	authority  []rr
+	additional []rr
+}
+
+// buildZone turns the records of a domain into a zone. Records that cannot
+// be encoded are skipped and returned as warnings.
+func buildZone(id int64, domain string, serial uint32, records []Record) (*zone, []string) {
+	z := &zone{id: id, origin: fqdn(domain), serial: serial, nodes: map[string][]rr{}}
+	var warnings []string
+
+	primary, hasPrimary := defaultPrimaryNS, false
+	for _, r := range records {
+		rrType, data, err := encodeRData(r, z.origin)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %s %s: %v", domain, r.Type, r.Name, err))
+			continue
+		}
+		owner := absolute(r.Name, z.origin)
+		if !inZone(owner, z.origin) {
+			warnings = append(warnings, fmt.Sprintf("%s: %s is outside the zone", domain, owner))
+			continue
+		}
+		if rrType == typeNS && owner == z.origin && !hasPrimary {
+			primary, _, _ = readName(data, 0)
+			hasPrimary = true
+		}
+		ttl := r.TTL
+		if ttl <= 0 {
+			ttl = soaTTL
+		}
+		z.nodes[owner] = append(z.nodes[owner], rr{Name: owner, Type: rrType, Class: classIN, TTL: uint32(ttl), Data: data})
+	}
+
+	soa := appendName(nil, primary)
+	soa = appendName(soa, "hostmaster."+z.origin)
+	for _, v := range []uint32{serial, soaRefresh, soaRetry, soaExpire, soaMinimum} {
+		soa = binary.BigEndian.AppendUint32(soa, v)
+	}
+	z.soa = rr{Name: z.origin, Type: typeSOA, Class: classIN, TTL: soaTTL, Data: soa}
+
+	for name := range z.nodes {
+		z.names = append(z.names, name)
+	}
+	sort.Strings(z.names)
+	return z, warnings
+}
+
+// encodeRData encodes the content of a record as RDATA
+func encodeRData(r Record, origin string) (uint16, []byte, error) {
+	rrType, ok := rrTypes[strings.ToUpper(r.Type)]
+	if !ok || rrType == typeSOA {
+		return 0, nil, fmt.Errorf("unsupported type")
+	}
+	content := strings.TrimSpace(r.Content)
+
+	switch rrType {
+	case typeA:
+		ip := net.ParseIP(content).To4()
+		if ip == nil {
+			return 0, nil, fmt.Errorf("invalid IPv4 address %q", content)
+		}
+		return rrType, ip, nil
+	case typeAAAA:
+		ip := net.ParseIP(content)
+		if ip == nil || ip.To4() != nil {
+			return 0, nil, fmt.Errorf("invalid IPv6 address %q", content)
+		}
+		return rrType, ip.To16(), nil
+	case typeCNAME, typeNS, typePTR:
+		return rrType, appendName(nil, absolute(content, origin)), nil
+	case typeMX:
+		data := binary.BigEndian.AppendUint16(nil, uint16(r.Priority))
+		return rrType, appendName(data, absolute(content, origin)), nil
+	case typeTXT:
+		var data []byte
+		for _, s := range txtStrings(content) {
+			data = append(data, byte(len(s)))
+			data = append(data, s...)
+		}
+		return rrType, data, nil
+	case typeSRV:
+		// weight port target, with the priority in its own column
+		fields := strings.Fields(content)
+		priority := r.Priority
+		if len(fields) == 4 {
+			priority, _ = strconv.Atoi(fields[0])
+			fields = fields[1:]
+		}
+		if len(fields) != 3 {
+			return 0, nil, fmt.Errorf("SRV content must be weight port target")
+		}
+		weight, err1 := strconv.ParseUint(fields[0], 10, 16)
+		port, err2 := strconv.ParseUint(fields[1], 10, 16)
+		if err1 != nil || err2 != nil {
+			return 0, nil, fmt.Errorf("invalid SRV weight or port")
+		}
+		data := binary.BigEndian.AppendUint16(nil, uint16(priority))
+		data = binary.BigEndian.AppendUint16(data, uint16(weight))
+		data = binary.BigEndian.AppendUint16(data, uint16(port))
+		return rrType, appendName(data, absolute(fields[2], origin)), nil
+	case typeCAA:
+		fields := strings.SplitN(content, " ", 3)
+		if len(fields) != 3 {
+			return 0, nil, fmt.Errorf("CAA content must be flags tag value")
+		}
+		flags, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid CAA flags")
+		}
+		data := []byte{byte(flags), byte(len(fields[1]))}
+		data = append(data, fields[1]...)
+		return rrType, append(data, strings.Trim(strings.TrimSpace(fields[2]), `"`)...), nil
+	case typeDS, typeTLSA, typeSSHFP:
+		return encodeHexRData(rrType, content)
+	case typeNAPTR:
+		return encodeNAPTR(content, origin)
+	case typeSVCB, typeHTTPS:
+		return encodeSVCB(rrType, r.Priority, content, origin)
+	}
+	return 0, nil, fmt.Errorf("unsupported type")
+}
+
+// txtStrings splits TXT content into character strings of at most 255
+// bytes. Quoted content ("a" "b") keeps its strings.
+func txtStrings(content string) []string {
+	var strs []string
+	if strings.HasPrefix(content, `"`) {
+		var cur strings.Builder
+		inQuote := false
+		for i := 0; i < len(content); i++ {
+			ch := content[i]
+			switch {
+			case ch == '\\' && inQuote && i+1 < len(content):
+				i++
+				cur.WriteByte(content[i])
+			case ch == '"':
+				if inQuote {
+					strs = append(strs, cur.String())
+					cur.Reset()
+				}
+				inQuote = !inQuote
+			case inQuote:
+				cur.WriteByte(ch)
+			}
+		}
+		content = strings.Join(strs, "")
+		strs = nil
+		if len(content) == 0 {
+			return []string{""}
+		}
+	}
+	for len(content) > 255 {
+		strs = append(strs, content[:255])
+		content = content[255:]
+	}
+	return append(strs, content)
+}
+
+// lookup answers a query for a name in the zone
+func (z *zone) lookup(qname string, qtype uint16) answer {
+	a := answer{aa: true}
+
+	// The DS records of a zone cut are answered by the parent (RFC 4035)
+	if cut := z.delegation(qname); cut != "" && (qtype != typeDS || cut != qname) {
+		a.aa = false
+		a.authority = z.rrset(cut, typeNS, cut)
+		a.additional = z.glue(a.authority)
+		return a
+	}
+
+	owner := qname
+	rrs, ok := z.nodes[qname]
+	if !ok {
+		if z.hasDescendant(qname) {
+			// Empty non-terminal
+			a.authority = []rr{z.negativeSOA()}
+			return a
+		}
+		wild := z.wildcard(qname)
+		if wild == "" {
+			a.rcode = rcodeNXDomain
+			a.authority = []rr{z.negativeSOA()}
+			return a
+		}
+		rrs = z.nodes[wild]
+	}
+
+	if owner == z.origin {
+		switch qtype {
+		case typeSOA:
+			a.answer = []rr{z.soa}
+			return a
+		case typeANY:
+			a.answer = []rr{z.soa}
+		}
+	}
+
+	// Follow CNAMEs inside the zone
+	for hops := 0; ; hops++ {
+		if matched := filter(rrs, qtype, owner); len(matched) > 0 {
+			a.answer = append(a.answer, matched...)
+			break
+		}
+		cname := filter(rrs, typeCNAME, owner)
+		if len(cname) == 0 {
+			if len(a.answer) == 0 {
+				a.authority = []rr{z.negativeSOA()}
+			}
+			break
+		}
+		a.answer = append(a.answer, cname[0])
+		target, _, _ := readName(cname[0].Data, 0)
+		next, ok := z.nodes[target]
+		if hops >= 8 || !ok || z.delegation(target) != "" {
+			break
+		}
+		owner, rrs = target, next
+	}
+
+	a.additional = z.glue(a.answer)
+	return a
+}
+
+// filter returns the records of a type (all for ANY) with owner as their
+// name, which differs from theirs for wildcards
+func filter(rrs []rr, qtype uint16, owner string) []rr {
+	var out []rr
+	for _, r := range rrs {
+		if r.Type == qtype || qtype == typeANY {
+			r.Name = owner
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func (z *zone) rrset(name string, rrType uint16, owner string) []rr {
+	return filter(z.nodes[name], rrType, owner)
+}
+
+// delegation returns the zone cut at or above qname, if any
+func (z *zone) delegation(qname string) string {
+	var path []string
+	for name := qname; name != z.origin && inZone(name, z.origin); name = parent(name) {
+		path = append(path, name)
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		if len(z.rrset(path[i], typeNS, path[i])) > 0 {
+			return path[i]
+		}
+	}
+	return ""
+}
+
+func (z *zone) hasDescendant(name string) bool {
+	for _, n := range z.names {
+		if strings.HasSuffix(n, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcard returns the wildcard that matches qname, if any
+func (z *zone) wildcard(qname string) string {
+	for name := parent(qname); inZone(name, z.origin); name = parent(name) {
+		_, exists := z.nodes[name]
+		if exists || name == z.origin || z.hasDescendant(name) {
+			if _, ok := z.nodes["*."+name]; ok {
+				return "*." + name
+			}
+			return ""
+		}
+	}
+	return ""
+}
+
+// glue returns the in-zone addresses of the targets of NS, MX and SRV
+// records
+func (z *zone) glue(rrs []rr) []rr {
+	var out []rr
+	seen := map[string]bool{}
+	for _, r := range rrs {
+		var data []byte
+		switch r.Type {
+		case typeNS:
+			data = r.Data
+		case typeMX:
+			data = r.Data[2:]
+		case typeSRV:
+			data = r.Data[6:]
+		default:
+			continue
+		}
+		target, _, err := readName(data, 0)
+		if err != nil || seen[target] || !inZone(target, z.origin) {
+			continue
+		}
+		seen[target] = true
+		out = append(out, z.rrset(target, typeA, target)...)
+		out = append(out, z.rrset(target, typeAAAA, target)...)
+	}
+	return out
+}
+
+// negativeSOA is the SOA of negative answers, with the negative TTL
+func (z *zone) negativeSOA() rr {
+	soa := z.soa
+	if soa.TTL > soaMinimum {
+		soa.TTL = soaMinimum
+	}
+	return soa
+}
+
+// transfer returns the records of a zone transfer: SOA, the records, SOA
+func (z *zone) transfer() []rr {
+	out := []rr{z.soa}
+	for _, name := range z.names {
+		out = append(out, z.nodes[name]...)
+	}
+	return append(out, z.soa)
+}
+
+func fqdn(name string) string {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// absolute expands a name of dns_records ("@", "www", "mail.example.com.")
+func absolute(name, origin string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch {
+	case name == "" || name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return name
+	}
+	return name + "." + origin
+}
+
+func inZone(name, origin string) bool {
+	return name == origin || strings.HasSuffix(name, "."+origin)
+}
+
+func parent(name string) string {
+	_, rest, _ := strings.Cut(name, ".")
+	if rest == "" {
+		return "."
+	}
+	return rest
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/asergenalkan/serverpanel/internal/config"
 	"github.com/asergenalkan/serverpanel/internal/services/dns"
+	"github.com/asergenalkan/serverpanel/internal/services/quota"
 	"github.com/asergenalkan/serverpanel/internal/webserver"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -45,12 +46,14 @@ type CreateAccountRequest struct {
 	Password  string `json:"password"`
 	Domain    string `json:"domain"`
 	PackageID int64  `json:"package_id"`
+	ParentID  int64  `json:"parent_id"` // Owning reseller, 0 = admin
 }
 
 type Account struct {
 	ID          int64  `json:"id"`
 	Username    string `json:"username"`
 	Email       string `json:"email"`
+	ParentID    int64  `json:"parent_id,omitempty"`
 	Domain      string `json:"domain"`
 	HomeDir     string `json:"home_dir"`
 	PackageID   int64  `json:"package_id"`
@@ -58,7 +61,10 @@ type Account struct {
 	DiskUsed    int64  `json:"disk_used"`
 	DiskQuota   int64  `json:"disk_quota"`
 	Active      bool   `json:"active"`
-	CreatedAt   string `json:"created_at"`
+	// Set while the account is suspended
+	SuspendReason string `json:"suspend_reason,omitempty"`
+	SuspendedAt   string `json:"suspended_at,omitempty"`
+	CreatedAt     string `json:"created_at"`
 }
 
 func NewService(db DB) *Service {
@@ -134,6 +140,9 @@ func (s *Service) CreateAccount(req CreateAccountRequest) (*Account, error) {
 	if count > 0 {
 		return nil, ErrDomainExists
 	}
+	if peer, ok := dns.NewService(s.db).ClusterOwner(req.Domain); ok {
+		return nil, fmt.Errorf("%w on DNS cluster peer %s", ErrDomainExists, peer)
+	}
 
 	// Get package info
 	var packageName string
@@ -143,6 +152,22 @@ func (s *Service) CreateAccount(req CreateAccountRequest) (*Account, error) {
 		return nil, ErrPackageNotFound
 	}
 
+	// Accounts of a reseller are allocated from its pool
+	var parentID interface{}
+	if req.ParentID > 0 {
+		if _, err := s.GetResellerLimits(req.ParentID); err != nil {
+			return nil, err
+		}
+		limits, err := s.GetPackageLimits(req.PackageID)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.CheckResellerCapacity(req.ParentID, 1, *limits); err != nil {
+			return nil, err
+		}
+		parentID = req.ParentID
+	}
+
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), 10)
 	if err != nil {
@@ -160,9 +185,9 @@ func (s *Service) CreateAccount(req CreateAccountRequest) (*Account, error) {
 
 	// Create user in database
 	result, err := tx.Exec(`
-		INSERT INTO users (username, email, password, role, active, created_at, updated_at)
-		VALUES (?, ?, ?, 'user', 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-	`, req.Username, req.Email, string(hashedPassword))
+		INSERT INTO users (username, email, password, role, parent_id, active, created_at, updated_at)
+		VALUES (?, ?, ?, 'user', ?, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`, req.Username, req.Email, string(hashedPassword), parentID)
 	if err != nil {
 		return nil, err
 	}
@@ -197,12 +222,13 @@ func (s *Service) CreateAccount(req CreateAccountRequest) (*Account, error) {
 		return nil, fmt.Errorf("failed to create directories: %w", err)
 	}
 
-	if err := s.createWebServerVhost(req.Username, req.Domain, homeDir, documentRoot); err != nil {
-		return nil, fmt.Errorf("failed to create web server config: %w", err)
+	// Apply the package disk quota to the system user
+	if err := quota.NewManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath).SetQuota(req.Username, diskQuota); err != nil {
+		log.Printf("Warning: failed to set disk quota: %v", err)
 	}
 
-	if err := s.createPHPFPMPool(req.Username, homeDir); err != nil {
-		return nil, fmt.Errorf("failed to create PHP-FPM pool: %w", err)
+	if err := s.createWebServerVhost(req.Username, req.Domain, homeDir, documentRoot, s.packagePHPLimits(req.PackageID)); err != nil {
+		return nil, fmt.Errorf("failed to create web server config: %w", err)
 	}
 
 	// Create DNS zone
@@ -238,6 +264,7 @@ func (s *Service) CreateAccount(req CreateAccountRequest) (*Account, error) {
 		ID:          userID,
 		Username:    req.Username,
 		Email:       req.Email,
+		ParentID:    req.ParentID,
 		Domain:      req.Domain,
 		HomeDir:     homeDir,
 		PackageID:   req.PackageID,
@@ -322,15 +349,13 @@ Options -Indexes
 	return nil
 }
 
-// createWebServerVhost creates virtual host configuration using the configured web server driver
-func (s *Service) createWebServerVhost(username, domain, homeDir, documentRoot string) error {
-	// Get the appropriate web server driver
-	driverType := webserver.DriverApache // Default: Apache (supports .htaccess)
-	if s.cfg.WebServer == "nginx" {
-		driverType = webserver.DriverNginx
-	}
-
-	driver := webserver.NewDriver(driverType, s.cfg.SimulateMode, s.cfg.SimulateBasePath)
+// createWebServerVhost creates the virtual host and the PHP-FPM pool of an
+// account in one transaction: when the web server or PHP-FPM rejects the
+// config neither is left behind
+func (s *Service) createWebServerVhost(username, domain, homeDir, documentRoot string, php phpLimits) error {
+	driver := s.webDriver()
+	manager := s.phpManager(s.cfg.PHPVersion)
+	pool := PoolName(username, domain, php.perDomain)
 
 	vhostConfig := webserver.VhostConfig{
 		Domain:       domain,
@@ -339,31 +364,20 @@ func (s *Service) createWebServerVhost(username, domain, homeDir, documentRoot s
 		DocumentRoot: documentRoot,
 		HomeDir:      homeDir,
 		PHPVersion:   s.cfg.PHPVersion,
+		PHPPool:      pool,
 	}
 
-	if err := driver.CreateVhost(vhostConfig); err != nil {
+	// The pool goes first so its socket is up when the web server reloads
+	tx := webserver.NewTransaction()
+	manager.StagePool(tx, php.poolConfig(username, homeDir, s.cfg.PHPVersion, pool))
+	if err := driver.StageVhost(tx, vhostConfig); err != nil {
 		return err
 	}
-
-	log.Printf("✅ %s vhost created for: %s", driver.Name(), domain)
-	return nil
-}
-
-// createPHPFPMPool creates a PHP-FPM pool for the user
-func (s *Service) createPHPFPMPool(username, homeDir string) error {
-	manager := webserver.NewPHPFPMManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath, s.cfg.PHPVersion)
-
-	poolConfig := webserver.PHPFPMConfig{
-		Username:   username,
-		HomeDir:    homeDir,
-		PHPVersion: s.cfg.PHPVersion,
-	}
-
-	if err := manager.CreatePool(poolConfig); err != nil {
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	log.Printf("✅ PHP-FPM pool created for: %s", username)
+	log.Printf("✅ %s vhost and PHP-FPM pool created for: %s", driver.Name(), domain)
 	return nil
 }
 
@@ -393,12 +407,7 @@ func (s *Service) createDNSZone(domain string) error {
 
 // createWebmailVhost creates a webmail subdomain vhost for the domain
 func (s *Service) createWebmailVhost(domain string) error {
-	driverType := webserver.DriverApache
-	if s.cfg.WebServer == "nginx" {
-		driverType = webserver.DriverNginx
-	}
-
-	driver := webserver.NewDriver(driverType, s.cfg.SimulateMode, s.cfg.SimulateBasePath)
+	driver := s.webDriver()
 
 	// Only Apache supports webmail vhost for now
 	if apacheDriver, ok := driver.(*webserver.ApacheDriver); ok {
@@ -569,21 +578,31 @@ func (s *Service) createWelcomePage(username, domain, documentRoot string) error
 	return nil
 }
 
-// ListAccounts returns all hosting accounts
-func (s *Service) ListAccounts() ([]Account, error) {
-	rows, err := s.db.Query(`
-		SELECT u.id, u.username, u.email, u.active, u.created_at,
+// ListAccounts returns all hosting accounts, or only those below a
+// reseller when resellerID is set
+func (s *Service) ListAccounts(resellerID int64) ([]Account, error) {
+	query := `
+		SELECT u.id, u.username, u.email, COALESCE(u.parent_id, 0), u.active, u.created_at,
 			   COALESCE(d.name, '') as domain,
 			   COALESCE(p.id, 0) as package_id,
 			   COALESCE(p.name, 'No Package') as package_name,
-			   COALESCE(p.disk_quota, 0) as disk_quota
+			   COALESCE(p.disk_quota, 0) as disk_quota,
+			   COALESCE((SELECT used_mb FROM disk_usage_samples WHERE user_id = u.id ORDER BY id DESC LIMIT 1), 0) as disk_used,
+			   COALESCE(s.reason, ''), COALESCE(s.suspended_at, '')
 		FROM users u
+		LEFT JOIN account_suspensions s ON s.user_id = u.id
 		LEFT JOIN domains d ON d.user_id = u.id
 		LEFT JOIN user_packages up ON up.user_id = u.id
 		LEFT JOIN packages p ON p.id = up.package_id
-		WHERE u.role = 'user'
-		ORDER BY u.created_at DESC
-	`)
+		WHERE u.role = 'user'`
+	var args []interface{}
+	if resellerID > 0 {
+		query = descendantsCTE + query + " AND u.id IN (SELECT id FROM descendants)"
+		args = append(args, resellerID)
+	}
+	query += " ORDER BY u.created_at DESC"
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -592,8 +611,9 @@ func (s *Service) ListAccounts() ([]Account, error) {
 	var accounts []Account
 	for rows.Next() {
 		var a Account
-		if err := rows.Scan(&a.ID, &a.Username, &a.Email, &a.Active, &a.CreatedAt,
-			&a.Domain, &a.PackageID, &a.PackageName, &a.DiskQuota); err != nil {
+		if err := rows.Scan(&a.ID, &a.Username, &a.Email, &a.ParentID, &a.Active, &a.CreatedAt,
+			&a.Domain, &a.PackageID, &a.PackageName, &a.DiskQuota, &a.DiskUsed,
+			&a.SuspendReason, &a.SuspendedAt); err != nil {
 			continue
 		}
 		a.HomeDir = filepath.Join(s.cfg.HomeBaseDir, a.Username)
@@ -642,11 +662,7 @@ func (s *Service) DeleteAccount(userID int64) error {
 	}
 
 	// Delete web server configs for all domains
-	driverType := webserver.DriverApache
-	if s.cfg.WebServer == "nginx" {
-		driverType = webserver.DriverNginx
-	}
-	driver := webserver.NewDriver(driverType, s.cfg.SimulateMode, s.cfg.SimulateBasePath)
+	driver := s.webDriver()
 
 	for _, domainName := range domains {
 		if err := driver.DeleteVhost(domainName); err != nil {
@@ -657,12 +673,19 @@ func (s *Service) DeleteAccount(userID int64) error {
 		if err := dnsManager.DeleteZone(domainName); err != nil {
 			log.Printf("Warning: failed to delete DNS zone for %s: %v", domainName, err)
 		}
+		if err := dns.NewService(s.db).QueueZone(domainName, dns.ClusterDelete); err != nil {
+			log.Printf("Warning: failed to queue DNS cluster removal of %s: %v", domainName, err)
+		}
 	}
 
-	// Delete PHP-FPM pool first
-	phpfpm := webserver.NewPHPFPMManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath, s.cfg.PHPVersion)
-	if err := phpfpm.DeletePool(username); err != nil {
-		log.Printf("Warning: failed to delete PHP-FPM pool for %s: %v", username, err)
+	// Delete PHP-FPM pools first, of every PHP version
+	for _, version := range s.phpVersions() {
+		phpfpm := s.phpManager(version)
+		for _, pool := range phpfpm.UserPools(username) {
+			if err := phpfpm.DeletePool(pool); err != nil {
+				log.Printf("Warning: failed to delete PHP-FPM pool %s: %v", pool, err)
+			}
+		}
 	}
 
 	// Restart PHP-FPM to release the pool processes
@@ -726,12 +749,17 @@ func (s *Service) DeleteAccount(userID int64) error {
 	tx.Exec("DELETE FROM databases WHERE user_id = ?", userID)
 	tx.Exec("DELETE FROM email_accounts WHERE user_id = ?", userID)
 	tx.Exec("DELETE FROM activity_logs WHERE user_id = ?", userID)
+	tx.Exec("DELETE FROM disk_usage_samples WHERE user_id = ?", userID)
+	tx.Exec("DELETE FROM account_suspensions WHERE user_id = ?", userID)
+	tx.Exec("DELETE FROM vhost_directives WHERE user_id = ?", userID)
 	tx.Exec("DELETE FROM users WHERE id = ?", userID)
 
 	if err := tx.Commit(); err != nil {
 		return err
 	}
 
+	quota.NewManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath).RemoveQuota(username)
+
 	// Delete system user
 	if config.IsDevelopment() {
 		log.Printf("🔧 [SIMÜLASYON] userdel -r %s", username)
@@ -756,14 +784,70 @@ func (s *Service) DeleteAccount(userID int64) error {
 	return nil
 }
 
-// SuspendAccount suspends an account
-func (s *Service) SuspendAccount(userID int64) error {
-	_, err := s.db.Exec("UPDATE users SET active = 0 WHERE id = ?", userID)
-	return err
-}
+// ThrottleAccount limits the responses of every domain of an account,
+// addon and parked domains included, to rateKB KB/s and rebuilds their
+// vhosts; subdomains take the limit of their domain. 0 lifts the limit.
+// When a vhost can not be rebuilt the previous limits are restored.
+func (s *Service) ThrottleAccount(userID int64, rateKB int) error {
+	var username string
+	err := s.db.QueryRow("SELECT username FROM users WHERE id = ? AND role = 'user'", userID).Scan(&username)
+	if err != nil {
+		return err
+	}
+
+	previous := map[string]int{}
+	rows, err := s.db.Query("SELECT name, COALESCE(rate_limit_kb, 0) FROM domains WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		var rate int
+		if rows.Scan(&name, &rate) == nil {
+			previous[name] = rate
+		}
+	}
+	rows.Close()
+	if len(previous) == 0 {
+		return sql.ErrNoRows
+	}
+
+	var names []string
+	rows, err = s.db.Query(`
+		SELECT name FROM domains WHERE user_id = ?
+		UNION ALL
+		SELECT full_name FROM subdomains WHERE user_id = ? AND COALESCE(redirect_url, '') = ''
+	`, userID, userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	if _, err := s.db.Exec("UPDATE domains SET rate_limit_kb = ? WHERE user_id = ?", rateKB, userID); err != nil {
+		return err
+	}
+	for i, name := range names {
+		if err := s.RebuildVhost(name); err != nil {
+			for domain, rate := range previous {
+				s.db.Exec("UPDATE domains SET rate_limit_kb = ? WHERE name = ?", rate, domain)
+			}
+			for _, done := range names[:i] {
+				s.RebuildVhost(done)
+			}
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
 
-// UnsuspendAccount unsuspends an account
-func (s *Service) UnsuspendAccount(userID int64) error {
-	_, err := s.db.Exec("UPDATE users SET active = 1 WHERE id = ?", userID)
-	return err
+	if rateKB > 0 {
+		log.Printf("🐢 Account throttled: %s (%d KB/s, %d vhosts)", username, rateKB, len(names))
+	} else {
+		log.Printf("✅ Account throttle lifted: %s", username)
+	}
+	return nil
 }
@@ -0,0 +1,286 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/asergenalkan/serverpanel/internal/services/quota"
+	"github.com/asergenalkan/serverpanel/internal/webserver"
+)
+
+var ErrUsageExceedsPackage = errors.New("current usage exceeds the new package limits")
+
+// phpLimits are the PHP limits and PHP-FPM process settings of a package
+type phpLimits struct {
+	memory   string
+	upload   string
+	execTime int
+
+	pm             string
+	maxChildren    int
+	requestTimeout int
+	slowlogTimeout int
+	perDomain      bool // a pool per domain instead of one per account
+}
+
+// PackageChange describes moving an account to another package. On a
+// dry run nothing is changed.
+type PackageChange struct {
+	UserID         int64    `json:"user_id"`
+	Username       string   `json:"username"`
+	OldPackageID   int64    `json:"old_package_id"`
+	OldPackageName string   `json:"old_package_name"`
+	NewPackageID   int64    `json:"new_package_id"`
+	NewPackageName string   `json:"new_package_name"`
+	Domains        int      `json:"domains"`
+	Databases      int      `json:"databases"`
+	Emails         int      `json:"emails"`
+	FTPAccounts    int      `json:"ftp_accounts"`
+	DiskUsedMB     int64    `json:"disk_used_mb"`
+	LimitErrors    []string `json:"limit_errors"`
+	PHPPools       []string `json:"php_pools,omitempty"`
+	ClampedDomains []string `json:"clamped_domains,omitempty"`
+	DryRun         bool     `json:"dry_run"`
+}
+
+// ChangePackage moves an account to another package. Current usage must
+// fit the new limits; the PHP-FPM pools are re-rendered with the new PHP
+// limits and the policy daemon picks up the new mail rates from
+// user_packages on the next message.
+func (s *Service) ChangePackage(userID, packageID int64, dryRun bool) (*PackageChange, error) {
+	ch := &PackageChange{UserID: userID, NewPackageID: packageID, LimitErrors: []string{}, DryRun: dryRun}
+
+	var parentID sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT u.username, u.parent_id, COALESCE(up.package_id, 0), COALESCE(p.name, '')
+		FROM users u
+		LEFT JOIN user_packages up ON up.user_id = u.id
+		LEFT JOIN packages p ON p.id = up.package_id
+		WHERE u.id = ? AND u.role = 'user'
+	`, userID).Scan(&ch.Username, &parentID, &ch.OldPackageID, &ch.OldPackageName)
+	if err != nil {
+		return nil, err
+	}
+
+	newLimits, err := s.GetPackageLimits(packageID)
+	if err != nil {
+		return nil, err
+	}
+	s.db.QueryRow("SELECT name FROM packages WHERE id = ?", packageID).Scan(&ch.NewPackageName)
+	php := s.packagePHPLimits(packageID)
+
+	// Current usage against the new limits; 0 means unlimited, as for
+	// quotas and reseller pools
+	s.db.QueryRow("SELECT COUNT(*) FROM domains WHERE user_id = ?", userID).Scan(&ch.Domains)
+	s.db.QueryRow("SELECT COUNT(*) FROM databases WHERE user_id = ?", userID).Scan(&ch.Databases)
+	s.db.QueryRow("SELECT COUNT(*) FROM email_accounts WHERE user_id = ?", userID).Scan(&ch.Emails)
+	s.db.QueryRow("SELECT COUNT(*) FROM ftp_accounts WHERE user_id = ?", userID).Scan(&ch.FTPAccounts)
+	// A dry run only looks; the real change records the sample
+	measure := quota.NewService(s.db).Sample
+	if dryRun {
+		measure = quota.NewService(s.db).Measure
+	}
+	if usage, err := measure(userID); err == nil {
+		ch.DiskUsedMB = usage.UsedMB
+	}
+
+	limit := func(what string, have, max int64) {
+		if max > 0 && have > max {
+			ch.LimitErrors = append(ch.LimitErrors, fmt.Sprintf("%s: %d in use, package %s allows %d", what, have, ch.NewPackageName, max))
+		}
+	}
+	limit("domains", int64(ch.Domains), int64(newLimits.MaxDomains))
+	limit("databases", int64(ch.Databases), int64(newLimits.MaxDatabases))
+	limit("email accounts", int64(ch.Emails), int64(newLimits.MaxEmails))
+	limit("FTP accounts", int64(ch.FTPAccounts), int64(newLimits.MaxFTP))
+	limit("disk space (MB)", ch.DiskUsedMB, newLimits.DiskQuota)
+
+	// Accounts of a reseller are allocated from its pool
+	if parentID.Valid {
+		add := *newLimits
+		if ch.OldPackageID > 0 {
+			if old, err := s.GetPackageLimits(ch.OldPackageID); err == nil {
+				add = newLimits.Sub(*old)
+			}
+		}
+		if err := s.CheckResellerCapacity(parentID.Int64, 0, add); err != nil {
+			ch.LimitErrors = append(ch.LimitErrors, err.Error())
+		}
+	}
+
+	if dryRun {
+		return ch, nil
+	}
+	if len(ch.LimitErrors) > 0 {
+		return ch, ErrUsageExceedsPackage
+	}
+
+	if _, err := s.db.Exec(`
+		INSERT INTO user_packages (user_id, package_id) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET package_id = excluded.package_id
+	`, userID, packageID); err != nil {
+		return nil, err
+	}
+
+	if err := quota.NewService(s.db).Apply(userID); err != nil {
+		log.Printf("⚠️ Failed to update disk quota of %s: %v", ch.Username, err)
+	}
+
+	ch.ClampedDomains = s.clampPHPSettings(userID, php)
+	ch.PHPPools, err = s.RebuildPHPPools(userID)
+	if err != nil {
+		return ch, fmt.Errorf("package changed but PHP-FPM pools could not be updated: %w", err)
+	}
+
+	log.Printf("📦 Package changed: %s (%s → %s)", ch.Username, ch.OldPackageName, ch.NewPackageName)
+	return ch, nil
+}
+
+// packagePHPLimits returns the PHP limits of a package
+func (s *Service) packagePHPLimits(packageID int64) phpLimits {
+	php := phpLimits{memory: "256M", upload: "64M", execTime: 300, pm: webserver.PMDynamic, maxChildren: 5}
+	s.db.QueryRow(`
+		SELECT COALESCE(max_php_memory, '256M'), COALESCE(max_php_upload, '64M'),
+		       COALESCE(max_php_execution_time, 300),
+		       COALESCE(php_pm, 'dynamic'), COALESCE(php_max_children, 5),
+		       COALESCE(php_request_timeout, 0), COALESCE(php_slowlog_timeout, 0),
+		       COALESCE(php_pool_per_domain, 0)
+		FROM packages WHERE id = ?
+	`, packageID).Scan(&php.memory, &php.upload, &php.execTime,
+		&php.pm, &php.maxChildren, &php.requestTimeout, &php.slowlogTimeout, &php.perDomain)
+	return php
+}
+
+// accountPHPLimits returns the PHP limits of an account's package
+func (s *Service) accountPHPLimits(userID int64) phpLimits {
+	var packageID int64
+	s.db.QueryRow("SELECT package_id FROM user_packages WHERE user_id = ?", userID).Scan(&packageID)
+	return s.packagePHPLimits(packageID)
+}
+
+// poolConfig returns the PHP-FPM config of a pool of the account
+func (php phpLimits) poolConfig(username, homeDir, phpVersion, pool string) webserver.PHPFPMConfig {
+	return webserver.PHPFPMConfig{
+		Username:         username,
+		HomeDir:          homeDir,
+		PHPVersion:       phpVersion,
+		Pool:             pool,
+		MemoryLimit:      php.memory,
+		UploadMaxSize:    php.upload,
+		MaxExecutionTime: php.execTime,
+		ProcessManager:   php.pm,
+		MaxChildren:      php.maxChildren,
+		RequestTimeout:   php.requestTimeout,
+		SlowlogTimeout:   php.slowlogTimeout,
+	}
+}
+
+// clampPHPSettings lowers the custom PHP settings of an account's domains
+// to the package limits and returns the domains that changed
+func (s *Service) clampPHPSettings(userID int64, php phpLimits) []string {
+	rows, err := s.db.Query(`
+		SELECT ps.domain_id, d.name, ps.memory_limit, ps.max_execution_time,
+		       ps.post_max_size, ps.upload_max_filesize
+		FROM php_settings ps JOIN domains d ON d.id = ps.domain_id
+		WHERE d.user_id = ?
+	`, userID)
+	if err != nil {
+		return nil
+	}
+	type setting struct {
+		domainID     int64
+		domain       string
+		memory, post string
+		upload       string
+		execTime     int
+	}
+	var settings []setting
+	for rows.Next() {
+		var st setting
+		if rows.Scan(&st.domainID, &st.domain, &st.memory, &st.execTime, &st.post, &st.upload) == nil {
+			settings = append(settings, st)
+		}
+	}
+	rows.Close()
+
+	clamped := []string{}
+	for _, st := range settings {
+		changed := false
+		if exceedsPHPSize(st.memory, php.memory) {
+			st.memory, changed = php.memory, true
+		}
+		if exceedsPHPSize(st.upload, php.upload) {
+			st.upload, changed = php.upload, true
+		}
+		if exceedsPHPSize(st.post, php.upload) {
+			st.post, changed = php.upload, true
+		}
+		if st.execTime > php.execTime {
+			st.execTime, changed = php.execTime, true
+		}
+		if !changed {
+			continue
+		}
+		s.db.Exec(`
+			UPDATE php_settings SET memory_limit = ?, max_execution_time = ?, post_max_size = ?,
+				upload_max_filesize = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE domain_id = ?
+		`, st.memory, st.execTime, st.post, st.upload, st.domainID)
+		clamped = append(clamped, st.domain)
+	}
+
+	// Limits set through the php.ini editor
+	rows, err = s.db.Query(`
+		SELECT i.domain_id, d.name, i.name, i.value
+		FROM php_ini_directives i JOIN domains d ON d.id = i.domain_id
+		WHERE d.user_id = ?
+	`, userID)
+	if err != nil {
+		return clamped
+	}
+	type directive struct {
+		domainID            int64
+		domain, name, value string
+	}
+	var directives []directive
+	for rows.Next() {
+		var d directive
+		if rows.Scan(&d.domainID, &d.domain, &d.name, &d.value) == nil {
+			directives = append(directives, d)
+		}
+	}
+	rows.Close()
+
+	for _, d := range directives {
+		limit, over := php.iniLimit(d.name, d.value)
+		if !over {
+			continue
+		}
+		s.db.Exec(`
+			UPDATE php_ini_directives SET value = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE domain_id = ? AND name = ?
+		`, limit, d.domainID, d.name)
+		seen := false
+		for _, name := range clamped {
+			seen = seen || name == d.domain
+		}
+		if !seen {
+			clamped = append(clamped, d.domain)
+		}
+	}
+	return clamped
+}
+
+// exceedsPHPSize reports whether a php.ini size is above a package limit.
+// A limit that is not a size (such as -1) is unlimited; a value that is not
+// one is treated as above any limit.
+func exceedsPHPSize(value, limit string) bool {
+	max, ok := webserver.ParseIniSize(limit)
+	if !ok {
+		return false
+	}
+	n, ok := webserver.ParseIniSize(value)
+	return !ok || n > max
+}
@@ -0,0 +1,102 @@
+package account
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asergenalkan/serverpanel/internal/database"
+)
+
+func TestMain(m *testing.M) {
+	// The panel config and simulated server files live below HOME
+	home, err := os.MkdirTemp("", "serverpanel-account")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// testService returns a service on a fresh panel database
+func testService(t *testing.T) (*Service, *database.DB) {
+	t.Helper()
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewService(db), db
+}
+
+// testAccount inserts an account with domains, on a package unless 0
+func testAccount(t *testing.T, db *database.DB, username string, packageID int64, domains ...string) int64 {
+	t.Helper()
+	res, err := db.Exec("INSERT INTO users (username, email, password, role) VALUES (?, ?, 'x', 'user')",
+		username, username+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	if packageID > 0 {
+		db.Exec("INSERT INTO user_packages (user_id, package_id) VALUES (?, ?)", id, packageID)
+	}
+	for _, d := range domains {
+		if _, err := db.Exec("INSERT INTO domains (user_id, name) VALUES (?, ?)", id, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+func TestChangePackageLimits(t *testing.T) {
+	s, db := testService(t)
+	insert := func(name string, disk, domains int) int64 {
+		res, err := db.Exec(`INSERT INTO packages (name, disk_quota, max_domains, max_databases, max_emails, max_ftp)
+			VALUES (?, ?, ?, 0, 0, 0)`, name, disk, domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := res.LastInsertId()
+		return id
+	}
+	small := insert("Small", 100, 1)
+	unlimited := insert("Unlimited", 0, 0)
+	two := insert("Two", 100, 2)
+
+	user := testAccount(t, db, "alice", small, "alice.test", "alice-shop.test")
+
+	tests := []struct {
+		name      string
+		packageID int64
+		errors    int
+	}{
+		{"unlimited package", unlimited, 0},
+		{"enough domains", two, 0},
+		{"too few domains", small, 1},
+	}
+	for _, tt := range tests {
+		ch, err := s.ChangePackage(user, tt.packageID, true)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(ch.LimitErrors) != tt.errors {
+			t.Errorf("%s: limit errors %q, want %d", tt.name, ch.LimitErrors, tt.errors)
+		}
+	}
+
+	// The move itself goes through for an unlimited package
+	if _, err := s.ChangePackage(user, unlimited, false); err != nil {
+		t.Fatalf("move to the unlimited package: %v", err)
+	}
+	var packageID int64
+	db.QueryRow("SELECT package_id FROM user_packages WHERE user_id = ?", user).Scan(&packageID)
+	if packageID != unlimited {
+		t.Errorf("account is on package %d, want %d", packageID, unlimited)
+	}
+	if _, err := s.ChangePackage(user, small, false); err != ErrUsageExceedsPackage {
+		t.Errorf("move to a too small package: %v, want ErrUsageExceedsPackage", err)
+	}
+}
@@ -0,0 +1,237 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/webserver"
+)
+
+var ErrPHPIniNotAllowed = errors.New("php.ini directive is not allowed on this server")
+
+// PHPIniSetting is a directive of the php.ini catalog with its value for a
+// domain
+type PHPIniSetting struct {
+	webserver.PHPIniDirective
+	Value   string `json:"value"`
+	Custom  bool   `json:"custom"`  // set through the php.ini editor
+	Allowed bool   `json:"allowed"` // customers may change it
+}
+
+// phpIniPolicy returns the admin's allow and deny lists of php.ini
+// directives; an empty allow list allows the whole catalog
+func (s *Service) phpIniPolicy() (allowed, denied map[string]bool) {
+	list := func(key string) map[string]bool {
+		var value string
+		s.db.QueryRow("SELECT value FROM server_settings WHERE key = ?", key).Scan(&value)
+		names := map[string]bool{}
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names[name] = true
+			}
+		}
+		return names
+	}
+	return list("php_ini_allowed"), list("php_ini_denied")
+}
+
+// PHPIniAllowed reports whether customers may change a directive
+func (s *Service) PHPIniAllowed(name string) bool {
+	allowed, denied := s.phpIniPolicy()
+	if denied[name] {
+		return false
+	}
+	return len(allowed) == 0 || allowed[name]
+}
+
+// phpSettingsValues returns the values a domain set through the PHP
+// settings form, nil when it has none
+func (s *Service) phpSettingsValues(domainID int64) map[string]string {
+	var memory, post, upload, errorReporting string
+	var execTime, inputTime, fileUploads int
+	var displayErrors bool
+	err := s.db.QueryRow(`
+		SELECT memory_limit, max_execution_time, max_input_time, post_max_size,
+		       upload_max_filesize, max_file_uploads, display_errors, error_reporting
+		FROM php_settings WHERE domain_id = ?
+	`, domainID).Scan(&memory, &execTime, &inputTime, &post, &upload, &fileUploads, &displayErrors, &errorReporting)
+	if err != nil {
+		return nil
+	}
+
+	display := "off"
+	if displayErrors {
+		display = "on"
+	}
+	return map[string]string{
+		"memory_limit":        memory,
+		"max_execution_time":  strconv.Itoa(execTime),
+		"max_input_time":      strconv.Itoa(inputTime),
+		"post_max_size":       post,
+		"upload_max_filesize": upload,
+		"max_file_uploads":    strconv.Itoa(fileUploads),
+		"display_errors":      display,
+		"error_reporting":     errorReporting,
+	}
+}
+
+// phpIniValues returns the directives a domain set through the php.ini
+// editor
+func (s *Service) phpIniValues(domainID int64) map[string]string {
+	values := map[string]string{}
+	rows, err := s.db.Query("SELECT name, value FROM php_ini_directives WHERE domain_id = ?", domainID)
+	if err != nil {
+		return values
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if rows.Scan(&name, &value) == nil {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// domainPHPIni returns the custom php.ini values of a domain, the php.ini
+// editor overriding the PHP settings form
+func (s *Service) domainPHPIni(domainID int64) map[string]string {
+	values := s.phpSettingsValues(domainID)
+	if values == nil {
+		values = map[string]string{}
+	}
+	for name, value := range s.phpIniValues(domainID) {
+		values[name] = value
+	}
+	return values
+}
+
+// GetPHPIni returns the php.ini catalog with the values of a domain
+func (s *Service) GetPHPIni(domainID int64) ([]PHPIniSetting, error) {
+	var exists int
+	if err := s.db.QueryRow("SELECT 1 FROM domains WHERE id = ?", domainID).Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	values := s.domainPHPIni(domainID)
+	custom := s.phpIniValues(domainID)
+	allowed, denied := s.phpIniPolicy()
+
+	settings := []PHPIniSetting{}
+	for _, d := range webserver.PHPIniCatalog() {
+		st := PHPIniSetting{
+			PHPIniDirective: d,
+			Value:           d.Default,
+			Allowed:         !denied[d.Name] && (len(allowed) == 0 || allowed[d.Name]),
+		}
+		if value, ok := values[d.Name]; ok {
+			st.Value = value
+		}
+		_, st.Custom = custom[d.Name]
+		settings = append(settings, st)
+	}
+	return settings, nil
+}
+
+// SetPHPIni sets php.ini directives of a domain and renders its PHP-FPM
+// pool; an empty value removes a directive. Customers are held to the
+// admin's allow and deny lists and the PHP limits of their package. The
+// previous directives are restored when the pool is rejected.
+func (s *Service) SetPHPIni(domainID int64, values map[string]string, admin bool) error {
+	var userID int64
+	var domain string
+	if err := s.db.QueryRow("SELECT user_id, name FROM domains WHERE id = ?", domainID).Scan(&userID, &domain); err != nil {
+		return err
+	}
+	php := s.accountPHPLimits(userID)
+
+	normalized := map[string]string{}
+	for name, value := range values {
+		if !admin && !s.PHPIniAllowed(name) {
+			return fmt.Errorf("%w: %s", ErrPHPIniNotAllowed, name)
+		}
+		if strings.TrimSpace(value) == "" {
+			normalized[name] = ""
+			continue
+		}
+		value, err := webserver.ValidatePHPIni(name, value)
+		if err != nil {
+			return err
+		}
+		if limit, over := php.iniLimit(name, value); over && !admin {
+			return fmt.Errorf("%w: %s exceeds the package limit of %s", webserver.ErrInvalidPHPIni, name, limit)
+		}
+		normalized[name] = value
+	}
+
+	previous := s.phpIniValues(domainID)
+	if err := s.savePHPIni(domainID, normalized); err != nil {
+		return err
+	}
+
+	if _, err := s.RebuildPHPPools(userID); err != nil {
+		// Put the previous directives back and render the pool again
+		restore := map[string]string{}
+		for name := range normalized {
+			restore[name] = previous[name]
+		}
+		if rerr := s.savePHPIni(domainID, restore); rerr == nil {
+			s.RebuildPHPPools(userID)
+		}
+		return err
+	}
+
+	log.Printf("⚙️ php.ini of %s updated: %d directives", domain, len(normalized))
+	return nil
+}
+
+// savePHPIni writes directives of a domain, deleting the empty ones
+func (s *Service) savePHPIni(domainID int64, values map[string]string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for name, value := range values {
+		if value == "" {
+			_, err = tx.Exec("DELETE FROM php_ini_directives WHERE domain_id = ? AND name = ?", domainID, name)
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO php_ini_directives (domain_id, name, value, updated_at)
+				VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+				ON CONFLICT(domain_id, name) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+			`, domainID, name, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ResetPHPIni removes directives of a domain from the php.ini editor, so
+// the values of the PHP settings form apply again
+func (s *Service) ResetPHPIni(domainID int64, names ...string) {
+	for _, name := range names {
+		s.db.Exec("DELETE FROM php_ini_directives WHERE domain_id = ? AND name = ?", domainID, name)
+	}
+}
+
+// iniLimit returns the package limit of a directive and whether value
+// exceeds it
+func (php phpLimits) iniLimit(name, value string) (string, bool) {
+	switch name {
+	case "memory_limit":
+		return php.memory, exceedsPHPSize(value, php.memory)
+	case "upload_max_filesize", "post_max_size":
+		return php.upload, exceedsPHPSize(value, php.upload)
+	case "max_execution_time":
+		n, _ := strconv.Atoi(value)
+		return strconv.Itoa(php.execTime), n > php.execTime
+	}
+	return "", false
+}
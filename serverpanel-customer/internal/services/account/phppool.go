@@ -0,0 +1,377 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/webserver"
+)
+
+var ErrPHPVersionNotInstalled = errors.New("PHP version is not installed")
+
+// poolSocketTimeout is how long a new pool has to come up before a switch
+// of PHP version is given up
+const poolSocketTimeout = 10 * time.Second
+
+// PHPPool is a PHP-FPM pool of an account and the domains it serves
+type PHPPool struct {
+	Name           string                `json:"name"`
+	PHPVersion     string                `json:"php_version"`
+	Domains        []string              `json:"domains"`
+	ProcessManager string                `json:"process_manager"`
+	MaxChildren    int                   `json:"max_children"`
+	RequestTimeout int                   `json:"request_timeout"`
+	SlowlogTimeout int                   `json:"slowlog_timeout"`
+	Status         *webserver.PoolStatus `json:"status,omitempty"`
+	StatusError    string                `json:"status_error,omitempty"`
+}
+
+// PoolName returns the PHP-FPM pool serving a domain: the account's pool,
+// or one of the domain's own on pool-per-domain packages so a busy addon
+// domain cannot starve the others
+func PoolName(username, domain string, perDomain bool) string {
+	if perDomain && domain != "" {
+		return username + "-" + domain
+	}
+	return username
+}
+
+// phpManager returns the PHP-FPM manager of a PHP version
+func (s *Service) phpManager(version string) *webserver.PHPFPMManager {
+	return webserver.NewPHPFPMManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath, version)
+}
+
+// phpVersions returns the PHP versions that have a pool directory; pools of
+// an account may be left in any of them
+func (s *Service) phpVersions() []string {
+	pattern := "/etc/php/*/fpm/pool.d"
+	if s.cfg.SimulateMode {
+		pattern = filepath.Join(s.cfg.SimulateBasePath, "php-fpm", "*", "pool.d")
+	}
+	dirs, _ := filepath.Glob(pattern)
+	versions := []string{}
+	for _, dir := range dirs {
+		version := filepath.Base(filepath.Dir(dir))
+		if !s.cfg.SimulateMode {
+			version = filepath.Base(filepath.Dir(filepath.Dir(dir)))
+		}
+		versions = append(versions, version)
+	}
+	if len(versions) == 0 {
+		versions = append(versions, s.cfg.PHPVersion)
+	}
+	return versions
+}
+
+// accountPools returns the pools an account should have: one per PHP
+// version its domains use, or one per domain
+func (s *Service) accountPools(userID int64, username string, php phpLimits) ([]PHPPool, error) {
+	rows, err := s.db.Query("SELECT name, COALESCE(php_version, '') FROM domains WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pools := []PHPPool{}
+	index := map[string]int{}
+	for rows.Next() {
+		var domain, version string
+		if rows.Scan(&domain, &version) != nil {
+			continue
+		}
+		if version == "" {
+			version = s.cfg.PHPVersion
+		}
+		name := PoolName(username, domain, php.perDomain)
+		key := name + "@" + version
+		if i, ok := index[key]; ok {
+			pools[i].Domains = append(pools[i].Domains, domain)
+			continue
+		}
+		index[key] = len(pools)
+		pools = append(pools, PHPPool{Name: name, PHPVersion: version, Domains: []string{domain}})
+	}
+	if len(pools) == 0 {
+		pools = append(pools, PHPPool{Name: username, PHPVersion: s.cfg.PHPVersion, Domains: []string{}})
+	}
+
+	for i := range pools {
+		pools[i].ProcessManager = php.pm
+		pools[i].MaxChildren = php.maxChildren
+		pools[i].RequestTimeout = php.requestTimeout
+		pools[i].SlowlogTimeout = php.slowlogTimeout
+	}
+	return pools, nil
+}
+
+// stagePool adds a pool of an account to a transaction. The custom PHP
+// settings and php.ini directives of the pool's domains go into it, the
+// last changed domain winning when a pool serves several domains.
+func (s *Service) stagePool(tx *webserver.Transaction, username string, pool PHPPool, php phpLimits) {
+	config := php.poolConfig(username, filepath.Join(s.cfg.HomeBaseDir, username), pool.PHPVersion, pool.Name)
+
+	if len(pool.Domains) > 0 {
+		args := make([]interface{}, len(pool.Domains))
+		for i, domain := range pool.Domains {
+			args[i] = domain
+		}
+		var domainID int64
+		err := s.db.QueryRow(`
+			SELECT changed.domain_id FROM (
+				SELECT domain_id, updated_at FROM php_settings
+				UNION ALL
+				SELECT domain_id, updated_at FROM php_ini_directives
+			) changed JOIN domains d ON d.id = changed.domain_id
+			WHERE d.name IN (?`+strings.Repeat(", ?", len(args)-1)+`)
+			ORDER BY changed.updated_at DESC LIMIT 1
+		`, args...).Scan(&domainID)
+		if err == nil {
+			config.IniValues = s.domainPHPIni(domainID)
+		}
+	}
+
+	s.phpManager(pool.PHPVersion).StagePool(tx, config)
+}
+
+// RebuildPHPPools renders the PHP-FPM pools of an account from its
+// package and PHP settings, points its vhosts at new pools and removes the
+// pools it no longer uses. The names of the pools are returned.
+func (s *Service) RebuildPHPPools(userID int64) ([]string, error) {
+	var username string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		return nil, err
+	}
+	php := s.accountPHPLimits(userID)
+	pools, err := s.accountPools(userID, username, php)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	created := false
+	tx := webserver.NewTransaction()
+	for _, pool := range pools {
+		created = created || !s.phpManager(pool.PHPVersion).HasPool(pool.Name)
+		s.stagePool(tx, username, pool, php)
+		names = append(names, pool.Name)
+	}
+	if err := tx.Commit(); err != nil {
+		return names, err
+	}
+
+	// The vhosts of a suspended account are restored as they were, so
+	// their pools stay until the account is unsuspended and rebuilt
+	if s.IsSuspended(userID) {
+		return names, nil
+	}
+
+	// New pools (pool-per-domain switched on or off) get their vhosts
+	if created {
+		vhosts, _ := s.vhostNames(userID)
+		for _, name := range vhosts {
+			if err := s.RebuildVhost(name); err != nil {
+				log.Printf("⚠️ Rebuilding vhost %s: %v", name, err)
+			}
+		}
+	}
+
+	if err := s.PrunePHPPools(userID); err != nil {
+		return names, err
+	}
+	log.Printf("✅ PHP-FPM pools of %s rebuilt: %v", username, names)
+	return names, nil
+}
+
+// PrunePHPPools removes the pools of an account none of its domains uses
+// any more, e.g. after a domain is deleted or the package switches between
+// per-account and per-domain pools
+func (s *Service) PrunePHPPools(userID int64) error {
+	var username string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		return err
+	}
+	pools, err := s.accountPools(userID, username, s.accountPHPLimits(userID))
+	if err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	for _, pool := range pools {
+		wanted[pool.Name+"@"+pool.PHPVersion] = true
+	}
+
+	for _, version := range s.phpVersions() {
+		manager := s.phpManager(version)
+		tx := webserver.NewTransaction()
+		stale := 0
+		for _, name := range manager.UserPools(username) {
+			if !wanted[name+"@"+version] {
+				manager.StageRemovePool(tx, name)
+				stale++
+			}
+		}
+		if stale == 0 {
+			continue
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to remove unused PHP %s pools: %w", version, err)
+		}
+		log.Printf("🗑️ %d unused PHP %s pool(s) of %s removed", stale, version, username)
+	}
+	return nil
+}
+
+// GetPHPPools returns the pools of an account with their live status
+func (s *Service) GetPHPPools(userID int64) ([]PHPPool, error) {
+	var username string
+	if err := s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username); err != nil {
+		return nil, err
+	}
+	pools, err := s.accountPools(userID, username, s.accountPHPLimits(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pools {
+		status, err := s.phpManager(pools[i].PHPVersion).PoolStatus(pools[i].Name)
+		if err != nil {
+			pools[i].StatusError = err.Error()
+			continue
+		}
+		pools[i].Status = status
+	}
+	return pools, nil
+}
+
+// RebuildPackagePHPPools renders the pools of every account on a package
+// again, e.g. after its PHP limits or process settings changed
+func (s *Service) RebuildPackagePHPPools(packageID int64) {
+	rows, err := s.db.Query("SELECT user_id FROM user_packages WHERE package_id = ?", packageID)
+	if err != nil {
+		return
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			userIDs = append(userIDs, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range userIDs {
+		if _, err := s.RebuildPHPPools(id); err != nil {
+			log.Printf("⚠️ PHP-FPM pools of account %d: %v", id, err)
+		}
+	}
+}
+
+// SwitchPHPVersion moves a domain and its subdomains to another PHP
+// version. The pool is created under the new version and its socket must
+// be up before the vhosts switch to it; the pool of the old version is
+// removed once no domain of the account uses it any more. When a step
+// fails the domain stays on the old version.
+func (s *Service) SwitchPHPVersion(domain, version string) error {
+	var domainID, userID int64
+	var username, oldVersion string
+	err := s.db.QueryRow(`
+		SELECT d.id, d.user_id, u.username, COALESCE(d.php_version, '')
+		FROM domains d JOIN users u ON u.id = d.user_id
+		WHERE d.name = ?
+	`, domain).Scan(&domainID, &userID, &username, &oldVersion)
+	if err == sql.ErrNoRows {
+		return ErrVhostNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if oldVersion == "" {
+		oldVersion = s.cfg.PHPVersion
+	}
+	if oldVersion == version {
+		return nil
+	}
+	if s.IsSuspended(userID) {
+		return ErrAccountSuspended
+	}
+
+	manager := s.phpManager(version)
+	if !manager.Installed() {
+		return ErrPHPVersionNotInstalled
+	}
+
+	if _, err := s.db.Exec("UPDATE domains SET php_version = ? WHERE id = ?", version, domainID); err != nil {
+		return err
+	}
+	php := s.accountPHPLimits(userID)
+	poolName := PoolName(username, domain, php.perDomain)
+	created := !manager.HasPool(poolName)
+
+	// Puts the domain back on the old version and drops a pool created
+	// for the switch
+	revert := func() {
+		s.db.Exec("UPDATE domains SET php_version = ? WHERE id = ?", oldVersion, domainID)
+		if created {
+			tx := webserver.NewTransaction()
+			manager.StageRemovePool(tx, poolName)
+			if err := tx.Commit(); err != nil {
+				log.Printf("⚠️ Removing PHP %s pool %s: %v", version, poolName, err)
+			}
+		}
+	}
+
+	pools, err := s.accountPools(userID, username, php)
+	if err != nil {
+		revert()
+		return err
+	}
+	tx := webserver.NewTransaction()
+	for _, pool := range pools {
+		if pool.Name == poolName && pool.PHPVersion == version {
+			s.stagePool(tx, username, pool, php)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		revert()
+		return err
+	}
+	if err := manager.WaitForSocket(poolName, poolSocketTimeout); err != nil {
+		revert()
+		return err
+	}
+
+	// The subdomains run on the PHP version of their domain
+	names := []string{domain}
+	rows, err := s.db.Query("SELECT full_name FROM subdomains WHERE domain_id = ? AND COALESCE(redirect_url, '') = ''", domainID)
+	if err == nil {
+		for rows.Next() {
+			var name string
+			if rows.Scan(&name) == nil {
+				names = append(names, name)
+			}
+		}
+		rows.Close()
+	}
+
+	for i, name := range names {
+		if err := s.RebuildVhost(name); err != nil {
+			revert()
+			for _, switched := range names[:i] {
+				if err := s.RebuildVhost(switched); err != nil {
+					log.Printf("⚠️ Restoring vhost %s: %v", switched, err)
+				}
+			}
+			return err
+		}
+	}
+
+	if err := s.PrunePHPPools(userID); err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+	log.Printf("✅ %s switched from PHP %s to %s", domain, oldVersion, version)
+	return nil
+}
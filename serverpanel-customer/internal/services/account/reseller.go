@@ -0,0 +1,413 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrNotReseller         = errors.New("user is not a reseller")
+	ErrResellerPool        = errors.New("reseller pool exceeded")
+	ErrResellerHasPackages = errors.New("reseller still owns packages")
+	ErrPackageInUse        = errors.New("package is in use")
+)
+
+// descendantsCTE selects the ids of every user below a reseller (direct
+// customers and the customers of sub-resellers)
+const descendantsCTE = `
+	WITH RECURSIVE descendants(id) AS (
+		SELECT id FROM users WHERE parent_id = ?
+		UNION
+		SELECT u.id FROM users u JOIN descendants d ON u.parent_id = d.id
+	)`
+
+// ResellerLimits are the resource pool an admin allocates to a reseller.
+// The reseller's packages are carved out of it: the limits of all the
+// accounts below the reseller added up must stay within the pool. Disk
+// and bandwidth are in MB; 0 means unlimited.
+type ResellerLimits struct {
+	UserID       int64 `json:"user_id"`
+	MaxAccounts  int   `json:"max_accounts"`
+	MaxDiskQuota int64 `json:"max_disk_quota"`
+	MaxBandwidth int64 `json:"max_bandwidth"`
+	MaxDomains   int   `json:"max_domains"`
+	MaxDatabases int   `json:"max_databases"`
+	MaxEmails    int   `json:"max_emails"`
+	MaxFTP       int   `json:"max_ftp"`
+}
+
+// PackageLimits are the pooled limits of a package
+type PackageLimits struct {
+	DiskQuota      int64 `json:"disk_quota"`
+	BandwidthQuota int64 `json:"bandwidth_quota"`
+	MaxDomains     int   `json:"max_domains"`
+	MaxDatabases   int   `json:"max_databases"`
+	MaxEmails      int   `json:"max_emails"`
+	MaxFTP         int   `json:"max_ftp"`
+}
+
+// Scale returns the limits multiplied by n accounts
+func (l PackageLimits) Scale(n int) PackageLimits {
+	return PackageLimits{
+		DiskQuota:      l.DiskQuota * int64(n),
+		BandwidthQuota: l.BandwidthQuota * int64(n),
+		MaxDomains:     l.MaxDomains * n,
+		MaxDatabases:   l.MaxDatabases * n,
+		MaxEmails:      l.MaxEmails * n,
+		MaxFTP:         l.MaxFTP * n,
+	}
+}
+
+// Sub returns l - o
+func (l PackageLimits) Sub(o PackageLimits) PackageLimits {
+	return PackageLimits{
+		DiskQuota:      l.DiskQuota - o.DiskQuota,
+		BandwidthQuota: l.BandwidthQuota - o.BandwidthQuota,
+		MaxDomains:     l.MaxDomains - o.MaxDomains,
+		MaxDatabases:   l.MaxDatabases - o.MaxDatabases,
+		MaxEmails:      l.MaxEmails - o.MaxEmails,
+		MaxFTP:         l.MaxFTP - o.MaxFTP,
+	}
+}
+
+// ResellerUsage is what the accounts below a reseller take of its pool
+type ResellerUsage struct {
+	ResellerLimits
+	Username string `json:"username"`
+	Packages int    `json:"packages"`
+	Accounts int    `json:"accounts"`
+	// Allocated is the sum of the package limits of the accounts
+	Allocated PackageLimits `json:"allocated"`
+	// Utilization is the allocated share of each limited resource (%)
+	Utilization map[string]float64 `json:"utilization"`
+}
+
+// GetPackageLimits returns the pooled limits of a package
+func (s *Service) GetPackageLimits(packageID int64) (*PackageLimits, error) {
+	var l PackageLimits
+	err := s.db.QueryRow(`
+		SELECT disk_quota, bandwidth_quota, max_domains, max_databases, max_emails, max_ftp
+		FROM packages WHERE id = ?
+	`, packageID).Scan(&l.DiskQuota, &l.BandwidthQuota, &l.MaxDomains, &l.MaxDatabases, &l.MaxEmails, &l.MaxFTP)
+	if err == sql.ErrNoRows {
+		return nil, ErrPackageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// IsDescendant reports whether userID belongs to the reseller's tree
+func (s *Service) IsDescendant(resellerID, userID int64) bool {
+	var count int
+	s.db.QueryRow(descendantsCTE+`
+		SELECT COUNT(*) FROM descendants WHERE id = ?
+	`, resellerID, userID).Scan(&count)
+	return count > 0
+}
+
+// DescendantIDs returns the ids of every user below a reseller
+func (s *Service) DescendantIDs(resellerID int64) ([]int64, error) {
+	rows, err := s.db.Query(descendantsCTE+`SELECT id FROM descendants`, resellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// GetResellerLimits returns the pool of a reseller (unlimited if none
+// was set)
+func (s *Service) GetResellerLimits(resellerID int64) (*ResellerLimits, error) {
+	var role string
+	if err := s.db.QueryRow("SELECT role FROM users WHERE id = ?", resellerID).Scan(&role); err != nil {
+		return nil, err
+	}
+	if role != "reseller" {
+		return nil, ErrNotReseller
+	}
+
+	limits := &ResellerLimits{UserID: resellerID}
+	err := s.db.QueryRow(`
+		SELECT COALESCE(max_accounts, 0), COALESCE(max_disk_quota, 0), COALESCE(max_bandwidth, 0),
+			COALESCE(max_domains, 0), COALESCE(max_databases, 0), COALESCE(max_emails, 0),
+			COALESCE(max_ftp, 0)
+		FROM reseller_limits WHERE user_id = ?
+	`, resellerID).Scan(&limits.MaxAccounts, &limits.MaxDiskQuota, &limits.MaxBandwidth,
+		&limits.MaxDomains, &limits.MaxDatabases, &limits.MaxEmails, &limits.MaxFTP)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// SetResellerLimits stores the pool of a reseller. A pool can not be made
+// smaller than what is already allocated from it.
+func (s *Service) SetResellerLimits(limits ResellerLimits) error {
+	if limits.MaxAccounts < 0 || limits.MaxDiskQuota < 0 || limits.MaxBandwidth < 0 ||
+		limits.MaxDomains < 0 || limits.MaxDatabases < 0 || limits.MaxEmails < 0 || limits.MaxFTP < 0 {
+		return fmt.Errorf("limits can not be negative")
+	}
+	usage, err := s.GetResellerUsage(limits.UserID)
+	if err != nil {
+		return err
+	}
+	usage.ResellerLimits = limits
+	if err := usage.check(0, PackageLimits{}); err != nil {
+		return fmt.Errorf("pool is smaller than what is allocated: %w", err)
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO reseller_limits (user_id, max_accounts, max_disk_quota, max_bandwidth,
+			max_domains, max_databases, max_emails, max_ftp)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET max_accounts = excluded.max_accounts,
+			max_disk_quota = excluded.max_disk_quota, max_bandwidth = excluded.max_bandwidth,
+			max_domains = excluded.max_domains, max_databases = excluded.max_databases,
+			max_emails = excluded.max_emails, max_ftp = excluded.max_ftp,
+			updated_at = CURRENT_TIMESTAMP
+	`, limits.UserID, limits.MaxAccounts, limits.MaxDiskQuota, limits.MaxBandwidth,
+		limits.MaxDomains, limits.MaxDatabases, limits.MaxEmails, limits.MaxFTP)
+	return err
+}
+
+// GetResellerUsage returns the pool of a reseller with what is allocated
+// from it
+func (s *Service) GetResellerUsage(resellerID int64) (*ResellerUsage, error) {
+	limits, err := s.GetResellerLimits(resellerID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &ResellerUsage{ResellerLimits: *limits}
+	s.db.QueryRow("SELECT username FROM users WHERE id = ?", resellerID).Scan(&usage.Username)
+	s.db.QueryRow("SELECT COUNT(*) FROM packages WHERE owner_id = ?", resellerID).Scan(&usage.Packages)
+
+	a := &usage.Allocated
+	err = s.db.QueryRow(descendantsCTE+`
+		SELECT COUNT(*), COALESCE(SUM(p.disk_quota), 0), COALESCE(SUM(p.bandwidth_quota), 0),
+			COALESCE(SUM(p.max_domains), 0), COALESCE(SUM(p.max_databases), 0),
+			COALESCE(SUM(p.max_emails), 0), COALESCE(SUM(p.max_ftp), 0)
+		FROM users u
+		JOIN descendants d ON d.id = u.id
+		LEFT JOIN user_packages up ON up.user_id = u.id
+		LEFT JOIN packages p ON p.id = up.package_id
+		WHERE u.role = 'user'
+	`, resellerID).Scan(&usage.Accounts, &a.DiskQuota, &a.BandwidthQuota,
+		&a.MaxDomains, &a.MaxDatabases, &a.MaxEmails, &a.MaxFTP)
+	if err != nil {
+		return nil, err
+	}
+
+	usage.Utilization = map[string]float64{}
+	percent := func(name string, used, max int64) {
+		if max > 0 {
+			usage.Utilization[name] = math.Round(float64(used)*1000/float64(max)) / 10
+		}
+	}
+	percent("accounts", int64(usage.Accounts), int64(usage.MaxAccounts))
+	percent("disk", a.DiskQuota, usage.MaxDiskQuota)
+	percent("bandwidth", a.BandwidthQuota, usage.MaxBandwidth)
+	percent("domains", int64(a.MaxDomains), int64(usage.MaxDomains))
+	percent("databases", int64(a.MaxDatabases), int64(usage.MaxDatabases))
+	percent("emails", int64(a.MaxEmails), int64(usage.MaxEmails))
+	percent("ftp", int64(a.MaxFTP), int64(usage.MaxFTP))
+	return usage, nil
+}
+
+// check returns an error if adding accounts and limits would overflow the
+// pool
+func (u *ResellerUsage) check(addAccounts int, add PackageLimits) error {
+	over := func(name string, used, added, max int64) error {
+		// Giving capacity back is always allowed
+		if max > 0 && added >= 0 && used+added > max {
+			return fmt.Errorf("%w: %s (%s: %d of %d allocated, %d more requested)",
+				ErrResellerPool, name, u.Username, used, max, added)
+		}
+		return nil
+	}
+
+	a := u.Allocated
+	checks := []error{
+		over("accounts", int64(u.Accounts), int64(addAccounts), int64(u.MaxAccounts)),
+		over("disk (MB)", a.DiskQuota, add.DiskQuota, u.MaxDiskQuota),
+		over("bandwidth (MB)", a.BandwidthQuota, add.BandwidthQuota, u.MaxBandwidth),
+		over("domains", int64(a.MaxDomains), int64(add.MaxDomains), int64(u.MaxDomains)),
+		over("databases", int64(a.MaxDatabases), int64(add.MaxDatabases), int64(u.MaxDatabases)),
+		over("email accounts", int64(a.MaxEmails), int64(add.MaxEmails), int64(u.MaxEmails)),
+		over("FTP accounts", int64(a.MaxFTP), int64(add.MaxFTP), int64(u.MaxFTP)),
+	}
+	for _, err := range checks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListResellers returns every reseller with its pool utilization
+func (s *Service) ListResellers() ([]ResellerUsage, error) {
+	rows, err := s.db.Query("SELECT id FROM users WHERE role = 'reseller' ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	resellers := []ResellerUsage{}
+	for _, id := range ids {
+		usage, err := s.GetResellerUsage(id)
+		if err != nil {
+			return nil, err
+		}
+		resellers = append(resellers, *usage)
+	}
+	return resellers, nil
+}
+
+// CheckResellerCapacity checks that a reseller, and every reseller above
+// it, can take addAccounts more accounts and add more allocated limits
+// (negative values free capacity)
+func (s *Service) CheckResellerCapacity(resellerID int64, addAccounts int, add PackageLimits) error {
+	seen := map[int64]bool{}
+	for id := resellerID; id > 0 && !seen[id]; {
+		seen[id] = true
+		usage, err := s.GetResellerUsage(id)
+		if err == ErrNotReseller {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := usage.check(addAccounts, add); err != nil {
+			return err
+		}
+
+		var parent sql.NullInt64
+		s.db.QueryRow("SELECT parent_id FROM users WHERE id = ?", id).Scan(&parent)
+		id = parent.Int64
+	}
+	return nil
+}
+
+// CheckPackageFitsPool checks that one account on a package fits in the
+// reseller's pool on its own, so the package can ever be assigned
+func (s *Service) CheckPackageFitsPool(resellerID int64, limits PackageLimits) error {
+	pool, err := s.GetResellerLimits(resellerID)
+	if err != nil {
+		return err
+	}
+	empty := &ResellerUsage{ResellerLimits: *pool}
+	s.db.QueryRow("SELECT username FROM users WHERE id = ?", resellerID).Scan(&empty.Username)
+	return empty.check(0, limits)
+}
+
+// CheckPackageChange checks that changing the limits of a package keeps
+// the pools of the resellers whose accounts use it within bounds
+func (s *Service) CheckPackageChange(packageID int64, limits PackageLimits) error {
+	old, err := s.GetPackageLimits(packageID)
+	if err != nil {
+		return err
+	}
+	delta := limits.Sub(*old)
+
+	rows, err := s.db.Query(`
+		SELECT COALESCE(u.parent_id, 0), COUNT(*)
+		FROM user_packages up JOIN users u ON u.id = up.user_id
+		WHERE up.package_id = ? AND u.parent_id IS NOT NULL
+		GROUP BY u.parent_id
+	`, packageID)
+	if err != nil {
+		return err
+	}
+	counts := map[int64]int{}
+	for rows.Next() {
+		var parent int64
+		var n int
+		if rows.Scan(&parent, &n) == nil {
+			counts[parent] = n
+		}
+	}
+	rows.Close()
+
+	for parent, n := range counts {
+		if err := s.CheckResellerCapacity(parent, 0, delta.Scale(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckResellerDelete refuses to delete a reseller that owns packages.
+// Its packages would otherwise lose their owner and turn into admin
+// packages; they have to be deleted or given to another reseller first.
+func (s *Service) CheckResellerDelete(userID int64) error {
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM packages WHERE owner_id = ?", userID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: delete its %d packages or move them to another reseller first", ErrResellerHasPackages, count)
+	}
+	return nil
+}
+
+// CheckPackageOwnerChange checks moving a package from one owner to
+// another (0 = admin). Like deleting a reseller, the old owner may not
+// lose a package its accounts are on; the accounts of other owners on the
+// package are drawn from the new owner's pool.
+func (s *Service) CheckPackageOwnerChange(packageID, from, to int64, limits PackageLimits) error {
+	if from == to {
+		return nil
+	}
+	if from > 0 {
+		var n int
+		if err := s.db.QueryRow(descendantsCTE+`
+			SELECT COUNT(*) FROM user_packages
+			WHERE package_id = ? AND user_id IN (SELECT id FROM descendants)
+		`, from, packageID).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return fmt.Errorf("%w: %d accounts of the current owner are on it, move them to another package first", ErrPackageInUse, n)
+		}
+	}
+	if to > 0 {
+		var n int
+		if err := s.db.QueryRow(descendantsCTE+`
+			SELECT COUNT(*) FROM user_packages
+			WHERE package_id = ? AND user_id NOT IN (SELECT id FROM descendants)
+		`, to, packageID).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			return s.CheckResellerCapacity(to, n, limits.Scale(n))
+		}
+	}
+	return nil
+}
+
+// CanUsePackage reports whether a reseller may assign a package: its own
+// packages only. Admin packages (owner_id NULL) are open to the admin.
+func (s *Service) CanUsePackage(resellerID, packageID int64) bool {
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM packages WHERE id = ? AND owner_id = ?", packageID, resellerID).Scan(&count)
+	return count > 0
+}
@@ -0,0 +1,699 @@
+package account
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/webserver"
+)
+
+var (
+	ErrAccountNotFound  = errors.New("account not found")
+	ErrAlreadySuspended = errors.New("account is already suspended")
+	ErrNotSuspended     = errors.New("account is not suspended")
+)
+
+// SuspendedCronPrefix comments out the crontab lines of a suspended account
+const SuspendedCronPrefix = "#SUSPENDED# "
+
+const (
+	dovecotUsersFile  = "/etc/dovecot/users"
+	postfixMailboxes  = "/etc/postfix/vmailbox"
+	postfixAliases    = "/etc/postfix/virtual"
+	pureftpdPasswd    = "/etc/pure-ftpd/pureftpd.passwd"
+	crontabDir        = "/var/spool/cron/crontabs"
+	suspendedPageRoot = "/var/www/suspended"
+)
+
+// Suspension is the suspension record of an account
+type Suspension struct {
+	UserID      int64  `json:"user_id"`
+	Reason      string `json:"reason"`
+	SuspendedBy int64  `json:"suspended_by,omitempty"`
+	SuspendedAt string `json:"suspended_at"`
+}
+
+// suspensionState records everything a suspension changed so
+// UnsuspendAccount can put it back exactly
+type suspensionState struct {
+	Vhosts   []suspendedVhost    `json:"vhosts"`
+	SSLSites []string            `json:"ssl_sites,omitempty"` // <name>-ssl sites that were disabled
+	Lines    map[string][]string `json:"lines,omitempty"`     // removed lines per mail/FTP file
+	Crontab  bool                `json:"crontab"`
+	// System user: whether the suspension locked the password and the
+	// account expiry (shadow field, days) it replaced
+	PasswordLocked bool   `json:"password_locked"`
+	UserExpired    bool   `json:"user_expired"`
+	Expire         string `json:"expire,omitempty"`
+}
+
+// suspendedVhost is the original config of a vhost that was swapped for
+// the suspended page
+type suspendedVhost struct {
+	Name    string `json:"name"`
+	Config  string `json:"config"`
+	Enabled bool   `json:"enabled"`
+}
+
+// lineFile is a mail or FTP file with one line per entry, keyed by prefix
+type lineFile struct {
+	path    string
+	keys    []string
+	rebuild [][]string // commands that apply a change of the file
+}
+
+var (
+	// Apache, Nginx and Caddy (tls <cert> <key>) certificate lines
+	sslCertLine = regexp.MustCompile(`(?m)^\s*(?:SSLCertificateFile|ssl_certificate|tls)\s+([^\s;]+)`)
+	sslKeyLine  = regexp.MustCompile(`(?m)^\s*(?:SSLCertificateKeyFile|ssl_certificate_key|tls\s+\S+)\s+([^\s;]+)`)
+	cronEnvLine = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\s*=`)
+)
+
+// systemPath maps a system file to the simulation tree in simulate mode
+// (/etc/dovecot/users → <base>/dovecot/users)
+func (s *Service) systemPath(path string) string {
+	if !s.cfg.SimulateMode {
+		return path
+	}
+	path = strings.TrimPrefix(path, "/etc")
+	path = strings.TrimPrefix(path, "/var/spool")
+	path = strings.TrimPrefix(path, "/var")
+	return filepath.Join(s.cfg.SimulateBasePath, path)
+}
+
+// run executes a system command, or logs it in simulate mode
+func (s *Service) run(name string, args ...string) error {
+	if s.cfg.SimulateMode {
+		log.Printf("🔧 [SIMÜLASYON] %s %s", name, strings.Join(args, " "))
+		return nil
+	}
+	if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %s - %w", name, strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// webDriver returns the driver of the configured web server
+func (s *Service) webDriver() webserver.Driver {
+	return webserver.NewDriver(webserver.DriverType(s.cfg.WebServer), s.cfg.SimulateMode, s.cfg.SimulateBasePath)
+}
+
+// GetSuspension returns the suspension record of an account
+func (s *Service) GetSuspension(userID int64) (*Suspension, error) {
+	sp := &Suspension{UserID: userID}
+	err := s.db.QueryRow(`
+		SELECT reason, COALESCE(suspended_by, 0), COALESCE(suspended_at, '')
+		FROM account_suspensions WHERE user_id = ?
+	`, userID).Scan(&sp.Reason, &sp.SuspendedBy, &sp.SuspendedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotSuspended
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// IsSuspended reports whether an account is suspended
+func (s *Service) IsSuspended(userID int64) bool {
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM account_suspensions WHERE user_id = ?", userID).Scan(&count)
+	return count > 0
+}
+
+// SuspendAccount disables an account: its vhosts serve the suspended page,
+// its mailboxes, forwarders and FTP users are removed from the mail and
+// FTP servers, its crontab is commented out and its system user is
+// locked. Everything changed is stored for UnsuspendAccount.
+func (s *Service) SuspendAccount(userID int64, reason string, suspendedBy int64) error {
+	var username string
+	err := s.db.QueryRow("SELECT username FROM users WHERE id = ? AND role = 'user'", userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return ErrAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if s.IsSuspended(userID) {
+		return ErrAlreadySuspended
+	}
+
+	files, err := s.lineFiles(userID)
+	if err != nil {
+		return err
+	}
+
+	state := suspensionState{Lines: map[string][]string{}}
+
+	if err := s.suspendVhosts(userID, username, &state); err != nil {
+		log.Printf("⚠️ Suspending vhosts of %s: %v", username, err)
+	}
+
+	for _, f := range files {
+		removed, err := removeLines(s.systemPath(f.path), f.keys)
+		if err != nil {
+			log.Printf("⚠️ Suspending %s entries of %s: %v", f.path, username, err)
+			continue
+		}
+		if len(removed) > 0 {
+			state.Lines[f.path] = removed
+			s.rebuild(f)
+		}
+	}
+	if len(state.Lines) > 0 {
+		s.run("postfix", "reload")
+		for _, line := range state.Lines[dovecotUsersFile] {
+			// Drop open IMAP/POP3 sessions
+			s.run("doveadm", "kick", strings.SplitN(line, ":", 2)[0])
+		}
+	}
+
+	if state.Crontab, err = s.commentCrontab(username); err != nil {
+		log.Printf("⚠️ Suspending crontab of %s: %v", username, err)
+	}
+
+	if err := s.lockSystemUser(username, &state); err != nil {
+		log.Printf("⚠️ Locking system user %s: %v", username, err)
+	}
+
+	// Without the stored state UnsuspendAccount could not undo the
+	// changes, so they are undone right away
+	if err := s.saveSuspension(userID, reason, suspendedBy, state); err != nil {
+		log.Printf("↩️ Suspension of %s could not be recorded, undoing it: %v", username, err)
+		s.restoreSuspended(username, state, files)
+		return err
+	}
+
+	log.Printf("⛔ Account suspended: %s (%s)", username, reason)
+	return nil
+}
+
+// saveSuspension records a suspension and deactivates the account
+func (s *Service) saveSuspension(userID int64, reason string, suspendedBy int64, state suspensionState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO account_suspensions (user_id, reason, suspended_by, state)
+		VALUES (?, ?, ?, ?)
+	`, userID, reason, suspendedBy, string(stateJSON)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET active = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnsuspendAccount reverses SuspendAccount from the stored state. Entries
+// deleted while the account was suspended are not brought back.
+func (s *Service) UnsuspendAccount(userID int64) error {
+	var username string
+	err := s.db.QueryRow("SELECT username FROM users WHERE id = ? AND role = 'user'", userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return ErrAccountNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var stateJSON string
+	err = s.db.QueryRow("SELECT state FROM account_suspensions WHERE user_id = ?", userID).Scan(&stateJSON)
+	if err == sql.ErrNoRows {
+		// Suspended before suspensions were recorded: only the flag was set
+		_, err = s.db.Exec("UPDATE users SET active = 1 WHERE id = ?", userID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	var state suspensionState
+	if err := json.Unmarshal([]byte(stateJSON), &state); err != nil {
+		return fmt.Errorf("invalid suspension state: %w", err)
+	}
+
+	files, err := s.lineFiles(userID)
+	if err != nil {
+		return err
+	}
+	s.restoreSuspended(username, state, files)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM account_suspensions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET active = 1 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("✅ Account unsuspended: %s", username)
+	return nil
+}
+
+// restoreSuspended puts back what a suspension changed on the system:
+// vhosts, mail and FTP entries that still belong to the account, the
+// crontab and the system user
+func (s *Service) restoreSuspended(username string, state suspensionState, files []lineFile) {
+	if err := s.restoreVhosts(state); err != nil {
+		log.Printf("⚠️ Restoring vhosts of %s: %v", username, err)
+	}
+
+	restored := false
+	for _, f := range files {
+		lines := keepLines(state.Lines[f.path], f.keys)
+		if len(lines) == 0 {
+			continue
+		}
+		if err := restoreLines(s.systemPath(f.path), lines); err != nil {
+			log.Printf("⚠️ Restoring %s entries of %s: %v", f.path, username, err)
+			continue
+		}
+		s.rebuild(f)
+		restored = true
+	}
+	if restored {
+		s.run("postfix", "reload")
+	}
+
+	if state.Crontab {
+		if err := s.uncommentCrontab(username); err != nil {
+			log.Printf("⚠️ Restoring crontab of %s: %v", username, err)
+		}
+	}
+
+	if err := s.unlockSystemUser(username, state); err != nil {
+		log.Printf("⚠️ Unlocking system user %s: %v", username, err)
+	}
+}
+
+// vhostNames returns the vhost names of an account's domains and
+// subdomains
+func (s *Service) vhostNames(userID int64) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT name FROM domains WHERE user_id = ?
+		UNION ALL
+		SELECT full_name FROM subdomains WHERE user_id = ?
+	`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// suspendedPageDir writes the suspended page and returns its directory
+func (s *Service) suspendedPageDir() (string, error) {
+	dir := suspendedPageRoot
+	if s.cfg.SimulateMode {
+		dir = filepath.Join(s.cfg.SimulateBasePath, "www", "suspended")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	page := `<!DOCTYPE html>
+<html lang="tr">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Hesap Askıya Alındı</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; background: #f3f4f6; color: #374151; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+        .box { background: white; padding: 3rem; border-radius: 1rem; box-shadow: 0 10px 25px rgba(0,0,0,0.08); text-align: center; max-width: 480px; }
+        h1 { color: #b91c1c; margin-bottom: 1rem; }
+    </style>
+</head>
+<body>
+    <div class="box">
+        <h1>Hesap Askıya Alındı</h1>
+        <p>Bu web sitesinin hesabı geçici olarak askıya alınmıştır.</p>
+        <p>This account has been suspended.</p>
+    </div>
+</body>
+</html>
+`
+	return dir, os.WriteFile(filepath.Join(dir, "index.html"), []byte(page), 0644)
+}
+
+// suspendVhosts swaps every vhost of an account for the suspended page
+func (s *Service) suspendVhosts(userID int64, username string, state *suspensionState) error {
+	names, err := s.vhostNames(userID)
+	if err != nil {
+		return err
+	}
+	pageDir, err := s.suspendedPageDir()
+	if err != nil {
+		return err
+	}
+
+	driver := s.webDriver()
+	configPath := driver.GetConfigPath()
+	enabledPath := filepath.Join(filepath.Dir(configPath), "sites-enabled")
+
+	for _, name := range names {
+		configFile := filepath.Join(configPath, name+".conf")
+		content, err := os.ReadFile(configFile)
+		if err != nil {
+			continue
+		}
+		_, err = os.Lstat(filepath.Join(enabledPath, name+".conf"))
+		state.Vhosts = append(state.Vhosts, suspendedVhost{Name: name, Config: string(content), Enabled: err == nil})
+
+		vhost := webserver.VhostConfig{
+			Domain:       name,
+			Aliases:      []string{"www." + name},
+			Username:     username,
+			DocumentRoot: pageDir,
+			HomeDir:      filepath.Join(s.cfg.HomeBaseDir, username),
+			Suspended:    true,
+		}
+
+		// The certificate comes from the vhost itself or its -ssl vhost,
+		// which is disabled while suspended
+		certSource := string(content)
+		sslName := name + "-ssl"
+		if sslContent, err := os.ReadFile(filepath.Join(configPath, sslName+".conf")); err == nil {
+			certSource += "\n" + string(sslContent)
+			if _, err := os.Lstat(filepath.Join(enabledPath, sslName+".conf")); err == nil {
+				if err := driver.DisableSite(sslName); err != nil {
+					log.Printf("⚠️ %v", err)
+				} else {
+					state.SSLSites = append(state.SSLSites, sslName)
+				}
+			}
+		}
+		if cert := sslCertLine.FindStringSubmatch(certSource); cert != nil {
+			if key := sslKeyLine.FindStringSubmatch(certSource); key != nil {
+				vhost.SSLEnabled = true
+				vhost.SSLCertPath = cert[1]
+				vhost.SSLKeyPath = key[1]
+			}
+		}
+
+		if err := driver.CreateVhost(vhost); err != nil {
+			log.Printf("⚠️ Suspended vhost for %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// restoreVhosts writes back the original vhost configs
+func (s *Service) restoreVhosts(state suspensionState) error {
+	driver := s.webDriver()
+	for _, v := range state.Vhosts {
+		configFile := filepath.Join(driver.GetConfigPath(), v.Name+".conf")
+		if err := os.WriteFile(configFile, []byte(v.Config), 0644); err != nil {
+			log.Printf("⚠️ Restoring vhost %s: %v", v.Name, err)
+			continue
+		}
+		if v.Enabled {
+			driver.EnableSite(v.Name)
+		} else {
+			driver.DisableSite(v.Name)
+		}
+	}
+	for _, name := range state.SSLSites {
+		if err := driver.EnableSite(name); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+	}
+	return driver.Reload()
+}
+
+// lineFiles returns the mail and FTP files with the account's entries
+func (s *Service) lineFiles(userID int64) ([]lineFile, error) {
+	collect := func(query string) ([]string, error) {
+		rows, err := s.db.Query(query, userID)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var values []string
+		for rows.Next() {
+			var v string
+			if rows.Scan(&v) == nil {
+				values = append(values, v)
+			}
+		}
+		return values, nil
+	}
+
+	emails, err := collect("SELECT email FROM email_accounts WHERE user_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	sources, err := collect("SELECT source FROM email_forwarders WHERE user_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	ftpUsers, err := collect("SELECT username FROM ftp_accounts WHERE user_id = ?")
+	if err != nil {
+		return nil, err
+	}
+
+	suffixed := func(values []string, sep string) []string {
+		keys := make([]string, len(values))
+		for i, v := range values {
+			keys[i] = v + sep
+		}
+		return keys
+	}
+
+	return []lineFile{
+		{path: dovecotUsersFile, keys: suffixed(emails, ":"), rebuild: [][]string{{"doveadm", "reload"}}},
+		{path: postfixMailboxes, keys: suffixed(emails, " "), rebuild: [][]string{{"postmap", s.systemPath(postfixMailboxes)}}},
+		{path: postfixAliases, keys: suffixed(sources, " "), rebuild: [][]string{{"postmap", s.systemPath(postfixAliases)}}},
+		{path: pureftpdPasswd, keys: suffixed(ftpUsers, ":"), rebuild: [][]string{{"pure-pw", "mkdb"}}},
+	}, nil
+}
+
+func (s *Service) rebuild(f lineFile) {
+	for _, cmd := range f.rebuild {
+		if err := s.run(cmd[0], cmd[1:]...); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+	}
+}
+
+func hasKey(line string, keys []string) bool {
+	for _, key := range keys {
+		if strings.HasPrefix(line, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepLines returns the lines that still belong to one of keys
+func keepLines(lines, keys []string) []string {
+	var kept []string
+	for _, line := range lines {
+		if hasKey(line, keys) {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// removeLines removes the lines starting with one of keys from a file and
+// returns them
+func removeLines(path string, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept, removed []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" && hasKey(line, keys) {
+			removed = append(removed, line)
+		} else {
+			kept = append(kept, line)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, os.WriteFile(path, []byte(strings.Join(kept, "\n")), info.Mode().Perm())
+}
+
+// restoreLines appends the lines missing from a file
+func restoreLines(path string, lines []string) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, line := range strings.Split(string(content), "\n") {
+		existing[line] = true
+	}
+
+	var b strings.Builder
+	b.Write(content)
+	if len(content) > 0 && !strings.HasSuffix(string(content), "\n") {
+		b.WriteString("\n")
+	}
+	for _, line := range lines {
+		if !existing[line] {
+			b.WriteString(line + "\n")
+		}
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	} else {
+		os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	return os.WriteFile(path, []byte(b.String()), mode)
+}
+
+// commentCrontab prefixes the jobs in an account's crontab with
+// SuspendedCronPrefix. Comments and environment lines are kept.
+func (s *Service) commentCrontab(username string) (bool, error) {
+	path := filepath.Join(s.systemPath(crontabDir), username)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	changed := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || cronEnvLine.MatchString(trimmed) {
+			continue
+		}
+		lines[i] = SuspendedCronPrefix + line
+		changed = true
+	}
+	if !changed {
+		return false, nil
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		return false, err
+	}
+	s.run("systemctl", "reload", "cron")
+	return true, nil
+}
+
+// uncommentCrontab removes SuspendedCronPrefix from an account's crontab
+func (s *Service) uncommentCrontab(username string) error {
+	path := filepath.Join(s.systemPath(crontabDir), username)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, SuspendedCronPrefix)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		return err
+	}
+	s.run("systemctl", "reload", "cron")
+	return nil
+}
+
+// lockSystemUser locks the password of the system user and expires it so
+// key-based logins stop too. What was already locked is left as is.
+func (s *Service) lockSystemUser(username string, state *suspensionState) error {
+	if s.cfg.SimulateMode {
+		s.run("usermod", "-L", username)
+		s.run("chage", "-E", "0", username)
+		state.PasswordLocked = true
+		state.UserExpired = true
+		return nil
+	}
+	if !s.cfg.IsLinux {
+		return nil
+	}
+
+	// shadow: name:password:lastchg:min:max:warn:inactive:expire:
+	output, err := exec.Command("getent", "shadow", username).Output()
+	if err != nil {
+		return fmt.Errorf("getent shadow failed: %w", err)
+	}
+	fields := strings.Split(strings.TrimSpace(string(output)), ":")
+	if len(fields) < 8 {
+		return fmt.Errorf("unexpected shadow entry for %s", username)
+	}
+
+	if !strings.HasPrefix(fields[1], "!") {
+		if err := s.run("usermod", "-L", username); err != nil {
+			return err
+		}
+		state.PasswordLocked = true
+	}
+	state.Expire = fields[7]
+	if err := s.run("chage", "-E", "0", username); err != nil {
+		return err
+	}
+	state.UserExpired = true
+	return nil
+}
+
+// unlockSystemUser reverses lockSystemUser
+func (s *Service) unlockSystemUser(username string, state suspensionState) error {
+	if state.PasswordLocked {
+		if err := s.run("usermod", "-U", username); err != nil {
+			return err
+		}
+	}
+	if state.UserExpired {
+		expire := state.Expire
+		if expire == "" {
+			expire = "-1"
+		}
+		return s.run("chage", "-E", expire, username)
+	}
+	return nil
+}
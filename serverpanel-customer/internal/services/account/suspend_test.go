@@ -0,0 +1,91 @@
+package account
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes a file below a simulated system path
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestSuspendAccountUndoneWhenNotRecorded(t *testing.T) {
+	s, db := testService(t)
+	if !s.cfg.SimulateMode {
+		t.Skip("needs simulate mode")
+	}
+	user := testAccount(t, db, "suspendme", 0, "suspendme.test")
+	var domainID int64
+	db.QueryRow("SELECT id FROM domains WHERE user_id = ?", user).Scan(&domainID)
+	db.Exec("INSERT INTO email_accounts (user_id, domain_id, email, password_hash) VALUES (?, ?, 'info@suspendme.test', 'x')", user, domainID)
+
+	vhost := filepath.Join(s.webDriver().GetConfigPath(), "suspendme.test.conf")
+	users := s.systemPath(dovecotUsersFile)
+	crontab := filepath.Join(s.systemPath(crontabDir), "suspendme")
+	files := map[string]string{
+		vhost:   "<VirtualHost *:80>\n    ServerName suspendme.test\n</VirtualHost>\n",
+		users:   "other@example.test:{SHA512-CRYPT}a\ninfo@suspendme.test:{SHA512-CRYPT}b\n",
+		crontab: "MAILTO=\"\"\n*/5 * * * * php cron.php\n",
+	}
+	for path, content := range files {
+		writeTestFile(t, path, content)
+	}
+
+	// Recording the suspension fails after the system was changed
+	if _, err := db.Exec(`CREATE TRIGGER fail_suspension BEFORE INSERT ON account_suspensions
+		BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SuspendAccount(user, "test", 0); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("SuspendAccount = %v, want the insert error", err)
+	}
+
+	for path, want := range files {
+		if got := readTestFile(t, path); got != want {
+			t.Errorf("%s not restored:\n%s\nwant:\n%s", filepath.Base(path), got, want)
+		}
+	}
+	var active int
+	db.QueryRow("SELECT active FROM users WHERE id = ?", user).Scan(&active)
+	if active != 1 || s.IsSuspended(user) {
+		t.Errorf("account left inactive (%d) or suspended", active)
+	}
+
+	// With the failure gone the account suspends and unsuspends normally
+	db.Exec("DROP TRIGGER fail_suspension")
+	if err := s.SuspendAccount(user, "test", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, users); strings.Contains(got, "suspendme") {
+		t.Errorf("mailbox still in dovecot users: %q", got)
+	}
+	if got := readTestFile(t, crontab); !strings.Contains(got, SuspendedCronPrefix) {
+		t.Errorf("crontab not commented out: %q", got)
+	}
+	if err := s.UnsuspendAccount(user); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range files {
+		if got := readTestFile(t, path); got != want {
+			t.Errorf("%s after unsuspend:\n%s\nwant:\n%s", filepath.Base(path), got, want)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/webserver"
+)
+
+var (
+	ErrVhostNotFound    = errors.New("domain not found")
+	ErrAccountSuspended = errors.New("account is suspended")
+)
+
+// vhostSource is what a domain or subdomain vhost is rendered from
+type vhostSource struct {
+	userID  int64
+	config  webserver.VhostConfig
+	sslSite string // separate <name>-ssl vhost the certificate was taken from
+}
+
+// vhostSource loads the vhost settings of a domain or subdomain from the
+// panel database. The certificate is kept from the current config.
+func (s *Service) vhostSource(name string) (*vhostSource, error) {
+	src := &vhostSource{}
+	var username, documentRoot, phpVersion, redirectURL, domain string
+	var rateKB int
+	err := s.db.QueryRow(`
+		SELECT d.user_id, u.username, COALESCE(d.document_root, ''), COALESCE(d.php_version, ''),
+		       COALESCE(d.rate_limit_kb, 0), '', d.name
+		FROM domains d JOIN users u ON u.id = d.user_id
+		WHERE d.name = ?
+	`, name).Scan(&src.userID, &username, &documentRoot, &phpVersion, &rateKB, &redirectURL, &domain)
+	if err == sql.ErrNoRows {
+		err = s.db.QueryRow(`
+			SELECT sd.user_id, u.username, COALESCE(sd.document_root, ''), COALESCE(d.php_version, ''),
+			       COALESCE(d.rate_limit_kb, 0), COALESCE(sd.redirect_url, ''), d.name
+			FROM subdomains sd
+			JOIN users u ON u.id = sd.user_id
+			JOIN domains d ON d.id = sd.domain_id
+			WHERE sd.full_name = ?
+		`, name).Scan(&src.userID, &username, &documentRoot, &phpVersion, &rateKB, &redirectURL, &domain)
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrVhostNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if redirectURL != "" {
+		return nil, fmt.Errorf("%s redirects to %s and has no site vhost", name, redirectURL)
+	}
+
+	homeDir := filepath.Join(s.cfg.HomeBaseDir, username)
+	if documentRoot == "" {
+		documentRoot = filepath.Join(homeDir, "public_html")
+	}
+	if phpVersion == "" {
+		phpVersion = s.cfg.PHPVersion
+	}
+
+	src.config = webserver.VhostConfig{
+		Domain:       name,
+		Aliases:      []string{fmt.Sprintf("www.%s", name)},
+		Username:     username,
+		DocumentRoot: documentRoot,
+		HomeDir:      homeDir,
+		PHPVersion:   phpVersion,
+		PHPPool:      PoolName(username, domain, s.accountPHPLimits(src.userID).perDomain),
+		RateLimitKB:  rateKB,
+		Directives:   s.GetVhostDirectives(name),
+	}
+
+	// A Node.js app published on the domain is served through a proxy
+	s.db.QueryRow("SELECT port FROM nodejs_apps WHERE app_url = ? ORDER BY id DESC LIMIT 1", name).Scan(&src.config.ProxyPort)
+
+	// HTTPS moves into the rendered vhost; a separate <name>-ssl vhost
+	// would otherwise answer HTTPS without the proxy or directives
+	configPath := s.webDriver().GetConfigPath()
+	for _, site := range []string{name, name + "-ssl"} {
+		content, err := os.ReadFile(filepath.Join(configPath, site+".conf"))
+		if err != nil {
+			continue
+		}
+		cert := sslCertLine.FindStringSubmatch(string(content))
+		key := sslKeyLine.FindStringSubmatch(string(content))
+		if cert != nil && key != nil && s.certExists(cert[1]) {
+			src.config.SSLEnabled = true
+			src.config.SSLCertPath = cert[1]
+			src.config.SSLKeyPath = key[1]
+			if site != name {
+				src.sslSite = site
+			}
+			break
+		}
+	}
+
+	// A certificate issued before the vhost carries it, e.g. when TLS is
+	// terminated by a frontend that has no separate -ssl vhost
+	if !src.config.SSLEnabled {
+		certPath := filepath.Join("/etc/letsencrypt/live", name, "fullchain.pem")
+		if !s.cfg.SimulateMode && s.certExists(certPath) {
+			src.config.SSLEnabled = true
+			src.config.SSLCertPath = certPath
+			src.config.SSLKeyPath = filepath.Join("/etc/letsencrypt/live", name, "privkey.pem")
+		}
+	}
+
+	return src, nil
+}
+
+// certExists reports whether a certificate file is still there; revoked
+// certificates are deleted and a vhost pointing at them would not load.
+// Simulated vhosts refer to certificates that never exist.
+func (s *Service) certExists(path string) bool {
+	if s.cfg.SimulateMode {
+		return true
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// RebuildVhost renders the vhost of a domain or subdomain from its
+// template and settings. The vhosts of suspended accounts are left alone
+// until they are unsuspended.
+func (s *Service) RebuildVhost(name string) error {
+	src, err := s.vhostSource(name)
+	if err != nil {
+		return err
+	}
+	return s.applyVhost(src)
+}
+
+// InstallCertificate serves a domain or subdomain over HTTPS with the
+// given certificate. A separate -ssl vhost is retired in the same
+// transaction; when the web server rejects the result nothing changes.
+func (s *Service) InstallCertificate(name, certPath, keyPath string) error {
+	src, err := s.vhostSource(name)
+	if err != nil {
+		return err
+	}
+	src.config.SSLEnabled = true
+	src.config.SSLCertPath = certPath
+	src.config.SSLKeyPath = keyPath
+	src.sslSite = name + "-ssl"
+	return s.applyVhost(src)
+}
+
+// RemoveCertificate serves a domain or subdomain over plain HTTP again and
+// removes its separate -ssl vhost, if any
+func (s *Service) RemoveCertificate(name string) error {
+	src, err := s.vhostSource(name)
+	if err != nil {
+		return err
+	}
+	if s.IsSuspended(src.userID) {
+		return ErrAccountSuspended
+	}
+	src.config.SSLEnabled = false
+	src.config.SSLCertPath = ""
+	src.config.SSLKeyPath = ""
+
+	driver := s.webDriver()
+	tx := webserver.NewTransaction()
+	s.stageMissingPool(tx, src)
+	if err := driver.StageVhost(tx, src.config); err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(driver.GetConfigPath(), name+"-ssl.conf")); err == nil {
+		tx.RemoveSite(driver, name+"-ssl")
+	}
+	return tx.Commit()
+}
+
+// applyVhost installs a vhost and disables the separate -ssl vhost its
+// certificate came from as one transaction
+func (s *Service) applyVhost(src *vhostSource) error {
+	if s.IsSuspended(src.userID) {
+		return ErrAccountSuspended
+	}
+
+	driver := s.webDriver()
+	tx := webserver.NewTransaction()
+	s.stageMissingPool(tx, src)
+	if err := driver.StageVhost(tx, src.config); err != nil {
+		return err
+	}
+	if src.sslSite != "" {
+		tx.DisableSite(driver, src.sslSite)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("✅ %s vhost rebuilt: %s", driver.Name(), src.config.Domain)
+	return nil
+}
+
+// stageMissingPool adds the pool a PHP site runs in to a transaction when
+// it does not exist yet, e.g. for a new domain on a pool-per-domain package
+func (s *Service) stageMissingPool(tx *webserver.Transaction, src *vhostSource) {
+	if src.config.ProxyPort > 0 || s.phpManager(src.config.PHPVersion).HasPool(src.config.PHPPool) {
+		return
+	}
+
+	php := s.accountPHPLimits(src.userID)
+	pools, err := s.accountPools(src.userID, src.config.Username, php)
+	if err != nil {
+		return
+	}
+	for _, pool := range pools {
+		if pool.Name == src.config.PHPPool && pool.PHPVersion == src.config.PHPVersion {
+			s.stagePool(tx, src.config.Username, pool, php)
+			return
+		}
+	}
+}
+
+// RebuildAllVhosts renders the vhosts of every domain and subdomain again,
+// e.g. after a template change, and returns how many were rebuilt and
+// what failed
+func (s *Service) RebuildAllVhosts() (int, []string) {
+	rows, err := s.db.Query(`
+		SELECT name FROM domains
+		UNION ALL
+		SELECT full_name FROM subdomains WHERE COALESCE(redirect_url, '') = ''
+	`)
+	if err != nil {
+		return 0, []string{err.Error()}
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	rebuilt := 0
+	failures := []string{}
+	for _, name := range names {
+		err := s.RebuildVhost(name)
+		switch {
+		case err == nil:
+			rebuilt++
+		case err == ErrAccountSuspended:
+		default:
+			log.Printf("⚠️ Rebuilding vhost %s: %v", name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	return rebuilt, failures
+}
+
+// GetVhostDirectives returns the custom directives of a domain
+func (s *Service) GetVhostDirectives(name string) []string {
+	var directives string
+	s.db.QueryRow("SELECT directives FROM vhost_directives WHERE name = ?", name).Scan(&directives)
+	if directives == "" {
+		return []string{}
+	}
+	return strings.Split(directives, "\n")
+}
+
+// SetVhostDirectives validates and stores the custom directives of a
+// domain and rebuilds its vhost. When the web server rejects the result
+// the previous directives are put back.
+func (s *Service) SetVhostDirectives(name string, directives []string) error {
+	var cleaned []string
+	for _, line := range directives {
+		for _, l := range strings.Split(line, "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				cleaned = append(cleaned, l)
+			}
+		}
+	}
+
+	if err := s.webDriver().ValidateDirectives(cleaned); err != nil {
+		return err
+	}
+
+	src, err := s.vhostSource(name)
+	if err != nil {
+		return err
+	}
+	if s.IsSuspended(src.userID) {
+		return ErrAccountSuspended
+	}
+
+	previous := s.GetVhostDirectives(name)
+	if err := s.storeVhostDirectives(src.userID, name, cleaned); err != nil {
+		return err
+	}
+
+	if err := s.RebuildVhost(name); err != nil {
+		s.storeVhostDirectives(src.userID, name, previous)
+		return err
+	}
+	return nil
+}
+
+func (s *Service) storeVhostDirectives(userID int64, name string, directives []string) error {
+	if len(directives) == 0 {
+		_, err := s.db.Exec("DELETE FROM vhost_directives WHERE name = ?", name)
+		return err
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO vhost_directives (name, user_id, directives, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(name) DO UPDATE SET
+			directives = excluded.directives, updated_at = CURRENT_TIMESTAMP
+	`, name, userID, strings.Join(directives, "\n"))
+	return err
+}
@@ -0,0 +1,387 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// archiveWriter writes a .spbackup archive (tar.gz) and keeps a sha256
+// checksum for every regular file it adds. With a seal the stream is
+// encrypted before it reaches the disk.
+type archiveWriter struct {
+	file *os.File
+	enc  *encryptWriter
+	gz   *gzip.Writer
+	tw   *tar.Writer
+	sums map[string]string
+}
+
+func newArchiveWriter(path string, seal *archiveSeal) (*archiveWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = f
+	var enc *encryptWriter
+	if seal != nil {
+		if enc, err = newEncryptWriter(f, seal.header, seal.dataKey); err != nil {
+			f.Close()
+			return nil, err
+		}
+		out = enc
+	}
+
+	gz := gzip.NewWriter(out)
+	return &archiveWriter{
+		file: f,
+		enc:  enc,
+		gz:   gz,
+		tw:   tar.NewWriter(gz),
+		sums: make(map[string]string),
+	}, nil
+}
+
+// addBytes adds an in-memory file to the archive
+func (w *archiveWriter) addBytes(name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		Typeflag: tar.TypeReg,
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	w.sums[name] = sha256Hex(data)
+	_, err := w.tw.Write(data)
+	return err
+}
+
+// addJSON marshals v and adds it to the archive
+func (w *archiveWriter) addJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return w.addBytes(name, data)
+}
+
+// addFile copies a file from disk into the archive under name
+func (w *archiveWriter) addFile(name, src string, info os.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w.tw, h), f); err != nil {
+		return err
+	}
+	w.sums[name] = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// addTree adds every entry below root into the archive under prefix.
+// Returns the total size of regular files and the number of files added.
+func (w *archiveWriter) addTree(prefix, root string) (int64, int64, error) {
+	var size, count int64
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Unreadable entries are skipped, not fatal
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		name := prefix
+		if rel != "." {
+			name = prefix + "/" + filepath.ToSlash(rel)
+		}
+
+		switch {
+		case info.Mode().IsRegular():
+			if err := w.addFile(name, path, info); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			size += info.Size()
+			count++
+		case info.IsDir():
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return nil
+			}
+			hdr.Name = name + "/"
+			return w.tw.WriteHeader(hdr)
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return nil
+			}
+			hdr, err := tar.FileInfoHeader(info, target)
+			if err != nil {
+				return nil
+			}
+			hdr.Name = name
+			return w.tw.WriteHeader(hdr)
+		}
+		// Sockets, devices and pipes are not backed up
+		return nil
+	})
+
+	return size, count, err
+}
+
+// checksumFile renders the collected checksums in sha256sum format
+func (w *archiveWriter) checksumFile() []byte {
+	names := make([]string, 0, len(w.sums))
+	for name := range w.sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", w.sums[name], name)
+	}
+	return buf.Bytes()
+}
+
+func (w *archiveWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.gz.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	return w.file.Close()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadManifest reads manifest.json from a .spbackup archive
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := ReadArchiveFile(path, ManifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Format != FormatName {
+		return nil, fmt.Errorf("unsupported backup format: %s", m.Format)
+	}
+	return &m, nil
+}
+
+// ReadArchiveFile returns the content of a single file inside the archive
+func ReadArchiveFile(path, name string) ([]byte, error) {
+	var data []byte
+	found := false
+
+	err := walkArchive(path, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name != name {
+			return nil
+		}
+		var err error
+		if data, err = io.ReadAll(r); err != nil {
+			return err
+		}
+		found = true
+		return errStopWalk
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s not found in archive", name)
+	}
+	return data, nil
+}
+
+var errStopWalk = errors.New("stop walk")
+
+// walkArchive calls fn for every entry of the archive. fn may return
+// errStopWalk to end the walk early. Encrypted archives are opened with
+// the server master keys.
+func walkArchive(path string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	return walkArchiveWith(path, nil, fn)
+}
+
+// walkArchiveWith is walkArchive with an explicit key source
+func walkArchiveWith(path string, unlock Unlocker, fn func(hdr *tar.Header, r io.Reader) error) error {
+	f, err := openArchive(path, unlock)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("not a valid backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Never trust names coming from an archive
+		if !isSafeArchiveName(hdr.Name) {
+			continue
+		}
+		if err := fn(hdr, tr); err != nil {
+			if err == errStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// extractTree extracts the archive entry prefix (a file or a directory
+// tree) to base, a slash-separated path below root ("" for root itself).
+// Returns the number of regular files and bytes written.
+func extractTree(path, prefix, root, base string) (int64, int64, error) {
+	var files, size int64
+	found := false
+
+	err := walkArchive(path, func(hdr *tar.Header, r io.Reader) error {
+		name := strings.TrimSuffix(hdr.Name, "/")
+		var rel string
+		switch {
+		case name == prefix:
+			rel = base
+		case strings.HasPrefix(name, prefix+"/"):
+			rel = strings.TrimPrefix(name, prefix+"/")
+			if base != "" {
+				rel = base + "/" + rel
+			}
+		default:
+			return nil
+		}
+		found = true
+
+		target, err := securePath(root, rel)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			return os.MkdirAll(target, os.FileMode(hdr.Mode).Perm())
+		case tar.TypeSymlink:
+			os.Remove(target)
+			return os.Symlink(hdr.Linkname, target)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			// Never write through an existing symlink
+			if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				os.Remove(target)
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(f, r)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+			files++
+			size += n
+		}
+		return nil
+	})
+	if err != nil {
+		return files, size, err
+	}
+	if !found {
+		return 0, 0, ErrItemNotInBackup
+	}
+	return files, size, nil
+}
+
+// securePath joins rel onto dest and makes sure the result, with symlinks
+// in its nearest existing ancestor resolved, does not leave dest.
+func securePath(dest, rel string) (string, error) {
+	target := filepath.Join(dest, filepath.FromSlash(rel))
+	if target != dest && !strings.HasPrefix(target, dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid path in archive: %s", rel)
+	}
+
+	root, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		root = dest
+	}
+
+	for dir := filepath.Dir(target); len(dir) >= len(dest); dir = filepath.Dir(dir) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if resolved != root && !strings.HasPrefix(resolved, root+string(os.PathSeparator)) {
+			return "", fmt.Errorf("invalid path in archive: %s", rel)
+		}
+		break
+	}
+	return target, nil
+}
+
+// isSafeArchiveName rejects absolute names and names with ".." segments
+func isSafeArchiveName(name string) bool {
+	if strings.HasPrefix(name, "/") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,213 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	files := map[string]string{
+		"public_html/index.php":     "<?php echo 'hi';",
+		"public_html/css/site.css":  "body{}",
+		"mail/info/cur/1.eml":       "Subject: test\n\nbody",
+		"public_html/empty.txt":     "",
+		"public_html/ünicode name":  "x",
+		"public_html/deep/a/b/c/d/": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("index.php", filepath.Join(src, "public_html", "link.php")); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(dir, "test.spbackup")
+	w, err := newArchiveWriter(archive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := Manifest{Format: FormatName}
+	if err := w.addJSON(ManifestFile, manifest); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.addBytes("databases/shop.sql", []byte("CREATE TABLE t (id int);\n")); err != nil {
+		t.Fatal(err)
+	}
+	size, count, err := w.addTree("homedir", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.addBytes("checksums.sha256", w.checksumFile()); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Errorf("addTree counted %d files, want 5", count)
+	}
+	var want int64
+	for name, content := range files {
+		if !strings.HasSuffix(name, "/") {
+			want += int64(len(content))
+		}
+	}
+	if size != want {
+		t.Errorf("addTree size = %d, want %d", size, want)
+	}
+
+	m, err := ReadManifest(archive)
+	if err != nil {
+		t.Fatalf("ReadManifest: %v", err)
+	}
+	if m.Format != FormatName {
+		t.Errorf("manifest format = %q", m.Format)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"databases/shop.sql", "CREATE TABLE t (id int);\n"},
+		{"homedir/public_html/index.php", files["public_html/index.php"]},
+		{"homedir/public_html/empty.txt", ""},
+		{"homedir/public_html/ünicode name", "x"},
+	}
+	for _, tt := range tests {
+		got, err := ReadArchiveFile(archive, tt.name)
+		if err != nil {
+			t.Errorf("ReadArchiveFile(%s): %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("ReadArchiveFile(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := ReadArchiveFile(archive, "homedir/missing"); err == nil {
+		t.Error("ReadArchiveFile of a missing entry succeeded")
+	}
+
+	sums, err := ReadArchiveFile(archive, "checksums.sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sums), sha256Hex([]byte(files["public_html/css/site.css"]))+"  homedir/public_html/css/site.css\n") {
+		t.Errorf("checksums do not list site.css:\n%s", sums)
+	}
+
+	// The tree extracts back to what was archived
+	dest := filepath.Join(dir, "dest")
+	n, _, err := extractTree(archive, "homedir", dest, "")
+	if err != nil {
+		t.Fatalf("extractTree: %v", err)
+	}
+	if n != count {
+		t.Errorf("extractTree wrote %d files, want %d", n, count)
+	}
+	for name, content := range files {
+		path := filepath.Join(dest, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				t.Errorf("directory %s was not restored", name)
+			}
+			continue
+		}
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != content {
+			t.Errorf("%s = %q (%v), want %q", name, got, err, content)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "public_html", "link.php")); err != nil || target != "index.php" {
+		t.Errorf("symlink restored as %q (%v)", target, err)
+	}
+
+	// A single file restores to a different place
+	if _, _, err := extractTree(archive, "homedir/public_html/index.php", dest, "restored/index.php"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, "restored", "index.php")); string(got) != files["public_html/index.php"] {
+		t.Errorf("single file restore = %q", got)
+	}
+	if _, _, err := extractTree(archive, "homedir/nothing", dest, ""); err != ErrItemNotInBackup {
+		t.Errorf("extractTree of a missing item: %v, want ErrItemNotInBackup", err)
+	}
+}
+
+func TestSecurePath(t *testing.T) {
+	dest := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dest, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dir", filepath.Join(dest, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel  string
+		want string // "" = rejected
+	}{
+		{"", dest},
+		{"file.txt", filepath.Join(dest, "file.txt")},
+		{"dir/file.txt", filepath.Join(dest, "dir", "file.txt")},
+		{"new/sub/file.txt", filepath.Join(dest, "new", "sub", "file.txt")},
+		{"dir/../file.txt", filepath.Join(dest, "file.txt")},
+		{"inside/file.txt", filepath.Join(dest, "inside", "file.txt")},
+		{"../file.txt", ""},
+		{"dir/../../file.txt", ""},
+		{"/etc/passwd", filepath.Join(dest, "etc", "passwd")},
+		{"escape/file.txt", ""},
+		{"escape/new/file.txt", ""},
+	}
+	for _, tt := range tests {
+		got, err := securePath(dest, tt.rel)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("securePath(%q) = %q, want an error", tt.rel, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("securePath(%q) = %q, %v; want %q", tt.rel, got, err, tt.want)
+		}
+	}
+}
+
+func TestIsSafeArchiveName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"manifest.json", true},
+		{"homedir/public_html/index.php", true},
+		{"homedir/..hidden", true},
+		{"/etc/passwd", false},
+		{"../etc/passwd", false},
+		{"homedir/../../etc/passwd", false},
+		{"homedir/..", false},
+	}
+	for _, tt := range tests {
+		if got := isSafeArchiveName(tt.name); got != tt.want {
+			t.Errorf("isSafeArchiveName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+)
+
+// Backup format identifiers written into manifest.json
+const (
+	FormatName    = "serverpanel"
+	FormatVersion = "1.0.0"
+	FileExtension = ".spbackup"
+
+	ManifestFile  = "manifest.json"
+	AccountFile   = "account.json"
+	ChecksumsFile = "checksums.sha256"
+)
+
+// Backup types
+const (
+	TypeFull    = "full"
+	TypePartial = "partial"
+)
+
+// Job statuses
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+var (
+	ErrAccountNotFound = errors.New("account not found")
+	ErrJobNotFound     = errors.New("backup not found")
+	ErrJobNotReady     = errors.New("backup is not completed")
+)
+
+// DB interface for database operations
+type DB interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Begin() (*sql.Tx, error)
+}
+
+// Service creates and manages .spbackup account archives
+type Service struct {
+	db  DB
+	cfg *config.Config
+}
+
+// Options selects which parts of an account are backed up
+type Options struct {
+	IncludeFiles     bool `json:"include_files"`
+	IncludeDatabases bool `json:"include_databases"`
+	IncludeEmail     bool `json:"include_email"`
+	IncludeDNS       bool `json:"include_dns"`
+	IncludeFTP       bool `json:"include_ftp"`
+
+	// DestinationID uploads the archive to a remote destination
+	// (0 = the default destination, if any)
+	DestinationID int64 `json:"destination_id,omitempty"`
+}
+
+// FullOptions returns options that include every component
+func FullOptions() Options {
+	return Options{
+		IncludeFiles:     true,
+		IncludeDatabases: true,
+		IncludeEmail:     true,
+		IncludeDNS:       true,
+		IncludeFTP:       true,
+	}
+}
+
+func (o Options) isFull() bool {
+	return o.IncludeFiles && o.IncludeDatabases && o.IncludeEmail && o.IncludeDNS && o.IncludeFTP
+}
+
+// Job is a single backup run stored in backup_jobs
+type Job struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	CreatedBy int64  `json:"created_by"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	Options
+	BackupPath      string `json:"-"`
+	FileName        string `json:"file_name,omitempty"`
+	BackupSize      int64  `json:"backup_size"`
+	FileCount       int64  `json:"file_count"`
+	StartedAt       string `json:"started_at,omitempty"`
+	CompletedAt     string `json:"completed_at,omitempty"`
+	DurationSeconds int64  `json:"duration_seconds"`
+	ErrorMessage    string `json:"error_message,omitempty"`
+	StorageBackend  string `json:"storage_backend"`
+	RemotePath      string `json:"remote_path,omitempty"`
+	Encrypted       bool   `json:"encrypted"`
+	EncryptionKeyID string `json:"encryption_key_id,omitempty"`
+	CreatedAt       string `json:"created_at"`
+}
+
+// Manifest is the manifest.json at the root of every archive
+type Manifest struct {
+	Version     string              `json:"version"`
+	Format      string              `json:"format"`
+	CreatedAt   time.Time           `json:"created_at"`
+	CreatedBy   string              `json:"created_by"`
+	Type        string              `json:"type"`
+	Encryption  ManifestEncryption  `json:"encryption"`
+	Account     ManifestAccount     `json:"account"`
+	Contents    ManifestContents    `json:"contents"`
+	Checksums   ManifestChecksums   `json:"checksums"`
+	Incremental ManifestIncremental `json:"incremental"`
+}
+
+type ManifestEncryption struct {
+	Enabled   bool   `json:"enabled"`
+	Algorithm string `json:"algorithm,omitempty"`
+	KeyID     string `json:"key_id,omitempty"`
+}
+
+type ManifestAccount struct {
+	Username  string `json:"username"`
+	Domain    string `json:"domain"`
+	Package   string `json:"package"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ManifestContents struct {
+	Homedir   HomedirContents   `json:"homedir"`
+	Databases DatabasesContents `json:"databases"`
+	Email     EmailContents     `json:"email"`
+	DNS       DNSContents       `json:"dns"`
+	FTP       FTPContents       `json:"ftp"`
+}
+
+type HomedirContents struct {
+	Included  bool  `json:"included"`
+	SizeBytes int64 `json:"size_bytes"`
+	FileCount int64 `json:"file_count"`
+}
+
+type DatabasesContents struct {
+	Included       bool     `json:"included"`
+	Count          int      `json:"count"`
+	TotalSizeBytes int64    `json:"total_size_bytes"`
+	Names          []string `json:"names,omitempty"`
+}
+
+type EmailContents struct {
+	Included         bool     `json:"included"`
+	AccountCount     int      `json:"account_count"`
+	MailboxSizeBytes int64    `json:"mailbox_size_bytes"`
+	Mailboxes        []string `json:"mailboxes,omitempty"`
+}
+
+type DNSContents struct {
+	Included  bool     `json:"included"`
+	ZoneCount int      `json:"zone_count"`
+	Zones     []string `json:"zones,omitempty"`
+}
+
+type FTPContents struct {
+	Included     bool `json:"included"`
+	AccountCount int  `json:"account_count"`
+}
+
+type ManifestChecksums struct {
+	Algorithm    string `json:"algorithm"`
+	ManifestHash string `json:"manifest_hash"`
+}
+
+type ManifestIncremental struct {
+	Enabled          bool   `json:"enabled"`
+	BaseBackupID     *int64 `json:"base_backup_id"`
+	ChangedFilesOnly bool   `json:"changed_files_only"`
+}
+
+func NewService(db DB) *Service {
+	return &Service{
+		db:  db,
+		cfg: config.Get(),
+	}
+}
+
+// GetBackupDir returns the local directory holding a user's archives
+func (s *Service) GetBackupDir(username string) string {
+	return filepath.Join(s.cfg.DataDir, "backups", username)
+}
+
+// GetMailRoot returns the Maildir root (/var/mail/vhosts in production)
+func (s *Service) GetMailRoot() string {
+	if s.cfg.SimulateMode {
+		return filepath.Join(s.cfg.SimulateBasePath, "mail", "vhosts")
+	}
+	return "/var/mail/vhosts"
+}
+
+// CreateJob registers a pending backup for an account
+func (s *Service) CreateJob(userID, createdBy int64, opts Options) (*Job, error) {
+	var username string
+	err := s.db.QueryRow("SELECT username FROM users WHERE id = ? AND role = 'user'", userID).Scan(&username)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backupType := TypeFull
+	if !opts.isFull() {
+		backupType = TypePartial
+	}
+
+	var destination *DestinationRecord
+	if opts.DestinationID > 0 {
+		destination, err = s.GetDestination(opts.DestinationID)
+	} else {
+		destination, err = s.defaultDestination()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var destinationID sql.NullInt64
+	if destination != nil {
+		destinationID = sql.NullInt64{Int64: destination.ID, Valid: true}
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO backup_jobs (user_id, created_by, type, status,
+			include_files, include_databases, include_email, include_dns, include_ftp,
+			storage_backend, destination_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'local', ?)
+	`, userID, sql.NullInt64{Int64: createdBy, Valid: createdBy > 0}, backupType, StatusPending,
+		opts.IncludeFiles, opts.IncludeDatabases, opts.IncludeEmail, opts.IncludeDNS, opts.IncludeFTP,
+		destinationID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return s.GetJob(id)
+}
+
+// RunJob executes a pending backup job and records its result
+func (s *Service) RunJob(jobID int64) error {
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	s.db.Exec(`UPDATE backup_jobs SET status = ?, started_at = ? WHERE id = ?`,
+		StatusRunning, started.UTC().Format("2006-01-02 15:04:05"), jobID)
+
+	log.Printf("💾 Backup started: %s (job %d)", job.Username, jobID)
+
+	path, stats, err := s.createArchive(job, started)
+	duration := int64(time.Since(started).Seconds())
+	completed := time.Now().UTC().Format("2006-01-02 15:04:05")
+
+	if err != nil {
+		if path != "" {
+			os.Remove(path)
+		}
+		s.db.Exec(`
+			UPDATE backup_jobs SET status = ?, completed_at = ?, duration_seconds = ?, error_message = ?
+			WHERE id = ?
+		`, StatusFailed, completed, duration, err.Error(), jobID)
+		log.Printf("❌ Backup failed: %s (job %d): %v", job.Username, jobID, err)
+		return err
+	}
+
+	var size int64
+	if info, statErr := os.Stat(path); statErr == nil {
+		size = info.Size()
+	}
+
+	s.db.Exec(`
+		UPDATE backup_jobs SET status = ?, backup_path = ?, backup_size = ?, file_count = ?,
+			completed_at = ?, duration_seconds = ?, encrypted = ?, encryption_key_id = ?
+		WHERE id = ?
+	`, StatusCompleted, path, size, stats.FileCount, completed, duration,
+		stats.KeyID != "", sql.NullString{String: stats.KeyID, Valid: stats.KeyID != ""}, jobID)
+
+	log.Printf("✅ Backup completed: %s -> %s (%d bytes)", job.Username, path, size)
+
+	// A failed upload keeps the local archive; the job stays usable
+	if job.DestinationID > 0 {
+		job.BackupPath = path
+		if err := s.uploadJob(job); err != nil {
+			s.db.Exec("UPDATE backup_jobs SET error_message = ? WHERE id = ?", err.Error(), jobID)
+			log.Printf("⚠️ Backup upload failed: %s (job %d): %v", job.Username, jobID, err)
+		}
+	}
+	return nil
+}
+
+// Backup creates and runs a backup job synchronously
+func (s *Service) Backup(userID, createdBy int64, opts Options) (*Job, error) {
+	job, err := s.CreateJob(userID, createdBy, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.RunJob(job.ID); err != nil {
+		return nil, err
+	}
+	return s.GetJob(job.ID)
+}
+
+const jobColumns = `
+	j.id, j.user_id, u.username, COALESCE(j.created_by, 0), j.type, j.status,
+	j.include_files, j.include_databases, j.include_email, j.include_dns, j.include_ftp,
+	COALESCE(j.backup_path, ''), COALESCE(j.backup_size, 0), COALESCE(j.file_count, 0),
+	COALESCE(j.started_at, ''), COALESCE(j.completed_at, ''), COALESCE(j.duration_seconds, 0),
+	COALESCE(j.error_message, ''), COALESCE(j.storage_backend, 'local'),
+	COALESCE(j.destination_id, 0), COALESCE(j.remote_path, ''),
+	COALESCE(j.encrypted, 0), COALESCE(j.encryption_key_id, ''), j.created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (*Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.UserID, &j.Username, &j.CreatedBy, &j.Type, &j.Status,
+		&j.IncludeFiles, &j.IncludeDatabases, &j.IncludeEmail, &j.IncludeDNS, &j.IncludeFTP,
+		&j.BackupPath, &j.BackupSize, &j.FileCount,
+		&j.StartedAt, &j.CompletedAt, &j.DurationSeconds,
+		&j.ErrorMessage, &j.StorageBackend, &j.DestinationID, &j.RemotePath,
+		&j.Encrypted, &j.EncryptionKeyID, &j.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if j.BackupPath != "" {
+		j.FileName = filepath.Base(j.BackupPath)
+	}
+	return &j, nil
+}
+
+// GetJob returns a single backup job
+func (s *Service) GetJob(jobID int64) (*Job, error) {
+	row := s.db.QueryRow(`SELECT `+jobColumns+`
+		FROM backup_jobs j
+		JOIN users u ON u.id = j.user_id
+		WHERE j.id = ?`, jobID)
+
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// ListJobs returns backup jobs, optionally filtered by account (userID > 0)
+func (s *Service) ListJobs(userID int64) ([]Job, error) {
+	query := `SELECT ` + jobColumns + `
+		FROM backup_jobs j
+		JOIN users u ON u.id = j.user_id`
+	var args []interface{}
+	if userID > 0 {
+		query += ` WHERE j.user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY j.created_at DESC, j.id DESC`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
+
+// DeleteJob removes a backup job and its archive
+func (s *Service) DeleteJob(jobID int64) error {
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status == StatusRunning {
+		return fmt.Errorf("backup is still running")
+	}
+
+	if job.RemotePath != "" {
+		if err := s.deleteRemote(job); err != nil {
+			return fmt.Errorf("could not delete remote copy: %w", err)
+		}
+	}
+	if job.BackupPath != "" {
+		if err := os.Remove(job.BackupPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	_, err = s.db.Exec("DELETE FROM backup_jobs WHERE id = ?", jobID)
+	if err == nil {
+		log.Printf("🗑️ Backup deleted: %s (job %d)", job.Username, jobID)
+	}
+	return err
+}
+
+// GetArchivePath returns the archive of a completed job
+func (s *Service) GetArchivePath(jobID int64) (string, error) {
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		return "", err
+	}
+	if job.Status != StatusCompleted || job.BackupPath == "" {
+		return "", ErrJobNotReady
+	}
+	if _, err := os.Stat(job.BackupPath); err != nil {
+		if !os.IsNotExist(err) || job.RemotePath == "" {
+			return "", fmt.Errorf("backup archive missing: %w", err)
+		}
+		// Only the remote copy is left; bring it back for restore/download
+		if err := s.fetchJob(job); err != nil {
+			return "", fmt.Errorf("backup archive missing: %w", err)
+		}
+	}
+	return job.BackupPath, nil
+}
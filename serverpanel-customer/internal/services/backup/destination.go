@@ -0,0 +1,405 @@
+package backup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Destination types
+const (
+	DestinationLocal = "local"
+	DestinationSFTP  = "sftp"
+	DestinationFTP   = "ftp"
+	DestinationS3    = "s3"
+)
+
+var (
+	ErrDestinationNotFound = errors.New("backup destination not found")
+	ErrDestinationInUse    = errors.New("backup destination still holds backups")
+	ErrInvalidDestination  = errors.New("invalid backup destination")
+	ErrRemoteNotFound      = errors.New("file not found on backup destination")
+)
+
+// Destination stores backup archives outside the panel's data directory.
+// Names are slash separated and relative to the destination's base path.
+type Destination interface {
+	Put(name string, r io.Reader, size int64) error
+	Get(name string) (io.ReadCloser, error)
+	List(prefix string) ([]RemoteFile, error)
+	Delete(name string) error
+	Test() error
+}
+
+// RemoteFile is a file stored on a destination
+type RemoteFile struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// DestinationConfig holds the non-secret settings of a destination.
+// Which fields apply depends on the type.
+type DestinationConfig struct {
+	Path     string `json:"path,omitempty"`     // local, sftp, ftp: base directory
+	Host     string `json:"host,omitempty"`     // sftp, ftp
+	Port     int    `json:"port,omitempty"`     // sftp, ftp
+	Username string `json:"username,omitempty"` // sftp, ftp
+	HostKey  string `json:"host_key,omitempty"` // sftp: SHA256 fingerprint, learned on first test
+	TLS      bool   `json:"tls,omitempty"`      // ftp: explicit FTPS
+	Endpoint string `json:"endpoint,omitempty"` // s3: host[:port]
+	Region   string `json:"region,omitempty"`   // s3
+	Bucket   string `json:"bucket,omitempty"`   // s3
+	Prefix   string `json:"prefix,omitempty"`   // s3: key prefix
+	UseSSL   bool   `json:"use_ssl,omitempty"`  // s3
+}
+
+// DestinationCredentials are stored encrypted with the master key
+type DestinationCredentials struct {
+	Password   string `json:"password,omitempty"`    // sftp, ftp
+	PrivateKey string `json:"private_key,omitempty"` // sftp: PEM
+	AccessKey  string `json:"access_key,omitempty"`  // s3
+	SecretKey  string `json:"secret_key,omitempty"`  // s3
+}
+
+// DestinationRecord is a configured destination in backup_destinations
+type DestinationRecord struct {
+	ID             int64             `json:"id"`
+	Name           string            `json:"name"`
+	Type           string            `json:"type"`
+	Config         DestinationConfig `json:"config"`
+	KeepLocal      bool              `json:"keep_local"`
+	IsDefault      bool              `json:"is_default"`
+	HasCredentials bool              `json:"has_credentials"`
+	CreatedAt      string            `json:"created_at"`
+	UpdatedAt      string            `json:"updated_at"`
+
+	credentials string
+}
+
+// Validate checks that the fields required by the type are set
+func (d *DestinationRecord) Validate() error {
+	if strings.TrimSpace(d.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidDestination)
+	}
+	cfg := d.Config
+	switch d.Type {
+	case DestinationLocal:
+		if !filepath.IsAbs(cfg.Path) {
+			return fmt.Errorf("%w: an absolute path is required", ErrInvalidDestination)
+		}
+	case DestinationSFTP, DestinationFTP:
+		if cfg.Host == "" || cfg.Username == "" {
+			return fmt.Errorf("%w: host and username are required", ErrInvalidDestination)
+		}
+		if cfg.Port < 0 || cfg.Port > 65535 {
+			return fmt.Errorf("%w: invalid port", ErrInvalidDestination)
+		}
+	case DestinationS3:
+		if cfg.Endpoint == "" || cfg.Bucket == "" {
+			return fmt.Errorf("%w: endpoint and bucket are required", ErrInvalidDestination)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidDestination, d.Type)
+	}
+	return nil
+}
+
+const destinationColumns = `id, name, type, config, COALESCE(credentials, ''), keep_local, is_default, created_at, updated_at`
+
+func scanDestination(row rowScanner) (*DestinationRecord, error) {
+	var d DestinationRecord
+	var cfg string
+	err := row.Scan(&d.ID, &d.Name, &d.Type, &cfg, &d.credentials, &d.KeepLocal, &d.IsDefault, &d.CreatedAt, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(cfg), &d.Config); err != nil {
+		return nil, fmt.Errorf("invalid destination config: %w", err)
+	}
+	d.HasCredentials = d.credentials != ""
+	return &d, nil
+}
+
+// ListDestinations returns every configured destination
+func (s *Service) ListDestinations() ([]DestinationRecord, error) {
+	rows, err := s.db.Query(`SELECT ` + destinationColumns + ` FROM backup_destinations ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	destinations := []DestinationRecord{}
+	for rows.Next() {
+		d, err := scanDestination(rows)
+		if err != nil {
+			continue
+		}
+		destinations = append(destinations, *d)
+	}
+	return destinations, nil
+}
+
+// GetDestination returns a single destination
+func (s *Service) GetDestination(id int64) (*DestinationRecord, error) {
+	d, err := scanDestination(s.db.QueryRow(`SELECT `+destinationColumns+` FROM backup_destinations WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrDestinationNotFound
+	}
+	return d, err
+}
+
+// defaultDestination returns the destination used when a job names none
+func (s *Service) defaultDestination() (*DestinationRecord, error) {
+	d, err := scanDestination(s.db.QueryRow(`SELECT ` + destinationColumns + ` FROM backup_destinations WHERE is_default = 1 LIMIT 1`))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return d, err
+}
+
+// SaveDestination creates (ID == 0) or updates a destination. Nil
+// credentials keep the stored ones on update.
+func (s *Service) SaveDestination(d *DestinationRecord, creds *DestinationCredentials) (*DestinationRecord, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+
+	cfg, err := json.Marshal(d.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	var sealed sql.NullString
+	if creds != nil {
+		plain, err := json.Marshal(creds)
+		if err != nil {
+			return nil, err
+		}
+		value, err := s.sealSecret(plain)
+		if err != nil {
+			return nil, err
+		}
+		sealed = sql.NullString{String: value, Valid: true}
+	}
+
+	if d.IsDefault {
+		s.db.Exec("UPDATE backup_destinations SET is_default = 0 WHERE id != ?", d.ID)
+	}
+
+	if d.ID == 0 {
+		result, err := s.db.Exec(`
+			INSERT INTO backup_destinations (name, type, config, credentials, keep_local, is_default)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, d.Name, d.Type, string(cfg), sealed, d.KeepLocal, d.IsDefault)
+		if err != nil {
+			return nil, err
+		}
+		d.ID, _ = result.LastInsertId()
+	} else {
+		result, err := s.db.Exec(`
+			UPDATE backup_destinations SET name = ?, type = ?, config = ?,
+				credentials = COALESCE(?, credentials), keep_local = ?, is_default = ?,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, d.Name, d.Type, string(cfg), sealed, d.KeepLocal, d.IsDefault, d.ID)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, ErrDestinationNotFound
+		}
+	}
+
+	return s.GetDestination(d.ID)
+}
+
+// DeleteDestination removes a destination that no longer holds backups
+func (s *Service) DeleteDestination(id int64) error {
+	var count int
+	s.db.QueryRow(`
+		SELECT COUNT(*) FROM backup_jobs
+		WHERE destination_id = ? AND COALESCE(remote_path, '') != ''
+	`, id).Scan(&count)
+	if count > 0 {
+		return ErrDestinationInUse
+	}
+
+	result, err := s.db.Exec("DELETE FROM backup_destinations WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrDestinationNotFound
+	}
+	s.db.Exec("UPDATE backup_jobs SET destination_id = NULL WHERE destination_id = ?", id)
+	return nil
+}
+
+// credentials decrypts the stored credentials of a destination
+func (s *Service) credentials(d *DestinationRecord) (DestinationCredentials, error) {
+	var creds DestinationCredentials
+	if d.credentials == "" {
+		return creds, nil
+	}
+	plain, err := s.openSecret(d.credentials)
+	if err != nil {
+		return creds, err
+	}
+	err = json.Unmarshal(plain, &creds)
+	return creds, err
+}
+
+// OpenDestination returns the Destination implementation for a record
+func (s *Service) OpenDestination(d *DestinationRecord) (Destination, error) {
+	creds, err := s.credentials(d)
+	if err != nil {
+		return nil, err
+	}
+
+	switch d.Type {
+	case DestinationLocal:
+		return &localDestination{root: d.Config.Path}, nil
+	case DestinationSFTP:
+		return &sftpDestination{cfg: d.Config, creds: creds}, nil
+	case DestinationFTP:
+		return &ftpDestination{cfg: d.Config, creds: creds}, nil
+	case DestinationS3:
+		return newS3Destination(d.Config, creds)
+	}
+	return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidDestination, d.Type)
+}
+
+// TestDestination checks connectivity and write access. An SFTP host key
+// seen for the first time is remembered (trust on first use).
+func (s *Service) TestDestination(id int64) error {
+	d, err := s.GetDestination(id)
+	if err != nil {
+		return err
+	}
+	dest, err := s.OpenDestination(d)
+	if err != nil {
+		return err
+	}
+	if err := dest.Test(); err != nil {
+		return err
+	}
+
+	if sd, ok := dest.(*sftpDestination); ok && d.Config.HostKey == "" && sd.seenHostKey != "" {
+		d.Config.HostKey = sd.seenHostKey
+		if cfg, err := json.Marshal(d.Config); err == nil {
+			s.db.Exec("UPDATE backup_destinations SET config = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", string(cfg), id)
+		}
+		log.Printf("🔑 SFTP host key of %s pinned: %s", d.Config.Host, sd.seenHostKey)
+	}
+	return nil
+}
+
+// testObjectName is written and removed by Test implementations
+func testObjectName() string {
+	return fmt.Sprintf(".serverpanel-test-%d", time.Now().UnixNano())
+}
+
+// remoteName is the destination path of a job's archive
+func remoteName(job *Job) string {
+	return path.Join(job.Username, filepath.Base(job.BackupPath))
+}
+
+// uploadJob copies a completed archive to the job's destination and drops
+// the local copy unless the destination keeps one
+func (s *Service) uploadJob(job *Job) error {
+	d, err := s.GetDestination(job.DestinationID)
+	if err != nil {
+		return err
+	}
+	dest, err := s.OpenDestination(d)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(job.BackupPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	name := remoteName(job)
+	if err := dest.Put(name, f, info.Size()); err != nil {
+		return fmt.Errorf("upload to %s failed: %w", d.Name, err)
+	}
+	s.db.Exec("UPDATE backup_jobs SET remote_path = ?, storage_backend = ? WHERE id = ?", name, d.Type, job.ID)
+	log.Printf("☁️ Backup uploaded: %s -> %s:%s", job.Username, d.Name, name)
+
+	if !d.KeepLocal {
+		f.Close()
+		os.Remove(job.BackupPath)
+	}
+	return nil
+}
+
+// fetchJob downloads a job's archive back from its destination
+func (s *Service) fetchJob(job *Job) error {
+	d, err := s.GetDestination(job.DestinationID)
+	if err != nil {
+		return err
+	}
+	dest, err := s.OpenDestination(d)
+	if err != nil {
+		return err
+	}
+
+	rc, err := dest.Get(job.RemotePath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(filepath.Dir(job.BackupPath), 0700); err != nil {
+		return err
+	}
+	tmp := job.BackupPath + ".download"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	log.Printf("☁️ Backup fetched: %s:%s", d.Name, job.RemotePath)
+	return os.Rename(tmp, job.BackupPath)
+}
+
+// deleteRemote removes a job's archive from its destination
+func (s *Service) deleteRemote(job *Job) error {
+	d, err := s.GetDestination(job.DestinationID)
+	if err != nil {
+		return err
+	}
+	dest, err := s.OpenDestination(d)
+	if err != nil {
+		return err
+	}
+	err = dest.Delete(job.RemotePath)
+	if errors.Is(err, ErrRemoteNotFound) {
+		return nil
+	}
+	return err
+}
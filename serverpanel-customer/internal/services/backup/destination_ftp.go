@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// ftpDestination stores archives on an FTP server, optionally over
+// explicit TLS
+type ftpDestination struct {
+	cfg   DestinationConfig
+	creds DestinationCredentials
+}
+
+func (d *ftpDestination) connect() (*ftp.ServerConn, error) {
+	port := d.cfg.Port
+	if port == 0 {
+		port = 21
+	}
+
+	opts := []ftp.DialOption{ftp.DialWithTimeout(30 * time.Second)}
+	if d.cfg.TLS {
+		opts = append(opts, ftp.DialWithExplicitTLS(&tls.Config{ServerName: d.cfg.Host}))
+	}
+
+	c, err := ftp.Dial(net.JoinHostPort(d.cfg.Host, strconv.Itoa(port)), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Login(d.cfg.Username, d.creds.Password); err != nil {
+		c.Quit()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (d *ftpDestination) resolve(name string) string {
+	base := d.cfg.Path
+	if base == "" {
+		base = "."
+	}
+	return path.Join(base, path.Clean("/" + name)[1:])
+}
+
+// mkdirAll creates every directory of p; existing ones are ignored since
+// FTP has no portable way to tell them apart from other failures
+func (d *ftpDestination) mkdirAll(c *ftp.ServerConn, p string) {
+	dir := ""
+	if strings.HasPrefix(p, "/") {
+		dir = "/"
+	}
+	for _, part := range strings.Split(strings.Trim(p, "/"), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		dir = path.Join(dir, part)
+		c.MakeDir(dir)
+	}
+}
+
+func isFTPNotFound(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code == ftp.StatusFileUnavailable
+}
+
+func (d *ftpDestination) Put(name string, r io.Reader, size int64) error {
+	c, err := d.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Quit()
+
+	target := d.resolve(name)
+	d.mkdirAll(c, path.Dir(target))
+
+	tmp := target + ".part"
+	counter := &countingReader{r: r}
+	if err := c.Stor(tmp, counter); err != nil {
+		c.Delete(tmp)
+		return err
+	}
+	if size >= 0 && counter.n != size {
+		c.Delete(tmp)
+		return fmt.Errorf("short write: %d of %d bytes", counter.n, size)
+	}
+
+	c.Delete(target)
+	if err := c.Rename(tmp, target); err != nil {
+		c.Delete(tmp)
+		return err
+	}
+	return nil
+}
+
+// ftpReader closes the transfer and the control connection together
+type ftpReader struct {
+	*ftp.Response
+	conn *ftp.ServerConn
+}
+
+func (r *ftpReader) Close() error {
+	err := r.Response.Close()
+	r.conn.Quit()
+	return err
+}
+
+func (d *ftpDestination) Get(name string) (io.ReadCloser, error) {
+	c, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Retr(d.resolve(name))
+	if err != nil {
+		c.Quit()
+		if isFTPNotFound(err) {
+			return nil, ErrRemoteNotFound
+		}
+		return nil, err
+	}
+	return &ftpReader{Response: resp, conn: c}, nil
+}
+
+func (d *ftpDestination) List(prefix string) ([]RemoteFile, error) {
+	c, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Quit()
+
+	root := d.resolve("")
+	files := []RemoteFile{}
+	walker := c.Walk(root)
+	for walker.Next() {
+		if err := walker.Err(); err != nil {
+			if walker.Path() == root && isFTPNotFound(err) {
+				break
+			}
+			return nil, err
+		}
+		entry := walker.Stat()
+		if entry.Type != ftp.EntryTypeFile {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+		if !strings.HasPrefix(rel, prefix) || strings.HasSuffix(rel, ".part") {
+			continue
+		}
+		files = append(files, RemoteFile{Name: rel, Size: int64(entry.Size), ModTime: entry.Time})
+	}
+	return files, nil
+}
+
+func (d *ftpDestination) Delete(name string) error {
+	c, err := d.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Quit()
+
+	err = c.Delete(d.resolve(name))
+	if isFTPNotFound(err) {
+		return ErrRemoteNotFound
+	}
+	return err
+}
+
+func (d *ftpDestination) Test() error {
+	name := testObjectName()
+	if err := d.Put(name, strings.NewReader("ok"), 2); err != nil {
+		return err
+	}
+	return d.Delete(name)
+}
@@ -0,0 +1,116 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// localDestination stores archives in a directory on this server, e.g. a
+// second disk or a mounted NFS share
+type localDestination struct {
+	root string
+}
+
+// resolve maps a destination name into the root directory
+func (d *localDestination) resolve(name string) (string, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if clean == "" {
+		return "", fmt.Errorf("%w: empty name", ErrInvalidDestination)
+	}
+	return filepath.Join(d.root, filepath.FromSlash(clean)), nil
+}
+
+func (d *localDestination) Put(name string, r io.Reader, size int64) error {
+	target, err := d.resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	tmp := target + ".part"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("short write: %d of %d bytes", n, size)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+func (d *localDestination) Get(name string) (io.ReadCloser, error) {
+	target, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(target)
+	if os.IsNotExist(err) {
+		return nil, ErrRemoteNotFound
+	}
+	return f, err
+}
+
+func (d *localDestination) List(prefix string) ([]RemoteFile, error) {
+	files := []RemoteFile{}
+	err := filepath.WalkDir(d.root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == d.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, _ := filepath.Rel(d.root, p)
+		rel = filepath.ToSlash(rel)
+		if !strings.HasPrefix(rel, prefix) || strings.HasSuffix(rel, ".part") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, RemoteFile{Name: rel, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+func (d *localDestination) Delete(name string) error {
+	target, err := d.resolve(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(target)
+	if os.IsNotExist(err) {
+		return ErrRemoteNotFound
+	}
+	return err
+}
+
+func (d *localDestination) Test() error {
+	if err := os.MkdirAll(d.root, 0700); err != nil {
+		return err
+	}
+	name := testObjectName()
+	if err := d.Put(name, strings.NewReader("ok"), 2); err != nil {
+		return err
+	}
+	return d.Delete(name)
+}
@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Destination stores archives in an S3-compatible bucket (AWS S3,
+// MinIO, Backblaze B2, Wasabi, ...)
+type s3Destination struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3Destination(cfg DestinationConfig, creds DestinationCredentials) (*s3Destination, error) {
+	endpoint := strings.TrimPrefix(strings.TrimPrefix(cfg.Endpoint, "https://"), "http://")
+	client, err := minio.New(strings.TrimSuffix(endpoint, "/"), &minio.Options{
+		Creds:  credentials.NewStaticV4(creds.AccessKey, creds.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidDestination, err)
+	}
+	return &s3Destination{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(cfg.Prefix, "/"),
+	}, nil
+}
+
+func (d *s3Destination) key(name string) string {
+	name = path.Clean("/" + name)[1:]
+	if d.prefix == "" {
+		return name
+	}
+	return d.prefix + "/" + name
+}
+
+func isS3NotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+func (d *s3Destination) Put(name string, r io.Reader, size int64) error {
+	_, err := d.client.PutObject(context.Background(), d.bucket, d.key(name), r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (d *s3Destination) Get(name string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	// GetObject is lazy; stat first so a missing object is reported here
+	if _, err := d.client.StatObject(ctx, d.bucket, d.key(name), minio.StatObjectOptions{}); err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrRemoteNotFound
+		}
+		return nil, err
+	}
+	return d.client.GetObject(ctx, d.bucket, d.key(name), minio.GetObjectOptions{})
+}
+
+func (d *s3Destination) List(prefix string) ([]RemoteFile, error) {
+	files := []RemoteFile{}
+	keyPrefix := d.prefix
+	if keyPrefix != "" {
+		keyPrefix += "/"
+	}
+
+	for obj := range d.client.ListObjects(context.Background(), d.bucket, minio.ListObjectsOptions{
+		Prefix:    keyPrefix + prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		files = append(files, RemoteFile{
+			Name:    strings.TrimPrefix(obj.Key, keyPrefix),
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		})
+	}
+	return files, nil
+}
+
+func (d *s3Destination) Delete(name string) error {
+	ctx := context.Background()
+	if _, err := d.client.StatObject(ctx, d.bucket, d.key(name), minio.StatObjectOptions{}); err != nil {
+		if isS3NotFound(err) {
+			return ErrRemoteNotFound
+		}
+		return err
+	}
+	return d.client.RemoveObject(ctx, d.bucket, d.key(name), minio.RemoveObjectOptions{})
+}
+
+func (d *s3Destination) Test() error {
+	exists, err := d.client.BucketExists(context.Background(), d.bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", d.bucket)
+	}
+
+	name := testObjectName()
+	if err := d.Put(name, strings.NewReader("ok"), 2); err != nil {
+		return err
+	}
+	return d.Delete(name)
+}
@@ -0,0 +1,216 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpDestination stores archives on a remote host over SFTP
+type sftpDestination struct {
+	cfg   DestinationConfig
+	creds DestinationCredentials
+
+	// seenHostKey is the fingerprint presented by the server when no key
+	// was pinned yet
+	seenHostKey string
+}
+
+// sftpSession closes the SFTP client together with its SSH connection
+type sftpSession struct {
+	*sftp.Client
+	conn *ssh.Client
+}
+
+func (s *sftpSession) Close() error {
+	s.Client.Close()
+	return s.conn.Close()
+}
+
+func (d *sftpDestination) connect() (*sftpSession, error) {
+	var auth []ssh.AuthMethod
+	if d.creds.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if d.creds.Password != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(d.creds.PrivateKey), []byte(d.creds.Password))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(d.creds.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	} else if d.creds.Password != "" {
+		auth = append(auth, ssh.Password(d.creds.Password))
+	}
+
+	port := d.cfg.Port
+	if port == 0 {
+		port = 22
+	}
+
+	conn, err := ssh.Dial("tcp", net.JoinHostPort(d.cfg.Host, strconv.Itoa(port)), &ssh.ClientConfig{
+		User:            d.cfg.Username,
+		Auth:            auth,
+		HostKeyCallback: d.checkHostKey,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sftpSession{Client: client, conn: conn}, nil
+}
+
+func (d *sftpDestination) checkHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+	if d.cfg.HostKey == "" {
+		d.seenHostKey = fingerprint
+		return nil
+	}
+	if fingerprint != d.cfg.HostKey {
+		return fmt.Errorf("host key mismatch for %s: got %s, expected %s", hostname, fingerprint, d.cfg.HostKey)
+	}
+	return nil
+}
+
+func (d *sftpDestination) resolve(name string) string {
+	base := d.cfg.Path
+	if base == "" {
+		base = "."
+	}
+	return path.Join(base, path.Clean("/" + name)[1:])
+}
+
+func (d *sftpDestination) Put(name string, r io.Reader, size int64) error {
+	c, err := d.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	target := d.resolve(name)
+	if err := c.MkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+
+	tmp := target + ".part"
+	f, err := c.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	n, err := f.ReadFrom(r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("short write: %d of %d bytes", n, size)
+	}
+	if err != nil {
+		c.Remove(tmp)
+		return err
+	}
+
+	// Not every server supports posix-rename; fall back to remove + rename
+	if err := c.PosixRename(tmp, target); err != nil {
+		c.Remove(target)
+		if err := c.Rename(tmp, target); err != nil {
+			c.Remove(tmp)
+			return err
+		}
+	}
+	return nil
+}
+
+// sftpReader closes the remote file and the connection together
+type sftpReader struct {
+	*sftp.File
+	session *sftpSession
+}
+
+func (r *sftpReader) Close() error {
+	r.File.Close()
+	return r.session.Close()
+}
+
+func (d *sftpDestination) Get(name string) (io.ReadCloser, error) {
+	c, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Open(d.resolve(name))
+	if err != nil {
+		c.Close()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrRemoteNotFound
+		}
+		return nil, err
+	}
+	return &sftpReader{File: f, session: c}, nil
+}
+
+func (d *sftpDestination) List(prefix string) ([]RemoteFile, error) {
+	c, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	root := d.resolve("")
+	files := []RemoteFile{}
+	walker := c.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if walker.Path() == root && errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			return nil, err
+		}
+		info := walker.Stat()
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), root), "/")
+		if !strings.HasPrefix(rel, prefix) || strings.HasSuffix(rel, ".part") {
+			continue
+		}
+		files = append(files, RemoteFile{Name: rel, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (d *sftpDestination) Delete(name string) error {
+	c, err := d.connect()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	err = c.Remove(d.resolve(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrRemoteNotFound
+	}
+	return err
+}
+
+func (d *sftpDestination) Test() error {
+	name := testObjectName()
+	if err := d.Put(name, strings.NewReader("ok"), 2); err != nil {
+		return err
+	}
+	return d.Delete(name)
+}
@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalDestination(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "backups")
+	d := &localDestination{root: root}
+
+	if err := d.Test(); err != nil {
+		t.Fatalf("Test: %v", err)
+	}
+	files, err := d.List("")
+	if err != nil || len(files) != 0 {
+		t.Fatalf("List after Test = %v, %v; want nothing left behind", files, err)
+	}
+
+	puts := []struct {
+		name    string
+		content string
+	}{
+		{"alice/daily.spbackup", "daily"},
+		{"alice/weekly.spbackup", "weekly"},
+		{"bob/daily.spbackup", "bob"},
+		{"../../escape.spbackup", "escape"},
+	}
+	for _, p := range puts {
+		if err := d.Put(p.name, strings.NewReader(p.content), int64(len(p.content))); err != nil {
+			t.Fatalf("Put(%s): %v", p.name, err)
+		}
+	}
+	// Names can not leave the root
+	if _, err := os.Stat(filepath.Join(root, "escape.spbackup")); err != nil {
+		t.Errorf("../../escape.spbackup was not stored below the root: %v", err)
+	}
+
+	if err := d.Put("short.spbackup", strings.NewReader("abc"), 10); err == nil {
+		t.Error("Put with a short reader succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(root, "short.spbackup.part")); !os.IsNotExist(err) {
+		t.Error("failed Put left its .part file")
+	}
+
+	rc, err := d.Get("alice/weekly.spbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(data) != "weekly" {
+		t.Errorf("Get = %q, want weekly", data)
+	}
+	if _, err := d.Get("alice/missing"); !errors.Is(err, ErrRemoteNotFound) {
+		t.Errorf("Get of a missing file: %v, want ErrRemoteNotFound", err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"alice/", []string{"alice/daily.spbackup", "alice/weekly.spbackup"}},
+		{"bob/", []string{"bob/daily.spbackup"}},
+		{"carol/", nil},
+	}
+	for _, tt := range tests {
+		files, err := d.List(tt.prefix)
+		if err != nil {
+			t.Fatalf("List(%s): %v", tt.prefix, err)
+		}
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("List(%s) = %v, want %v", tt.prefix, names, tt.want)
+		}
+	}
+
+	if err := d.Delete("alice/daily.spbackup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("alice/daily.spbackup"); !errors.Is(err, ErrRemoteNotFound) {
+		t.Errorf("second Delete: %v, want ErrRemoteNotFound", err)
+	}
+	if err := d.Put("", strings.NewReader(""), 0); !errors.Is(err, ErrInvalidDestination) {
+		t.Errorf("Put with an empty name: %v, want ErrInvalidDestination", err)
+	}
+}
+
+func TestRemoteNames(t *testing.T) {
+	tests := []struct {
+		base, name string
+		want       string
+	}{
+		{"", "alice/daily.spbackup", "alice/daily.spbackup"},
+		{"/backups", "alice/daily.spbackup", "/backups/alice/daily.spbackup"},
+		{"/backups", "../../etc/passwd", "/backups/etc/passwd"},
+		{"/backups", "/abs/name", "/backups/abs/name"},
+		{"relative", "a/./b", "relative/a/b"},
+	}
+	for _, tt := range tests {
+		cfg := DestinationConfig{Path: tt.base}
+		if got := (&sftpDestination{cfg: cfg}).resolve(tt.name); got != tt.want {
+			t.Errorf("sftp resolve(%q, %q) = %q, want %q", tt.base, tt.name, got, tt.want)
+		}
+		if got := (&ftpDestination{cfg: cfg}).resolve(tt.name); got != tt.want {
+			t.Errorf("ftp resolve(%q, %q) = %q, want %q", tt.base, tt.name, got, tt.want)
+		}
+	}
+
+	keys := []struct {
+		prefix, name string
+		want         string
+	}{
+		{"", "alice/daily.spbackup", "alice/daily.spbackup"},
+		{"panel", "alice/daily.spbackup", "panel/alice/daily.spbackup"},
+		{"panel", "../x", "panel/x"},
+	}
+	for _, tt := range keys {
+		if got := (&s3Destination{prefix: tt.prefix}).key(tt.name); got != tt.want {
+			t.Errorf("s3 key(%q, %q) = %q, want %q", tt.prefix, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDestinationValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		d    DestinationRecord
+		ok   bool
+	}{
+		{"local", DestinationRecord{Name: "disk", Type: DestinationLocal, Config: DestinationConfig{Path: "/mnt/backup"}}, true},
+		{"local relative path", DestinationRecord{Name: "disk", Type: DestinationLocal, Config: DestinationConfig{Path: "backup"}}, false},
+		{"no name", DestinationRecord{Name: " ", Type: DestinationLocal, Config: DestinationConfig{Path: "/mnt"}}, false},
+		{"sftp", DestinationRecord{Name: "s", Type: DestinationSFTP, Config: DestinationConfig{Host: "h", Username: "u", Port: 22}}, true},
+		{"sftp no host", DestinationRecord{Name: "s", Type: DestinationSFTP, Config: DestinationConfig{Username: "u"}}, false},
+		{"ftp bad port", DestinationRecord{Name: "f", Type: DestinationFTP, Config: DestinationConfig{Host: "h", Username: "u", Port: 70000}}, false},
+		{"s3", DestinationRecord{Name: "b", Type: DestinationS3, Config: DestinationConfig{Endpoint: "s3.example.com", Bucket: "b"}}, true},
+		{"s3 no bucket", DestinationRecord{Name: "b", Type: DestinationS3, Config: DestinationConfig{Endpoint: "s3.example.com"}}, false},
+		{"unknown type", DestinationRecord{Name: "x", Type: "dropbox"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.d.Validate()
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidDestination) {
+			t.Errorf("%s: got %v, want ErrInvalidDestination", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+	"golang.org/x/crypto/argon2"
+)
+
+// Encrypted archive format (.spbackup.enc)
+//
+//	[0:8)      magic "SPBKENC1"
+//	[8:12)     header length, big endian
+//	[12:...)   EncryptionHeader as JSON, zero padded to encHeaderSize
+//	[4096:...) payload
+//
+// The payload is the plain .spbackup stream cut into ChunkSize pieces,
+// each sealed with AES-256-GCM under a random per-archive data key. The
+// nonce of a chunk is nonce_prefix(7) || counter(4) || last(1), so chunks
+// can not be reordered, dropped or cut off without failing to decrypt.
+//
+// The data key itself is only stored wrapped: by a server master key and,
+// when the account has one, by a key derived from the customer's
+// passphrase with Argon2id. Key rotation rewrites the fixed-size header in
+// place and never touches the payload.
+const (
+	EncryptedExtension  = ".enc"
+	EncryptionAlgorithm = "AES-256-GCM"
+
+	encMagic      = "SPBKENC1"
+	encVersion    = 1
+	encHeaderSize = 4096
+	encChunkSize  = 64 * 1024
+	dataKeySize   = 32
+)
+
+// Wrapped key types
+const (
+	KeyTypeMaster     = "master"
+	KeyTypePassphrase = "passphrase"
+)
+
+var (
+	ErrNotEncrypted     = errors.New("backup is not encrypted")
+	ErrNoUsableKey      = errors.New("no available key can decrypt this backup")
+	ErrWrongPassphrase  = errors.New("wrong backup passphrase")
+	ErrCorruptEncrypted = errors.New("encrypted backup is corrupt or truncated")
+)
+
+// EncryptionHeader describes an encrypted archive
+type EncryptionHeader struct {
+	Version     int          `json:"version"`
+	Algorithm   string       `json:"algorithm"`
+	ChunkSize   int          `json:"chunk_size"`
+	FileID      []byte       `json:"file_id"`
+	NoncePrefix []byte       `json:"nonce_prefix"`
+	Keys        []WrappedKey `json:"keys"`
+}
+
+// WrappedKey is the data key sealed with a key encryption key
+type WrappedKey struct {
+	Type  string     `json:"type"`
+	KeyID string     `json:"key_id,omitempty"`
+	KDF   *KDFParams `json:"kdf,omitempty"`
+	Nonce []byte     `json:"nonce"`
+	Key   []byte     `json:"key"`
+}
+
+// KDFParams are the Argon2id parameters of a passphrase
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory"`
+	Threads   uint8  `json:"threads"`
+}
+
+// newKDFParams returns Argon2id parameters with a fresh salt
+func newKDFParams() (*KDFParams, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &KDFParams{
+		Algorithm: "argon2id",
+		Salt:      salt,
+		Time:      3,
+		Memory:    64 * 1024,
+		Threads:   4,
+	}, nil
+}
+
+// deriveKey turns a passphrase into a key encryption key
+func deriveKey(passphrase string, p *KDFParams) ([]byte, error) {
+	if p == nil || p.Algorithm != "argon2id" || len(p.Salt) == 0 || p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+		return nil, fmt.Errorf("unsupported key derivation parameters")
+	}
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, dataKeySize), nil
+}
+
+// wrapKey seals dataKey with kek, bound to the archive's file id
+func wrapKey(kek, dataKey, fileID []byte) (nonce, wrapped []byte, err error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, dataKey, fileID), nil
+}
+
+func unwrapKey(kek []byte, wk WrappedKey, fileID []byte) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(wk.Nonce) != gcm.NonceSize() {
+		return nil, ErrCorruptEncrypted
+	}
+	return gcm.Open(nil, wk.Nonce, wk.Key, fileID)
+}
+
+// newEncryptionHeader creates the header of a new archive together with
+// its random data key. Keys still have to be wrapped by the caller.
+func newEncryptionHeader() (*EncryptionHeader, []byte, error) {
+	h := &EncryptionHeader{
+		Version:     encVersion,
+		Algorithm:   EncryptionAlgorithm,
+		ChunkSize:   encChunkSize,
+		FileID:      make([]byte, 16),
+		NoncePrefix: make([]byte, 7),
+	}
+	dataKey := make([]byte, dataKeySize)
+	for _, b := range [][]byte{h.FileID, h.NoncePrefix, dataKey} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+	}
+	return h, dataKey, nil
+}
+
+// marshal renders the padded on-disk header block
+func (h *EncryptionHeader) marshal() ([]byte, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	if len(encMagic)+4+len(data) > encHeaderSize {
+		return nil, fmt.Errorf("encryption header too large (%d bytes)", len(data))
+	}
+	block := make([]byte, encHeaderSize)
+	copy(block, encMagic)
+	binary.BigEndian.PutUint32(block[len(encMagic):], uint32(len(data)))
+	copy(block[len(encMagic)+4:], data)
+	return block, nil
+}
+
+// readEncryptionHeader reads the header block from the start of r.
+// Returns ErrNotEncrypted if r does not start with the magic.
+func readEncryptionHeader(r io.Reader) (*EncryptionHeader, error) {
+	block := make([]byte, encHeaderSize)
+	n, err := io.ReadFull(r, block)
+	if n < len(encMagic) || string(block[:len(encMagic)]) != encMagic {
+		return nil, ErrNotEncrypted
+	}
+	if err != nil {
+		return nil, ErrCorruptEncrypted
+	}
+
+	size := binary.BigEndian.Uint32(block[len(encMagic):])
+	if int(size) > encHeaderSize-len(encMagic)-4 {
+		return nil, ErrCorruptEncrypted
+	}
+	var h EncryptionHeader
+	if err := json.Unmarshal(block[len(encMagic)+4:len(encMagic)+4+int(size)], &h); err != nil {
+		return nil, ErrCorruptEncrypted
+	}
+	if h.Version != encVersion || h.Algorithm != EncryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported backup encryption: %s v%d", h.Algorithm, h.Version)
+	}
+	if h.ChunkSize <= 0 || h.ChunkSize > 16*1024*1024 || len(h.NoncePrefix) != 7 || len(h.FileID) == 0 {
+		return nil, ErrCorruptEncrypted
+	}
+	return &h, nil
+}
+
+// ReadEncryptionHeader returns the header of an encrypted archive
+func ReadEncryptionHeader(path string) (*EncryptionHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readEncryptionHeader(f)
+}
+
+// writeEncryptionHeader replaces the header of an encrypted archive in
+// place. The block has a fixed size, so the payload is left untouched.
+func writeEncryptionHeader(path string, h *EncryptionHeader) error {
+	block, err := h.marshal()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(block, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// IsEncrypted reports whether the file at path is an encrypted archive
+func IsEncrypted(path string) bool {
+	_, err := ReadEncryptionHeader(path)
+	return err == nil
+}
+
+// Unlocker recovers the data key of an encrypted archive from its header
+type Unlocker func(h *EncryptionHeader) ([]byte, error)
+
+// MasterKeyUnlocker unwraps data keys with the master keys in keyDir
+func MasterKeyUnlocker(keyDir string) Unlocker {
+	ring := keyRing{dir: keyDir}
+	return func(h *EncryptionHeader) ([]byte, error) {
+		for _, wk := range h.Keys {
+			if wk.Type != KeyTypeMaster {
+				continue
+			}
+			kek, err := ring.Get(wk.KeyID)
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if dataKey, err := unwrapKey(kek, wk, h.FileID); err == nil {
+				return dataKey, nil
+			}
+		}
+		return nil, ErrNoUsableKey
+	}
+}
+
+// PassphraseUnlocker unwraps data keys with a customer passphrase, so a
+// downloaded backup can be opened without the server's master key
+func PassphraseUnlocker(passphrase string) Unlocker {
+	return func(h *EncryptionHeader) ([]byte, error) {
+		found := false
+		for _, wk := range h.Keys {
+			if wk.Type != KeyTypePassphrase {
+				continue
+			}
+			found = true
+			kek, err := deriveKey(passphrase, wk.KDF)
+			if err != nil {
+				return nil, err
+			}
+			if dataKey, err := unwrapKey(kek, wk, h.FileID); err == nil {
+				return dataKey, nil
+			}
+		}
+		if !found {
+			return nil, ErrNoUsableKey
+		}
+		return nil, ErrWrongPassphrase
+	}
+}
+
+// serverUnlocker opens archives with this server's master keys
+func serverUnlocker() Unlocker {
+	return MasterKeyUnlocker(filepath.Join(config.Get().DataDir, "keys"))
+}
+
+// encryptWriter seals everything written to it in fixed-size chunks.
+// Close must be called to write the final chunk.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  *EncryptionHeader
+	buf     []byte
+	counter uint32
+}
+
+// newEncryptWriter writes the header block to w and returns a writer for
+// the payload
+func newEncryptWriter(w io.Writer, h *EncryptionHeader, dataKey []byte) (*encryptWriter, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	block, err := h.marshal()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(block); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: h,
+		buf:    make([]byte, 0, h.ChunkSize),
+	}, nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func (e *encryptWriter) sealChunk(last bool) error {
+	if e.counter == ^uint32(0) {
+		return fmt.Errorf("backup too large to encrypt")
+	}
+	out := e.aead.Seal(nil, chunkNonce(e.header.NoncePrefix, e.counter, last), e.buf, e.header.FileID)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, so the last
+		// chunk is always the one sealed by Close
+		if len(e.buf) == e.header.ChunkSize {
+			if err := e.sealChunk(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):e.header.ChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	return e.sealChunk(true)
+}
+
+// decryptReader returns the plaintext of an encrypted payload
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  *EncryptionHeader
+	chunk   []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+func newDecryptReader(r io.Reader, h *EncryptionHeader, dataKey []byte) (*decryptReader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:      bufio.NewReaderSize(r, h.ChunkSize+aead.Overhead()),
+		aead:   aead,
+		header: h,
+		chunk:  make([]byte, h.ChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		_, peekErr := d.r.Peek(1)
+		last = peekErr == io.EOF
+	}
+	if n < d.aead.Overhead() {
+		return ErrCorruptEncrypted
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], chunkNonce(d.header.NoncePrefix, d.counter, last), d.chunk[:n], d.header.FileID)
+	if err != nil {
+		return ErrCorruptEncrypted
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+// archiveReader closes the decrypting reader's file
+type archiveReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (r *archiveReader) Close() error {
+	return r.file.Close()
+}
+
+// openArchive opens an archive for reading, decrypting it on the fly
+// when it is encrypted. unlock defaults to the server master keys.
+func openArchive(path string, unlock Unlocker) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(len(encMagic))
+	if !bytes.Equal(magic, []byte(encMagic)) {
+		return &archiveReader{Reader: br, file: f}, nil
+	}
+
+	h, err := readEncryptionHeader(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if unlock == nil {
+		unlock = serverUnlocker()
+	}
+	dataKey, err := unlock(h)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	dr, err := newDecryptReader(br, h, dataKey)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &archiveReader{Reader: dr, file: f}, nil
+}
+
+// DecryptArchive writes the plain .spbackup of an encrypted archive to dst
+func DecryptArchive(src, dst string, unlock Unlocker) error {
+	if !IsEncrypted(src) {
+		return ErrNotEncrypted
+	}
+	in, err := openArchive(src, unlock)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".part"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,592 @@
+package backup
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/services/dns"
+)
+
+// AccountData is stored as account.json
+type AccountData struct {
+	Username     string          `json:"username"`
+	Email        string          `json:"email"`
+	PasswordHash string          `json:"password_hash"`
+	Active       bool            `json:"active"`
+	HomeDir      string          `json:"home_dir"`
+	CreatedAt    string          `json:"created_at"`
+	Package      *PackageData    `json:"package,omitempty"`
+	Domains      []DomainData    `json:"domains"`
+	Subdomains   []SubdomainData `json:"subdomains"`
+}
+
+type PackageData struct {
+	Name                string `json:"name"`
+	DiskQuota           int64  `json:"disk_quota"`
+	BandwidthQuota      int64  `json:"bandwidth_quota"`
+	MaxDomains          int    `json:"max_domains"`
+	MaxDatabases        int    `json:"max_databases"`
+	MaxEmails           int    `json:"max_emails"`
+	MaxFTP              int    `json:"max_ftp"`
+	MaxPHPMemory        string `json:"max_php_memory"`
+	MaxPHPUpload        string `json:"max_php_upload"`
+	MaxPHPExecutionTime int    `json:"max_php_execution_time"`
+}
+
+type DomainData struct {
+	Name         string `json:"name"`
+	DomainType   string `json:"domain_type"`
+	ParentDomain string `json:"parent_domain,omitempty"`
+	DocumentRoot string `json:"document_root"`
+	PHPVersion   string `json:"php_version"`
+	SSLEnabled   bool   `json:"ssl_enabled"`
+	Active       bool   `json:"active"`
+}
+
+type SubdomainData struct {
+	Name         string `json:"name"`
+	Domain       string `json:"domain"`
+	FullName     string `json:"full_name"`
+	DocumentRoot string `json:"document_root"`
+	RedirectURL  string `json:"redirect_url,omitempty"`
+	RedirectType string `json:"redirect_type,omitempty"`
+}
+
+// DatabaseMeta is stored as databases/<name>.meta.json
+type DatabaseMeta struct {
+	Name  string             `json:"name"`
+	Type  string             `json:"type"`
+	Size  int64              `json:"size"`
+	Users []DatabaseUserData `json:"users"`
+}
+
+type DatabaseUserData struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+}
+
+type EmailAccountData struct {
+	Email        string `json:"email"`
+	Domain       string `json:"domain"`
+	PasswordHash string `json:"password_hash"`
+	QuotaMB      int    `json:"quota_mb"`
+	Active       bool   `json:"active"`
+}
+
+type ForwarderData struct {
+	Domain      string `json:"domain"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Active      bool   `json:"active"`
+}
+
+type AutoresponderData struct {
+	Domain    string `json:"domain"`
+	Email     string `json:"email"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+	StartDate string `json:"start_date,omitempty"`
+	EndDate   string `json:"end_date,omitempty"`
+	Active    bool   `json:"active"`
+}
+
+type DNSRecordData struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Content  string `json:"content"`
+	TTL      int    `json:"ttl"`
+	Priority int    `json:"priority"`
+	Active   bool   `json:"active"`
+}
+
+type FTPAccountData struct {
+	Username          string `json:"username"`
+	PasswordHash      string `json:"password_hash"`
+	HomeDirectory     string `json:"home_directory"`
+	QuotaMB           int    `json:"quota_mb"`
+	UploadBandwidth   int    `json:"upload_bandwidth"`
+	DownloadBandwidth int    `json:"download_bandwidth"`
+	Active            bool   `json:"active"`
+}
+
+type archiveStats struct {
+	FileCount int64
+	KeyID     string
+}
+
+// createArchive writes the .spbackup archive for a job, encrypted to
+// .spbackup.enc when encryption is enabled for the account
+func (s *Service) createArchive(job *Job, started time.Time) (string, *archiveStats, error) {
+	acc, err := s.loadAccount(job.UserID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	backupDir := s.GetBackupDir(job.Username)
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return "", nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	var seal *archiveSeal
+	ext := FileExtension
+	if s.EncryptionEnabled(job.UserID) {
+		if seal, err = s.newArchiveSeal(job.UserID); err != nil {
+			return "", nil, fmt.Errorf("failed to prepare encryption: %w", err)
+		}
+		ext += EncryptedExtension
+	}
+
+	name := fmt.Sprintf("backup-%s-%s", job.Username, started.Format("2006-01-02T15-04-05"))
+	path := filepath.Join(backupDir, name+ext)
+	if _, err := os.Stat(path); err == nil {
+		path = filepath.Join(backupDir, fmt.Sprintf("%s-%d%s", name, job.ID, ext))
+	}
+
+	w, err := newArchiveWriter(path, seal)
+	if err != nil {
+		return "", nil, err
+	}
+
+	manifest := &Manifest{
+		Version:   FormatVersion,
+		Format:    FormatName,
+		CreatedAt: started.UTC(),
+		CreatedBy: s.usernameByID(job.CreatedBy),
+		Type:      job.Type,
+		Account: ManifestAccount{
+			Username:  acc.Username,
+			CreatedAt: acc.CreatedAt,
+		},
+		Checksums: ManifestChecksums{Algorithm: "sha256"},
+	}
+	if seal != nil {
+		manifest.Encryption = ManifestEncryption{
+			Enabled:   true,
+			Algorithm: EncryptionAlgorithm,
+			KeyID:     seal.keyID,
+		}
+	}
+	if acc.Package != nil {
+		manifest.Account.Package = acc.Package.Name
+	}
+	for _, d := range acc.Domains {
+		if manifest.Account.Domain == "" {
+			manifest.Account.Domain = d.Name
+		}
+		if d.DomainType == "primary" {
+			manifest.Account.Domain = d.Name
+			break
+		}
+	}
+
+	stats := &archiveStats{}
+	if seal != nil {
+		stats.KeyID = seal.keyID
+	}
+	if err := s.writeContents(w, job, acc, manifest, stats); err != nil {
+		w.Close()
+		return path, nil, err
+	}
+
+	// checksums.sha256 covers every file written so far; its own hash is
+	// pinned in the manifest so the manifest is the root of trust.
+	checksums := w.checksumFile()
+	manifest.Checksums.ManifestHash = sha256Hex(checksums)
+	if err := w.addBytes(ChecksumsFile, checksums); err != nil {
+		w.Close()
+		return path, nil, err
+	}
+	if err := w.addJSON(ManifestFile, manifest); err != nil {
+		w.Close()
+		return path, nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return path, nil, err
+	}
+	return path, stats, nil
+}
+
+func (s *Service) writeContents(w *archiveWriter, job *Job, acc *AccountData, m *Manifest, stats *archiveStats) error {
+	if err := w.addJSON(AccountFile, acc); err != nil {
+		return err
+	}
+
+	if job.IncludeFiles {
+		homeDir := filepath.Join(s.cfg.HomeBaseDir, job.Username)
+		if _, err := os.Stat(homeDir); err == nil {
+			size, count, err := w.addTree("homedir", homeDir)
+			if err != nil {
+				return fmt.Errorf("homedir backup failed: %w", err)
+			}
+			m.Contents.Homedir = HomedirContents{Included: true, SizeBytes: size, FileCount: count}
+			stats.FileCount += count
+		}
+	}
+
+	if job.IncludeDatabases {
+		if err := s.writeDatabases(w, job, m); err != nil {
+			return fmt.Errorf("database backup failed: %w", err)
+		}
+	}
+
+	if job.IncludeEmail {
+		if err := s.writeEmail(w, job, m); err != nil {
+			return fmt.Errorf("email backup failed: %w", err)
+		}
+	}
+
+	if job.IncludeDNS {
+		if err := s.writeDNS(w, acc, m); err != nil {
+			return fmt.Errorf("dns backup failed: %w", err)
+		}
+	}
+
+	if job.IncludeFTP {
+		if err := s.writeFTP(w, job, m); err != nil {
+			return fmt.Errorf("ftp backup failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) loadAccount(userID int64) (*AccountData, error) {
+	acc := &AccountData{Domains: []DomainData{}, Subdomains: []SubdomainData{}}
+	err := s.db.QueryRow(`
+		SELECT username, email, password, active, created_at FROM users WHERE id = ?
+	`, userID).Scan(&acc.Username, &acc.Email, &acc.PasswordHash, &acc.Active, &acc.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	acc.HomeDir = filepath.Join(s.cfg.HomeBaseDir, acc.Username)
+
+	var pkg PackageData
+	err = s.db.QueryRow(`
+		SELECT p.name, p.disk_quota, p.bandwidth_quota, p.max_domains, p.max_databases,
+		       p.max_emails, p.max_ftp, COALESCE(p.max_php_memory, '256M'),
+		       COALESCE(p.max_php_upload, '64M'), COALESCE(p.max_php_execution_time, 300)
+		FROM user_packages up
+		JOIN packages p ON p.id = up.package_id
+		WHERE up.user_id = ?
+	`, userID).Scan(&pkg.Name, &pkg.DiskQuota, &pkg.BandwidthQuota, &pkg.MaxDomains, &pkg.MaxDatabases,
+		&pkg.MaxEmails, &pkg.MaxFTP, &pkg.MaxPHPMemory, &pkg.MaxPHPUpload, &pkg.MaxPHPExecutionTime)
+	if err == nil {
+		acc.Package = &pkg
+	}
+
+	rows, err := s.db.Query(`
+		SELECT d.name, COALESCE(d.domain_type, 'primary'), COALESCE(p.name, ''),
+		       COALESCE(d.document_root, ''), COALESCE(d.php_version, ''), d.ssl_enabled, d.active
+		FROM domains d
+		LEFT JOIN domains p ON p.id = d.parent_domain_id
+		WHERE d.user_id = ?
+		ORDER BY d.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d DomainData
+		if err := rows.Scan(&d.Name, &d.DomainType, &d.ParentDomain, &d.DocumentRoot,
+			&d.PHPVersion, &d.SSLEnabled, &d.Active); err != nil {
+			continue
+		}
+		acc.Domains = append(acc.Domains, d)
+	}
+
+	subRows, err := s.db.Query(`
+		SELECT s.name, d.name, s.full_name, COALESCE(s.document_root, ''),
+		       COALESCE(s.redirect_url, ''), COALESCE(s.redirect_type, '')
+		FROM subdomains s
+		JOIN domains d ON d.id = s.domain_id
+		WHERE s.user_id = ?
+		ORDER BY s.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer subRows.Close()
+	for subRows.Next() {
+		var sd SubdomainData
+		if err := subRows.Scan(&sd.Name, &sd.Domain, &sd.FullName, &sd.DocumentRoot,
+			&sd.RedirectURL, &sd.RedirectType); err != nil {
+			continue
+		}
+		acc.Subdomains = append(acc.Subdomains, sd)
+	}
+
+	return acc, nil
+}
+
+func (s *Service) writeDatabases(w *archiveWriter, job *Job, m *Manifest) error {
+	rows, err := s.db.Query(`
+		SELECT id, name, COALESCE(type, 'mysql'), COALESCE(size, 0)
+		FROM databases WHERE user_id = ? ORDER BY name
+	`, job.UserID)
+	if err != nil {
+		return err
+	}
+
+	type dbRow struct {
+		id   int64
+		meta DatabaseMeta
+	}
+	var dbs []dbRow
+	for rows.Next() {
+		var r dbRow
+		if err := rows.Scan(&r.id, &r.meta.Name, &r.meta.Type, &r.meta.Size); err != nil {
+			continue
+		}
+		dbs = append(dbs, r)
+	}
+	rows.Close()
+
+	m.Contents.Databases.Included = true
+	for _, d := range dbs {
+		meta := d.meta
+		meta.Users = []DatabaseUserData{}
+
+		userRows, err := s.db.Query(`
+			SELECT db_username, COALESCE(password, ''), COALESCE(host, 'localhost')
+			FROM database_users WHERE database_id = ?
+		`, d.id)
+		if err == nil {
+			for userRows.Next() {
+				var u DatabaseUserData
+				if userRows.Scan(&u.Username, &u.Password, &u.Host) == nil {
+					meta.Users = append(meta.Users, u)
+				}
+			}
+			userRows.Close()
+		}
+
+		dumpPath, err := s.dumpDatabase(job.Username, meta.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", meta.Name, err)
+		}
+		info, err := os.Stat(dumpPath)
+		if err != nil {
+			os.Remove(dumpPath)
+			return err
+		}
+		err = w.addFile("databases/"+meta.Name+".sql.gz", dumpPath, info)
+		os.Remove(dumpPath)
+		if err != nil {
+			return err
+		}
+		if err := w.addJSON("databases/"+meta.Name+".meta.json", meta); err != nil {
+			return err
+		}
+
+		m.Contents.Databases.Count++
+		m.Contents.Databases.TotalSizeBytes += info.Size()
+		m.Contents.Databases.Names = append(m.Contents.Databases.Names, meta.Name)
+	}
+	return nil
+}
+
+// dumpDatabase writes a gzip-compressed SQL dump to a temporary file.
+// In simulation mode the dump comes from the simulated MySQL directory.
+func (s *Service) dumpDatabase(username, name string) (string, error) {
+	tmp, err := os.CreateTemp(s.GetBackupDir(username), "."+name+"-*.sql.gz")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+
+	if s.cfg.SimulateMode {
+		simFile := filepath.Join(s.cfg.SimulateBasePath, "mysql", name+".sql")
+		if data, err := os.ReadFile(simFile); err == nil {
+			gz.Write(data)
+		} else {
+			fmt.Fprintf(gz, "-- [SIMULATED] dump of database %s\n", name)
+		}
+	} else {
+		mysqlRootPass := os.Getenv("MYSQL_ROOT_PASSWORD")
+		cmd := exec.Command("mysqldump", "-uroot", "-p"+mysqlRootPass,
+			"--single-transaction", "--routines", "--triggers", "--events", name)
+		cmd.Stdout = gz
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			gz.Close()
+			os.Remove(tmp.Name())
+			return "", fmt.Errorf("mysqldump failed: %s - %w", strings.TrimSpace(stderr.String()), err)
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func (s *Service) writeEmail(w *archiveWriter, job *Job, m *Manifest) error {
+	accounts := []EmailAccountData{}
+	rows, err := s.db.Query(`
+		SELECT e.email, d.name, e.password_hash, COALESCE(e.quota_mb, 1024), e.active
+		FROM email_accounts e
+		JOIN domains d ON d.id = e.domain_id
+		WHERE e.user_id = ? ORDER BY e.email
+	`, job.UserID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var a EmailAccountData
+		if rows.Scan(&a.Email, &a.Domain, &a.PasswordHash, &a.QuotaMB, &a.Active) == nil {
+			accounts = append(accounts, a)
+		}
+	}
+	rows.Close()
+
+	forwarders := []ForwarderData{}
+	rows, err = s.db.Query(`
+		SELECT d.name, f.source, f.destination, f.active
+		FROM email_forwarders f
+		JOIN domains d ON d.id = f.domain_id
+		WHERE f.user_id = ? ORDER BY f.source
+	`, job.UserID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var f ForwarderData
+		if rows.Scan(&f.Domain, &f.Source, &f.Destination, &f.Active) == nil {
+			forwarders = append(forwarders, f)
+		}
+	}
+	rows.Close()
+
+	autoresponders := []AutoresponderData{}
+	rows, err = s.db.Query(`
+		SELECT d.name, a.email, a.subject, a.body, COALESCE(a.start_date, ''), COALESCE(a.end_date, ''), a.active
+		FROM email_autoresponders a
+		JOIN domains d ON d.id = a.domain_id
+		WHERE a.user_id = ? ORDER BY a.email
+	`, job.UserID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var a AutoresponderData
+		if rows.Scan(&a.Domain, &a.Email, &a.Subject, &a.Body, &a.StartDate, &a.EndDate, &a.Active) == nil {
+			autoresponders = append(autoresponders, a)
+		}
+	}
+	rows.Close()
+
+	if err := w.addJSON("email/accounts.json", accounts); err != nil {
+		return err
+	}
+	if err := w.addJSON("email/forwarders.json", forwarders); err != nil {
+		return err
+	}
+	if err := w.addJSON("email/autoresponders.json", autoresponders); err != nil {
+		return err
+	}
+
+	m.Contents.Email.Included = true
+	m.Contents.Email.AccountCount = len(accounts)
+	for _, a := range accounts {
+		parts := strings.SplitN(a.Email, "@", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		maildir := filepath.Join(s.GetMailRoot(), parts[1], parts[0])
+		if _, err := os.Stat(maildir); err != nil {
+			continue
+		}
+		size, _, err := w.addTree("email/mailboxes/"+a.Email, maildir)
+		if err != nil {
+			return err
+		}
+		m.Contents.Email.MailboxSizeBytes += size
+		m.Contents.Email.Mailboxes = append(m.Contents.Email.Mailboxes, a.Email)
+	}
+	return nil
+}
+
+func (s *Service) writeDNS(w *archiveWriter, acc *AccountData, m *Manifest) error {
+	dnsManager := dns.NewManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath)
+	m.Contents.DNS.Included = true
+
+	for _, d := range acc.Domains {
+		records := []DNSRecordData{}
+		rows, err := s.db.Query(`
+			SELECT r.name, r.type, r.content, r.ttl, r.priority, r.active
+			FROM dns_records r
+			JOIN domains d ON d.id = r.domain_id
+			WHERE d.name = ? ORDER BY r.type, r.name
+		`, d.Name)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var r DNSRecordData
+			if rows.Scan(&r.Name, &r.Type, &r.Content, &r.TTL, &r.Priority, &r.Active) == nil {
+				records = append(records, r)
+			}
+		}
+		rows.Close()
+
+		if err := w.addJSON("dns/"+d.Name+".json", records); err != nil {
+			return err
+		}
+
+		zoneFile := filepath.Join(dnsManager.GetZonePath(), "db."+d.Name)
+		if info, err := os.Stat(zoneFile); err == nil {
+			if err := w.addFile("dns/"+d.Name+".zone", zoneFile, info); err != nil {
+				return err
+			}
+		}
+
+		m.Contents.DNS.ZoneCount++
+		m.Contents.DNS.Zones = append(m.Contents.DNS.Zones, d.Name)
+	}
+	return nil
+}
+
+func (s *Service) writeFTP(w *archiveWriter, job *Job, m *Manifest) error {
+	accounts := []FTPAccountData{}
+	rows, err := s.db.Query(`
+		SELECT username, password, home_directory, COALESCE(quota_mb, 0),
+		       COALESCE(upload_bandwidth, 0), COALESCE(download_bandwidth, 0), active
+		FROM ftp_accounts WHERE user_id = ? ORDER BY username
+	`, job.UserID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a FTPAccountData
+		if rows.Scan(&a.Username, &a.PasswordHash, &a.HomeDirectory, &a.QuotaMB,
+			&a.UploadBandwidth, &a.DownloadBandwidth, &a.Active) == nil {
+			accounts = append(accounts, a)
+		}
+	}
+
+	m.Contents.FTP = FTPContents{Included: true, AccountCount: len(accounts)}
+	return w.addJSON("ftp/accounts.json", accounts)
+}
+
+func (s *Service) usernameByID(userID int64) string {
+	var username string
+	s.db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	return username
+}
@@ -0,0 +1,735 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChunkSize is the block size used to split files in the chunk store
+const ChunkSize = 4 * 1024 * 1024
+
+// Default retention when an account has no package
+const (
+	DefaultKeepDaily  = 7
+	DefaultKeepWeekly = 4
+)
+
+// Snapshot file types
+const (
+	FileTypeFile    = "file"
+	FileTypeDir     = "dir"
+	FileTypeSymlink = "symlink"
+)
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// chunkStoreMu keeps the prune job from collecting chunks that a running
+// snapshot has written but not referenced yet. Snapshots share the lock.
+var chunkStoreMu sync.RWMutex
+
+// Snapshot is an incremental home directory backup stored in backup_snapshots
+type Snapshot struct {
+	ID              int64  `json:"id"`
+	UserID          int64  `json:"user_id"`
+	Username        string `json:"username"`
+	CreatedBy       int64  `json:"created_by"`
+	BaseSnapshotID  int64  `json:"base_snapshot_id,omitempty"`
+	Status          string `json:"status"`
+	FileCount       int64  `json:"file_count"`
+	TotalSize       int64  `json:"total_size"`
+	ChangedFiles    int64  `json:"changed_files"`
+	NewChunks       int64  `json:"new_chunks"`
+	NewBytes        int64  `json:"new_bytes"`
+	StartedAt       string `json:"started_at,omitempty"`
+	CompletedAt     string `json:"completed_at,omitempty"`
+	DurationSeconds int64  `json:"duration_seconds"`
+	ErrorMessage    string `json:"error_message,omitempty"`
+	CreatedAt       string `json:"created_at"`
+}
+
+// PruneResult summarizes a prune run
+type PruneResult struct {
+	SnapshotsDeleted int   `json:"snapshots_deleted"`
+	ChunksDeleted    int   `json:"chunks_deleted"`
+	BytesFreed       int64 `json:"bytes_freed"`
+}
+
+// snapshotFile is a row of backup_snapshot_files
+type snapshotFile struct {
+	Path       string
+	Type       string
+	Mode       int64
+	Size       int64
+	MTime      int64
+	LinkTarget string
+	Chunks     string
+}
+
+// GetChunkDir returns the root of the content-addressed chunk store
+func (s *Service) GetChunkDir() string {
+	return filepath.Join(s.cfg.DataDir, "backups", "chunks")
+}
+
+func (s *Service) chunkPath(hash string) string {
+	return filepath.Join(s.GetChunkDir(), hash[:2], hash)
+}
+
+// storeChunk writes a block into the chunk store unless it already exists.
+// Returns the chunk hash and the number of bytes newly stored on disk.
+func (s *Service) storeChunk(data []byte) (string, int64, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := s.chunkPath(hash)
+
+	if _, err := os.Stat(path); err == nil {
+		return hash, 0, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", 0, err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		return "", 0, err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", 0, err
+	}
+
+	s.db.Exec(`INSERT OR IGNORE INTO backup_chunks (hash, size, stored_size) VALUES (?, ?, ?)`,
+		hash, len(data), buf.Len())
+	return hash, int64(buf.Len()), nil
+}
+
+// readChunk returns the decompressed content of a chunk
+func (s *Service) readChunk(hash string) ([]byte, error) {
+	if len(hash) != sha256.Size*2 {
+		return nil, fmt.Errorf("invalid chunk hash: %s", hash)
+	}
+	f, err := os.Open(s.chunkPath(hash))
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s missing: %w", hash, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s corrupt: %w", hash, err)
+	}
+	defer gz.Close()
+
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s corrupt: %w", hash, err)
+	}
+	if sha256Hex(data) != hash {
+		return nil, fmt.Errorf("chunk %s failed checksum verification", hash)
+	}
+	return data, nil
+}
+
+// CreateSnapshot registers a pending incremental snapshot for an account
+func (s *Service) CreateSnapshot(userID, createdBy int64) (*Snapshot, error) {
+	var exists int
+	s.db.QueryRow("SELECT COUNT(*) FROM users WHERE id = ? AND role = 'user'", userID).Scan(&exists)
+	if exists == 0 {
+		return nil, ErrAccountNotFound
+	}
+
+	var baseID sql.NullInt64
+	s.db.QueryRow(`
+		SELECT id FROM backup_snapshots WHERE user_id = ? AND status = ?
+		ORDER BY id DESC LIMIT 1
+	`, userID, StatusCompleted).Scan(&baseID)
+
+	result, err := s.db.Exec(`
+		INSERT INTO backup_snapshots (user_id, created_by, base_snapshot_id, status)
+		VALUES (?, ?, ?, ?)
+	`, userID, createdBy, baseID, StatusPending)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return s.GetSnapshot(id)
+}
+
+// RunSnapshot walks the account's home directory and stores only files that
+// changed since the base snapshot. Unchanged files reuse the base's chunks.
+func (s *Service) RunSnapshot(snapshotID int64) error {
+	snap, err := s.GetSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	chunkStoreMu.RLock()
+	defer chunkStoreMu.RUnlock()
+
+	started := time.Now()
+	s.db.Exec(`UPDATE backup_snapshots SET status = ?, started_at = ? WHERE id = ?`,
+		StatusRunning, started.UTC().Format("2006-01-02 15:04:05"), snapshotID)
+
+	log.Printf("💾 Incremental backup started: %s (snapshot %d)", snap.Username, snapshotID)
+
+	err = s.writeSnapshot(snap)
+	duration := int64(time.Since(started).Seconds())
+	completed := time.Now().UTC().Format("2006-01-02 15:04:05")
+
+	if err != nil {
+		s.db.Exec("DELETE FROM backup_snapshot_files WHERE snapshot_id = ?", snapshotID)
+		s.db.Exec(`
+			UPDATE backup_snapshots SET status = ?, completed_at = ?, duration_seconds = ?, error_message = ?
+			WHERE id = ?
+		`, StatusFailed, completed, duration, err.Error(), snapshotID)
+		log.Printf("❌ Incremental backup failed: %s (snapshot %d): %v", snap.Username, snapshotID, err)
+		return err
+	}
+
+	s.db.Exec(`
+		UPDATE backup_snapshots SET status = ?, file_count = ?, total_size = ?, changed_files = ?,
+			new_chunks = ?, new_bytes = ?, completed_at = ?, duration_seconds = ?
+		WHERE id = ?
+	`, StatusCompleted, snap.FileCount, snap.TotalSize, snap.ChangedFiles,
+		snap.NewChunks, snap.NewBytes, completed, duration, snapshotID)
+
+	log.Printf("✅ Incremental backup completed: %s (snapshot %d, %d/%d files changed, %d bytes stored)",
+		snap.Username, snapshotID, snap.ChangedFiles, snap.FileCount, snap.NewBytes)
+	return nil
+}
+
+// Snapshot creates and runs an incremental snapshot synchronously
+func (s *Service) Snapshot(userID, createdBy int64) (*Snapshot, error) {
+	snap, err := s.CreateSnapshot(userID, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.RunSnapshot(snap.ID); err != nil {
+		return nil, err
+	}
+	return s.GetSnapshot(snap.ID)
+}
+
+func (s *Service) writeSnapshot(snap *Snapshot) error {
+	homeDir := filepath.Join(s.cfg.HomeBaseDir, snap.Username)
+	if _, err := os.Stat(homeDir); err != nil {
+		return fmt.Errorf("home directory not found: %w", err)
+	}
+
+	base := map[string]snapshotFile{}
+	if snap.BaseSnapshotID > 0 {
+		files, err := s.snapshotFiles(snap.BaseSnapshotID, "")
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			base[f.Path] = f
+		}
+	}
+
+	var entries []snapshotFile
+	err := filepath.Walk(homeDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Unreadable entries are skipped, not fatal
+			return nil
+		}
+		rel, err := filepath.Rel(homeDir, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+
+		f := snapshotFile{
+			Path:  filepath.ToSlash(rel),
+			Mode:  int64(info.Mode().Perm()),
+			MTime: info.ModTime().Unix(),
+		}
+
+		switch {
+		case info.IsDir():
+			f.Type = FileTypeDir
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return nil
+			}
+			f.Type = FileTypeSymlink
+			f.LinkTarget = target
+		case info.Mode().IsRegular():
+			f.Type = FileTypeFile
+			f.Size = info.Size()
+			if prev, ok := base[f.Path]; ok && prev.Type == FileTypeFile &&
+				prev.Size == f.Size && prev.MTime == f.MTime {
+				f.Chunks = prev.Chunks
+			} else {
+				chunks, err := s.storeFile(path, snap)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				f.Chunks = chunks
+				snap.ChangedFiles++
+			}
+			snap.FileCount++
+			snap.TotalSize += f.Size
+		default:
+			// Sockets, devices and pipes are not backed up
+			return nil
+		}
+
+		entries = append(entries, f)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// File rows are written in one short transaction after the walk so the
+	// database is not locked while the home directory is being read
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO backup_snapshot_files (snapshot_id, path, type, mode, size, mtime, link_target, chunks)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, f := range entries {
+		if _, err := stmt.Exec(snap.ID, f.Path, f.Type, f.Mode, f.Size, f.MTime, f.LinkTarget, f.Chunks); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// storeFile splits a file into chunks and returns the comma separated hash list
+func (s *Service) storeFile(path string, snap *Snapshot) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var hashes []string
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			hash, stored, storeErr := s.storeChunk(buf[:n])
+			if storeErr != nil {
+				return "", storeErr
+			}
+			if stored > 0 {
+				snap.NewChunks++
+				snap.NewBytes += stored
+			}
+			hashes = append(hashes, hash)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(hashes, ","), nil
+}
+
+// snapshotFiles returns the entries of a snapshot, optionally limited to a
+// path and everything below it
+func (s *Service) snapshotFiles(snapshotID int64, prefix string) ([]snapshotFile, error) {
+	query := `
+		SELECT path, type, mode, size, mtime, COALESCE(link_target, ''), COALESCE(chunks, '')
+		FROM backup_snapshot_files WHERE snapshot_id = ?`
+	args := []interface{}{snapshotID}
+	if prefix != "" {
+		query += ` AND (path = ? OR path LIKE ? ESCAPE '\')`
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix)
+		args = append(args, prefix, escaped+"/%")
+	}
+	query += ` ORDER BY path`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []snapshotFile
+	for rows.Next() {
+		var f snapshotFile
+		if err := rows.Scan(&f.Path, &f.Type, &f.Mode, &f.Size, &f.MTime, &f.LinkTarget, &f.Chunks); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// RestoreSnapshotPath restores a file or directory from a snapshot into the
+// account's home directory. An empty relPath restores the whole home.
+func (s *Service) RestoreSnapshotPath(snapshotID int64, relPath string) (*RestoreResult, error) {
+	snap, err := s.GetSnapshot(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if snap.Status != StatusCompleted {
+		return nil, ErrJobNotReady
+	}
+
+	relPath = strings.Trim(filepath.ToSlash(filepath.Clean("/"+relPath)), "/")
+	files, err := s.snapshotFiles(snapshotID, relPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrItemNotInBackup
+	}
+
+	homeDir := filepath.Join(s.cfg.HomeBaseDir, snap.Username)
+	if err := os.MkdirAll(homeDir, 0711); err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{Type: RestoreFile, Item: "/" + relPath, Target: filepath.Join(homeDir, filepath.FromSlash(relPath))}
+	for _, f := range files {
+		target, err := securePath(homeDir, f.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch f.Type {
+		case FileTypeDir:
+			if err := os.MkdirAll(target, os.FileMode(f.Mode)); err != nil {
+				return nil, err
+			}
+		case FileTypeSymlink:
+			os.Remove(target)
+			if err := os.Symlink(f.LinkTarget, target); err != nil {
+				return nil, err
+			}
+		case FileTypeFile:
+			n, err := s.restoreSnapshotFile(f, target)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Path, err)
+			}
+			result.Files++
+			result.Bytes += n
+		}
+	}
+
+	s.chown(snap.Username+":"+snap.Username, result.Target)
+
+	log.Printf("♻️ Restored %s from snapshot %d -> %s (%d files)", "/"+relPath, snapshotID, result.Target, result.Files)
+	return result, nil
+}
+
+func (s *Service) restoreSnapshotFile(f snapshotFile, target string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	// Never write through an existing symlink
+	if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(target)
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(f.Mode))
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	if f.Chunks != "" {
+		for _, hash := range strings.Split(f.Chunks, ",") {
+			data, err := s.readChunk(hash)
+			if err != nil {
+				out.Close()
+				return written, err
+			}
+			n, err := out.Write(data)
+			written += int64(n)
+			if err != nil {
+				out.Close()
+				return written, err
+			}
+		}
+	}
+	if err := out.Close(); err != nil {
+		return written, err
+	}
+
+	mtime := time.Unix(f.MTime, 0)
+	os.Chtimes(target, mtime, mtime)
+	return written, nil
+}
+
+const snapshotColumns = `
+	s.id, s.user_id, u.username, COALESCE(s.created_by, 0), COALESCE(s.base_snapshot_id, 0), s.status,
+	COALESCE(s.file_count, 0), COALESCE(s.total_size, 0), COALESCE(s.changed_files, 0),
+	COALESCE(s.new_chunks, 0), COALESCE(s.new_bytes, 0),
+	COALESCE(s.started_at, ''), COALESCE(s.completed_at, ''), COALESCE(s.duration_seconds, 0),
+	COALESCE(s.error_message, ''), s.created_at`
+
+func scanSnapshot(row rowScanner) (*Snapshot, error) {
+	var sn Snapshot
+	err := row.Scan(&sn.ID, &sn.UserID, &sn.Username, &sn.CreatedBy, &sn.BaseSnapshotID, &sn.Status,
+		&sn.FileCount, &sn.TotalSize, &sn.ChangedFiles,
+		&sn.NewChunks, &sn.NewBytes,
+		&sn.StartedAt, &sn.CompletedAt, &sn.DurationSeconds,
+		&sn.ErrorMessage, &sn.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &sn, nil
+}
+
+// GetSnapshot returns a single snapshot
+func (s *Service) GetSnapshot(snapshotID int64) (*Snapshot, error) {
+	row := s.db.QueryRow(`SELECT `+snapshotColumns+`
+		FROM backup_snapshots s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = ?`, snapshotID)
+
+	snap, err := scanSnapshot(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrSnapshotNotFound
+	}
+	return snap, err
+}
+
+// ListSnapshots returns snapshots, optionally filtered by account (userID > 0)
+func (s *Service) ListSnapshots(userID int64) ([]Snapshot, error) {
+	query := `SELECT ` + snapshotColumns + `
+		FROM backup_snapshots s
+		JOIN users u ON u.id = s.user_id`
+	var args []interface{}
+	if userID > 0 {
+		query += ` WHERE s.user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY s.created_at DESC, s.id DESC`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snapshots := []Snapshot{}
+	for rows.Next() {
+		snap, err := scanSnapshot(rows)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, *snap)
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes a snapshot. Its chunks are freed by the next prune.
+func (s *Service) DeleteSnapshot(snapshotID int64) error {
+	snap, err := s.GetSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+	if snap.Status == StatusRunning {
+		return fmt.Errorf("snapshot is still running")
+	}
+
+	s.db.Exec("DELETE FROM backup_snapshot_files WHERE snapshot_id = ?", snapshotID)
+	_, err = s.db.Exec("DELETE FROM backup_snapshots WHERE id = ?", snapshotID)
+	if err == nil {
+		log.Printf("🗑️ Snapshot deleted: %s (snapshot %d)", snap.Username, snapshotID)
+	}
+	return err
+}
+
+// Prune applies each account's package retention (keep the newest snapshot
+// of the last N days and of the last M weeks), drops failed snapshots and
+// removes chunks no longer referenced by any snapshot.
+func (s *Service) Prune() (*PruneResult, error) {
+	chunkStoreMu.Lock()
+	defer chunkStoreMu.Unlock()
+
+	result := &PruneResult{}
+
+	rows, err := s.db.Query(`
+		SELECT DISTINCT s.user_id,
+		       COALESCE(p.backup_keep_daily, ?), COALESCE(p.backup_keep_weekly, ?)
+		FROM backup_snapshots s
+		LEFT JOIN user_packages up ON up.user_id = s.user_id
+		LEFT JOIN packages p ON p.id = up.package_id
+	`, DefaultKeepDaily, DefaultKeepWeekly)
+	if err != nil {
+		return nil, err
+	}
+	type retention struct {
+		userID        int64
+		daily, weekly int
+	}
+	var policies []retention
+	for rows.Next() {
+		var r retention
+		if rows.Scan(&r.userID, &r.daily, &r.weekly) == nil {
+			policies = append(policies, r)
+		}
+	}
+	rows.Close()
+
+	for _, p := range policies {
+		expired, err := s.expiredSnapshots(p.userID, p.daily, p.weekly)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range expired {
+			s.db.Exec("DELETE FROM backup_snapshot_files WHERE snapshot_id = ?", id)
+			if _, err := s.db.Exec("DELETE FROM backup_snapshots WHERE id = ?", id); err == nil {
+				result.SnapshotsDeleted++
+			}
+		}
+	}
+
+	chunks, freed, err := s.collectChunks()
+	if err != nil {
+		return nil, err
+	}
+	result.ChunksDeleted = chunks
+	result.BytesFreed = freed
+
+	log.Printf("🧹 Backup prune: %d snapshots, %d chunks deleted (%d bytes freed)",
+		result.SnapshotsDeleted, result.ChunksDeleted, result.BytesFreed)
+	return result, nil
+}
+
+// expiredSnapshots returns the snapshots of an account that fall outside
+// its retention window
+func (s *Service) expiredSnapshots(userID int64, keepDaily, keepWeekly int) ([]int64, error) {
+	rows, err := s.db.Query(`
+		SELECT id, status, created_at FROM backup_snapshots
+		WHERE user_id = ? AND status IN (?, ?)
+		ORDER BY created_at DESC, id DESC
+	`, userID, StatusCompleted, StatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	var expired []int64
+	for rows.Next() {
+		var id int64
+		var status string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &status, &createdAt); err != nil {
+			continue
+		}
+		if status == StatusFailed {
+			expired = append(expired, id)
+			continue
+		}
+
+		keep := false
+		day := createdAt.Format("2006-01-02")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep = true
+		}
+		year, week := createdAt.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[weekKey] && len(weeks) < keepWeekly {
+			weeks[weekKey] = true
+			keep = true
+		}
+		if !keep {
+			expired = append(expired, id)
+		}
+	}
+	return expired, nil
+}
+
+// collectChunks deletes chunks that no snapshot references anymore
+func (s *Service) collectChunks() (int, int64, error) {
+	referenced := map[string]bool{}
+	rows, err := s.db.Query(`SELECT chunks FROM backup_snapshot_files WHERE type = ? AND chunks != ''`, FileTypeFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	for rows.Next() {
+		var chunks string
+		if rows.Scan(&chunks) == nil {
+			for _, hash := range strings.Split(chunks, ",") {
+				referenced[hash] = true
+			}
+		}
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`SELECT hash, stored_size FROM backup_chunks`)
+	if err != nil {
+		return 0, 0, err
+	}
+	type chunk struct {
+		hash string
+		size int64
+	}
+	var unused []chunk
+	for rows.Next() {
+		var c chunk
+		if rows.Scan(&c.hash, &c.size) == nil && !referenced[c.hash] {
+			unused = append(unused, c)
+		}
+	}
+	rows.Close()
+
+	var count int
+	var freed int64
+	for _, c := range unused {
+		if err := os.Remove(s.chunkPath(c.hash)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Could not remove chunk %s: %v", c.hash, err)
+			continue
+		}
+		s.db.Exec("DELETE FROM backup_chunks WHERE hash = ?", c.hash)
+		count++
+		freed += c.size
+	}
+	return count, freed, nil
+}
+
+// StartPruneWorker runs Prune periodically in the background
+func (s *Service) StartPruneWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := s.Prune(); err != nil {
+				log.Printf("❌ Backup prune failed: %v", err)
+			}
+		}
+	}()
+}
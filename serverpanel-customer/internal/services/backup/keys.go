@@ -0,0 +1,339 @@
+package backup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// MinPassphraseLength is the shortest accepted backup passphrase
+const MinPassphraseLength = 10
+
+var ErrWeakPassphrase = fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+
+// archiveSeal is the encryption state of an archive being written
+type archiveSeal struct {
+	header  *EncryptionHeader
+	dataKey []byte
+	keyID   string
+}
+
+// MasterKeyInfo describes a key of the master key ring
+type MasterKeyInfo struct {
+	ID        string `json:"id"`
+	Current   bool   `json:"current"`
+	CreatedAt string `json:"created_at"`
+	Backups   int    `json:"backups"`
+}
+
+// RewrapResult summarises a re-wrap run over encrypted backups
+type RewrapResult struct {
+	KeyID     string   `json:"key_id"`
+	Rewrapped int      `json:"rewrapped"`
+	Resealed  int      `json:"resealed"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// EncryptionEnabled reports whether new backups of an account are
+// encrypted: server wide through the backup_encryption setting, or
+// because the account set a backup passphrase
+func (s *Service) EncryptionEnabled(userID int64) bool {
+	var value string
+	s.db.QueryRow("SELECT value FROM server_settings WHERE key = 'backup_encryption'").Scan(&value)
+	return value == "true" || s.HasPassphrase(userID)
+}
+
+// newArchiveSeal creates the data key of a new archive, wrapped for the
+// current master key and the account passphrase
+func (s *Service) newArchiveSeal(userID int64) (*archiveSeal, error) {
+	h, dataKey, err := newEncryptionHeader()
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := s.wrapDataKey(h, dataKey, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveSeal{header: h, dataKey: dataKey, keyID: keyID}, nil
+}
+
+// wrapDataKey replaces the wrapped keys of h and returns the id of the
+// master key used
+func (s *Service) wrapDataKey(h *EncryptionHeader, dataKey []byte, userID int64) (string, error) {
+	keyID, masterKey, err := s.keys().Current()
+	if err != nil {
+		return "", err
+	}
+	nonce, wrapped, err := wrapKey(masterKey, dataKey, h.FileID)
+	if err != nil {
+		return "", err
+	}
+	keys := []WrappedKey{{Type: KeyTypeMaster, KeyID: keyID, Nonce: nonce, Key: wrapped}}
+
+	kdf, kek, err := s.accountKey(userID)
+	if err != nil {
+		return "", err
+	}
+	if kek != nil {
+		nonce, wrapped, err := wrapKey(kek, dataKey, h.FileID)
+		if err != nil {
+			return "", err
+		}
+		keys = append(keys, WrappedKey{Type: KeyTypePassphrase, KDF: kdf, Nonce: nonce, Key: wrapped})
+	}
+
+	h.Keys = keys
+	return keyID, nil
+}
+
+// accountKey returns the passphrase key of an account (nil if none)
+func (s *Service) accountKey(userID int64) (*KDFParams, []byte, error) {
+	var kdfJSON, sealed string
+	err := s.db.QueryRow("SELECT kdf, wrapped_key FROM backup_encryption_keys WHERE user_id = ?", userID).Scan(&kdfJSON, &sealed)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var kdf KDFParams
+	if err := json.Unmarshal([]byte(kdfJSON), &kdf); err != nil {
+		return nil, nil, fmt.Errorf("invalid passphrase parameters: %w", err)
+	}
+	kek, err := s.openSecret(sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &kdf, kek, nil
+}
+
+// HasPassphrase reports whether an account has a backup passphrase
+func (s *Service) HasPassphrase(userID int64) bool {
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM backup_encryption_keys WHERE user_id = ?", userID).Scan(&count)
+	return count > 0
+}
+
+// SetPassphrase sets the backup passphrase of an account. Only the
+// derived key is stored; existing backups pick it up with RewrapJobs.
+func (s *Service) SetPassphrase(userID int64, passphrase string) error {
+	if len(passphrase) < MinPassphraseLength {
+		return ErrWeakPassphrase
+	}
+
+	kdf, err := newKDFParams()
+	if err != nil {
+		return err
+	}
+	kek, err := deriveKey(passphrase, kdf)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.sealSecret(kek)
+	if err != nil {
+		return err
+	}
+	kdfJSON, _ := json.Marshal(kdf)
+
+	_, err = s.db.Exec(`
+		INSERT INTO backup_encryption_keys (user_id, kdf, wrapped_key) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET kdf = excluded.kdf, wrapped_key = excluded.wrapped_key,
+			updated_at = CURRENT_TIMESTAMP
+	`, userID, string(kdfJSON), sealed)
+	return err
+}
+
+// RemovePassphrase removes the backup passphrase of an account
+func (s *Service) RemovePassphrase(userID int64) error {
+	_, err := s.db.Exec("DELETE FROM backup_encryption_keys WHERE user_id = ?", userID)
+	return err
+}
+
+// ListMasterKeys returns the keys of the master key ring
+func (s *Service) ListMasterKeys() ([]MasterKeyInfo, error) {
+	ring := s.keys()
+	ids, err := ring.IDs()
+	if err != nil {
+		return nil, err
+	}
+	current, _ := ring.CurrentID()
+
+	keys := []MasterKeyInfo{}
+	for _, id := range ids {
+		info := MasterKeyInfo{ID: id, Current: id == current}
+		if st, err := os.Stat(ring.path(id)); err == nil {
+			info.CreatedAt = st.ModTime().UTC().Format("2006-01-02 15:04:05")
+		}
+		s.db.QueryRow("SELECT COUNT(*) FROM backup_jobs WHERE encrypted = 1 AND encryption_key_id = ?", id).Scan(&info.Backups)
+		keys = append(keys, info)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt < keys[j].CreatedAt })
+	return keys, nil
+}
+
+// RotateMasterKey makes a new master key current, re-seals the stored
+// secrets and re-wraps the data keys of every encrypted backup. Payloads
+// are not re-encrypted. Old keys stay in the ring so copies that could
+// not be re-wrapped remain readable.
+func (s *Service) RotateMasterKey(logf func(format string, args ...interface{})) (*RewrapResult, error) {
+	keyID, _, err := s.keys().Generate()
+	if err != nil {
+		return nil, err
+	}
+	logf("🔑 New master key: %s", keyID)
+	log.Printf("🔑 Backup master key rotated: %s", keyID)
+
+	result := &RewrapResult{KeyID: keyID, Errors: []string{}}
+
+	// Destination credentials and passphrase keys are sealed in SQLite
+	reseal := func(table, column, idColumn string) {
+		rows, err := s.db.Query(fmt.Sprintf("SELECT %s, %s FROM %s WHERE COALESCE(%s, '') != ''", idColumn, column, table, column))
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", table, err))
+			return
+		}
+		type sealedRow struct {
+			id    int64
+			value string
+		}
+		var values []sealedRow
+		for rows.Next() {
+			var r sealedRow
+			if rows.Scan(&r.id, &r.value) == nil && sealedKeyID(r.value) != keyID {
+				values = append(values, r)
+			}
+		}
+		rows.Close()
+
+		for _, r := range values {
+			plain, err := s.openSecret(r.value)
+			if err == nil {
+				r.value, err = s.sealSecret(plain)
+			}
+			if err == nil {
+				_, err = s.db.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", table, column, idColumn), r.value, r.id)
+			}
+			if err != nil {
+				result.Failed++
+				result.Errors = append(result.Errors, fmt.Sprintf("%s #%d: %v", table, r.id, err))
+				continue
+			}
+			result.Resealed++
+		}
+	}
+	reseal("backup_destinations", "credentials", "id")
+	reseal("backup_encryption_keys", "wrapped_key", "user_id")
+	logf("🔐 Re-sealed %d stored secrets", result.Resealed)
+
+	rewrapped, err := s.RewrapJobs(0, logf)
+	if err != nil {
+		return result, err
+	}
+	result.Rewrapped = rewrapped.Rewrapped
+	result.Failed += rewrapped.Failed
+	result.Errors = append(result.Errors, rewrapped.Errors...)
+	return result, nil
+}
+
+// RewrapJobs re-wraps the data keys of the encrypted backups of an
+// account (userID 0 = every account) with the current master key and
+// passphrase. Remote-only copies are fetched, re-wrapped and uploaded.
+func (s *Service) RewrapJobs(userID int64, logf func(format string, args ...interface{})) (*RewrapResult, error) {
+	jobs, err := s.ListJobs(userID)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := s.keys().CurrentID()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RewrapResult{KeyID: keyID, Errors: []string{}}
+	for i := range jobs {
+		job := &jobs[i]
+		if !job.Encrypted || job.Status != StatusCompleted {
+			continue
+		}
+		if err := s.rewrapJob(job); err != nil {
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s #%d: %v", job.Username, job.ID, err))
+			logf("⚠️ %s (#%d): %v", job.FileName, job.ID, err)
+			continue
+		}
+		result.Rewrapped++
+		logf("✓ %s (#%d)", job.FileName, job.ID)
+	}
+	logf("🔁 Re-wrapped %d backups, %d failed", result.Rewrapped, result.Failed)
+	return result, nil
+}
+
+func (s *Service) rewrapJob(job *Job) error {
+	remoteOnly := false
+	if _, err := os.Stat(job.BackupPath); err != nil {
+		if !os.IsNotExist(err) || job.RemotePath == "" {
+			return err
+		}
+		if err := s.fetchJob(job); err != nil {
+			return err
+		}
+		remoteOnly = true
+	}
+
+	h, err := ReadEncryptionHeader(job.BackupPath)
+	if err != nil {
+		return err
+	}
+	dataKey, err := MasterKeyUnlocker(s.GetKeyDir())(h)
+	if err != nil {
+		return err
+	}
+	keyID, err := s.wrapDataKey(h, dataKey, job.UserID)
+	if err != nil {
+		return err
+	}
+	if err := writeEncryptionHeader(job.BackupPath, h); err != nil {
+		return err
+	}
+	s.db.Exec("UPDATE backup_jobs SET encryption_key_id = ? WHERE id = ?", keyID, job.ID)
+
+	// The remote copy still carries the old header
+	if job.RemotePath != "" {
+		err := s.uploadJob(job)
+		if err != nil && remoteOnly {
+			os.Remove(job.BackupPath)
+		}
+		return err
+	}
+	return nil
+}
+
+// VerifyJob checks that a backup decrypts (with the passphrase, when one
+// is given) and that its contents match the manifest checksums
+func (s *Service) VerifyJob(jobID int64, passphrase string) (*VerifyResult, error) {
+	path, err := s.GetArchivePath(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	var unlock Unlocker
+	if passphrase != "" {
+		unlock = PassphraseUnlocker(passphrase)
+	}
+	started := time.Now()
+	result, err := VerifyArchive(path, unlock)
+	if err != nil {
+		return nil, err
+	}
+	if result.Valid {
+		log.Printf("✅ Backup verified: job %d (%s)", jobID, time.Since(started).Round(time.Millisecond))
+	} else {
+		log.Printf("❌ Backup verification failed: job %d: %s", jobID, result.Error)
+	}
+	return result, nil
+}
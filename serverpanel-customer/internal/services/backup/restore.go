@@ -0,0 +1,279 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+	"github.com/asergenalkan/serverpanel/internal/services/dns"
+)
+
+// Restore item types
+const (
+	RestoreFile     = "file"
+	RestoreDatabase = "database"
+	RestoreMailbox  = "mailbox"
+	RestoreDNSZone  = "dns"
+)
+
+var (
+	ErrItemNotInBackup = errors.New("item not found in backup")
+	ErrNotOwner        = errors.New("item does not belong to this account")
+)
+
+// RestoreResult describes what a granular restore wrote back
+type RestoreResult struct {
+	Type   string `json:"type"`
+	Item   string `json:"item"`
+	Target string `json:"target"`
+	Files  int64  `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// openRestore returns a completed job, its archive and manifest
+func (s *Service) openRestore(jobID int64) (*Job, string, *Manifest, error) {
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	path, err := s.GetArchivePath(jobID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return job, path, manifest, nil
+}
+
+// RestoreFile restores a single file or directory from homedir/.
+// relPath is relative to the account's home directory; an empty path
+// restores the whole home directory.
+func (s *Service) RestoreFile(jobID int64, relPath string) (*RestoreResult, error) {
+	job, path, manifest, err := s.openRestore(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if !manifest.Contents.Homedir.Included {
+		return nil, ErrItemNotInBackup
+	}
+
+	relPath = strings.Trim(filepath.ToSlash(filepath.Clean("/"+relPath)), "/")
+	prefix := "homedir"
+	if relPath != "" {
+		prefix += "/" + relPath
+	}
+
+	homeDir := filepath.Join(s.cfg.HomeBaseDir, job.Username)
+	if err := os.MkdirAll(homeDir, 0711); err != nil {
+		return nil, err
+	}
+
+	files, size, err := extractTree(path, prefix, homeDir, relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	target := filepath.Join(homeDir, filepath.FromSlash(relPath))
+	s.chown(job.Username+":"+job.Username, target)
+
+	log.Printf("♻️ Restored %s from backup %d -> %s (%d files)", "homedir/"+relPath, jobID, target, files)
+	return &RestoreResult{Type: RestoreFile, Item: "/" + relPath, Target: target, Files: files, Bytes: size}, nil
+}
+
+// RestoreDatabase loads a single database dump back into MySQL
+func (s *Service) RestoreDatabase(jobID int64, name string) (*RestoreResult, error) {
+	job, path, manifest, err := s.openRestore(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(manifest.Contents.Databases.Names, name) {
+		return nil, ErrItemNotInBackup
+	}
+
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM databases WHERE name = ? AND user_id = ?", name, job.UserID).Scan(&count)
+	if count == 0 {
+		return nil, ErrNotOwner
+	}
+
+	data, err := ReadArchiveFile(path, "databases/"+name+".sql.gz")
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid database dump: %w", err)
+	}
+	defer gz.Close()
+
+	var target string
+	var size int64
+	if s.cfg.SimulateMode {
+		simDir := filepath.Join(s.cfg.SimulateBasePath, "mysql")
+		os.MkdirAll(simDir, 0755)
+		target = filepath.Join(simDir, name+".sql")
+		f, err := os.Create(target)
+		if err != nil {
+			return nil, err
+		}
+		size, err = io.Copy(f, gz)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("🔧 [SIMÜLASYON] mysql %s < %s.sql", name, name)
+	} else {
+		target = name
+		mysqlRootPass := os.Getenv("MYSQL_ROOT_PASSWORD")
+		cmd := exec.Command("mysql", "-uroot", "-p"+mysqlRootPass, name)
+		counter := &countingReader{r: gz}
+		cmd.Stdin = counter
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("mysql import failed: %s - %w", strings.TrimSpace(stderr.String()), err)
+		}
+		size = counter.n
+	}
+
+	log.Printf("♻️ Restored database %s from backup %d", name, jobID)
+	return &RestoreResult{Type: RestoreDatabase, Item: name, Target: target, Files: 1, Bytes: size}, nil
+}
+
+// RestoreMailbox restores a single Maildir under /var/mail/vhosts
+func (s *Service) RestoreMailbox(jobID int64, email string) (*RestoreResult, error) {
+	job, path, manifest, err := s.openRestore(jobID)
+	if err != nil {
+		return nil, err
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if !contains(manifest.Contents.Email.Mailboxes, email) {
+		return nil, ErrItemNotInBackup
+	}
+
+	var domain string
+	err = s.db.QueryRow(`
+		SELECT d.name FROM email_accounts e
+		JOIN domains d ON d.id = e.domain_id
+		WHERE e.email = ? AND e.user_id = ? AND d.user_id = ?
+	`, email, job.UserID, job.UserID).Scan(&domain)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotOwner
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(email, "@", 2)
+	if len(parts) != 2 || parts[1] != domain {
+		return nil, ErrNotOwner
+	}
+
+	maildir := filepath.Join(s.GetMailRoot(), domain, parts[0])
+	if err := os.MkdirAll(maildir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, size, err := extractTree(path, "email/mailboxes/"+email, maildir, "")
+	if err != nil {
+		return nil, err
+	}
+	s.chown("vmail:vmail", maildir)
+
+	log.Printf("♻️ Restored mailbox %s from backup %d (%d messages)", email, jobID, files)
+	return &RestoreResult{Type: RestoreMailbox, Item: email, Target: maildir, Files: files, Bytes: size}, nil
+}
+
+// RestoreDNSZone replaces a domain's DNS records with the ones in the
+// backup. The caller is responsible for regenerating the zone file.
+func (s *Service) RestoreDNSZone(jobID int64, domain string) (*RestoreResult, error) {
+	job, path, manifest, err := s.openRestore(jobID)
+	if err != nil {
+		return nil, err
+	}
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if !contains(manifest.Contents.DNS.Zones, domain) {
+		return nil, ErrItemNotInBackup
+	}
+
+	var domainID int64
+	err = s.db.QueryRow("SELECT id FROM domains WHERE name = ? AND user_id = ?", domain, job.UserID).Scan(&domainID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotOwner
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ReadArchiveFile(path, "dns/"+domain+".json")
+	if err != nil {
+		return nil, err
+	}
+	var records []DNSRecordData
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("invalid dns data: %w", err)
+	}
+
+	if _, err := s.db.Exec("DELETE FROM dns_records WHERE domain_id = ?", domainID); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		_, err := s.db.Exec(`
+			INSERT INTO dns_records (domain_id, name, type, content, ttl, priority, active)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, domainID, r.Name, r.Type, r.Content, r.TTL, r.Priority, r.Active)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dnsSvc := dns.NewService(s.db)
+	if err := dnsSvc.QueueZone(domain, dns.ClusterPush); err == nil {
+		go dnsSvc.SyncCluster()
+	}
+
+	log.Printf("♻️ Restored DNS zone %s from backup %d (%d records)", domain, jobID, len(records))
+	return &RestoreResult{Type: RestoreDNSZone, Item: domain, Target: domain, Files: int64(len(records)), Bytes: int64(len(data))}, nil
+}
+
+// chown fixes ownership of restored files (skipped in simulation mode)
+func (s *Service) chown(owner, path string) {
+	if config.IsDevelopment() || !s.cfg.IsLinux {
+		log.Printf("🔧 [SIMÜLASYON] chown -R %s %s", owner, path)
+		return
+	}
+	exec.Command("chown", "-R", owner, path).Run()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/asergenalkan/serverpanel/internal/services/scheduler"
+)
+
+// KindBackup is the scheduled job kind that runs backup policies
+const KindBackup = "backup"
+
+// SchedulePolicy is the payload of a scheduled backup job
+type SchedulePolicy struct {
+	Options
+
+	// KeepLast is the number of backups made by this schedule that are
+	// kept per account (0 = keep all)
+	KeepLast int `json:"keep_last"`
+}
+
+func init() {
+	scheduler.Register(KindBackup, scheduler.Kind{
+		Validate: validateSchedule,
+		Run:      runSchedule,
+	})
+}
+
+// ParseSchedulePolicy decodes the payload of a scheduled backup job
+func ParseSchedulePolicy(payload []byte) (*SchedulePolicy, error) {
+	var p SchedulePolicy
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("invalid backup policy: %w", err)
+	}
+	if !p.IncludeFiles && !p.IncludeDatabases && !p.IncludeEmail && !p.IncludeDNS && !p.IncludeFTP {
+		return nil, fmt.Errorf("backup policy must include at least one component")
+	}
+	if p.KeepLast < 0 {
+		return nil, fmt.Errorf("keep_last can not be negative")
+	}
+	return &p, nil
+}
+
+func validateSchedule(db scheduler.DB, job *scheduler.Job) error {
+	p, err := ParseSchedulePolicy(job.Payload)
+	if err != nil {
+		return err
+	}
+
+	if job.UserID > 0 {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM users WHERE id = ? AND role = 'user'", job.UserID).Scan(&count)
+		if count == 0 {
+			return ErrAccountNotFound
+		}
+	}
+	if p.DestinationID > 0 {
+		if _, err := NewService(db).GetDestination(p.DestinationID); err != nil {
+			return err
+		}
+	}
+
+	// Store the normalised policy
+	job.Payload, err = json.Marshal(p)
+	return err
+}
+
+type scheduledAccount struct {
+	id       int64
+	username string
+}
+
+// scheduleAccounts returns the accounts a schedule covers: one account,
+// or every active account for server wide schedules
+func (s *Service) scheduleAccounts(userID int64) ([]scheduledAccount, error) {
+	query := "SELECT id, username FROM users WHERE role = 'user' AND active = 1"
+	var args []interface{}
+	if userID > 0 {
+		query = "SELECT id, username FROM users WHERE role = 'user' AND id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY username"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []scheduledAccount{}
+	for rows.Next() {
+		var a scheduledAccount
+		if rows.Scan(&a.id, &a.username) == nil {
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, nil
+}
+
+// runSchedule backs up every account of a schedule one after the other
+func runSchedule(db scheduler.DB, job *scheduler.Job, run *scheduler.Run, logf scheduler.LogFunc) (*scheduler.Result, error) {
+	p, err := ParseSchedulePolicy(job.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewService(db)
+	accounts, err := s.scheduleAccounts(job.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(accounts) == 0 {
+		return nil, ErrAccountNotFound
+	}
+
+	res := &scheduler.Result{Items: len(accounts)}
+	logf("💾 Backing up %d account(s)", len(accounts))
+
+	for _, acc := range accounts {
+		backupJob, err := s.CreateJob(acc.id, job.CreatedBy, p.Options)
+		if err == nil {
+			s.db.Exec("UPDATE backup_jobs SET schedule_id = ?, schedule_run_id = ? WHERE id = ?", job.ID, run.ID, backupJob.ID)
+			err = s.RunJob(backupJob.ID)
+		}
+		if err != nil {
+			res.Failed++
+			logf("❌ %s: %v", acc.username, err)
+			continue
+		}
+
+		done, err := s.GetJob(backupJob.ID)
+		if err != nil {
+			continue
+		}
+		res.Size += done.BackupSize
+		logf("✓ %s: %s (%d bytes, %ds)", acc.username, done.FileName, done.BackupSize, done.DurationSeconds)
+		if done.ErrorMessage != "" {
+			logf("⚠️ %s: %s", acc.username, done.ErrorMessage)
+		}
+
+		if p.KeepLast > 0 {
+			removed, err := s.pruneScheduled(job.ID, acc.id, p.KeepLast)
+			if err != nil {
+				logf("⚠️ %s: retention: %v", acc.username, err)
+			} else if removed > 0 {
+				logf("🗑️ %s: removed %d old backup(s)", acc.username, removed)
+			}
+		}
+	}
+
+	res.Summary = fmt.Sprintf("%d of %d accounts backed up", res.Items-res.Failed, res.Items)
+	logf("📊 %s", res.Summary)
+	return res, nil
+}
+
+// pruneScheduled deletes the oldest completed backups a schedule made for
+// an account beyond keep
+func (s *Service) pruneScheduled(scheduleID, userID int64, keep int) (int, error) {
+	rows, err := s.db.Query(`
+		SELECT id FROM backup_jobs
+		WHERE schedule_id = ? AND user_id = ? AND status = ?
+		ORDER BY id DESC LIMIT -1 OFFSET ?
+	`, scheduleID, userID, StatusCompleted, keep)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	removed := 0
+	for _, id := range ids {
+		if err := s.DeleteJob(id); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
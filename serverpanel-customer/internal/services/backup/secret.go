@@ -0,0 +1,262 @@
+package backup
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MasterKeySize is the size of the server master key (AES-256)
+const MasterKeySize = 32
+
+// Sealed value prefixes. v1 values were sealed with the original
+// master.key, v2 values carry the id of the key that sealed them.
+const (
+	sealedPrefixV1 = "v1:"
+	sealedPrefixV2 = "v2:"
+)
+
+// legacyKeyID is the id of the master.key created before key rotation
+const legacyKeyID = "master"
+
+var (
+	ErrInvalidSecret = errors.New("encrypted value is corrupt or was sealed with another key")
+	ErrKeyNotFound   = errors.New("master key not found")
+
+	masterKeyMu sync.Mutex
+
+	keyIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+)
+
+// keyRing is the set of master keys in a key directory. Every key is
+// kept after a rotation so older backups and secrets stay readable; the
+// "current" file names the key used for new data.
+type keyRing struct {
+	dir string
+}
+
+// GetKeyDir returns the directory holding the server master keys
+func (s *Service) GetKeyDir() string {
+	return filepath.Join(s.cfg.DataDir, "keys")
+}
+
+func (s *Service) keys() keyRing {
+	return keyRing{dir: s.GetKeyDir()}
+}
+
+func (k keyRing) path(id string) string {
+	return filepath.Join(k.dir, id+".key")
+}
+
+// Get loads the master key with the given id
+func (k keyRing) Get(id string) ([]byte, error) {
+	if !keyIDRegex.MatchString(id) {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	key, err := os.ReadFile(k.path(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("master key %s has invalid size", id)
+	}
+	return key, nil
+}
+
+// CurrentID returns the id of the key used for new data ("" if the ring
+// is empty)
+func (k keyRing) CurrentID() (string, error) {
+	data, err := os.ReadFile(filepath.Join(k.dir, "current"))
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	// Servers set up before rotation only have master.key
+	if _, err := os.Stat(k.path(legacyKeyID)); err == nil {
+		return legacyKeyID, nil
+	}
+	return "", nil
+}
+
+// Current returns the current master key, creating the first one on
+// first use
+func (k keyRing) Current() (string, []byte, error) {
+	masterKeyMu.Lock()
+	defer masterKeyMu.Unlock()
+
+	id, err := k.CurrentID()
+	if err != nil {
+		return "", nil, err
+	}
+	if id == "" {
+		return k.generate()
+	}
+	key, err := k.Get(id)
+	return id, key, err
+}
+
+// Generate creates a new master key and makes it current
+func (k keyRing) Generate() (string, []byte, error) {
+	masterKeyMu.Lock()
+	defer masterKeyMu.Unlock()
+	return k.generate()
+}
+
+func (k keyRing) generate() (string, []byte, error) {
+	if err := os.MkdirAll(k.dir, 0700); err != nil {
+		return "", nil, err
+	}
+
+	suffix := make([]byte, 4)
+	key := make([]byte, MasterKeySize)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, err
+	}
+	id := fmt.Sprintf("master-%s-%s", time.Now().UTC().Format("20060102"), hex.EncodeToString(suffix))
+
+	// O_EXCL so two processes never overwrite each other's key
+	path := k.path(id)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", nil, err
+	}
+	if err := f.Close(); err != nil {
+		return "", nil, err
+	}
+
+	// Switch "current" atomically so readers never see a partial id
+	tmp := filepath.Join(k.dir, "current.tmp")
+	if err := os.WriteFile(tmp, []byte(id+"\n"), 0600); err != nil {
+		return "", nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(k.dir, "current")); err != nil {
+		return "", nil, err
+	}
+	return id, key, nil
+}
+
+// IDs lists the ids of every key in the ring
+func (k keyRing) IDs() ([]string, error) {
+	entries, err := os.ReadDir(k.dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, e := range entries {
+		id := strings.TrimSuffix(e.Name(), ".key")
+		if e.Type().IsRegular() && id != e.Name() && keyIDRegex.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// sealSecret encrypts a value with the current master key for storage in
+// SQLite
+func (s *Service) sealSecret(plain []byte) (string, error) {
+	id, key, err := s.keys().Current()
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	return sealedPrefixV2 + id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret decrypts a value produced by sealSecret
+func (s *Service) openSecret(sealed string) ([]byte, error) {
+	id, encoded, err := parseSealed(sealed)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidSecret
+	}
+	key, err := s.keys().Get(id)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrInvalidSecret
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrInvalidSecret
+	}
+	return plain, nil
+}
+
+// parseSealed splits a sealed value into its key id and payload
+func parseSealed(sealed string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(sealed, sealedPrefixV1):
+		return legacyKeyID, strings.TrimPrefix(sealed, sealedPrefixV1), nil
+	case strings.HasPrefix(sealed, sealedPrefixV2):
+		id, encoded, ok := strings.Cut(strings.TrimPrefix(sealed, sealedPrefixV2), ":")
+		if !ok {
+			return "", "", ErrInvalidSecret
+		}
+		return id, encoded, nil
+	}
+	return "", "", ErrInvalidSecret
+}
+
+// sealedKeyID returns the id of the key a sealed value was sealed with
+func sealedKeyID(sealed string) string {
+	id, _, err := parseSealed(sealed)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// VerifyResult is the outcome of verifying an archive
+type VerifyResult struct {
+	Valid         bool     `json:"valid"`
+	Encrypted     bool     `json:"encrypted"`
+	KeyIDs        []string `json:"key_ids,omitempty"`
+	HasPassphrase bool     `json:"has_passphrase"`
+	Decrypted     bool     `json:"decrypted"`
+	ManifestOK    bool     `json:"manifest_ok"`
+	FilesChecked  int      `json:"files_checked"`
+	Mismatches    []string `json:"mismatches,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+func (r *VerifyResult) fail(format string, args ...interface{}) *VerifyResult {
+	r.Valid = false
+	r.Error = fmt.Sprintf(format, args...)
+	return r
+}
+
+// VerifyArchive proves that an archive decrypts end to end and that every
+// file matches checksums.sha256, whose own hash is pinned in the manifest.
+// Problems with the archive are reported in the result; the error is only
+// set when the file can not be read at all.
+func VerifyArchive(path string, unlock Unlocker) (*VerifyResult, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{}
+	if h, err := ReadEncryptionHeader(path); err == nil {
+		result.Encrypted = true
+		for _, wk := range h.Keys {
+			switch wk.Type {
+			case KeyTypeMaster:
+				result.KeyIDs = append(result.KeyIDs, wk.KeyID)
+			case KeyTypePassphrase:
+				result.HasPassphrase = true
+			}
+		}
+	} else if err != ErrNotEncrypted {
+		return result.fail("%v", err), nil
+	}
+
+	rc, err := openArchive(path, unlock)
+	if err != nil {
+		return result.fail("%v", err), nil
+	}
+	defer rc.Close()
+
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return result.fail("not a valid backup archive: %v", err), nil
+	}
+	defer gz.Close()
+
+	sums := make(map[string]string)
+	var checksums, manifestData []byte
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result.fail("%v", err), nil
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch hdr.Name {
+		case ChecksumsFile:
+			if checksums, err = io.ReadAll(tr); err != nil {
+				return result.fail("%v", err), nil
+			}
+		case ManifestFile:
+			if manifestData, err = io.ReadAll(tr); err != nil {
+				return result.fail("%v", err), nil
+			}
+		default:
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return result.fail("%s: %v", hdr.Name, err), nil
+			}
+			sums[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+
+	// Read to the very end so the final chunk is authenticated as well
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		return result.fail("%v", err), nil
+	}
+	result.Decrypted = result.Encrypted
+
+	if manifestData == nil {
+		return result.fail("%s not found in archive", ManifestFile), nil
+	}
+	var m Manifest
+	if err := json.Unmarshal(manifestData, &m); err != nil {
+		return result.fail("invalid manifest: %v", err), nil
+	}
+	if m.Format != FormatName {
+		return result.fail("unsupported backup format: %s", m.Format), nil
+	}
+	if checksums == nil {
+		return result.fail("%s not found in archive", ChecksumsFile), nil
+	}
+	if m.Checksums.ManifestHash != sha256Hex(checksums) {
+		return result.fail("%s does not match the manifest", ChecksumsFile), nil
+	}
+	result.ManifestOK = true
+
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		sum, name, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+		listed[name] = true
+		actual, found := sums[name]
+		switch {
+		case !found:
+			result.Mismatches = append(result.Mismatches, "missing: "+name)
+		case actual != sum:
+			result.Mismatches = append(result.Mismatches, "changed: "+name)
+		default:
+			result.FilesChecked++
+		}
+	}
+	for name := range sums {
+		if !listed[name] {
+			result.Mismatches = append(result.Mismatches, "unlisted: "+name)
+		}
+	}
+	sort.Strings(result.Mismatches)
+
+	if len(result.Mismatches) > 0 {
+		return result.fail("%d file(s) do not match their checksums", len(result.Mismatches)), nil
+	}
+	result.Valid = true
+	return result, nil
+}
@@ -0,0 +1,338 @@
+package bandwidth
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+)
+
+var ErrAccountNotFound = errors.New("account not found")
+
+// DB interface for database operations
+type DB interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Begin() (*sql.Tx, error)
+}
+
+// Service ingests web server access logs into per-domain daily traffic
+// and acts on accounts over their monthly bandwidth
+type Service struct {
+	db  DB
+	cfg *config.Config
+}
+
+// IngestResult summarises one pass over the access logs
+type IngestResult struct {
+	Files    int   `json:"files"`
+	Lines    int   `json:"lines"`
+	Skipped  int   `json:"skipped"`
+	Bytes    int64 `json:"bytes"`
+	Requests int   `json:"requests"`
+}
+
+// logSource is an access log and the domain its traffic belongs to
+type logSource struct {
+	path     string
+	domainID int64
+	userID   int64
+	username string
+}
+
+type dayKey struct {
+	domainID int64
+	day      string
+}
+
+type dayTotal struct {
+	userID   int64
+	bytes    int64
+	requests int
+}
+
+// Combined log format as written by Apache (combined) and Nginx (default):
+// host ident user [time] "request" status bytes ...
+var combinedLine = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "(?:[^"\\]|\\.)*" (\d{3}) (\d+|-)`)
+
+const logTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// caddyLine is the part of a Caddy JSON access log entry that is counted
+type caddyLine struct {
+	Ts   float64 `json:"ts"`
+	Size int64   `json:"size"`
+}
+
+// At most this much of the first line identifies a log across passes
+const fingerprintSize = 256
+
+func NewService(db DB) *Service {
+	return &Service{
+		db:  db,
+		cfg: config.Get(),
+	}
+}
+
+// GetApacheLogDir returns ${APACHE_LOG_DIR}, where the domain, SSL and
+// Node.js vhosts written by the panel log
+func (s *Service) GetApacheLogDir() string {
+	if s.cfg.SimulateMode {
+		return filepath.Join(s.cfg.SimulateBasePath, "log", "apache2")
+	}
+	return "/var/log/apache2"
+}
+
+// GetNginxLogDir returns the Nginx log directory
+func (s *Service) GetNginxLogDir() string {
+	if s.cfg.SimulateMode {
+		return filepath.Join(s.cfg.SimulateBasePath, "log", "nginx")
+	}
+	return "/var/log/nginx"
+}
+
+// GetCaddyLogDir returns the Caddy log directory
+func (s *Service) GetCaddyLogDir() string {
+	if s.cfg.SimulateMode {
+		return filepath.Join(s.cfg.SimulateBasePath, "log", "caddy")
+	}
+	return "/var/log/caddy"
+}
+
+// sources lists the access logs of every domain. Only the web servers'
+// own log directories are read: logs in ~/logs belong to the account,
+// which could truncate them to hide its traffic.
+func (s *Service) sources() ([]logSource, error) {
+	rows, err := s.db.Query(`
+		SELECT d.id, d.name, d.user_id, u.username
+		FROM domains d JOIN users u ON u.id = d.user_id
+		ORDER BY d.user_id, d.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []logSource
+	for rows.Next() {
+		var domainID, userID int64
+		var domain, username string
+		if rows.Scan(&domainID, &domain, &userID, &username) != nil {
+			continue
+		}
+
+		paths := []string{
+			filepath.Join(s.GetApacheLogDir(), domain+"-access.log"),
+			filepath.Join(s.GetApacheLogDir(), domain+"-ssl-access.log"),
+			filepath.Join(s.GetNginxLogDir(), domain+"-access.log"),
+			filepath.Join(s.GetCaddyLogDir(), domain+"-access.log"),
+		}
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				sources = append(sources, logSource{path: path, domainID: domainID, userID: userID, username: username})
+			}
+		}
+	}
+	return sources, nil
+}
+
+// Ingest reads what was appended to every access log since the last pass
+// and adds it to the daily totals. A log that shrank or was replaced
+// (rotated) is read from the start.
+func (s *Service) Ingest() (*IngestResult, error) {
+	sources, err := s.sources()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &IngestResult{}
+	totals := map[dayKey]*dayTotal{}
+	offsets := map[string][2]int64{}
+
+	for _, src := range sources {
+		fingerprint, offset, err := s.readLog(src, totals, res)
+		if err != nil {
+			log.Printf("⚠️ Bandwidth: %s: %v", src.path, err)
+			continue
+		}
+		offsets[src.path] = [2]int64{fingerprint, offset}
+		res.Files++
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for key, t := range totals {
+		if _, err := tx.Exec(`
+			INSERT INTO bandwidth_usage (domain_id, user_id, day, bytes, requests)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(domain_id, day) DO UPDATE SET
+				bytes = bytes + excluded.bytes, requests = requests + excluded.requests
+		`, key.domainID, t.userID, key.day, t.bytes, t.requests); err != nil {
+			return nil, err
+		}
+	}
+	for path, o := range offsets {
+		if _, err := tx.Exec(`
+			INSERT INTO bandwidth_log_offsets (path, fingerprint, offset, updated_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(path) DO UPDATE SET
+				fingerprint = excluded.fingerprint, offset = excluded.offset, updated_at = CURRENT_TIMESTAMP
+		`, path, o[0], o[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// readLog adds the new lines of one log to totals and returns the
+// position to continue from
+func (s *Service) readLog(src logSource, totals map[dayKey]*dayTotal, res *IngestResult) (int64, int64, error) {
+	f, err := os.Open(src.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	// The first line does not change while a log is appended to
+	head := make([]byte, fingerprintSize)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if i := bytes.IndexByte(head, '\n'); i >= 0 {
+		head = head[:i]
+	}
+	fingerprint := int64(crc32.ChecksumIEEE(head))
+
+	var lastFingerprint, offset int64
+	s.db.QueryRow("SELECT fingerprint, offset FROM bandwidth_log_offsets WHERE path = ?", src.path).Scan(&lastFingerprint, &offset)
+	if fingerprint != lastFingerprint || info.Size() < offset {
+		// New, rotated or truncated log
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	var appended bytes.Buffer
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// A partial last line is read again on the next pass
+			break
+		}
+		offset += int64(len(line))
+		res.Lines++
+		appended.WriteString(line)
+
+		t, sent, ok := parseLogLine(line)
+		if !ok {
+			res.Skipped++
+			continue
+		}
+
+		key := dayKey{domainID: src.domainID, day: t.Format("2006-01-02")}
+		total := totals[key]
+		if total == nil {
+			total = &dayTotal{userID: src.userID}
+			totals[key] = total
+		}
+		total.bytes += sent
+		total.requests++
+		res.Bytes += sent
+		res.Requests++
+	}
+
+	if appended.Len() > 0 {
+		if err := s.copyToAccount(src, appended.Bytes()); err != nil {
+			log.Printf("⚠️ Bandwidth: copy of %s for %s: %v", filepath.Base(src.path), src.username, err)
+		}
+	}
+	return fingerprint, offset, nil
+}
+
+// copyToAccount appends new log lines to the account's copy in
+// ~/logs/<log name>. The home directory belongs to the account, so the
+// copy is opened through an os.Root that refuses symlinks out of it.
+func (s *Service) copyToAccount(src logSource, lines []byte) error {
+	root, err := os.OpenRoot(filepath.Join(s.cfg.HomeBaseDir, src.username))
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	if err := root.MkdirAll("logs", 0755); err != nil {
+		return err
+	}
+	name := filepath.Join("logs", filepath.Base(src.path))
+	f, err := root.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(lines)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if !s.cfg.SimulateMode {
+		if u, err := user.Lookup(src.username); err == nil {
+			uid, _ := strconv.Atoi(u.Uid)
+			gid, _ := strconv.Atoi(u.Gid)
+			root.Lchown(name, uid, gid)
+		}
+	}
+	return nil
+}
+
+// parseLogLine returns the time and response size of an access log line
+// in combined format or, as Caddy writes it, JSON
+func parseLogLine(line string) (time.Time, int64, bool) {
+	if strings.HasPrefix(line, "{") {
+		var entry caddyLine
+		if err := json.Unmarshal([]byte(line), &entry); err != nil || entry.Ts == 0 {
+			return time.Time{}, 0, false
+		}
+		sec := int64(entry.Ts)
+		nsec := int64((entry.Ts - float64(sec)) * 1e9)
+		return time.Unix(sec, nsec), entry.Size, true
+	}
+
+	m := combinedLine.FindStringSubmatch(line)
+	if m == nil {
+		return time.Time{}, 0, false
+	}
+	t, err := time.Parse(logTimeLayout, m[1])
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	var sent int64
+	if m[3] != "-" {
+		sent, _ = strconv.ParseInt(m[3], 10, 64)
+	}
+	return t, sent, true
+}
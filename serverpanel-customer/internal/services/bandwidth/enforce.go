@@ -0,0 +1,419 @@
+package bandwidth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+)
+
+// Actions taken when an account goes over its monthly bandwidth
+// (server_settings bandwidth_overage_action)
+const (
+	ActionNotify   = "notify"
+	ActionThrottle = "throttle"
+	ActionSuspend  = "suspend"
+)
+
+const mb = 1024 * 1024
+
+// Settings configure what happens to accounts over their bandwidth
+type Settings struct {
+	Action       string `json:"action"`
+	ThrottleRate int    `json:"throttle_rate"` // KB/s
+}
+
+// DomainUsage is the traffic of one domain in a period
+type DomainUsage struct {
+	DomainID int64  `json:"domain_id"`
+	Domain   string `json:"domain"`
+	Bytes    int64  `json:"bytes"`
+	Requests int64  `json:"requests"`
+}
+
+// DayUsage is the traffic of an account on one day
+type DayUsage struct {
+	Day      string `json:"day"`
+	Bytes    int64  `json:"bytes"`
+	Requests int64  `json:"requests"`
+}
+
+// Overage records an account that went over its bandwidth in a period
+type Overage struct {
+	ID         int64  `json:"id"`
+	UserID     int64  `json:"user_id"`
+	Username   string `json:"username,omitempty"`
+	Period     string `json:"period"`
+	Action     string `json:"action"`
+	UsedMB     int64  `json:"used_mb"`
+	QuotaMB    int64  `json:"quota_mb"`
+	CreatedAt  string `json:"created_at"`
+	ResolvedAt string `json:"resolved_at,omitempty"`
+}
+
+// AccountUsage is the traffic of an account in a period (YYYY-MM) against
+// its package bandwidth_quota (MB, 0 = unlimited)
+type AccountUsage struct {
+	UserID    int64         `json:"user_id"`
+	Username  string        `json:"username"`
+	Period    string        `json:"period"`
+	Bytes     int64         `json:"bytes"`
+	Requests  int64         `json:"requests"`
+	UsedMB    int64         `json:"used_mb"`
+	QuotaMB   int64         `json:"quota_mb"`
+	Percent   float64       `json:"percent"`
+	OverQuota bool          `json:"over_quota"`
+	Domains   []DomainUsage `json:"domains,omitempty"`
+	Days      []DayUsage    `json:"days,omitempty"`
+	Overage   *Overage      `json:"overage,omitempty"`
+}
+
+// CurrentPeriod returns the accounting period of now
+func CurrentPeriod() string {
+	return time.Now().Format("2006-01")
+}
+
+func (u *AccountUsage) finish() {
+	u.UsedMB = (u.Bytes + mb - 1) / mb
+	if u.QuotaMB > 0 {
+		u.Percent = float64(u.Bytes) * 100 / float64(u.QuotaMB*mb)
+		u.OverQuota = u.Bytes > u.QuotaMB*mb
+	}
+}
+
+// GetSettings returns the overage action and throttle rate
+func (s *Service) GetSettings() Settings {
+	settings := Settings{Action: ActionNotify, ThrottleRate: 256}
+
+	var action, rate string
+	s.db.QueryRow("SELECT value FROM server_settings WHERE key = 'bandwidth_overage_action'").Scan(&action)
+	s.db.QueryRow("SELECT value FROM server_settings WHERE key = 'bandwidth_throttle_rate'").Scan(&rate)
+
+	switch action {
+	case ActionNotify, ActionThrottle, ActionSuspend:
+		settings.Action = action
+	}
+	if n, err := strconv.Atoi(rate); err == nil && n > 0 {
+		settings.ThrottleRate = n
+	}
+	return settings
+}
+
+// accountTotals returns the traffic of every account in a period
+func (s *Service) accountTotals(period string) ([]AccountUsage, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, COALESCE(p.bandwidth_quota, 0),
+		       COALESCE(SUM(b.bytes), 0), COALESCE(SUM(b.requests), 0)
+		FROM users u
+		LEFT JOIN user_packages up ON up.user_id = u.id
+		LEFT JOIN packages p ON p.id = up.package_id
+		LEFT JOIN bandwidth_usage b ON b.user_id = u.id AND b.day LIKE ?
+		WHERE u.role = 'user'
+		GROUP BY u.id
+		ORDER BY u.username
+	`, period+"-%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usages := []AccountUsage{}
+	for rows.Next() {
+		u := AccountUsage{Period: period}
+		if rows.Scan(&u.UserID, &u.Username, &u.QuotaMB, &u.Bytes, &u.Requests) == nil {
+			u.finish()
+			usages = append(usages, u)
+		}
+	}
+	return usages, nil
+}
+
+// Report returns the traffic of every account in a period, or of the
+// accounts below a reseller when resellerID is set
+func (s *Service) Report(period string, resellerID int64) ([]AccountUsage, error) {
+	usages, err := s.accountTotals(period)
+	if err != nil || resellerID == 0 {
+		return usages, err
+	}
+
+	ids, err := account.NewService(s.db).DescendantIDs(resellerID)
+	if err != nil {
+		return nil, err
+	}
+	below := map[int64]bool{}
+	for _, id := range ids {
+		below[id] = true
+	}
+	filtered := []AccountUsage{}
+	for _, u := range usages {
+		if below[u.UserID] {
+			filtered = append(filtered, u)
+		}
+	}
+	return filtered, nil
+}
+
+// AccountUsage returns the traffic of an account in a period per domain
+// and per day
+func (s *Service) AccountUsage(userID int64, period string) (*AccountUsage, error) {
+	u := &AccountUsage{UserID: userID, Period: period, Domains: []DomainUsage{}, Days: []DayUsage{}}
+	err := s.db.QueryRow(`
+		SELECT u.username, COALESCE(p.bandwidth_quota, 0)
+		FROM users u
+		LEFT JOIN user_packages up ON up.user_id = u.id
+		LEFT JOIN packages p ON p.id = up.package_id
+		WHERE u.id = ? AND u.role = 'user'
+	`, userID).Scan(&u.Username, &u.QuotaMB)
+	if err == sql.ErrNoRows {
+		return nil, ErrAccountNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT d.id, d.name, COALESCE(SUM(b.bytes), 0), COALESCE(SUM(b.requests), 0)
+		FROM domains d
+		LEFT JOIN bandwidth_usage b ON b.domain_id = d.id AND b.day LIKE ?
+		WHERE d.user_id = ?
+		GROUP BY d.id
+		ORDER BY d.name
+	`, period+"-%", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var d DomainUsage
+		if rows.Scan(&d.DomainID, &d.Domain, &d.Bytes, &d.Requests) == nil {
+			u.Domains = append(u.Domains, d)
+		}
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`
+		SELECT day, SUM(bytes), SUM(requests)
+		FROM bandwidth_usage
+		WHERE user_id = ? AND day LIKE ?
+		GROUP BY day
+		ORDER BY day
+	`, userID, period+"-%")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var d DayUsage
+		if rows.Scan(&d.Day, &d.Bytes, &d.Requests) == nil {
+			u.Days = append(u.Days, d)
+			u.Bytes += d.Bytes
+			u.Requests += d.Requests
+		}
+	}
+	rows.Close()
+
+	u.finish()
+	u.Overage, _ = s.getOverage(userID, period)
+	return u, nil
+}
+
+func (s *Service) getOverage(userID int64, period string) (*Overage, error) {
+	o := &Overage{}
+	err := s.db.QueryRow(`
+		SELECT id, user_id, period, action, used_mb, quota_mb, COALESCE(created_at, ''), COALESCE(resolved_at, '')
+		FROM bandwidth_overages WHERE user_id = ? AND period = ?
+	`, userID, period).Scan(&o.ID, &o.UserID, &o.Period, &o.Action, &o.UsedMB, &o.QuotaMB, &o.CreatedAt, &o.ResolvedAt)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// ListOverages returns the overages of a period, newest first
+func (s *Service) ListOverages(period string) ([]Overage, error) {
+	rows, err := s.db.Query(`
+		SELECT o.id, o.user_id, u.username, o.period, o.action, o.used_mb, o.quota_mb,
+		       COALESCE(o.created_at, ''), COALESCE(o.resolved_at, '')
+		FROM bandwidth_overages o JOIN users u ON u.id = o.user_id
+		WHERE o.period = ?
+		ORDER BY o.id DESC
+	`, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overages := []Overage{}
+	for rows.Next() {
+		var o Overage
+		if rows.Scan(&o.ID, &o.UserID, &o.Username, &o.Period, &o.Action, &o.UsedMB, &o.QuotaMB, &o.CreatedAt, &o.ResolvedAt) == nil {
+			overages = append(overages, o)
+		}
+	}
+	return overages, nil
+}
+
+// Enforce acts on accounts over their bandwidth in the current period,
+// once per account and period, and lifts the throttle or suspension of
+// accounts that are back within their bandwidth (new period or bigger
+// package)
+func (s *Service) Enforce() ([]Overage, error) {
+	period := CurrentPeriod()
+	usages, err := s.accountTotals(period)
+	if err != nil {
+		return nil, err
+	}
+	settings := s.GetSettings()
+	accounts := account.NewService(s.db)
+
+	over := map[int64]bool{}
+	created := []Overage{}
+	for _, u := range usages {
+		if !u.OverQuota {
+			continue
+		}
+		over[u.UserID] = true
+
+		var count int
+		s.db.QueryRow("SELECT COUNT(*) FROM bandwidth_overages WHERE user_id = ? AND period = ?", u.UserID, period).Scan(&count)
+		if count > 0 {
+			continue
+		}
+
+		var actionErr error
+		switch settings.Action {
+		case ActionThrottle:
+			actionErr = accounts.ThrottleAccount(u.UserID, settings.ThrottleRate)
+		case ActionSuspend:
+			actionErr = accounts.SuspendAccount(u.UserID, suspendReason(period), 0)
+			if actionErr == account.ErrAlreadySuspended {
+				actionErr = nil
+			}
+		}
+		if actionErr != nil {
+			log.Printf("❌ Bandwidth %s failed for %s: %v", settings.Action, u.Username, actionErr)
+			continue
+		}
+
+		if _, err := s.db.Exec(`
+			INSERT INTO bandwidth_overages (user_id, period, action, used_mb, quota_mb)
+			VALUES (?, ?, ?, ?, ?)
+		`, u.UserID, period, settings.Action, u.UsedMB, u.QuotaMB); err != nil {
+			return created, err
+		}
+		s.notify(u, settings.Action)
+
+		log.Printf("⚠️ Bandwidth exceeded: %s (%d/%d MB) → %s", u.Username, u.UsedMB, u.QuotaMB, settings.Action)
+		if o, err := s.getOverage(u.UserID, period); err == nil {
+			o.Username = u.Username
+			created = append(created, *o)
+		}
+	}
+
+	s.resolve(period, over, accounts)
+	return created, nil
+}
+
+// suspendReason is the suspension reason of accounts suspended for going
+// over their bandwidth in a period
+func suspendReason(period string) string {
+	return fmt.Sprintf("Bandwidth limit exceeded for %s", period)
+}
+
+// resolve lifts the actions of overages whose account is no longer over
+func (s *Service) resolve(period string, over map[int64]bool, accounts *account.Service) {
+	rows, err := s.db.Query(`
+		SELECT o.id, o.user_id, o.period, o.action, u.username
+		FROM bandwidth_overages o JOIN users u ON u.id = o.user_id
+		WHERE o.resolved_at IS NULL
+	`)
+	if err != nil {
+		return
+	}
+	type pending struct {
+		id, userID               int64
+		period, action, username string
+	}
+	var list []pending
+	for rows.Next() {
+		var p pending
+		if rows.Scan(&p.id, &p.userID, &p.period, &p.action, &p.username) == nil {
+			list = append(list, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range list {
+		if p.period == period && over[p.userID] {
+			continue
+		}
+
+		var err error
+		switch p.action {
+		case ActionThrottle:
+			err = accounts.ThrottleAccount(p.userID, 0)
+		case ActionSuspend:
+			// Only lift the bandwidth suspension; a suspension set since
+			// then for another reason stays
+			if sp, spErr := accounts.GetSuspension(p.userID); spErr == nil && sp.Reason == suspendReason(p.period) {
+				err = accounts.UnsuspendAccount(p.userID)
+			}
+		}
+		if err != nil {
+			log.Printf("❌ Lifting bandwidth %s failed for %s: %v", p.action, p.username, err)
+			continue
+		}
+		s.db.Exec("UPDATE bandwidth_overages SET resolved_at = CURRENT_TIMESTAMP WHERE id = ?", p.id)
+		log.Printf("✅ Bandwidth %s lifted: %s", p.action, p.username)
+	}
+}
+
+// notify records the overage in the account's activity log and queues a
+// mail to the account owner
+func (s *Service) notify(u AccountUsage, action string) {
+	details := fmt.Sprintf("Bandwidth limit exceeded for %s: %d MB of %d MB used", u.Period, u.UsedMB, u.QuotaMB)
+	switch action {
+	case ActionThrottle:
+		details += ", the account is throttled until the next period"
+	case ActionSuspend:
+		details += ", the account is suspended until the next period"
+	}
+	s.db.Exec(`
+		INSERT INTO activity_logs (user_id, action, details, ip_address)
+		VALUES (?, 'bandwidth_exceeded', ?, '')
+	`, u.UserID, details)
+
+	var email string
+	s.db.QueryRow("SELECT email FROM users WHERE id = ?", u.UserID).Scan(&email)
+	if email == "" {
+		return
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	s.db.Exec(`
+		INSERT INTO mail_queue (user_id, sender, recipient, subject, body)
+		VALUES (?, ?, ?, ?, ?)
+	`, u.UserID, "serverpanel@"+hostname, email, "Bandwidth limit exceeded", details+".\n")
+}
+
+// StartWorker ingests the access logs and enforces the bandwidth limits
+// periodically in the background
+func (s *Service) StartWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := s.Ingest(); err != nil {
+				log.Printf("❌ Bandwidth ingestion failed: %v", err)
+				continue
+			}
+			if _, err := s.Enforce(); err != nil {
+				log.Printf("❌ Bandwidth enforcement failed: %v", err)
+			}
+		}
+	}()
+}
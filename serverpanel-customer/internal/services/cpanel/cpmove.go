@@ -0,0 +1,612 @@
+package cpanel
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/services/dns"
+)
+
+var ErrNotCPMove = errors.New("not a cPanel cpmove archive")
+
+// Archive is the parsed content of a cpmove-USER.tar.gz. Large payloads
+// (homedir, SQL dumps, Maildirs) are not loaded; they are streamed from
+// the archive when the import runs.
+type Archive struct {
+	Path          string         `json:"-"`
+	Username      string         `json:"username"`
+	ContactEmail  string         `json:"contact_email,omitempty"`
+	Plan          string         `json:"plan,omitempty"`
+	OldIP         string         `json:"old_ip,omitempty"`
+	MainDomain    string         `json:"main_domain"`
+	DocumentRoot  string         `json:"document_root,omitempty"`
+	AddonDomains  []AddonDomain  `json:"addon_domains"`
+	ParkedDomains []string       `json:"parked_domains"`
+	SubDomains    []SubDomain    `json:"sub_domains"`
+	Databases     []Database     `json:"databases"`
+	DatabaseUsers []DatabaseUser `json:"database_users"`
+	EmailAccounts []EmailAccount `json:"email_accounts"`
+	Forwarders    []Forwarder    `json:"forwarders"`
+	Zones         []Zone         `json:"zones"`
+	HomedirFiles  int64          `json:"homedir_files"`
+	HomedirSize   int64          `json:"homedir_size"`
+	Warnings      []string       `json:"warnings,omitempty"`
+	docRoots      map[string]string
+}
+
+type AddonDomain struct {
+	Domain       string `json:"domain"`
+	SubDomain    string `json:"sub_domain"`
+	DocumentRoot string `json:"document_root"`
+}
+
+type SubDomain struct {
+	Name         string `json:"name"`
+	Domain       string `json:"domain"`
+	FullName     string `json:"full_name"`
+	DocumentRoot string `json:"document_root"`
+}
+
+type Database struct {
+	Name   string `json:"name"`
+	Source string `json:"source,omitempty"` // Name in the archive when renamed
+	Size   int64  `json:"size"`
+}
+
+type DatabaseUser struct {
+	Username     string   `json:"username"`
+	PasswordHash string   `json:"-"`
+	Password     string   `json:"-"` // Generated when there is no hash
+	Databases    []string `json:"databases"`
+}
+
+type EmailAccount struct {
+	Email        string `json:"email"`
+	Domain       string `json:"domain"`
+	LocalPart    string `json:"local_part"`
+	PasswordHash string `json:"-"`
+	Password     string `json:"-"` // Generated when the hash is unusable
+	QuotaMB      int    `json:"quota_mb"`
+	HasMaildir   bool   `json:"has_maildir"`
+}
+
+type Forwarder struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+type Zone struct {
+	Domain   string           `json:"domain"`
+	Records  []dns.ZoneRecord `json:"records"`
+	Warnings []string         `json:"warnings,omitempty"`
+}
+
+// entryHandler is called for every archive entry with its name relative to
+// the cpmove root directory (e.g. "homedir/public_html/index.php")
+type entryHandler func(name string, hdr *tar.Header, r io.Reader) error
+
+var errStopWalk = errors.New("stop walk")
+
+// walk streams the archive. A nested homedir.tar (older pkgacct versions)
+// is expanded transparently under homedir/.
+func walk(archivePath string, fn entryHandler) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return ErrNotCPMove
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := entryName(hdr.Name)
+		if name == "" {
+			continue
+		}
+
+		if name == "homedir.tar" {
+			if err := walkNested(tr, fn); err != nil {
+				if err == errStopWalk {
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+
+		if err := fn(name, hdr, tr); err != nil {
+			if err == errStopWalk {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func walkNested(r io.Reader, fn entryHandler) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := cleanName(hdr.Name)
+		if name == "" || name == "." {
+			continue
+		}
+		if err := fn("homedir/"+name, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// entryName strips the cpmove-USER/ top directory and rejects unsafe names
+func entryName(name string) string {
+	name = cleanName(name)
+	if name == "" {
+		return ""
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[i+1:]
+	}
+	// The top-level directory itself
+	return ""
+}
+
+func cleanName(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || strings.HasPrefix(name, "/") {
+		return ""
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return ""
+		}
+	}
+	return name
+}
+
+// maxMetaSize bounds the metadata files read into memory
+const maxMetaSize = 4 * 1024 * 1024
+
+// Parse reads the account metadata of a cpmove archive
+func Parse(archivePath string) (*Archive, error) {
+	arc := &Archive{
+		Path:          archivePath,
+		AddonDomains:  []AddonDomain{},
+		ParkedDomains: []string{},
+		SubDomains:    []SubDomain{},
+		Databases:     []Database{},
+		DatabaseUsers: []DatabaseUser{},
+		EmailAccounts: []EmailAccount{},
+		Forwarders:    []Forwarder{},
+		Zones:         []Zone{},
+		docRoots:      map[string]string{},
+	}
+
+	meta := map[string]string{}
+	maildirs := map[string]bool{}
+	seenRoot := false
+
+	err := walk(archivePath, func(name string, hdr *tar.Header, r io.Reader) error {
+		seenRoot = true
+		switch {
+		case strings.HasPrefix(name, "homedir/"):
+			rel := strings.TrimPrefix(name, "homedir/")
+			if hdr.Typeflag == tar.TypeReg {
+				arc.HomedirFiles++
+				arc.HomedirSize += hdr.Size
+			}
+			// homedir/etc/<domain>/{passwd,shadow,quota} describe mailboxes
+			if strings.HasPrefix(rel, "etc/") && hdr.Typeflag == tar.TypeReg && strings.Count(rel, "/") == 2 {
+				return readMeta(meta, name, r)
+			}
+			// homedir/mail/<domain>/<user>/ are the Maildirs
+			if parts := strings.Split(rel, "/"); len(parts) >= 3 && parts[0] == "mail" && strings.Contains(parts[1], ".") {
+				maildirs[parts[2]+"@"+parts[1]] = true
+			}
+			return nil
+		case strings.HasPrefix(name, "mysql/") && strings.HasSuffix(name, ".sql"):
+			arc.Databases = append(arc.Databases, Database{
+				Name: strings.TrimSuffix(path.Base(name), ".sql"),
+				Size: hdr.Size,
+			})
+			return nil
+		case hdr.Typeflag != tar.TypeReg:
+			return nil
+		case strings.HasPrefix(name, "cp/"), strings.HasPrefix(name, "userdata/"),
+			strings.HasPrefix(name, "va/"), strings.HasPrefix(name, "dnszones/"),
+			name == "mysql.sql":
+			return readMeta(meta, name, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !seenRoot {
+		return nil, ErrNotCPMove
+	}
+
+	// cp/USER holds the account summary
+	for name, content := range meta {
+		if strings.HasPrefix(name, "cp/") {
+			arc.Username = strings.TrimPrefix(name, "cp/")
+			parseCPFile(arc, content)
+			break
+		}
+	}
+	if arc.Username == "" {
+		return nil, fmt.Errorf("%w: cp/<user> file missing", ErrNotCPMove)
+	}
+
+	if main, ok := meta["userdata/main"]; ok {
+		parseUserdataMain(arc, main)
+	}
+	if arc.MainDomain == "" {
+		return nil, fmt.Errorf("%w: main domain not found", ErrNotCPMove)
+	}
+	for name, content := range meta {
+		if strings.HasPrefix(name, "userdata/") && name != "userdata/main" && !strings.Contains(name, "_SSL") {
+			domain := strings.TrimPrefix(name, "userdata/")
+			if root := yamlValue(content, "documentroot"); root != "" {
+				arc.docRoots[domain] = root
+			}
+		}
+	}
+	arc.DocumentRoot = arc.docRoots[arc.MainDomain]
+	for i := range arc.AddonDomains {
+		arc.AddonDomains[i].DocumentRoot = arc.docRoots[arc.AddonDomains[i].SubDomain]
+	}
+	for i := range arc.SubDomains {
+		arc.SubDomains[i].DocumentRoot = arc.docRoots[arc.SubDomains[i].FullName]
+	}
+
+	if grants, ok := meta["mysql.sql"]; ok {
+		arc.DatabaseUsers = parseGrants(grants)
+	}
+	sort.Slice(arc.Databases, func(i, j int) bool { return arc.Databases[i].Name < arc.Databases[j].Name })
+
+	parseEmail(arc, meta, maildirs)
+	parseForwarders(arc, meta)
+	parseZones(arc, meta)
+
+	return arc, nil
+}
+
+func readMeta(meta map[string]string, name string, r io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(r, maxMetaSize))
+	if err != nil {
+		return err
+	}
+	meta[name] = string(data)
+	return nil
+}
+
+// Domains returns every domain of the account (main, addon, parked)
+func (a *Archive) Domains() []string {
+	domains := []string{a.MainDomain}
+	for _, d := range a.AddonDomains {
+		domains = append(domains, d.Domain)
+	}
+	return append(domains, a.ParkedDomains...)
+}
+
+// parseCPFile reads the KEY=value summary in cp/USER
+func parseCPFile(arc *Archive, content string) {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "DNS":
+			arc.MainDomain = strings.ToLower(value)
+		case "CONTACTEMAIL":
+			arc.ContactEmail = value
+		case "PLAN":
+			arc.Plan = value
+		case "IP":
+			arc.OldIP = value
+		}
+	}
+}
+
+// parseUserdataMain reads the YAML userdata/main file. Only the flat
+// structure pkgacct writes is supported.
+func parseUserdataMain(arc *Archive, content string) {
+	section := ""
+	addonSubs := map[string]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || line == "---" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			key, value, _ := strings.Cut(line, ":")
+			section = key
+			if key == "main_domain" {
+				arc.MainDomain = strings.ToLower(unquoteYAML(value))
+			}
+			continue
+		}
+
+		item := strings.TrimSpace(line)
+		switch section {
+		case "addon_domains":
+			domain, sub, ok := strings.Cut(item, ":")
+			if ok {
+				d := AddonDomain{Domain: strings.ToLower(unquoteYAML(domain)), SubDomain: strings.ToLower(unquoteYAML(sub))}
+				arc.AddonDomains = append(arc.AddonDomains, d)
+				addonSubs[d.SubDomain] = true
+			}
+		case "parked_domains":
+			if strings.HasPrefix(item, "- ") {
+				arc.ParkedDomains = append(arc.ParkedDomains, strings.ToLower(unquoteYAML(item[2:])))
+			}
+		case "sub_domains":
+			if strings.HasPrefix(item, "- ") {
+				full := strings.ToLower(unquoteYAML(item[2:]))
+				arc.SubDomains = append(arc.SubDomains, SubDomain{FullName: full})
+			}
+		}
+	}
+
+	// Addon domains are backed by a subdomain in cPanel; those are not
+	// imported as subdomains of their own
+	subs := arc.SubDomains[:0]
+	for _, s := range arc.SubDomains {
+		if addonSubs[s.FullName] {
+			continue
+		}
+		for _, parent := range arc.Domains() {
+			if strings.HasSuffix(s.FullName, "."+parent) {
+				s.Domain = parent
+				s.Name = strings.TrimSuffix(s.FullName, "."+parent)
+				break
+			}
+		}
+		if s.Domain == "" {
+			arc.Warnings = append(arc.Warnings, fmt.Sprintf("subdomain %s has no parent domain, skipped", s.FullName))
+			continue
+		}
+		subs = append(subs, s)
+	}
+	arc.SubDomains = subs
+}
+
+// yamlValue returns a top-level scalar from a flat YAML document
+func yamlValue(content, key string) string {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, key+":") {
+			return unquoteYAML(strings.TrimPrefix(line, key+":"))
+		}
+	}
+	return ""
+}
+
+func unquoteYAML(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		s = s[1 : len(s)-1]
+	}
+	return s
+}
+
+var (
+	grantUserRe = regexp.MustCompile("(?i)^GRANT USAGE ON \\*\\.\\* TO '([^']+)'@'([^']+)'(?: IDENTIFIED BY PASSWORD '([^']+)')?")
+	grantDBRe   = regexp.MustCompile("(?i)^GRANT .+ ON `([^`]+)`\\.\\* TO '([^']+)'@'([^']+)'")
+)
+
+// parseGrants reads mysql.sql and returns the MySQL users with the
+// databases they were granted
+func parseGrants(content string) []DatabaseUser {
+	users := map[string]*DatabaseUser{}
+	var order []string
+
+	get := func(name string) *DatabaseUser {
+		if u, ok := users[name]; ok {
+			return u
+		}
+		u := &DatabaseUser{Username: name, Databases: []string{}}
+		users[name] = u
+		order = append(order, name)
+		return u
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := grantUserRe.FindStringSubmatch(line); m != nil {
+			if m[2] != "localhost" {
+				continue
+			}
+			u := get(m[1])
+			if m[3] != "" {
+				u.PasswordHash = m[3]
+			}
+			continue
+		}
+		if m := grantDBRe.FindStringSubmatch(line); m != nil {
+			if m[3] != "localhost" {
+				continue
+			}
+			db := strings.ReplaceAll(m[1], `\_`, "_")
+			u := get(m[2])
+			if !containsString(u.Databases, db) {
+				u.Databases = append(u.Databases, db)
+			}
+		}
+	}
+
+	result := make([]DatabaseUser, 0, len(order))
+	for _, name := range order {
+		result = append(result, *users[name])
+	}
+	return result
+}
+
+var (
+	emailLocalRe  = regexp.MustCompile(`^[a-z0-9_+-]+(\.[a-z0-9_+-]+)*$`)
+	emailDomainRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*\.[a-z]{2,}$`)
+)
+
+// parseEmail reads homedir/etc/<domain>/{passwd,shadow,quota}. Mailboxes
+// whose names could not be used in Maildir paths and Postfix maps are
+// skipped with a warning.
+func parseEmail(arc *Archive, meta map[string]string, maildirs map[string]bool) {
+	var domains []string
+	for name := range meta {
+		if strings.HasPrefix(name, "homedir/etc/") && strings.HasSuffix(name, "/passwd") {
+			domains = append(domains, strings.TrimSuffix(strings.TrimPrefix(name, "homedir/etc/"), "/passwd"))
+		}
+	}
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		if !emailDomainRe.MatchString(strings.ToLower(domain)) {
+			arc.Warnings = append(arc.Warnings, fmt.Sprintf("mail domain %q is not valid, its mailboxes are skipped", domain))
+			continue
+		}
+		base := "homedir/etc/" + domain + "/"
+		hashes := colonFile(meta[base+"shadow"])
+		quotas := colonFile(meta[base+"quota"])
+
+		scanner := bufio.NewScanner(strings.NewReader(meta[base+"passwd"]))
+		for scanner.Scan() {
+			local, _, ok := strings.Cut(scanner.Text(), ":")
+			if !ok || local == "" {
+				continue
+			}
+			if len(local) > 64 || !emailLocalRe.MatchString(strings.ToLower(local)) {
+				arc.Warnings = append(arc.Warnings, fmt.Sprintf("mailbox %q of %s is not a valid address, skipped", local, domain))
+				continue
+			}
+			email := strings.ToLower(local + "@" + domain)
+			acc := EmailAccount{
+				Email:        email,
+				Domain:       strings.ToLower(domain),
+				LocalPart:    strings.ToLower(local),
+				PasswordHash: hashes[local],
+				QuotaMB:      1024,
+				HasMaildir:   maildirs[email],
+			}
+			if q, err := strconv.ParseInt(quotas[local], 10, 64); err == nil && q > 0 {
+				acc.QuotaMB = int(q / (1024 * 1024))
+			}
+			if acc.PasswordHash == "" {
+				arc.Warnings = append(arc.Warnings, fmt.Sprintf("no password hash for %s, a new password will be generated", email))
+			}
+			arc.EmailAccounts = append(arc.EmailAccounts, acc)
+		}
+	}
+}
+
+// colonFile maps the first field of "key:value:..." lines to the second
+func colonFile(content string) map[string]string {
+	result := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) >= 2 {
+			result[parts[0]] = parts[1]
+		}
+	}
+	return result
+}
+
+// parseForwarders reads va/<domain> ("source: dest1,dest2")
+func parseForwarders(arc *Archive, meta map[string]string) {
+	var names []string
+	for name := range meta {
+		if strings.HasPrefix(name, "va/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		scanner := bufio.NewScanner(strings.NewReader(meta[name]))
+		for scanner.Scan() {
+			source, dests, ok := strings.Cut(scanner.Text(), ":")
+			source = strings.ToLower(strings.TrimSpace(source))
+			if !ok || !strings.Contains(source, "@") || strings.HasPrefix(source, "*") {
+				continue
+			}
+			for _, dest := range strings.Split(dests, ",") {
+				dest = strings.TrimSpace(dest)
+				// Pipes, :fail: and :blackhole: targets have no equivalent here
+				if dest == "" || !strings.Contains(dest, "@") || strings.HasPrefix(dest, "|") || strings.HasPrefix(dest, ":") {
+					if dest != "" {
+						arc.Warnings = append(arc.Warnings, fmt.Sprintf("forwarder %s -> %s is not supported, skipped", source, dest))
+					}
+					continue
+				}
+				arc.Forwarders = append(arc.Forwarders, Forwarder{Source: source, Destination: strings.ToLower(dest)})
+			}
+		}
+	}
+}
+
+// parseZones reads dnszones/<domain>.db for the account's domains
+func parseZones(arc *Archive, meta map[string]string) {
+	for _, domain := range arc.Domains() {
+		content, ok := meta["dnszones/"+domain+".db"]
+		if !ok {
+			continue
+		}
+		parsed, err := dns.ParseZone(content, domain)
+		if err != nil {
+			arc.Warnings = append(arc.Warnings, fmt.Sprintf("zone %s could not be parsed: %v", domain, err))
+			continue
+		}
+		records := parsed.Records
+		if records == nil {
+			records = []dns.ZoneRecord{}
+		}
+		arc.Zones = append(arc.Zones, Zone{Domain: domain, Records: records, Warnings: parsed.Warnings})
+	}
+}
+
+func containsString(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,711 @@
+package cpanel
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+	"github.com/asergenalkan/serverpanel/internal/services/account"
+)
+
+var (
+	ErrPackageNotFound = errors.New("package not found")
+	ErrCannotImport    = errors.New("import has conflicts or exceeds package limits")
+	ErrUnsafePath      = errors.New("archive entry escapes its target directory")
+)
+
+// DB interface for database operations
+type DB interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Begin() (*sql.Tx, error)
+}
+
+type Service struct {
+	db  DB
+	cfg *config.Config
+}
+
+func NewService(db DB) *Service {
+	return &Service{
+		db:  db,
+		cfg: config.Get(),
+	}
+}
+
+// Options control how a cpmove archive is mapped onto this server
+type Options struct {
+	Username     string `json:"username"`      // Defaults to the cPanel username
+	PackageID    int64  `json:"package_id"`    // Required
+	Password     string `json:"password"`      // Panel password; generated if empty
+	ContactEmail string `json:"contact_email"` // Defaults to the cPanel contact address
+	ReplaceIP    bool   `json:"replace_ip"`    // Point A records of the old server IP to this one
+	DryRun       bool   `json:"dry_run"`
+}
+
+// Report is the import plan. With DryRun it is returned without changing
+// anything; otherwise it is what the executor works through.
+type Report struct {
+	SourceUsername string         `json:"source_username"`
+	Username       string         `json:"username"`
+	Email          string         `json:"email"`
+	Password       string         `json:"-"`
+	PackageID      int64          `json:"package_id"`
+	PackageName    string         `json:"package_name"`
+	HomeDir        string         `json:"home_dir"`
+	MainDomain     string         `json:"main_domain"`
+	DocumentRoot   string         `json:"document_root"`
+	AddonDomains   []AddonDomain  `json:"addon_domains"`
+	ParkedDomains  []string       `json:"parked_domains"`
+	SubDomains     []SubDomain    `json:"sub_domains"`
+	Databases      []Database     `json:"databases"`
+	DatabaseUsers  []DatabaseUser `json:"database_users"`
+	EmailAccounts  []EmailAccount `json:"email_accounts"`
+	Forwarders     []Forwarder    `json:"forwarders"`
+	Zones          []ZoneSummary  `json:"zones"`
+	HomedirFiles   int64          `json:"homedir_files"`
+	HomedirSizeMB  int64          `json:"homedir_size_mb"`
+	Conflicts      []string       `json:"conflicts"`
+	LimitErrors    []string       `json:"limit_errors"`
+	Warnings       []string       `json:"warnings"`
+	CanImport      bool           `json:"can_import"`
+	DryRun         bool           `json:"dry_run"`
+	Credentials    []Credential   `json:"credentials,omitempty"`
+
+	archive *Archive
+	oldIP   string
+	zones   []Zone
+}
+
+// Credential is a password generated for the import. They are returned
+// once with the started import and never written to the task log.
+type Credential struct {
+	Kind     string `json:"kind"` // panel, mysql or email
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type ZoneSummary struct {
+	Domain  string `json:"domain"`
+	Records int    `json:"records"`
+}
+
+// Plan maps the archive onto this server and checks it against existing
+// accounts and the package limits
+func (s *Service) Plan(arc *Archive, opts Options) (*Report, error) {
+	username := strings.ToLower(strings.TrimSpace(opts.Username))
+	if username == "" {
+		username = arc.Username
+	}
+
+	r := &Report{
+		SourceUsername: arc.Username,
+		Username:       username,
+		Email:          opts.ContactEmail,
+		Password:       opts.Password,
+		PackageID:      opts.PackageID,
+		HomeDir:        filepath.Join(s.cfg.HomeBaseDir, username),
+		MainDomain:     arc.MainDomain,
+		ParkedDomains:  arc.ParkedDomains,
+		Forwarders:     arc.Forwarders,
+		HomedirFiles:   arc.HomedirFiles,
+		HomedirSizeMB:  (arc.HomedirSize + 1024*1024 - 1) / (1024 * 1024),
+		Conflicts:      []string{},
+		LimitErrors:    []string{},
+		Warnings:       append([]string{}, arc.Warnings...),
+		DryRun:         opts.DryRun,
+		archive:        arc,
+		oldIP:          arc.OldIP,
+		zones:          arc.Zones,
+	}
+	if r.Email == "" {
+		r.Email = arc.ContactEmail
+	}
+	if r.Email == "" {
+		r.Email = "admin@" + arc.MainDomain
+	}
+	if !opts.ReplaceIP {
+		r.oldIP = ""
+	}
+
+	// Paths and MySQL names carry the cPanel username; rename them when the
+	// account is imported under a different name
+	r.DocumentRoot = s.mapDocRoot(arc, username, arc.DocumentRoot, "public_html")
+	for _, d := range arc.AddonDomains {
+		d.DocumentRoot = s.mapDocRoot(arc, username, d.DocumentRoot, "public_html/"+d.Domain)
+		r.AddonDomains = append(r.AddonDomains, d)
+	}
+	for _, sd := range arc.SubDomains {
+		sd.DocumentRoot = s.mapDocRoot(arc, username, sd.DocumentRoot, "public_html/"+sd.FullName)
+		r.SubDomains = append(r.SubDomains, sd)
+	}
+	for _, db := range arc.Databases {
+		r.Databases = append(r.Databases, Database{
+			Name:   renamePrefix(db.Name, arc.Username, username),
+			Source: db.Name,
+			Size:   db.Size,
+		})
+	}
+	for _, u := range arc.DatabaseUsers {
+		mapped := DatabaseUser{
+			Username:     renamePrefix(u.Username, arc.Username, username),
+			PasswordHash: u.PasswordHash,
+			Databases:    []string{},
+		}
+		// The cPanel account's own MySQL user has no equivalent here
+		if u.Username == arc.Username {
+			continue
+		}
+		for _, db := range u.Databases {
+			mapped.Databases = append(mapped.Databases, renamePrefix(db, arc.Username, username))
+		}
+		if mapped.PasswordHash == "" {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("MySQL user %s has no password hash and will get a new password", mapped.Username))
+		}
+		r.DatabaseUsers = append(r.DatabaseUsers, mapped)
+	}
+	r.EmailAccounts = append([]EmailAccount{}, arc.EmailAccounts...)
+	for _, z := range arc.Zones {
+		r.Zones = append(r.Zones, ZoneSummary{Domain: z.Domain, Records: len(z.Records)})
+		for _, w := range z.Warnings {
+			r.Warnings = append(r.Warnings, z.Domain+": "+w)
+		}
+	}
+	if r.AddonDomains == nil {
+		r.AddonDomains = []AddonDomain{}
+	}
+	if r.SubDomains == nil {
+		r.SubDomains = []SubDomain{}
+	}
+	if r.Databases == nil {
+		r.Databases = []Database{}
+	}
+	if r.DatabaseUsers == nil {
+		r.DatabaseUsers = []DatabaseUser{}
+	}
+	if r.Zones == nil {
+		r.Zones = []ZoneSummary{}
+	}
+
+	if err := s.check(r); err != nil {
+		return nil, err
+	}
+	r.CanImport = len(r.Conflicts) == 0 && len(r.LimitErrors) == 0
+	if r.CanImport && !r.DryRun {
+		r.generateCredentials()
+	}
+	return r, nil
+}
+
+// generateCredentials picks the passwords the import has to make up: the
+// panel password when none was given, MySQL users without a hash and
+// mailboxes whose hash can not be used for logins
+func (r *Report) generateCredentials() {
+	if r.Password == "" {
+		r.Password = generatePassword(16)
+		r.Credentials = append(r.Credentials, Credential{Kind: "panel", Name: r.Username, Password: r.Password})
+	}
+	for i, u := range r.DatabaseUsers {
+		if u.PasswordHash == "" {
+			r.DatabaseUsers[i].Password = generatePassword(16)
+			r.Credentials = append(r.Credentials, Credential{Kind: "mysql", Name: u.Username, Password: r.DatabaseUsers[i].Password})
+		}
+	}
+	for i, e := range r.EmailAccounts {
+		if e.PasswordHash == "" || strings.HasPrefix(e.PasswordHash, "!") || strings.HasPrefix(e.PasswordHash, "*") {
+			r.EmailAccounts[i].Password = generatePassword(16)
+			r.Credentials = append(r.Credentials, Credential{Kind: "email", Name: e.Email, Password: r.EmailAccounts[i].Password})
+		}
+	}
+}
+
+// check fills in conflicts with existing data and package limit violations
+func (s *Service) check(r *Report) error {
+	accounts := account.NewService(s.db)
+	if err := accounts.ValidateUsername(r.Username); err != nil {
+		r.Conflicts = append(r.Conflicts, fmt.Sprintf("username %q is not valid here, pick another with the username option", r.Username))
+	}
+
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", r.Username).Scan(&count)
+	if count > 0 {
+		r.Conflicts = append(r.Conflicts, fmt.Sprintf("username %s already exists", r.Username))
+	}
+
+	allDomains := []string{r.MainDomain}
+	for _, d := range r.AddonDomains {
+		allDomains = append(allDomains, d.Domain)
+	}
+	allDomains = append(allDomains, r.ParkedDomains...)
+	for _, d := range allDomains {
+		if err := accounts.ValidateDomain(d); err != nil {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("domain %q is not valid", d))
+		}
+		s.db.QueryRow("SELECT COUNT(*) FROM domains WHERE name = ?", d).Scan(&count)
+		if count > 0 {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("domain %s already exists", d))
+		}
+	}
+	for _, sd := range r.SubDomains {
+		s.db.QueryRow("SELECT COUNT(*) FROM subdomains WHERE full_name = ?", sd.FullName).Scan(&count)
+		if count > 0 {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("subdomain %s already exists", sd.FullName))
+		}
+	}
+	// MySQL names and hashes end up in statements run as root
+	for _, db := range r.Databases {
+		if !mysqlDBNameRe.MatchString(db.Name) {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("database name %q is not valid (a-z, 0-9 and _, at most 64 characters)", db.Name))
+			continue
+		}
+		s.db.QueryRow("SELECT COUNT(*) FROM databases WHERE name = ?", db.Name).Scan(&count)
+		if count > 0 {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("database %s already exists", db.Name))
+		}
+	}
+	for _, u := range r.DatabaseUsers {
+		if !mysqlUserRe.MatchString(u.Username) {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("MySQL user %q is not valid (a-z, 0-9 and _, at most 32 characters)", u.Username))
+			continue
+		}
+		if u.PasswordHash != "" && !mysqlHashRe.MatchString(u.PasswordHash) {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("MySQL user %s has an invalid password hash", u.Username))
+		}
+		for _, db := range u.Databases {
+			if !mysqlDBNameRe.MatchString(db) {
+				r.Conflicts = append(r.Conflicts, fmt.Sprintf("MySQL user %s is granted on invalid database name %q", u.Username, db))
+			}
+		}
+		s.db.QueryRow("SELECT COUNT(*) FROM database_users WHERE db_username = ?", u.Username).Scan(&count)
+		if count > 0 {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("MySQL user %s already exists", u.Username))
+		}
+	}
+	for _, e := range r.EmailAccounts {
+		s.db.QueryRow("SELECT COUNT(*) FROM email_accounts WHERE email = ?", e.Email).Scan(&count)
+		if count > 0 {
+			r.Conflicts = append(r.Conflicts, fmt.Sprintf("email account %s already exists", e.Email))
+		}
+	}
+
+	// Package limits; negative values mean unlimited
+	var diskQuota, maxDomains, maxDatabases, maxEmails int64
+	err := s.db.QueryRow(`
+		SELECT name, COALESCE(disk_quota, 1024), COALESCE(max_domains, 1),
+		       COALESCE(max_databases, 1), COALESCE(max_emails, 5)
+		FROM packages WHERE id = ?
+	`, r.PackageID).Scan(&r.PackageName, &diskQuota, &maxDomains, &maxDatabases, &maxEmails)
+	if err == sql.ErrNoRows {
+		return ErrPackageNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	limit := func(what string, have, max int64) {
+		if max >= 0 && have > max {
+			r.LimitErrors = append(r.LimitErrors, fmt.Sprintf("%s: %d needed, package %s allows %d", what, have, r.PackageName, max))
+		}
+	}
+	limit("domains", int64(len(allDomains)), maxDomains)
+	limit("subdomains", int64(len(r.SubDomains)), maxDomains*10)
+	limit("databases", int64(len(r.Databases)), maxDatabases)
+	limit("email accounts", int64(len(r.EmailAccounts)), maxEmails)
+	limit("disk space (MB)", r.HomedirSizeMB, diskQuota)
+
+	return nil
+}
+
+// mapDocRoot moves a cPanel document root (/home/USER/...) under the new
+// home directory. Roots outside the cPanel home fall back to def.
+func (s *Service) mapDocRoot(arc *Archive, username, root, def string) string {
+	home := filepath.Join(s.cfg.HomeBaseDir, username)
+	if root != "" {
+		parts := strings.Split(strings.Trim(filepath.ToSlash(filepath.Clean(root)), "/"), "/")
+		for i, p := range parts {
+			if p == arc.Username && i > 0 {
+				rest := filepath.Join(parts[i+1:]...)
+				if rest != "" && !strings.HasPrefix(rest, "..") {
+					return filepath.Join(home, rest)
+				}
+				break
+			}
+		}
+	}
+	return filepath.Join(home, def)
+}
+
+// renamePrefix rewrites cPanel's "USER_" MySQL prefix
+func renamePrefix(name, oldUser, newUser string) string {
+	if oldUser == newUser {
+		return name
+	}
+	if strings.HasPrefix(name, oldUser+"_") {
+		return newUser + "_" + strings.TrimPrefix(name, oldUser+"_")
+	}
+	if name == oldUser {
+		return newUser
+	}
+	return name
+}
+
+// ZoneRecords returns the parsed zones with A records of the old server IP
+// pointed at this server when ReplaceIP was requested
+func (r *Report) ZoneRecords(serverIP string) []Zone {
+	zones := make([]Zone, 0, len(r.zones))
+	for _, z := range r.zones {
+		out := Zone{Domain: z.Domain, Records: append(z.Records[:0:0], z.Records...)}
+		if r.oldIP != "" {
+			for i := range out.Records {
+				if out.Records[i].Type == "A" && out.Records[i].Content == r.oldIP {
+					out.Records[i].Content = serverIP
+				}
+			}
+		}
+		zones = append(zones, out)
+	}
+	return zones
+}
+
+// MailRoot returns the Maildir root (/var/mail/vhosts in production)
+func (s *Service) MailRoot() string {
+	if s.cfg.SimulateMode {
+		return filepath.Join(s.cfg.SimulateBasePath, "mail", "vhosts")
+	}
+	return "/var/mail/vhosts"
+}
+
+// homedirSkip lists cPanel-only paths that are not copied into the new home
+var homedirSkip = []string{"etc", "mail", ".cpanel", ".cphorde", ".trash", "tmp", "access-logs", "logs"}
+
+// ExtractFiles writes homedir/ into the account's home directory and the
+// Maildirs of the imported mailboxes into the mail root
+func (s *Service) ExtractFiles(r *Report) (files, size int64, err error) {
+	mailboxes := map[string]bool{}
+	for _, e := range r.EmailAccounts {
+		mailboxes[e.Domain+"/"+e.LocalPart] = true
+	}
+	mailRoot := s.MailRoot()
+
+	if err := os.MkdirAll(r.HomeDir, 0711); err != nil {
+		return 0, 0, err
+	}
+
+	err = walk(r.archive.Path, func(name string, hdr *tar.Header, rd io.Reader) error {
+		if !strings.HasPrefix(name, "homedir/") {
+			return nil
+		}
+		rel := strings.TrimPrefix(name, "homedir/")
+		top := strings.SplitN(rel, "/", 2)[0]
+
+		var root, target string
+		switch {
+		case top == "mail":
+			// mail/<domain>/<local>/...
+			parts := strings.SplitN(rel, "/", 4)
+			if len(parts) < 3 || !mailboxes[parts[1]+"/"+parts[2]] {
+				return nil
+			}
+			root = filepath.Join(mailRoot, parts[1], parts[2])
+			if len(parts) == 4 {
+				target = parts[3]
+			}
+		case containsString(homedirSkip, top):
+			return nil
+		default:
+			root = r.HomeDir
+			target = rel
+		}
+
+		if err := os.MkdirAll(root, 0700); err != nil {
+			return err
+		}
+		if target == "" {
+			return nil
+		}
+		path, err := securePath(root, target)
+		if err != nil {
+			log.Printf("⚠️ cPanel import: skipped %s: %v", name, err)
+			return nil
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			return os.MkdirAll(path, mode|0700)
+		case tar.TypeSymlink:
+			os.Remove(path)
+			return os.Symlink(hdr.Linkname, path)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			// Never write through an existing symlink
+			os.Remove(path)
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(f, rd)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			os.Chtimes(path, hdr.ModTime, hdr.ModTime)
+			files++
+			size += n
+		}
+		return nil
+	})
+	if err != nil {
+		return files, size, err
+	}
+
+	s.chown(r.Username+":"+r.Username, r.HomeDir)
+	for mb := range mailboxes {
+		if _, err := os.Stat(filepath.Join(mailRoot, mb)); err == nil {
+			s.chown("vmail:vmail", filepath.Join(mailRoot, mb))
+		}
+	}
+	return files, size, nil
+}
+
+// securePath joins rel below root and refuses paths that leave root,
+// including through symlinks already present on disk
+func securePath(root, rel string) (string, error) {
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if path != root && !strings.HasPrefix(path, root+string(os.PathSeparator)) {
+		return "", ErrUnsafePath
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		if dir == root {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if realDir != realRoot && !strings.HasPrefix(realDir, realRoot+string(os.PathSeparator)) {
+		return "", ErrUnsafePath
+	}
+	return path, nil
+}
+
+// ImportDatabases creates the databases and MySQL users and loads the
+// dumps. Outside production the dumps are written to the simulation dir.
+// The dumps come from another server, so each one is loaded as a temporary
+// MySQL user granted only on its own database rather than as root.
+func (s *Service) ImportDatabases(r *Report, logf func(string, ...interface{})) error {
+	if err := validateMySQLNames(r); err != nil {
+		return err
+	}
+
+	isProduction := os.Getenv("ENVIRONMENT") == "production"
+	mysqlPassword := os.Getenv("MYSQL_ROOT_PASSWORD")
+	rootExec := func(stmt string) error {
+		output, err := exec.Command("mysql", "-u", "root", fmt.Sprintf("-p%s", mysqlPassword), "-e", stmt).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	sources := map[string]string{}
+	for _, db := range r.Databases {
+		sources[db.Source] = db.Name
+		if isProduction {
+			createDBCmd := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s` CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci;", db.Name)
+			if err := rootExec(createDBCmd); err != nil {
+				return fmt.Errorf("failed to create database %s: %w", db.Name, err)
+			}
+		}
+	}
+
+	simDir := filepath.Join(s.cfg.SimulateBasePath, "mysql")
+	if !isProduction {
+		os.MkdirAll(simDir, 0755)
+	}
+
+	err := walk(r.archive.Path, func(name string, hdr *tar.Header, rd io.Reader) error {
+		if !strings.HasPrefix(name, "mysql/") || !strings.HasSuffix(name, ".sql") {
+			return nil
+		}
+		target, ok := sources[strings.TrimSuffix(filepath.Base(name), ".sql")]
+		if !ok {
+			return nil
+		}
+
+		if !isProduction {
+			f, err := os.Create(filepath.Join(simDir, target+".sql"))
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(f, rd)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			logf("🔧 [SIMÜLASYON] mysql %s < %s (%d bytes)", target, filepath.Base(name), n)
+			return nil
+		}
+
+		loader := "import_" + generatePassword(16)
+		password := generatePassword(32)
+		err := rootExec(fmt.Sprintf(
+			"CREATE USER '%s'@'localhost' IDENTIFIED BY '%s'; GRANT ALL PRIVILEGES ON `%s`.* TO '%s'@'localhost';",
+			loader, password, target, loader))
+		if err != nil {
+			return fmt.Errorf("failed to create import user for %s: %w", target, err)
+		}
+		defer rootExec(fmt.Sprintf("DROP USER IF EXISTS '%s'@'localhost';", loader))
+
+		cmd := exec.Command("mysql", "-u", loader, target)
+		cmd.Env = append(os.Environ(), "MYSQL_PWD="+password)
+		dump := stripDefiners(rd)
+		defer dump.Close()
+		cmd.Stdin = dump
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("mysql import of %s failed: %s - %w", target, strings.TrimSpace(stderr.String()), err)
+		}
+		logf("✅ Database %s loaded", target)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, u := range r.DatabaseUsers {
+		identified := fmt.Sprintf("IDENTIFIED WITH mysql_native_password AS '%s'", u.PasswordHash)
+		if u.PasswordHash == "" {
+			if u.Password == "" {
+				return fmt.Errorf("MySQL user %s has neither a hash nor a password", u.Username)
+			}
+			identified = fmt.Sprintf("IDENTIFIED BY '%s'", u.Password)
+			logf("⚠️ MySQL user %s got a new password", u.Username)
+		}
+		r.DatabaseUsers[i].PasswordHash = ""
+		if !isProduction {
+			logf("🔧 [SIMÜLASYON] CREATE USER '%s'@'localhost' (%d databases)", u.Username, len(u.Databases))
+			continue
+		}
+
+		stmt := fmt.Sprintf("CREATE USER IF NOT EXISTS '%s'@'localhost' %s;", u.Username, identified)
+		for _, db := range u.Databases {
+			stmt += fmt.Sprintf(" GRANT ALL PRIVILEGES ON `%s`.* TO '%s'@'localhost';", db, u.Username)
+		}
+		stmt += " FLUSH PRIVILEGES;"
+		if err := rootExec(stmt); err != nil {
+			return fmt.Errorf("failed to create MySQL user %s: %w", u.Username, err)
+		}
+	}
+	return nil
+}
+
+var (
+	mysqlDBNameRe = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+	mysqlUserRe   = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+	mysqlHashRe   = regexp.MustCompile(`^\*[0-9A-F]{40}$`)
+	definerRe     = regexp.MustCompile("DEFINER=`[^`]*`@`[^`]*`")
+)
+
+// validateMySQLNames refuses a plan whose MySQL names or hashes could break
+// out of the statements they are written into. check reports the same
+// problems as conflicts; this guards the executor on its own.
+func validateMySQLNames(r *Report) error {
+	for _, db := range r.Databases {
+		if !mysqlDBNameRe.MatchString(db.Name) {
+			return fmt.Errorf("invalid database name %q", db.Name)
+		}
+	}
+	for _, u := range r.DatabaseUsers {
+		if !mysqlUserRe.MatchString(u.Username) {
+			return fmt.Errorf("invalid MySQL user %q", u.Username)
+		}
+		if u.PasswordHash != "" && !mysqlHashRe.MatchString(u.PasswordHash) {
+			return fmt.Errorf("invalid password hash for MySQL user %s", u.Username)
+		}
+		for _, db := range u.Databases {
+			if !mysqlDBNameRe.MatchString(db) {
+				return fmt.Errorf("invalid database name %q", db)
+			}
+		}
+	}
+	return nil
+}
+
+// stripDefiners drops the DEFINER clauses of views, triggers and routines,
+// which an unprivileged user may not set to another account; the objects
+// are then owned by the user loading the dump
+func stripDefiners(rd io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		br := bufio.NewReaderSize(rd, 64*1024)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) > 0 {
+				if bytes.Contains(line, []byte("DEFINER=")) {
+					line = definerRe.ReplaceAll(line, nil)
+				}
+				if _, werr := pw.Write(line); werr != nil {
+					return
+				}
+			}
+			if err == io.EOF {
+				pw.Close()
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// chown fixes ownership of imported files (skipped in simulation mode)
+func (s *Service) chown(owner, path string) {
+	if config.IsDevelopment() || !s.cfg.IsLinux {
+		log.Printf("🔧 [SIMÜLASYON] chown -R %s %s", owner, path)
+		return
+	}
+	exec.Command("chown", "-R", owner, path).Run()
+}
+
+func generatePassword(length int) string {
+	b := make([]byte, length/2)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ArchivePath returns the cpmove archive the plan was made from
+func (r *Report) ArchivePath() string {
+	return r.archive.Path
+}
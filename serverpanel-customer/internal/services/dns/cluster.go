@@ -0,0 +1,579 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPeerNotFound = errors.New("DNS cluster peer not found")
+	ErrPeerExists   = errors.New("a DNS cluster peer with this name already exists")
+	ErrInvalidPeer  = errors.New("peer URL must be an http(s) URL of a panel")
+	ErrZoneConflict = errors.New("the zone is owned by another server in the DNS cluster")
+)
+
+// Sync actions and states of a zone on a peer
+const (
+	ClusterPush   = "push"
+	ClusterDelete = "delete"
+
+	SyncPending  = "pending"
+	SyncSynced   = "synced"
+	SyncFailed   = "failed"
+	SyncConflict = "conflict"
+)
+
+// ClusterTokenHeader carries the peer token on cluster requests
+const ClusterTokenHeader = "X-Cluster-Token"
+
+// clusterZonePath is where the zones of a peer are pushed to
+const clusterZonePath = "/api/v1/dns/cluster/zones/"
+
+// syncMu keeps the background sync and the one started by a change from
+// pushing the same zone twice at once
+var syncMu sync.Mutex
+
+var clusterClient = &http.Client{Timeout: 15 * time.Second}
+
+// ClusterPeer is another panel server in the DNS cluster. The token is
+// shared: it is sent with the zones pushed to the peer and expected on the
+// zones the peer pushes here, so both sides register each other with it.
+type ClusterPeer struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	URL        string     `json:"url"`
+	Token      string     `json:"token,omitempty"` // only returned when the peer is added
+	Active     bool       `json:"active"`
+	LastSyncAt *time.Time `json:"last_sync_at"`
+	LastError  string     `json:"last_error"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Zones of this server by sync state on the peer, and zones served
+	// here for the peer
+	Synced    int `json:"synced"`
+	Pending   int `json:"pending"`
+	Failed    int `json:"failed"`
+	Conflicts int `json:"conflicts"`
+	Hosted    int `json:"hosted"`
+
+	token string
+}
+
+// ClusterRecord is a record of a zone pushed between peers
+type ClusterRecord struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Content  string `json:"content"`
+	TTL      int    `json:"ttl"`
+	Priority int    `json:"priority"`
+}
+
+// ClusterZone is a zone pushed to a peer
+type ClusterZone struct {
+	Domain  string          `json:"domain"`
+	Records []ClusterRecord `json:"records"`
+}
+
+// ZoneSync is the sync state of a zone of this server on a peer
+type ZoneSync struct {
+	Domain    string     `json:"domain"`
+	Action    string     `json:"action"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error"`
+	Attempts  int        `json:"attempts"`
+	SyncedAt  *time.Time `json:"synced_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ZoneConflict is a domain claimed by more than one server of the cluster
+type ZoneConflict struct {
+	Domain string `json:"domain"`
+	Peer   string `json:"peer"`
+	// "local": the domain is on this server and served here for the
+	// peer; "remote": the peer refused a zone of this server
+	Kind  string `json:"kind"`
+	Error string `json:"error,omitempty"`
+}
+
+// Peers returns the cluster peers with their sync counts
+func (s *Service) Peers() ([]ClusterPeer, error) {
+	rows, err := s.db.Query(`
+		SELECT p.id, p.name, p.url, p.active, p.last_sync_at, COALESCE(p.last_error, ''), p.created_at,
+		       (SELECT COUNT(*) FROM dns_cluster_sync WHERE peer_id = p.id AND status = 'synced'),
+		       (SELECT COUNT(*) FROM dns_cluster_sync WHERE peer_id = p.id AND status = 'pending'),
+		       (SELECT COUNT(*) FROM dns_cluster_sync WHERE peer_id = p.id AND status = 'failed'),
+		       (SELECT COUNT(*) FROM dns_cluster_sync WHERE peer_id = p.id AND status = 'conflict'),
+		       (SELECT COUNT(*) FROM dns_cluster_zones WHERE peer_id = p.id)
+		FROM dns_cluster_peers p
+		ORDER BY p.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	peers := []ClusterPeer{}
+	for rows.Next() {
+		var p ClusterPeer
+		var lastSync sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Name, &p.URL, &p.Active, &lastSync, &p.LastError, &p.CreatedAt,
+			&p.Synced, &p.Pending, &p.Failed, &p.Conflicts, &p.Hosted); err != nil {
+			return nil, err
+		}
+		if lastSync.Valid {
+			p.LastSyncAt = &lastSync.Time
+		}
+		peers = append(peers, p)
+	}
+	return peers, rows.Err()
+}
+
+// Peer returns a cluster peer
+func (s *Service) Peer(id int64) (*ClusterPeer, error) {
+	peers, err := s.Peers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range peers {
+		if peers[i].ID == id {
+			return &peers[i], nil
+		}
+	}
+	return nil, ErrPeerNotFound
+}
+
+// AddPeer registers a cluster peer and queues every zone of this server
+// for it. An empty token generates one, to be registered on the peer.
+func (s *Service) AddPeer(name, peerURL, token string) (*ClusterPeer, error) {
+	name = strings.TrimSpace(name)
+	peerURL = strings.TrimRight(strings.TrimSpace(peerURL), "/")
+	u, err := url.Parse(peerURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || name == "" {
+		return nil, ErrInvalidPeer
+	}
+
+	if token == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		token = hex.EncodeToString(b)
+	}
+	if len(token) < 32 {
+		return nil, fmt.Errorf("%w: token must be at least 32 characters", ErrInvalidPeer)
+	}
+
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM dns_cluster_peers WHERE name = ?", name).Scan(&count)
+	if count > 0 {
+		return nil, ErrPeerExists
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO dns_cluster_peers (name, url, token, active) VALUES (?, ?, ?, 1)
+	`, name, peerURL, token)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := result.LastInsertId()
+
+	s.queuePeerZones(id)
+
+	p, err := s.Peer(id)
+	if err != nil {
+		return nil, err
+	}
+	p.Token = token
+	return p, nil
+}
+
+// DeletePeer removes a cluster peer and returns the zones that were served
+// here for it
+func (s *Service) DeletePeer(id int64) ([]string, error) {
+	rows, err := s.db.Query("SELECT domain FROM dns_cluster_zones WHERE peer_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	var domains []string
+	for rows.Next() {
+		var d string
+		if rows.Scan(&d) == nil {
+			domains = append(domains, d)
+		}
+	}
+	rows.Close()
+
+	result, err := s.db.Exec("DELETE FROM dns_cluster_peers WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrPeerNotFound
+	}
+	return domains, nil
+}
+
+// PeerByToken returns the active peer a cluster request comes from
+func (s *Service) PeerByToken(token string) (*ClusterPeer, error) {
+	if token == "" {
+		return nil, ErrPeerNotFound
+	}
+	rows, err := s.db.Query("SELECT id, name, token FROM dns_cluster_peers WHERE active = 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p ClusterPeer
+		if rows.Scan(&p.ID, &p.Name, &p.token) != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(p.token), []byte(token)) == 1 {
+			return &p, nil
+		}
+	}
+	return nil, ErrPeerNotFound
+}
+
+// ZoneSyncs returns the sync state of the zones of this server on a peer
+func (s *Service) ZoneSyncs(peerID int64) ([]ZoneSync, error) {
+	rows, err := s.db.Query(`
+		SELECT domain, action, status, COALESCE(error, ''), attempts, synced_at, updated_at
+		FROM dns_cluster_sync WHERE peer_id = ?
+		ORDER BY domain
+	`, peerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	syncs := []ZoneSync{}
+	for rows.Next() {
+		var z ZoneSync
+		var synced sql.NullTime
+		if err := rows.Scan(&z.Domain, &z.Action, &z.Status, &z.Error, &z.Attempts, &synced, &z.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if synced.Valid {
+			z.SyncedAt = &synced.Time
+		}
+		syncs = append(syncs, z)
+	}
+	return syncs, rows.Err()
+}
+
+// QueueZone marks a zone of this server to be pushed to (or deleted from)
+// every active peer
+func (s *Service) QueueZone(domain, action string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO dns_cluster_sync (peer_id, domain, action, status, attempts, updated_at)
+		SELECT id, ?, ?, 'pending', 0, CURRENT_TIMESTAMP FROM dns_cluster_peers WHERE active = 1
+		ON CONFLICT(peer_id, domain) DO UPDATE SET action = excluded.action, status = 'pending',
+			error = NULL, attempts = 0, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	`, domain, action)
+	return err
+}
+
+// ResyncPeer queues every zone of this server for a peer again
+func (s *Service) ResyncPeer(peerID int64) error {
+	if _, err := s.Peer(peerID); err != nil {
+		return err
+	}
+	return s.queuePeerZones(peerID)
+}
+
+// queuePeerZones queues the zones of this server that have records for a
+// full sync to a peer
+func (s *Service) queuePeerZones(peerID int64) error {
+	_, err := s.db.Exec(`
+		INSERT INTO dns_cluster_sync (peer_id, domain, action, status, attempts, updated_at)
+		SELECT ?, d.name, 'push', 'pending', 0, CURRENT_TIMESTAMP FROM domains d
+		WHERE d.active = 1 AND EXISTS (SELECT 1 FROM dns_records r WHERE r.domain_id = d.id)
+		ON CONFLICT(peer_id, domain) DO UPDATE SET action = 'push', status = 'pending',
+			error = NULL, attempts = 0, version = version + 1, updated_at = CURRENT_TIMESTAMP
+	`, peerID)
+	return err
+}
+
+// SyncCluster pushes the pending and failed zones to the peers
+func (s *Service) SyncCluster() error {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+
+	type job struct {
+		peer    ClusterPeer
+		domain  string
+		action  string
+		version int
+	}
+	rows, err := s.db.Query(`
+		SELECT p.id, p.name, p.url, p.token, s.domain, s.action, s.version
+		FROM dns_cluster_sync s
+		JOIN dns_cluster_peers p ON s.peer_id = p.id
+		WHERE p.active = 1 AND s.status IN ('pending', 'failed')
+		ORDER BY p.id, s.updated_at
+	`)
+	if err != nil {
+		return err
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if rows.Scan(&j.peer.ID, &j.peer.Name, &j.peer.URL, &j.peer.token, &j.domain, &j.action, &j.version) == nil {
+			jobs = append(jobs, j)
+		}
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		err := s.syncZone(&j.peer, j.domain, j.action)
+		status, message := SyncSynced, ""
+		switch {
+		case errors.Is(err, ErrZoneConflict):
+			status, message = SyncConflict, err.Error()
+			log.Printf("⚠️ DNS cluster: %s is owned by another server (%s)", j.domain, j.peer.Name)
+		case err != nil:
+			status, message = SyncFailed, err.Error()
+			log.Printf("❌ DNS cluster: %s %s to %s failed: %v", j.action, j.domain, j.peer.Name, err)
+		}
+
+		// A change queued while pushing keeps the zone pending
+		s.db.Exec(`
+			UPDATE dns_cluster_sync SET status = ?, error = NULLIF(?, ''), attempts = attempts + 1,
+				synced_at = CASE WHEN ? = 'synced' THEN CURRENT_TIMESTAMP ELSE synced_at END,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE peer_id = ? AND domain = ? AND version = ?
+		`, status, message, status, j.peer.ID, j.domain, j.version)
+		s.db.Exec(`
+			UPDATE dns_cluster_peers SET last_sync_at = CURRENT_TIMESTAMP,
+				last_error = NULLIF(?, '') WHERE id = ?
+		`, message, j.peer.ID)
+	}
+	return nil
+}
+
+// syncZone pushes a zone to a peer or deletes it there
+func (s *Service) syncZone(p *ClusterPeer, domain, action string) error {
+	target := p.URL + clusterZonePath + url.PathEscape(domain)
+
+	var req *http.Request
+	var err error
+	if action == ClusterDelete {
+		req, err = http.NewRequest(http.MethodDelete, target, nil)
+	} else {
+		var zone *ClusterZone
+		zone, err = s.localZone(domain)
+		if err != nil {
+			return err
+		}
+		body, _ := json.Marshal(zone)
+		req, err = http.NewRequest(http.MethodPut, target, bytes.NewReader(body))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ClusterTokenHeader, p.token)
+
+	resp, err := clusterClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	json.Unmarshal(data, &body)
+	if body.Error == "" {
+		body.Error = resp.Status
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %s", ErrZoneConflict, strings.TrimPrefix(body.Error, ErrZoneConflict.Error()+": "))
+	}
+	return fmt.Errorf("peer answered %d: %s", resp.StatusCode, body.Error)
+}
+
+// localZone returns the active records of a zone of this server
+func (s *Service) localZone(domain string) (*ClusterZone, error) {
+	var domainID int64
+	err := s.db.QueryRow("SELECT id FROM domains WHERE name = ? AND active = 1", domain).Scan(&domainID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("zone %s is no longer on this server", domain)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT name, type, content, ttl, priority FROM dns_records
+		WHERE domain_id = ? AND active = 1
+		ORDER BY type, name
+	`, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	zone := &ClusterZone{Domain: domain, Records: []ClusterRecord{}}
+	for rows.Next() {
+		var r ClusterRecord
+		if err := rows.Scan(&r.Name, &r.Type, &r.Content, &r.TTL, &r.Priority); err != nil {
+			return nil, err
+		}
+		zone.Records = append(zone.Records, r)
+	}
+	return zone, rows.Err()
+}
+
+// ClusterOwner returns the peer a domain is served for, if any
+func (s *Service) ClusterOwner(domain string) (string, bool) {
+	var peer string
+	err := s.db.QueryRow(`
+		SELECT p.name FROM dns_cluster_zones z JOIN dns_cluster_peers p ON z.peer_id = p.id
+		WHERE z.domain = ?
+	`, domain).Scan(&peer)
+	return peer, err == nil
+}
+
+// ReceiveZone stores a zone pushed by a peer. A zone owned by this server
+// or by another peer is refused with ErrZoneConflict.
+func (s *Service) ReceiveZone(peer *ClusterPeer, zone ClusterZone) error {
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM domains WHERE name = ?", zone.Domain).Scan(&count)
+	if count > 0 {
+		return fmt.Errorf("%w: %s is a domain of this server", ErrZoneConflict, zone.Domain)
+	}
+
+	var ownerID int64
+	var stored string
+	var serial uint32
+	err := s.db.QueryRow("SELECT peer_id, records, serial FROM dns_cluster_zones WHERE domain = ?", zone.Domain).
+		Scan(&ownerID, &stored, &serial)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil && ownerID != peer.ID {
+		owner := strconv.FormatInt(ownerID, 10)
+		s.db.QueryRow("SELECT name FROM dns_cluster_peers WHERE id = ?", ownerID).Scan(&owner)
+		return fmt.Errorf("%w: %s is served here for %s", ErrZoneConflict, zone.Domain, owner)
+	}
+
+	records, _ := json.Marshal(zone.Records)
+	if string(records) == stored {
+		return nil
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO dns_cluster_zones (domain, peer_id, records, serial, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(domain) DO UPDATE SET records = excluded.records, serial = excluded.serial,
+			updated_at = CURRENT_TIMESTAMP
+	`, zone.Domain, peer.ID, string(records), nextSerial(serial))
+	return err
+}
+
+// RemoveZone deletes a zone a peer stopped serving
+func (s *Service) RemoveZone(peer *ClusterPeer, domain string) (bool, error) {
+	owner, ok := s.ClusterOwner(domain)
+	if !ok {
+		return false, nil
+	}
+	if owner != peer.Name {
+		return false, fmt.Errorf("%w: %s is served here for %s", ErrZoneConflict, domain, owner)
+	}
+	_, err := s.db.Exec("DELETE FROM dns_cluster_zones WHERE domain = ? AND peer_id = ?", domain, peer.ID)
+	return err == nil, err
+}
+
+// ClusterZoneRecords returns the records of a zone served for a peer
+func (s *Service) ClusterZoneRecords(domain string) ([]ClusterRecord, error) {
+	var data string
+	if err := s.db.QueryRow("SELECT records FROM dns_cluster_zones WHERE domain = ?", domain).Scan(&data); err != nil {
+		return nil, err
+	}
+	var records []ClusterRecord
+	err := json.Unmarshal([]byte(data), &records)
+	return records, err
+}
+
+// Conflicts returns the domains claimed by more than one server
+func (s *Service) Conflicts() ([]ZoneConflict, error) {
+	conflicts := []ZoneConflict{}
+
+	rows, err := s.db.Query(`
+		SELECT z.domain, p.name FROM dns_cluster_zones z
+		JOIN dns_cluster_peers p ON z.peer_id = p.id
+		JOIN domains d ON d.name = z.domain
+		ORDER BY z.domain
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		c := ZoneConflict{Kind: "local"}
+		if rows.Scan(&c.Domain, &c.Peer) == nil {
+			conflicts = append(conflicts, c)
+		}
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`
+		SELECT s.domain, p.name, COALESCE(s.error, '') FROM dns_cluster_sync s
+		JOIN dns_cluster_peers p ON s.peer_id = p.id
+		WHERE s.status = 'conflict'
+		ORDER BY s.domain
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c := ZoneConflict{Kind: "remote"}
+		if rows.Scan(&c.Domain, &c.Peer, &c.Error) == nil {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts, rows.Err()
+}
+
+// StartClusterWorker retries the failed zone pushes periodically
+func (s *Service) StartClusterWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.SyncCluster(); err != nil {
+				log.Printf("❌ DNS cluster sync failed: %v", err)
+			}
+		}
+	}()
+}
+
+// nextSerial returns a YYYYMMDDHH serial newer than serial
+func nextSerial(serial uint32) uint32 {
+	next := serial + 1
+	if now, _ := strconv.ParseUint(time.Now().Format("2006010215"), 10, 32); uint32(now) > next {
+		next = uint32(now)
+	}
+	return next
+}
@@ -8,6 +8,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
 )
 
 // Manager handles DNS zone operations
@@ -172,12 +174,37 @@ zone "%s" {
 	return nil
 }
 
+// WriteZone writes the zone file of a domain, adding the zone to
+// named.conf.local when it is not there yet, and reloads BIND
+func (m *Manager) WriteZone(domain, content string) error {
+	zonePath := m.GetZonePath()
+	if err := os.MkdirAll(zonePath, 0755); err != nil {
+		return fmt.Errorf("failed to create zone directory: %w", err)
+	}
+
+	zoneFile := filepath.Join(zonePath, "db."+domain)
+	if err := os.WriteFile(zoneFile, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write zone file: %w", err)
+	}
+
+	conf, _ := os.ReadFile(filepath.Join(m.GetConfigPath(), "named.conf.local"))
+	if !strings.Contains(string(conf), fmt.Sprintf("zone %q", domain)) {
+		if err := m.addZoneToConfig(domain, zoneFile); err != nil {
+			return err
+		}
+	}
+	return m.Reload()
+}
+
 // DeleteZone removes a DNS zone
 func (m *Manager) DeleteZone(domain string) error {
 	zoneFile := filepath.Join(m.GetZonePath(), "db."+domain)
 	if err := os.Remove(zoneFile); err != nil && !os.IsNotExist(err) {
 		log.Printf("Warning: failed to remove zone file: %v", err)
 	}
+	// Signed zone and DNSSEC keys, if any
+	os.Remove(zoneFile + ".signed")
+	m.writeKeys(domain, nil)
 
 	log.Printf("🗑️ DNS zone deleted: %s", domain)
 	// Note: In production, we'd also need to remove the zone from named.conf.local
@@ -186,6 +213,11 @@ func (m *Manager) DeleteZone(domain string) error {
 
 // Reload reloads BIND
 func (m *Manager) Reload() error {
+	// spdns reads the records from the database on its own
+	if config.Get().DNSServer == "native" {
+		return nil
+	}
+
 	if m.simulateMode {
 		log.Printf("🔧 [SIMÜLASYON] named-checkconf && systemctl reload bind9")
 		return nil
@@ -241,5 +273,12 @@ func (m *Manager) AddRecord(domain, recordType, name, value string, ttl int) err
 	}
 
 	log.Printf("📝 DNS record added: %s.%s %s %s", name, domain, recordType, value)
+
+	// A signed zone has to be signed again to serve the record
+	if m.zoneSigned(domain) {
+		if err := m.signZoneFile(domain); err != nil {
+			return err
+		}
+	}
 	return m.Reload()
 }
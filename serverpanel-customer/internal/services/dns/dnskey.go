@@ -0,0 +1,189 @@
+package dns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DNSSEC key flags and the signing algorithm of panel-managed zones
+const (
+	FlagsZSK = 256
+	FlagsKSK = 257
+
+	AlgECDSAP256SHA256 = 13
+	DigestSHA256       = 2
+)
+
+// Key states, derived from the key timing
+const (
+	KeyPublished = "published" // in the DNSKEY set, not signing yet
+	KeyActive    = "active"
+	KeyRetired   = "retired" // no longer signing, removed at DeleteAt
+)
+
+// DNSSECKey is a signing key of a zone
+type DNSSECKey struct {
+	ID         int64      `json:"id"`
+	DomainID   int64      `json:"domain_id"`
+	Flags      int        `json:"flags"`
+	Algorithm  int        `json:"algorithm"`
+	KeyTag     int        `json:"key_tag"`
+	PublicKey  string     `json:"public_key"`
+	PrivateKey string     `json:"-"`
+	PublishAt  time.Time  `json:"publish_at"`
+	ActivateAt time.Time  `json:"activate_at"`
+	InactiveAt *time.Time `json:"inactive_at,omitempty"`
+	DeleteAt   *time.Time `json:"delete_at,omitempty"`
+	Role       string     `json:"role"` // KSK or ZSK
+	State      string     `json:"state"`
+}
+
+// DSRecord is the delegation signer record of a KSK, submitted to the
+// registrar of the domain
+type DSRecord struct {
+	KeyTag     int    `json:"key_tag"`
+	Algorithm  int    `json:"algorithm"`
+	DigestType int    `json:"digest_type"`
+	Digest     string `json:"digest"`
+	Record     string `json:"record"`
+}
+
+// generateKey creates an ECDSA P-256 key
+func generateKey(flags int, publish, activate time.Time) (DNSSECKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return DNSSECKey{}, err
+	}
+
+	pub := make([]byte, 64)
+	priv.PublicKey.X.FillBytes(pub[:32])
+	priv.PublicKey.Y.FillBytes(pub[32:])
+	d := make([]byte, 32)
+	priv.D.FillBytes(d)
+
+	k := DNSSECKey{
+		Flags:      flags,
+		Algorithm:  AlgECDSAP256SHA256,
+		PublicKey:  base64.StdEncoding.EncodeToString(pub),
+		PrivateKey: base64.StdEncoding.EncodeToString(d),
+		PublishAt:  publish.UTC().Truncate(time.Second),
+		ActivateAt: activate.UTC().Truncate(time.Second),
+	}
+	k.KeyTag = keyTag(k.rdata())
+	return k, nil
+}
+
+// IsKSK reports whether the key signs the DNSKEY set
+func (k DNSSECKey) IsKSK() bool {
+	return k.Flags == FlagsKSK
+}
+
+// state returns the state of the key at now
+func (k DNSSECKey) state(now time.Time) string {
+	switch {
+	case k.InactiveAt != nil && !now.Before(*k.InactiveAt):
+		return KeyRetired
+	case now.Before(k.ActivateAt):
+		return KeyPublished
+	}
+	return KeyActive
+}
+
+// rdata returns the DNSKEY RDATA of the key
+func (k DNSSECKey) rdata() []byte {
+	pub, _ := base64.StdEncoding.DecodeString(k.PublicKey)
+	rdata := make([]byte, 4, 4+len(pub))
+	binary.BigEndian.PutUint16(rdata, uint16(k.Flags))
+	rdata[2] = 3 // protocol
+	rdata[3] = byte(k.Algorithm)
+	return append(rdata, pub...)
+}
+
+// keyTag computes the key tag of DNSKEY RDATA (RFC 4034 appendix B)
+func keyTag(rdata []byte) int {
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 1 {
+			ac += uint32(b)
+		} else {
+			ac += uint32(b) << 8
+		}
+	}
+	ac += ac >> 16 & 0xFFFF
+	return int(ac & 0xFFFF)
+}
+
+// DNSKEY returns the DNSKEY record of the key
+func (k DNSSECKey) DNSKEY(domain string) string {
+	return fmt.Sprintf("%s IN DNSKEY %d 3 %d %s", fqdn(domain), k.Flags, k.Algorithm, k.PublicKey)
+}
+
+// DS returns the SHA-256 DS record of the key
+func (k DNSSECKey) DS(domain string) DSRecord {
+	sum := sha256.Sum256(append(wireName(domain), k.rdata()...))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return DSRecord{
+		KeyTag:     k.KeyTag,
+		Algorithm:  k.Algorithm,
+		DigestType: DigestSHA256,
+		Digest:     digest,
+		Record:     fmt.Sprintf("%s IN DS %d %d %d %s", fqdn(domain), k.KeyTag, k.Algorithm, DigestSHA256, digest),
+	}
+}
+
+// wireName returns a name in canonical wire format
+func wireName(name string) []byte {
+	var wire []byte
+	for _, label := range strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".") {
+		if label == "" {
+			continue
+		}
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+	}
+	return append(wire, 0)
+}
+
+// fileBase is the name of the key's files in the BIND key directory
+func (k DNSSECKey) fileBase(domain string) string {
+	return fmt.Sprintf("K%s+%03d+%05d", fqdn(domain), k.Algorithm, k.KeyTag)
+}
+
+// keyFile renders the .key file of the key
+func (k DNSSECKey) keyFile(domain string) string {
+	kind := "zone-signing key"
+	if k.IsKSK() {
+		kind = "key-signing key"
+	}
+	return fmt.Sprintf("; This is a %s, keyid %d, for %s\n%s\n", kind, k.KeyTag, fqdn(domain), k.DNSKEY(domain))
+}
+
+// privateFile renders the .private file of the key with the timing
+// metadata dnssec-signzone -S uses to publish, activate and retire it
+func (k DNSSECKey) privateFile() string {
+	stamp := func(t time.Time) string {
+		return t.UTC().Format("20060102150405")
+	}
+	var b strings.Builder
+	b.WriteString("Private-key-format: v1.3\n")
+	fmt.Fprintf(&b, "Algorithm: %d (ECDSAP256SHA256)\n", k.Algorithm)
+	fmt.Fprintf(&b, "PrivateKey: %s\n", k.PrivateKey)
+	fmt.Fprintf(&b, "Created: %s\n", stamp(k.PublishAt))
+	fmt.Fprintf(&b, "Publish: %s\n", stamp(k.PublishAt))
+	fmt.Fprintf(&b, "Activate: %s\n", stamp(k.ActivateAt))
+	if k.InactiveAt != nil {
+		fmt.Fprintf(&b, "Inactive: %s\n", stamp(*k.InactiveAt))
+	}
+	if k.DeleteAt != nil {
+		fmt.Fprintf(&b, "Delete: %s\n", stamp(*k.DeleteAt))
+	}
+	return b.String()
+}
@@ -0,0 +1,434 @@
+package dns
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+)
+
+var (
+	ErrDNSSECEnabled   = errors.New("DNSSEC is already enabled for this zone")
+	ErrDNSSECDisabled  = errors.New("DNSSEC is not enabled for this zone")
+	ErrRolloverPending = errors.New("a ZSK rollover is already in progress")
+	ErrDNSSECNative    = errors.New("DNSSEC is not supported by the built-in DNS server")
+)
+
+const (
+	// ZSKs are rolled over when they have signed this long
+	zskLifetime = 90 * 24 * time.Hour
+	// A new ZSK is published this long before it signs, so resolvers
+	// have it when the first signatures made with it appear
+	zskPrepublish = 2 * 24 * time.Hour
+	// A retired ZSK stays published until signatures made with it have
+	// expired from caches
+	zskRetire = 2 * 24 * time.Hour
+	// Validity of the signatures; zones are re-signed well before
+	signatureValidity = 30 * 24 * time.Hour
+)
+
+// DB interface for database operations
+type DB interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Service keeps the DNSSEC keys of panel-managed zones and signs them
+type Service struct {
+	db  DB
+	cfg *config.Config
+}
+
+func NewService(db DB) *Service {
+	return &Service{
+		db:  db,
+		cfg: config.Get(),
+	}
+}
+
+func (s *Service) manager() *Manager {
+	return NewManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath)
+}
+
+// Keys returns the DNSSEC keys of a zone, KSKs first
+func (s *Service) Keys(domainID int64) ([]DNSSECKey, error) {
+	rows, err := s.db.Query(`
+		SELECT id, domain_id, flags, algorithm, key_tag, public_key, private_key,
+		       publish_at, activate_at, inactive_at, delete_at
+		FROM dnssec_keys WHERE domain_id = ?
+		ORDER BY flags DESC, activate_at
+	`, domainID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	keys := []DNSSECKey{}
+	for rows.Next() {
+		var k DNSSECKey
+		var inactive, del sql.NullTime
+		if err := rows.Scan(&k.ID, &k.DomainID, &k.Flags, &k.Algorithm, &k.KeyTag, &k.PublicKey, &k.PrivateKey,
+			&k.PublishAt, &k.ActivateAt, &inactive, &del); err != nil {
+			return nil, err
+		}
+		if inactive.Valid {
+			k.InactiveAt = &inactive.Time
+		}
+		if del.Valid {
+			k.DeleteAt = &del.Time
+		}
+		k.Role = "ZSK"
+		if k.IsKSK() {
+			k.Role = "KSK"
+		}
+		k.State = k.state(now)
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// Enabled reports whether a zone is signed
+func (s *Service) Enabled(domainID int64) bool {
+	var count int
+	s.db.QueryRow("SELECT COUNT(*) FROM dnssec_keys WHERE domain_id = ?", domainID).Scan(&count)
+	return count > 0
+}
+
+// Enable creates the KSK and ZSK of a zone. The zone is signed the next
+// time its zone file is written.
+func (s *Service) Enable(domainID int64) ([]DNSSECKey, error) {
+	if s.cfg.DNSServer == "native" {
+		return nil, ErrDNSSECNative
+	}
+	if s.Enabled(domainID) {
+		return nil, ErrDNSSECEnabled
+	}
+
+	now := time.Now()
+	for _, flags := range []int{FlagsKSK, FlagsZSK} {
+		k, err := generateKey(flags, now, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate DNSSEC key: %w", err)
+		}
+		k.DomainID = domainID
+		if err := s.insertKey(k); err != nil {
+			return nil, err
+		}
+	}
+	return s.Keys(domainID)
+}
+
+// Disable removes the keys of a zone and points BIND back at the unsigned
+// zone file. The DS record must be removed at the registrar first, or
+// validating resolvers will fail the domain.
+func (s *Service) Disable(domainID int64) error {
+	if !s.Enabled(domainID) {
+		return ErrDNSSECDisabled
+	}
+	var domain string
+	if err := s.db.QueryRow("SELECT name FROM domains WHERE id = ?", domainID).Scan(&domain); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM dnssec_keys WHERE domain_id = ?", domainID); err != nil {
+		return err
+	}
+	return s.manager().UnsignZone(domain)
+}
+
+// Sign signs the zone file of a domain if DNSSEC is enabled for it. BIND
+// still has to be reloaded.
+func (s *Service) Sign(domain string) error {
+	var domainID int64
+	if err := s.db.QueryRow("SELECT id FROM domains WHERE name = ?", domain).Scan(&domainID); err != nil {
+		return nil
+	}
+	keys, err := s.Keys(domainID)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	return s.manager().SignZone(domain, keys)
+}
+
+// DSRecords returns the DS records of the published KSKs of a zone
+func (s *Service) DSRecords(domainID int64) ([]DSRecord, error) {
+	var domain string
+	if err := s.db.QueryRow("SELECT name FROM domains WHERE id = ?", domainID).Scan(&domain); err != nil {
+		return nil, err
+	}
+	keys, err := s.Keys(domainID)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []DSRecord{}
+	for _, k := range keys {
+		if k.IsKSK() && k.State != KeyRetired {
+			records = append(records, k.DS(domain))
+		}
+	}
+	return records, nil
+}
+
+// RolloverZSK starts a pre-publish rollover of the ZSK of a zone: the new
+// key is published now and signs after zskPrepublish, when the old key
+// retires. The zone has to be signed again.
+func (s *Service) RolloverZSK(domainID int64) (*DNSSECKey, error) {
+	keys, err := s.Keys(domainID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, ErrDNSSECDisabled
+	}
+	for _, k := range keys {
+		if !k.IsKSK() && k.State == KeyPublished {
+			return nil, ErrRolloverPending
+		}
+	}
+
+	now := time.Now()
+	next, err := generateKey(FlagsZSK, now, now.Add(zskPrepublish))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate DNSSEC key: %w", err)
+	}
+	next.DomainID = domainID
+
+	inactive := next.ActivateAt
+	for _, k := range keys {
+		if k.IsKSK() || k.State != KeyActive {
+			continue
+		}
+		if _, err := s.db.Exec("UPDATE dnssec_keys SET inactive_at = ?, delete_at = ? WHERE id = ?",
+			inactive, inactive.Add(zskRetire), k.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.insertKey(next); err != nil {
+		return nil, err
+	}
+
+	log.Printf("🔑 ZSK rollover started for domain %d: key %d signs from %s", domainID, next.KeyTag, inactive.Format(time.RFC3339))
+	return &next, nil
+}
+
+func (s *Service) insertKey(k DNSSECKey) error {
+	_, err := s.db.Exec(`
+		INSERT INTO dnssec_keys (domain_id, flags, algorithm, key_tag, public_key, private_key, publish_at, activate_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, k.DomainID, k.Flags, k.Algorithm, k.KeyTag, k.PublicKey, k.PrivateKey, k.PublishAt, k.ActivateAt)
+	return err
+}
+
+// MaintainKeys removes deleted keys, rolls over ZSKs past zskLifetime and
+// signs every DNSSEC zone again so its signatures never expire
+func (s *Service) MaintainKeys() error {
+	now := time.Now().UTC().Truncate(time.Second) // compared as stored
+	if _, err := s.db.Exec("DELETE FROM dnssec_keys WHERE delete_at IS NOT NULL AND delete_at <= ?", now); err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT DISTINCT d.id, d.name FROM dnssec_keys k JOIN domains d ON d.id = k.domain_id
+	`)
+	if err != nil {
+		return err
+	}
+	type zone struct {
+		id   int64
+		name string
+	}
+	var zones []zone
+	for rows.Next() {
+		var z zone
+		if rows.Scan(&z.id, &z.name) == nil {
+			zones = append(zones, z)
+		}
+	}
+	rows.Close()
+	if len(zones) == 0 {
+		return nil
+	}
+
+	for _, z := range zones {
+		keys, err := s.Keys(z.id)
+		if err != nil {
+			log.Printf("⚠️ DNSSEC keys of %s: %v", z.name, err)
+			continue
+		}
+		if zskDue(keys, now) {
+			if _, err := s.RolloverZSK(z.id); err != nil {
+				log.Printf("⚠️ ZSK rollover of %s: %v", z.name, err)
+			}
+		}
+		if err := s.Sign(z.name); err != nil {
+			log.Printf("❌ Signing %s failed: %v", z.name, err)
+		}
+	}
+	return s.manager().Reload()
+}
+
+// zskDue reports whether the newest ZSK of a zone has signed for
+// zskLifetime with no rollover in progress
+func zskDue(keys []DNSSECKey, now time.Time) bool {
+	var newest *DNSSECKey
+	for i, k := range keys {
+		if k.IsKSK() {
+			continue
+		}
+		if k.State == KeyPublished {
+			return false
+		}
+		if newest == nil || k.ActivateAt.After(newest.ActivateAt) {
+			newest = &keys[i]
+		}
+	}
+	return newest != nil && now.Sub(newest.ActivateAt) >= zskLifetime
+}
+
+// StartKeyWorker runs MaintainKeys periodically in the background
+func (s *Service) StartKeyWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.MaintainKeys(); err != nil {
+				log.Printf("❌ DNSSEC key maintenance failed: %v", err)
+			}
+		}
+	}()
+}
+
+// GetKeyPath returns the directory of the DNSSEC key files
+func (m *Manager) GetKeyPath() string {
+	if m.simulateMode {
+		return filepath.Join(m.basePath, "bind", "keys")
+	}
+	return "/etc/bind/keys"
+}
+
+// SignZone signs the zone file of a domain into db.<domain>.signed with
+// dnssec-signzone and points named.conf.local at it. The zone file itself
+// stays unsigned so it can be regenerated from dns_records.
+func (m *Manager) SignZone(domain string, keys []DNSSECKey) error {
+	if err := m.writeKeys(domain, keys); err != nil {
+		return err
+	}
+	return m.signZoneFile(domain)
+}
+
+// signZoneFile signs a zone with the key files already in the key
+// directory
+func (m *Manager) signZoneFile(domain string) error {
+	zoneFile := filepath.Join(m.GetZonePath(), "db."+domain)
+	signedFile := zoneFile + ".signed"
+	args := []string{
+		"-S", "-K", m.GetKeyPath(), "-d", m.GetKeyPath(),
+		"-o", domain,
+		"-e", "+" + strconv.Itoa(int(signatureValidity.Seconds())),
+		"-f", signedFile,
+		zoneFile,
+	}
+
+	if m.simulateMode {
+		log.Printf("🔧 [SIMÜLASYON] dnssec-signzone %s", strings.Join(args, " "))
+		// Unsigned zone with the DNSKEY set, as far as it can be simulated
+		content, err := os.ReadFile(zoneFile)
+		if err != nil {
+			return fmt.Errorf("failed to read zone file: %w", err)
+		}
+		signed := string(content) + "\n; DNSKEY Records\n"
+		keyFiles, _ := filepath.Glob(filepath.Join(m.GetKeyPath(), "K"+fqdn(domain)+"+*.key"))
+		for _, f := range keyFiles {
+			if key, err := os.ReadFile(f); err == nil {
+				signed += string(key)
+			}
+		}
+		if err := os.WriteFile(signedFile, []byte(signed), 0644); err != nil {
+			return fmt.Errorf("failed to write signed zone: %w", err)
+		}
+	} else {
+		cmd := exec.Command("dnssec-signzone", args...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("dnssec-signzone failed: %s - %w", string(output), err)
+		}
+	}
+
+	log.Printf("🔏 DNS zone signed: %s", domain)
+	return m.setZoneFile(domain, signedFile)
+}
+
+// zoneSigned reports whether a domain is served from a signed zone file
+func (m *Manager) zoneSigned(domain string) bool {
+	_, err := os.Stat(filepath.Join(m.GetZonePath(), "db."+domain+".signed"))
+	return err == nil
+}
+
+// UnsignZone points named.conf.local back at the unsigned zone file of a
+// domain and removes its signed zone and key files
+func (m *Manager) UnsignZone(domain string) error {
+	zoneFile := filepath.Join(m.GetZonePath(), "db."+domain)
+	if err := m.setZoneFile(domain, zoneFile); err != nil {
+		return err
+	}
+	os.Remove(zoneFile + ".signed")
+	if err := m.writeKeys(domain, nil); err != nil {
+		return err
+	}
+	log.Printf("🔓 DNSSEC disabled: %s", domain)
+	return m.Reload()
+}
+
+// writeKeys replaces the key files of a domain in the key directory
+func (m *Manager) writeKeys(domain string, keys []DNSSECKey) error {
+	keyPath := m.GetKeyPath()
+	if err := os.MkdirAll(keyPath, 0750); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	old, _ := filepath.Glob(filepath.Join(keyPath, "K"+fqdn(domain)+"+*"))
+	for _, f := range old {
+		os.Remove(f)
+	}
+	os.Remove(filepath.Join(keyPath, "dsset-"+fqdn(domain)))
+
+	for _, k := range keys {
+		base := filepath.Join(keyPath, k.fileBase(domain))
+		if err := os.WriteFile(base+".key", []byte(k.keyFile(domain)), 0644); err != nil {
+			return fmt.Errorf("failed to write key file: %w", err)
+		}
+		if err := os.WriteFile(base+".private", []byte(k.privateFile()), 0600); err != nil {
+			return fmt.Errorf("failed to write key file: %w", err)
+		}
+	}
+	return nil
+}
+
+// setZoneFile changes the file of a zone in named.conf.local
+func (m *Manager) setZoneFile(domain, zoneFile string) error {
+	configPath := filepath.Join(m.GetConfigPath(), "named.conf.local")
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read named.conf.local: %w", err)
+	}
+
+	entry := regexp.MustCompile(`(zone "` + regexp.QuoteMeta(domain) + `" \{[^}]*?file ")[^"]*(";)`)
+	updated := entry.ReplaceAllString(string(content), "${1}"+strings.ReplaceAll(zoneFile, "$", "$$")+"${2}")
+	if updated == string(content) {
+		return nil
+	}
+	return os.WriteFile(configPath, []byte(updated), 0644)
+}
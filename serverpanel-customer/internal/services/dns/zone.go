@@ -0,0 +1,469 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ZoneRecord is a resource record parsed from a BIND zone file. Names are
+// relative to the zone origin ("@" for the apex) and hostnames in the
+// content are fully qualified with a trailing dot, matching dns_records.
+type ZoneRecord struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Content  string `json:"content"`
+	TTL      int    `json:"ttl"`
+	Priority int    `json:"priority"`
+}
+
+// SOARecord holds the parsed SOA of a zone
+type SOARecord struct {
+	PrimaryNS  string `json:"primary_ns"`
+	Hostmaster string `json:"hostmaster"`
+	Serial     uint32 `json:"serial"`
+	Refresh    int    `json:"refresh"`
+	Retry      int    `json:"retry"`
+	Expire     int    `json:"expire"`
+	Minimum    int    `json:"minimum"`
+}
+
+// ParsedZone is the result of ParseZone
+type ParsedZone struct {
+	Origin   string       `json:"origin"`
+	TTL      int          `json:"ttl"`
+	SOA      *SOARecord   `json:"soa,omitempty"`
+	Records  []ZoneRecord `json:"records"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+// ParseZone parses a BIND zone file for origin. It understands $ORIGIN,
+// $TTL, comments, multi-line (parenthesized) records, omitted owners, TTL
+// units (1h, 2d) and relative names. Records outside the zone are skipped
+// with a warning.
+func ParseZone(content, origin string) (*ParsedZone, error) {
+	origin = fqdn(strings.ToLower(origin))
+	zone := &ParsedZone{Origin: strings.TrimSuffix(origin, "."), TTL: 3600}
+
+	currentOrigin := origin
+	lastOwner := origin
+	lastTTL := -1
+
+	entries, err := zoneEntries(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		fields := e.fields
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "$TTL":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: $TTL without value", e.line)
+			}
+			ttl, err := ParseTTL(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", e.line, err)
+			}
+			zone.TTL = ttl
+			continue
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: $ORIGIN without value", e.line)
+			}
+			currentOrigin = absoluteName(strings.ToLower(fields[1]), currentOrigin)
+			continue
+		case "$INCLUDE", "$GENERATE":
+			zone.Warnings = append(zone.Warnings, fmt.Sprintf("line %d: %s is not supported, skipped", e.line, fields[0]))
+			continue
+		}
+
+		// Owner: omitted when the line starts with whitespace
+		owner := lastOwner
+		if !e.indented {
+			owner = absoluteName(strings.ToLower(fields[0]), currentOrigin)
+			fields = fields[1:]
+		}
+		lastOwner = owner
+
+		// Optional TTL and class, in either order
+		ttl := -1
+		for len(fields) > 0 {
+			upper := strings.ToUpper(fields[0])
+			if upper == "IN" || upper == "CH" || upper == "HS" {
+				fields = fields[1:]
+				continue
+			}
+			if t, err := ParseTTL(fields[0]); err == nil && ttl < 0 && isTTLToken(fields[0]) {
+				ttl = t
+				fields = fields[1:]
+				continue
+			}
+			break
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: missing record type", e.line)
+		}
+		if ttl < 0 {
+			// $TTL is the default; without it the last explicit TTL carries over
+			ttl = zone.TTL
+			if !e.hasTTLDirective && lastTTL >= 0 {
+				ttl = lastTTL
+			}
+		} else {
+			lastTTL = ttl
+		}
+
+		rrType := strings.ToUpper(fields[0])
+		rdata := fields[1:]
+
+		if rrType == "SOA" {
+			soa, err := parseSOA(rdata, currentOrigin)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", e.line, err)
+			}
+			zone.SOA = soa
+			if !e.hasTTLDirective && soa.Minimum > 0 {
+				// Without $TTL the SOA minimum is the default TTL (RFC 1035)
+				zone.TTL = soa.Minimum
+			}
+			continue
+		}
+
+		name, ok := relativeName(owner, origin)
+		if !ok {
+			zone.Warnings = append(zone.Warnings, fmt.Sprintf("line %d: %s is outside zone %s, skipped", e.line, owner, zone.Origin))
+			continue
+		}
+
+		rec, err := buildRecord(name, rrType, rdata, ttl, currentOrigin)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", e.line, err)
+		}
+		zone.Records = append(zone.Records, rec)
+	}
+
+	return zone, nil
+}
+
+// zoneEntry is one logical record (parentheses joined) of a zone file
+type zoneEntry struct {
+	line            int
+	indented        bool
+	hasTTLDirective bool
+	fields          []string
+}
+
+// zoneEntries tokenizes the zone file into logical entries. Quoted strings
+// are kept as single fields including their quotes.
+func zoneEntries(content string) ([]zoneEntry, error) {
+	var entries []zoneEntry
+	var cur *zoneEntry
+	depth := 0
+	sawTTL := false
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		if cur == nil {
+			cur = &zoneEntry{line: i + 1, indented: len(line) > 0 && (line[0] == ' ' || line[0] == '\t')}
+		}
+
+		tokens, opens, err := tokenizeZoneLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		depth += opens
+		if depth < 0 {
+			return nil, fmt.Errorf("line %d: unbalanced parentheses", i+1)
+		}
+		cur.fields = append(cur.fields, tokens...)
+
+		if depth == 0 {
+			if len(cur.fields) > 0 {
+				if strings.EqualFold(cur.fields[0], "$TTL") {
+					sawTTL = true
+				}
+				cur.hasTTLDirective = sawTTL
+				entries = append(entries, *cur)
+			}
+			cur = nil
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses at end of zone")
+	}
+	return entries, nil
+}
+
+// tokenizeZoneLine splits a line into fields, dropping comments and
+// parentheses. Returns the net parenthesis depth change.
+func tokenizeZoneLine(line string) ([]string, int, error) {
+	var tokens []string
+	var buf strings.Builder
+	depth := 0
+	inQuote := false
+
+	flush := func() {
+		if buf.Len() > 0 {
+			tokens = append(tokens, buf.String())
+			buf.Reset()
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case inQuote:
+			buf.WriteByte(ch)
+			if ch == '\\' && i+1 < len(line) {
+				i++
+				buf.WriteByte(line[i])
+			} else if ch == '"' {
+				inQuote = false
+				flush()
+			}
+		case ch == '"':
+			flush()
+			inQuote = true
+			buf.WriteByte(ch)
+		case ch == ';':
+			flush()
+			return tokens, depth, nil
+		case ch == '(':
+			flush()
+			depth++
+		case ch == ')':
+			flush()
+			depth--
+		case unicode.IsSpace(rune(ch)):
+			flush()
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	if inQuote {
+		return nil, 0, fmt.Errorf("unterminated quoted string")
+	}
+	flush()
+	return tokens, depth, nil
+}
+
+func parseSOA(rdata []string, origin string) (*SOARecord, error) {
+	if len(rdata) < 7 {
+		return nil, fmt.Errorf("SOA record needs 7 fields, got %d", len(rdata))
+	}
+	serial, err := strconv.ParseUint(rdata[2], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOA serial: %s", rdata[2])
+	}
+	soa := &SOARecord{
+		PrimaryNS:  absoluteName(strings.ToLower(rdata[0]), origin),
+		Hostmaster: absoluteName(strings.ToLower(rdata[1]), origin),
+		Serial:     uint32(serial),
+	}
+	timers := []*int{&soa.Refresh, &soa.Retry, &soa.Expire, &soa.Minimum}
+	for i, t := range timers {
+		v, err := ParseTTL(rdata[3+i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOA timer: %s", rdata[3+i])
+		}
+		*t = v
+	}
+	return soa, nil
+}
+
+// buildRecord converts rdata into the dns_records representation
+func buildRecord(name, rrType string, rdata []string, ttl int, origin string) (ZoneRecord, error) {
+	rec := ZoneRecord{Name: name, Type: rrType, TTL: ttl}
+
+	need := func(n int) error {
+		if len(rdata) < n {
+			return fmt.Errorf("%s record for %s needs %d fields", rrType, name, n)
+		}
+		return nil
+	}
+
+	switch rrType {
+	case "A", "AAAA":
+		if err := need(1); err != nil {
+			return rec, err
+		}
+		rec.Content = rdata[0]
+	case "CNAME", "NS", "PTR", "DNAME":
+		if err := need(1); err != nil {
+			return rec, err
+		}
+		rec.Content = absoluteName(strings.ToLower(rdata[0]), origin)
+	case "MX":
+		if err := need(2); err != nil {
+			return rec, err
+		}
+		prio, err := strconv.Atoi(rdata[0])
+		if err != nil {
+			return rec, fmt.Errorf("invalid MX priority: %s", rdata[0])
+		}
+		rec.Priority = prio
+		rec.Content = absoluteName(strings.ToLower(rdata[1]), origin)
+	case "SRV":
+		if err := need(4); err != nil {
+			return rec, err
+		}
+		prio, err := strconv.Atoi(rdata[0])
+		if err != nil {
+			return rec, fmt.Errorf("invalid SRV priority: %s", rdata[0])
+		}
+		rec.Priority = prio
+		rec.Content = fmt.Sprintf("%s %s %s", rdata[1], rdata[2], absoluteName(strings.ToLower(rdata[3]), origin))
+	case "TXT", "SPF":
+		if err := need(1); err != nil {
+			return rec, err
+		}
+		// Character strings are concatenated into one value
+		var sb strings.Builder
+		for _, part := range rdata {
+			sb.WriteString(unquoteZoneString(part))
+		}
+		rec.Content = sb.String()
+	case "HTTPS", "SVCB":
+		if err := need(2); err != nil {
+			return rec, err
+		}
+		prio, err := strconv.Atoi(rdata[0])
+		if err != nil {
+			return rec, fmt.Errorf("invalid %s priority: %s", rrType, rdata[0])
+		}
+		rec.Priority = prio
+		target := rdata[1]
+		if target != "." {
+			target = absoluteName(strings.ToLower(target), origin)
+		}
+		// key="quoted value" is tokenized as two fields
+		params := []string{target}
+		for i := 2; i < len(rdata); i++ {
+			param := rdata[i]
+			if strings.HasSuffix(param, "=") && i+1 < len(rdata) && strings.HasPrefix(rdata[i+1], `"`) {
+				i++
+				param += rdata[i]
+			}
+			params = append(params, param)
+		}
+		rec.Content = strings.Join(params, " ")
+	case "DS", "TLSA", "SSHFP":
+		// Hex data may be split over several fields
+		fixed := 3
+		if rrType == "SSHFP" {
+			fixed = 2
+		}
+		if err := need(fixed + 1); err != nil {
+			return rec, err
+		}
+		rec.Content = strings.Join(rdata[:fixed], " ") + " " + strings.Join(rdata[fixed:], "")
+	case "NAPTR":
+		if err := need(6); err != nil {
+			return rec, err
+		}
+		replacement := rdata[5]
+		if replacement != "." {
+			replacement = absoluteName(strings.ToLower(replacement), origin)
+		}
+		rec.Content = strings.Join(append(rdata[:5:5], replacement), " ")
+	default:
+		if err := need(1); err != nil {
+			return rec, err
+		}
+		rec.Content = strings.Join(rdata, " ")
+	}
+	return rec, nil
+}
+
+// ParseTTL parses a BIND TTL value: plain seconds or units (1w2d3h4m5s)
+func ParseTTL(s string) (int, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty TTL")
+	}
+	if v, err := strconv.Atoi(s); err == nil {
+		if v < 0 {
+			return 0, fmt.Errorf("invalid TTL: %s", s)
+		}
+		return v, nil
+	}
+
+	total, num := 0, -1
+	for _, ch := range strings.ToLower(s) {
+		if ch >= '0' && ch <= '9' {
+			if num < 0 {
+				num = 0
+			}
+			num = num*10 + int(ch-'0')
+			continue
+		}
+		if num < 0 {
+			return 0, fmt.Errorf("invalid TTL: %s", s)
+		}
+		switch ch {
+		case 's':
+			total += num
+		case 'm':
+			total += num * 60
+		case 'h':
+			total += num * 3600
+		case 'd':
+			total += num * 86400
+		case 'w':
+			total += num * 604800
+		default:
+			return 0, fmt.Errorf("invalid TTL: %s", s)
+		}
+		num = -1
+	}
+	if num >= 0 {
+		total += num
+	}
+	return total, nil
+}
+
+// isTTLToken reports whether a field looks like a TTL rather than a name
+func isTTLToken(s string) bool {
+	return len(s) > 0 && s[0] >= '0' && s[0] <= '9'
+}
+
+func unquoteZoneString(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(s, `\"`, `"`), `\\`, `\`)
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// absoluteName expands a possibly relative name against origin
+func absoluteName(name, origin string) string {
+	if name == "@" {
+		return origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "." + origin
+}
+
+// relativeName returns name relative to origin ("@" for the apex)
+func relativeName(name, origin string) (string, bool) {
+	if name == origin {
+		return "@", true
+	}
+	if strings.HasSuffix(name, "."+origin) {
+		return strings.TrimSuffix(name, "."+origin), true
+	}
+	return "", false
+}
@@ -0,0 +1,171 @@
+package dns
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseZone(t *testing.T) {
+	zone, err := ParseZone(`$TTL 1h
+$ORIGIN example.com.
+@   IN SOA ns1.example.com. hostmaster.example.com. (
+        2024010101 ; serial
+        7200 3600 1209600 300 )
+    IN NS ns1
+    IN NS ns2.other.net.
+@ 300 IN A 192.0.2.1
+www IN CNAME @
+mail 1d IN A 192.0.2.2
+@ IN MX 10 mail
+@ IN TXT "v=spf1 -all" ; comment
+_sip._tcp IN SRV 10 60 5060 sip.example.com.
+@ IN CAA 0 issue "letsencrypt.org"
+other.net. IN A 192.0.2.9
+$ORIGIN sub.example.com.
+host IN AAAA 2001:db8::1
+$INCLUDE other.zone
+long IN TXT ( "part one"
+   "part two" )
+`, "Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if zone.Origin != "example.com" || zone.TTL != 3600 {
+		t.Errorf("origin %q, TTL %d", zone.Origin, zone.TTL)
+	}
+	wantSOA := &SOARecord{
+		PrimaryNS: "ns1.example.com.", Hostmaster: "hostmaster.example.com.",
+		Serial: 2024010101, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 300,
+	}
+	if !reflect.DeepEqual(zone.SOA, wantSOA) {
+		t.Errorf("SOA = %+v, want %+v", zone.SOA, wantSOA)
+	}
+
+	want := []ZoneRecord{
+		{"@", "NS", "ns1.example.com.", 3600, 0},
+		{"@", "NS", "ns2.other.net.", 3600, 0},
+		{"@", "A", "192.0.2.1", 300, 0},
+		{"www", "CNAME", "example.com.", 3600, 0},
+		{"mail", "A", "192.0.2.2", 86400, 0},
+		{"@", "MX", "mail.example.com.", 3600, 10},
+		{"@", "TXT", "v=spf1 -all", 3600, 0},
+		{"_sip._tcp", "SRV", "60 5060 sip.example.com.", 3600, 10},
+		{"@", "CAA", `0 issue "letsencrypt.org"`, 3600, 0},
+		{"host.sub", "AAAA", "2001:db8::1", 3600, 0},
+		{"long.sub", "TXT", "part onepart two", 3600, 0},
+	}
+	if !reflect.DeepEqual(zone.Records, want) {
+		t.Errorf("records:\n got %+v\nwant %+v", zone.Records, want)
+	}
+
+	if len(zone.Warnings) != 2 ||
+		!strings.Contains(zone.Warnings[0], "other.net. is outside zone") ||
+		!strings.Contains(zone.Warnings[1], "$INCLUDE is not supported") {
+		t.Errorf("warnings = %q", zone.Warnings)
+	}
+}
+
+func TestParseZoneRecords(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+		want []ZoneRecord
+	}{
+		{
+			"without $TTL the last TTL carries over",
+			"a 600 IN A 192.0.2.1\nb IN A 192.0.2.2\n",
+			[]ZoneRecord{{"a", "A", "192.0.2.1", 600, 0}, {"b", "A", "192.0.2.2", 600, 0}},
+		},
+		{
+			"without $TTL the SOA minimum is the default",
+			"@ IN SOA ns1 host 1 2 3 4 900\nwww IN A 192.0.2.1\n",
+			[]ZoneRecord{{"www", "A", "192.0.2.1", 900, 0}},
+		},
+		{
+			"class and TTL in either order",
+			"$TTL 60\na IN 120 A 192.0.2.1\nb 2h IN A 192.0.2.2\nc A 192.0.2.3\n",
+			[]ZoneRecord{{"a", "A", "192.0.2.1", 120, 0}, {"b", "A", "192.0.2.2", 7200, 0}, {"c", "A", "192.0.2.3", 60, 0}},
+		},
+		{
+			"omitted owner repeats the last one",
+			"$TTL 60\nmail IN A 192.0.2.1\n     IN AAAA 2001:db8::1\n",
+			[]ZoneRecord{{"mail", "A", "192.0.2.1", 60, 0}, {"mail", "AAAA", "2001:db8::1", 60, 0}},
+		},
+		{
+			"absolute owner inside the zone",
+			"$TTL 60\nWWW.Example.COM. IN A 192.0.2.1\n",
+			[]ZoneRecord{{"www", "A", "192.0.2.1", 60, 0}},
+		},
+		{
+			"semicolon inside quotes is not a comment",
+			"$TTL 60\n@ IN TXT \"a;b\" ; real comment\n",
+			[]ZoneRecord{{"@", "TXT", "a;b", 60, 0}},
+		},
+		{
+			"empty zone",
+			"; nothing here\n\n",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		zone, err := ParseZone(tt.zone, "example.com")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(zone.Records, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, zone.Records, tt.want)
+		}
+	}
+}
+
+func TestParseZoneErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		zone string
+	}{
+		{"unbalanced parentheses", "@ IN SOA ns1 host ( 1 2 3 4 5\n"},
+		{"closing parenthesis without opening", "@ IN A 192.0.2.1 )\n"},
+		{"unterminated quote", "@ IN TXT \"open\n"},
+		{"$TTL without value", "$TTL\n"},
+		{"invalid $TTL", "$TTL soon\n"},
+		{"$ORIGIN without value", "$ORIGIN\n"},
+		{"short SOA", "@ IN SOA ns1 host 1 2 3\n"},
+		{"invalid SOA serial", "@ IN SOA ns1 host x 2 3 4 5\n"},
+		{"missing record type", "www 300 IN\n"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseZone(tt.zone, "example.com"); err == nil {
+			t.Errorf("%s: parsed without error", tt.name)
+		}
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+		ok   bool
+	}{
+		{"3600", 3600, true},
+		{"1h", 3600, true},
+		{"1H30M", 5400, true},
+		{"2d", 172800, true},
+		{"1w", 604800, true},
+		{"", 0, false},
+		{"h", 0, false},
+		{"1x", 0, false},
+		{"-5", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTTL(tt.in)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("ParseTTL(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("ParseTTL(%q) = %d, want an error", tt.in, got)
+		}
+	}
+}
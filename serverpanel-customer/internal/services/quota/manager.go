@@ -0,0 +1,119 @@
+package quota
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Manager applies per-user block quotas on the filesystem
+type Manager struct {
+	simulateMode bool
+	basePath     string
+}
+
+// NewManager creates a new quota manager
+func NewManager(simulateMode bool, basePath string) *Manager {
+	return &Manager{
+		simulateMode: simulateMode,
+		basePath:     basePath,
+	}
+}
+
+// GetQuotaPath returns where simulated quotas are stored
+func (m *Manager) GetQuotaPath() string {
+	return filepath.Join(m.basePath, "quota")
+}
+
+// SetQuota limits a system user to limitMB (soft = hard). limitMB <= 0
+// removes the limit.
+func (m *Manager) SetQuota(username string, limitMB int64) error {
+	if limitMB < 0 {
+		limitMB = 0
+	}
+	blocks := limitMB * 1024 // setquota counts 1K blocks
+
+	if m.simulateMode {
+		return m.simulateSetQuota(username, blocks)
+	}
+
+	// -a applies the quota on every filesystem with user quotas enabled
+	out, err := exec.Command("setquota", "-u", username,
+		strconv.FormatInt(blocks, 10), strconv.FormatInt(blocks, 10), "0", "0", "-a").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("setquota failed: %s", strings.TrimSpace(string(out)))
+	}
+
+	log.Printf("💽 Disk quota set: %s (%d MB)", username, limitMB)
+	return nil
+}
+
+func (m *Manager) simulateSetQuota(username string, blocks int64) error {
+	quotaPath := m.GetQuotaPath()
+	if err := os.MkdirAll(quotaPath, 0755); err != nil {
+		return fmt.Errorf("failed to create quota directory: %w", err)
+	}
+
+	// Same fields as setquota: block soft, block hard, inode soft, inode hard
+	content := fmt.Sprintf("%d %d 0 0\n", blocks, blocks)
+	quotaFile := filepath.Join(quotaPath, username)
+	if err := os.WriteFile(quotaFile, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write quota file: %w", err)
+	}
+
+	log.Printf("🔧 [SIMÜLASYON] setquota -u %s %d %d 0 0 -a", username, blocks, blocks)
+	return nil
+}
+
+// UsedBytes returns the blocks a system user has in use according to the
+// filesystem quotas, summed over every filesystem. Simulated quotas do
+// not account usage.
+func (m *Manager) UsedBytes(username string) (int64, error) {
+	if m.simulateMode {
+		return 0, fmt.Errorf("usage is not accounted in simulation mode")
+	}
+
+	// -w keeps each filesystem on one line: name, blocks (with * when over
+	// the soft limit), quota, limit, ...
+	out, err := exec.Command("quota", "-v", "-w", "-u", username).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("quota failed: %s", strings.TrimSpace(string(out)))
+	}
+	var blocks int64
+	header := false
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[0] == "Filesystem" {
+			header = true
+			continue
+		}
+		if !header {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSuffix(fields[1], "*"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected quota output: %s", line)
+		}
+		blocks += n
+	}
+	if !header {
+		return 0, fmt.Errorf("unexpected quota output: %s", strings.TrimSpace(string(out)))
+	}
+	return blocks * 1024, nil
+}
+
+// RemoveQuota drops the quota of a deleted user
+func (m *Manager) RemoveQuota(username string) error {
+	if m.simulateMode {
+		os.Remove(filepath.Join(m.GetQuotaPath(), username))
+		return nil
+	}
+	return m.SetQuota(username, 0)
+}
@@ -0,0 +1,403 @@
+package quota
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/config"
+	"github.com/asergenalkan/serverpanel/internal/services/mysql"
+)
+
+var (
+	ErrOverQuota       = errors.New("disk quota exceeded")
+	ErrAccountNotFound = errors.New("account not found")
+)
+
+// Samples older than this are removed
+const historyRetention = 90 * 24 * time.Hour
+
+// SampleInterval is how often the panel samples every account. Samples
+// older than this are measured again before a write is allowed.
+const SampleInterval = 30 * time.Minute
+
+const mb = 1024 * 1024
+
+// DB interface for database operations
+type DB interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Service accounts disk usage per account and enforces package quotas
+type Service struct {
+	db  DB
+	cfg *config.Config
+}
+
+// Usage is a disk usage sample of an account (sizes in MB, QuotaMB 0 =
+// unlimited)
+type Usage struct {
+	UserID     int64   `json:"user_id"`
+	Username   string  `json:"username,omitempty"`
+	HomeMB     int64   `json:"home_mb"`
+	MailMB     int64   `json:"mail_mb"`
+	DatabaseMB int64   `json:"database_mb"`
+	UsedMB     int64   `json:"used_mb"`
+	QuotaMB    int64   `json:"quota_mb"`
+	Percent    float64 `json:"percent"`
+	OverQuota  bool    `json:"over_quota"`
+	SampledAt  string  `json:"sampled_at"`
+}
+
+func NewService(db DB) *Service {
+	return &Service{
+		db:  db,
+		cfg: config.Get(),
+	}
+}
+
+func (u *Usage) finish() {
+	if u.QuotaMB > 0 {
+		u.Percent = float64(u.UsedMB) * 100 / float64(u.QuotaMB)
+		u.OverQuota = u.UsedMB >= u.QuotaMB
+	}
+}
+
+// GetMailRoot returns the Maildir root (/var/mail/vhosts in production)
+func (s *Service) GetMailRoot() string {
+	if s.cfg.SimulateMode {
+		return filepath.Join(s.cfg.SimulateBasePath, "mail", "vhosts")
+	}
+	return "/var/mail/vhosts"
+}
+
+func (s *Service) manager() *Manager {
+	return NewManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath)
+}
+
+// packageQuota returns the username and package disk quota of an account
+func (s *Service) packageQuota(userID int64) (string, int64, error) {
+	var username string
+	var quotaMB int64
+	err := s.db.QueryRow(`
+		SELECT u.username, COALESCE(p.disk_quota, 0)
+		FROM users u
+		LEFT JOIN user_packages up ON up.user_id = u.id
+		LEFT JOIN packages p ON p.id = up.package_id
+		WHERE u.id = ? AND u.role = 'user'
+	`, userID).Scan(&username, &quotaMB)
+	if err == sql.ErrNoRows {
+		return "", 0, ErrAccountNotFound
+	}
+	return username, quotaMB, err
+}
+
+// Apply sets the filesystem quota of an account from its package
+func (s *Service) Apply(userID int64) error {
+	username, quotaMB, err := s.packageQuota(userID)
+	if err != nil {
+		return err
+	}
+	return s.manager().SetQuota(username, quotaMB)
+}
+
+// ApplyPackage sets the filesystem quota of every account on a package,
+// after its disk quota changed
+func (s *Service) ApplyPackage(packageID int64) {
+	rows, err := s.db.Query("SELECT user_id FROM user_packages WHERE package_id = ?", packageID)
+	if err != nil {
+		return
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			userIDs = append(userIDs, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range userIDs {
+		if err := s.Apply(id); err != nil {
+			log.Printf("⚠️ Disk quota of account %d: %v", id, err)
+		}
+	}
+}
+
+// Remove drops the filesystem quota of an account
+func (s *Service) Remove(username string) error {
+	return s.manager().RemoveQuota(username)
+}
+
+// Sample measures the home directory, mailboxes and databases of an
+// account and stores the result in the usage history
+func (s *Service) Sample(userID int64) (*Usage, error) {
+	u, err := s.measure(userID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO disk_usage_samples (user_id, home_mb, mail_mb, database_mb, used_mb, quota_mb)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, u.UserID, u.HomeMB, u.MailMB, u.DatabaseMB, u.UsedMB, u.QuotaMB)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+	s.db.QueryRow("SELECT sampled_at FROM disk_usage_samples WHERE id = ?", id).Scan(&u.SampledAt)
+
+	return u, nil
+}
+
+// Measure returns the current usage of an account without storing it,
+// for previews such as a dry-run package change
+func (s *Service) Measure(userID int64) (*Usage, error) {
+	return s.measure(userID, false)
+}
+
+// measure sizes the home directory, mailboxes and databases of an account.
+// With refresh the cached database sizes are updated.
+func (s *Service) measure(userID int64, refresh bool) (*Usage, error) {
+	username, quotaMB, err := s.packageQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Usage{UserID: userID, Username: username, QuotaMB: quotaMB}
+	homeBytes := dirSize(filepath.Join(s.cfg.HomeBaseDir, username))
+
+	var mailBytes int64
+	rows, err := s.db.Query("SELECT name FROM domains WHERE user_id = ?", userID)
+	if err == nil {
+		for rows.Next() {
+			var domain string
+			if rows.Scan(&domain) == nil {
+				mailBytes += dirSize(filepath.Join(s.GetMailRoot(), domain))
+			}
+		}
+		rows.Close()
+	}
+	databaseBytes := s.databaseSize(userID, refresh)
+
+	u.HomeMB = toMB(homeBytes)
+	u.MailMB = toMB(mailBytes)
+	u.DatabaseMB = toMB(databaseBytes)
+	u.UsedMB = toMB(homeBytes + mailBytes + databaseBytes)
+	u.finish()
+	return u, nil
+}
+
+// databaseSize returns the size of an account's databases in bytes; with
+// refresh the cached size of each database is updated
+func (s *Service) databaseSize(userID int64, refresh bool) int64 {
+	type database struct {
+		id   int64
+		name string
+		size int64
+	}
+	var databases []database
+	rows, err := s.db.Query("SELECT id, name, COALESCE(size, 0) FROM databases WHERE user_id = ?", userID)
+	if err != nil {
+		return 0
+	}
+	for rows.Next() {
+		var d database
+		if rows.Scan(&d.id, &d.name, &d.size) == nil {
+			databases = append(databases, d)
+		}
+	}
+	rows.Close()
+
+	manager := mysql.NewManager(s.cfg.SimulateMode, s.cfg.SimulateBasePath)
+	var total int64
+	for _, d := range databases {
+		if s.cfg.SimulateMode {
+			if info, err := os.Stat(filepath.Join(s.cfg.SimulateBasePath, "mysql", d.name+".sql")); err == nil {
+				d.size = info.Size()
+			}
+		} else if size, err := manager.GetDatabaseSize(d.name); err == nil {
+			d.size = size
+			if refresh {
+				s.db.Exec("UPDATE databases SET size = ? WHERE id = ?", size, d.id)
+			}
+		}
+		total += d.size
+	}
+	return total
+}
+
+// SampleAll samples every account and prunes old history
+func (s *Service) SampleAll() ([]Usage, error) {
+	rows, err := s.db.Query("SELECT id FROM users WHERE role = 'user' ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	usages := []Usage{}
+	for _, id := range ids {
+		u, err := s.Sample(id)
+		if err != nil {
+			log.Printf("⚠️ Disk usage sampling failed for user %d: %v", id, err)
+			continue
+		}
+		if u.OverQuota {
+			log.Printf("⚠️ %s is over its disk quota (%d/%d MB)", u.Username, u.UsedMB, u.QuotaMB)
+		}
+		usages = append(usages, *u)
+	}
+
+	cutoff := time.Now().Add(-historyRetention).UTC().Format("2006-01-02 15:04:05")
+	s.db.Exec("DELETE FROM disk_usage_samples WHERE sampled_at < ?", cutoff)
+
+	return usages, nil
+}
+
+// StartSampler runs SampleAll periodically in the background
+func (s *Service) StartSampler(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := s.SampleAll(); err != nil {
+				log.Printf("❌ Disk usage sampling failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Current returns the latest sample of an account, sampling it first if
+// it has none
+func (s *Service) Current(userID int64) (*Usage, error) {
+	u := &Usage{UserID: userID}
+	err := s.db.QueryRow(`
+		SELECT s.home_mb, s.mail_mb, s.database_mb, s.used_mb, s.sampled_at
+		FROM disk_usage_samples s
+		WHERE s.user_id = ? ORDER BY s.id DESC LIMIT 1
+	`, userID).Scan(&u.HomeMB, &u.MailMB, &u.DatabaseMB, &u.UsedMB, &u.SampledAt)
+	if err == sql.ErrNoRows {
+		return s.Sample(userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The quota is read from the package so changes apply immediately
+	if u.Username, u.QuotaMB, err = s.packageQuota(userID); err != nil {
+		return nil, err
+	}
+	u.finish()
+	return u, nil
+}
+
+// History returns the latest samples of an account, newest first
+func (s *Service) History(userID int64, limit int) ([]Usage, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := s.db.Query(`
+		SELECT home_mb, mail_mb, database_mb, used_mb, quota_mb, sampled_at
+		FROM disk_usage_samples
+		WHERE user_id = ? ORDER BY id DESC LIMIT ?
+	`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []Usage{}
+	for rows.Next() {
+		u := Usage{UserID: userID}
+		if rows.Scan(&u.HomeMB, &u.MailMB, &u.DatabaseMB, &u.UsedMB, &u.QuotaMB, &u.SampledAt) == nil {
+			u.finish()
+			history = append(history, u)
+		}
+	}
+	return history, nil
+}
+
+// CheckWrite returns ErrOverQuota when writing addBytes more would take
+// the account over its quota. The home directory comes from the filesystem
+// quota in production and from the latest sample otherwise, mail and
+// databases always from the latest sample. Without filesystem quotas a
+// sample older than SampleInterval is measured again, so writes since the
+// last sample count. Unknown users (admin files outside an account) are
+// not limited.
+func (s *Service) CheckWrite(username string, addBytes int64) error {
+	var userID int64
+	err := s.db.QueryRow("SELECT id FROM users WHERE username = ? AND role = 'user'", username).Scan(&userID)
+	if err != nil {
+		return nil
+	}
+
+	u, err := s.Current(userID)
+	if err != nil || u.QuotaMB <= 0 {
+		return nil
+	}
+	if s.cfg.SimulateMode && stale(u.SampledAt) {
+		if fresh, err := s.measure(userID, false); err == nil {
+			u = fresh
+		}
+	}
+	if addBytes < 0 {
+		addBytes = 0
+	}
+
+	home := u.HomeMB * mb
+	if !s.cfg.SimulateMode {
+		if used, err := s.manager().UsedBytes(username); err == nil {
+			home = used
+		}
+	}
+	if home+(u.MailMB+u.DatabaseMB)*mb+addBytes > u.QuotaMB*mb {
+		return ErrOverQuota
+	}
+	return nil
+}
+
+// stale reports whether a sample taken at sampledAt is older than
+// SampleInterval; unreadable times count as stale
+func stale(sampledAt string) bool {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, sampledAt); err == nil {
+			return time.Since(t) > SampleInterval
+		}
+	}
+	return true
+}
+
+// dirSize returns the size of a directory tree in bytes
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// toMB converts bytes to MB, rounded up
+func toMB(bytes int64) int64 {
+	return (bytes + mb - 1) / mb
+}
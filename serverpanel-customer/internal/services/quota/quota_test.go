@@ -0,0 +1,98 @@
+package quota
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asergenalkan/serverpanel/internal/database"
+)
+
+func TestMain(m *testing.M) {
+	// The panel config and simulated home directories live below HOME
+	home, err := os.MkdirTemp("", "serverpanel-quota")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+func TestCheckWrite(t *testing.T) {
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "panel.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := NewService(db)
+	if !s.cfg.SimulateMode {
+		t.Skip("needs simulate mode")
+	}
+
+	res, err := db.Exec(`INSERT INTO packages (name, disk_quota) VALUES ('Tiny', 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packageID, _ := res.LastInsertId()
+	res, err = db.Exec("INSERT INTO users (username, email, password, role) VALUES ('alice', 'alice@example.com', 'x', 'user')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, _ := res.LastInsertId()
+	db.Exec("INSERT INTO user_packages (user_id, package_id) VALUES (?, ?)", userID, packageID)
+
+	home := filepath.Join(s.cfg.HomeBaseDir, "alice")
+	if err := os.MkdirAll(home, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+
+	// An empty home directory sampled an hour ago
+	if _, err := db.Exec(`INSERT INTO disk_usage_samples (user_id, sampled_at) VALUES (?, datetime('now', '-1 hour'))`, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.CheckWrite("alice", mb); err != nil {
+		t.Fatalf("1 MB into an empty account: %v", err)
+	}
+	if err := s.CheckWrite("alice", 3*mb); err != ErrOverQuota {
+		t.Fatalf("3 MB into a 2 MB quota: got %v, want ErrOverQuota", err)
+	}
+
+	// Files written since the stale sample count against the quota
+	if err := os.WriteFile(filepath.Join(home, "big.bin"), make([]byte, 3*mb/2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CheckWrite("alice", mb); err != ErrOverQuota {
+		t.Fatalf("1 MB on top of 1.5 MB: got %v, want ErrOverQuota", err)
+	}
+	if err := s.CheckWrite("alice", 0); err != nil {
+		t.Fatalf("nothing on top of 1.5 MB: %v", err)
+	}
+
+	if err := s.CheckWrite("nobody", 100*mb); err != nil {
+		t.Fatalf("unknown user: %v", err)
+	}
+}
+
+func TestStale(t *testing.T) {
+	tests := []struct {
+		sampledAt string
+		want      bool
+	}{
+		{"2020-01-02 03:04:05", true},
+		{"2020-01-02T03:04:05Z", true},
+		{"", true},
+		{"garbage", true},
+		{time.Now().UTC().Format("2006-01-02 15:04:05"), false},
+		{time.Now().UTC().Format(time.RFC3339), false},
+	}
+	for _, tt := range tests {
+		if got := stale(tt.sampledAt); got != tt.want {
+			t.Errorf("stale(%q) = %v, want %v", tt.sampledAt, got, tt.want)
+		}
+	}
+}
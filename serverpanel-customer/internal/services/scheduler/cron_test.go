@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"* * * * *", true},
+		{"0 3 * * *", true},
+		{"*/15 * * * *", true},
+		{"0 0 1,15 * *", true},
+		{"30 2 * * 1-5", true},
+		{"0 0 * * 7", true},
+		{"0 0 * * 0-7/2", true},
+		{"5/10 * * * *", true},
+		{"? * ? * ?", true},
+		{" @daily ", true},
+		{"@weekly", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * 32 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"*/x * * * *", false},
+		{"5-1 * * * *", false},
+		{"a-5 * * * *", false},
+		{"1,,2 * * * *", false},
+		{"@reboot", false},
+		{"@every 5m", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseCron(tt.expr)
+		if tt.ok && err != nil {
+			t.Errorf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", tt.expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Monday 15 January 2024, 10:30:45
+	from := time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", at(2024, 1, 15, 10, 31)},
+		{"30 10 * * *", at(2024, 1, 16, 10, 30)},
+		{"*/15 * * * *", at(2024, 1, 15, 10, 45)},
+		{"5/20 * * * *", at(2024, 1, 15, 10, 45)},
+		{"0 3 * * *", at(2024, 1, 16, 3, 0)},
+		{"@hourly", at(2024, 1, 15, 11, 0)},
+		{"@daily", at(2024, 1, 16, 0, 0)},
+		{"@weekly", at(2024, 1, 21, 0, 0)},
+		{"@monthly", at(2024, 2, 1, 0, 0)},
+		{"@yearly", at(2025, 1, 1, 0, 0)},
+		{"0 0 * * 7", at(2024, 1, 21, 0, 0)},
+		{"0 9 * * 1-5", at(2024, 1, 16, 9, 0)},
+		{"0 0 29 2 *", at(2024, 2, 29, 0, 0)},
+		{"0 0 31 * *", at(2024, 1, 31, 0, 0)},
+		{"0 0 31 4,6 *", time.Time{}},
+		{"0 0 31 2 *", time.Time{}},
+		{"0 12 1 * 5", at(2024, 1, 19, 12, 0)},
+		{"0 12 13 * 5", at(2024, 1, 19, 12, 0)},
+		{"0 12 16 * 5", at(2024, 1, 16, 12, 0)},
+		{"0 0 1 1 *", at(2025, 1, 1, 0, 0)},
+		{"59 23 31 12 *", at(2024, 12, 31, 23, 59)},
+	}
+	for _, tt := range tests {
+		e, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := e.Next(from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	// Next is strictly after the given time
+	e, _ := ParseCron("30 10 * * *")
+	exact := at(2024, 1, 15, 10, 30)
+	if got := e.Next(exact); !got.Equal(at(2024, 1, 16, 10, 30)) {
+		t.Errorf("Next at a matching minute = %v", got)
+	}
+
+	// The 29th of February is years away from a non-leap year
+	e, _ = ParseCron("0 0 29 2 *")
+	if got := e.Next(at(2025, 3, 1, 0, 0)); !got.Equal(at(2028, 2, 29, 0, 0)) {
+		t.Errorf("next 29 February = %v", got)
+	}
+}
+
+func TestCronMinInterval(t *testing.T) {
+	from := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Duration
+	}{
+		{"* * * * *", time.Minute},
+		{"*/15 * * * *", 15 * time.Minute},
+		{"0,5 * * * *", 5 * time.Minute},
+		{"@daily", 24 * time.Hour},
+		{"0 0 31 2 *", 0},
+	}
+	for _, tt := range tests {
+		e, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.MinInterval(from, 10); got != tt.want {
+			t.Errorf("%q.MinInterval = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
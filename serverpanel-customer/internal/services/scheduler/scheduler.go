@@ -0,0 +1,534 @@
+package scheduler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Run statuses
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusPartial   = "partial"
+	StatusFailed    = "failed"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+var (
+	ErrJobNotFound    = errors.New("scheduled job not found")
+	ErrUnknownKind    = errors.New("unknown job kind")
+	ErrAlreadyRunning = errors.New("job is already running")
+)
+
+// DB interface for database operations
+type DB interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Begin() (*sql.Tx, error)
+}
+
+// LogFunc receives the progress lines of a run
+type LogFunc func(format string, args ...interface{})
+
+// Result is reported by a kind's runner when a run ends
+type Result struct {
+	Items   int    // accounts, files, ... processed
+	Failed  int    // items that failed; the run is "partial" if some did
+	Size    int64  // bytes produced
+	Summary string // one line for the history
+}
+
+// Kind is a type of scheduled job, registered by the service owning it
+type Kind struct {
+	// Validate checks a job before it is saved (optional)
+	Validate func(db DB, job *Job) error
+	// Run executes the job
+	Run func(db DB, job *Job, run *Run, logf LogFunc) (*Result, error)
+}
+
+// TaskHook connects runs to the panel's task log so their progress can be
+// followed live. It returns the task id, the log function and a callback
+// for the end of the run.
+type TaskHook func(job *Job, run *Run) (taskID string, logf LogFunc, done func(success bool))
+
+var (
+	registryMu sync.RWMutex
+	kinds      = map[string]Kind{}
+	taskHook   TaskHook
+
+	// running holds the ids of jobs with a run in progress (one per job)
+	runningMu sync.Mutex
+	running   = map[int64]bool{}
+)
+
+// Register makes a job kind available to the scheduler
+func Register(name string, kind Kind) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	kinds[name] = kind
+}
+
+// Kinds returns the registered job kinds
+func Kinds() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetTaskHook sets the hook used to stream run progress
+func SetTaskHook(hook TaskHook) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	taskHook = hook
+}
+
+func lookupKind(name string) (Kind, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	kind, ok := kinds[name]
+	return kind, ok
+}
+
+// Job is a scheduled job stored in scheduled_jobs
+type Job struct {
+	ID             int64           `json:"id"`
+	Kind           string          `json:"kind"`
+	Name           string          `json:"name"`
+	UserID         int64           `json:"user_id"` // 0 = server wide
+	Username       string          `json:"username,omitempty"`
+	CronExpression string          `json:"cron_expression"`
+	Payload        json.RawMessage `json:"payload"`
+	Enabled        bool            `json:"enabled"`
+	LastRunAt      string          `json:"last_run_at,omitempty"`
+	LastStatus     string          `json:"last_status,omitempty"`
+	NextRunAt      string          `json:"next_run_at,omitempty"`
+	Running        bool            `json:"running"`
+	CreatedBy      int64           `json:"created_by"`
+	CreatedAt      string          `json:"created_at"`
+	UpdatedAt      string          `json:"updated_at"`
+}
+
+// Run is one execution of a job stored in scheduled_job_runs
+type Run struct {
+	ID              int64  `json:"id"`
+	JobID           int64  `json:"job_id"`
+	Trigger         string `json:"trigger"`
+	Status          string `json:"status"`
+	TaskID          string `json:"task_id,omitempty"`
+	StartedAt       string `json:"started_at"`
+	CompletedAt     string `json:"completed_at,omitempty"`
+	DurationSeconds int64  `json:"duration_seconds"`
+	Items           int    `json:"items"`
+	Failed          int    `json:"failed"`
+	Size            int64  `json:"size"`
+	Summary         string `json:"summary,omitempty"`
+	ErrorMessage    string `json:"error_message,omitempty"`
+}
+
+// Service stores scheduled jobs and runs them when they are due
+type Service struct {
+	db DB
+}
+
+func NewService(db DB) *Service {
+	return &Service{db: db}
+}
+
+func isRunning(jobID int64) bool {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	return running[jobID]
+}
+
+const jobColumns = `
+	j.id, j.kind, j.name, COALESCE(j.user_id, 0), COALESCE(u.username, ''), j.cron_expression,
+	COALESCE(j.payload, '{}'), j.enabled, COALESCE(j.last_run_at, ''), COALESCE(j.last_status, ''),
+	COALESCE(j.next_run_at, ''), COALESCE(j.created_by, 0), j.created_at, j.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (*Job, error) {
+	var j Job
+	var payload string
+	err := row.Scan(&j.ID, &j.Kind, &j.Name, &j.UserID, &j.Username, &j.CronExpression,
+		&payload, &j.Enabled, &j.LastRunAt, &j.LastStatus,
+		&j.NextRunAt, &j.CreatedBy, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	j.Payload = json.RawMessage(payload)
+	j.Running = isRunning(j.ID)
+	return &j, nil
+}
+
+// GetJob returns a single scheduled job
+func (s *Service) GetJob(id int64) (*Job, error) {
+	row := s.db.QueryRow(`SELECT `+jobColumns+`
+		FROM scheduled_jobs j
+		LEFT JOIN users u ON u.id = j.user_id
+		WHERE j.id = ?`, id)
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// ListJobs returns scheduled jobs, optionally filtered by kind and
+// account (userID > 0)
+func (s *Service) ListJobs(kind string, userID int64) ([]Job, error) {
+	query := `SELECT ` + jobColumns + `
+		FROM scheduled_jobs j
+		LEFT JOIN users u ON u.id = j.user_id
+		WHERE 1 = 1`
+	var args []interface{}
+	if kind != "" {
+		query += ` AND j.kind = ?`
+		args = append(args, kind)
+	}
+	if userID > 0 {
+		query += ` AND j.user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY j.id`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
+
+// nextRun returns the next run time of a job in UTC, or nil when it is
+// disabled or never due
+func nextRun(job *Job, after time.Time) (interface{}, error) {
+	expr, err := ParseCron(job.CronExpression)
+	if err != nil {
+		return nil, err
+	}
+	if !job.Enabled {
+		return nil, nil
+	}
+	next := expr.Next(after.Local())
+	if next.IsZero() {
+		return nil, nil
+	}
+	return next.UTC().Format(timeFormat), nil
+}
+
+// validate checks the expression and lets the kind check its payload
+func (s *Service) validate(job *Job) error {
+	kind, ok := lookupKind(job.Kind)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKind, job.Kind)
+	}
+	if job.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, err := ParseCron(job.CronExpression); err != nil {
+		return err
+	}
+	if len(job.Payload) == 0 {
+		job.Payload = json.RawMessage("{}")
+	}
+	if kind.Validate != nil {
+		return kind.Validate(s.db, job)
+	}
+	return nil
+}
+
+// SaveJob creates (job.ID == 0) or updates a scheduled job
+func (s *Service) SaveJob(job *Job) (*Job, error) {
+	if err := s.validate(job); err != nil {
+		return nil, err
+	}
+	next, err := nextRun(job, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	userID := sql.NullInt64{Int64: job.UserID, Valid: job.UserID > 0}
+	if job.ID == 0 {
+		result, err := s.db.Exec(`
+			INSERT INTO scheduled_jobs (kind, name, user_id, cron_expression, payload, enabled, next_run_at, created_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, job.Kind, job.Name, userID, job.CronExpression, string(job.Payload), job.Enabled, next, job.CreatedBy)
+		if err != nil {
+			return nil, err
+		}
+		job.ID, _ = result.LastInsertId()
+	} else {
+		result, err := s.db.Exec(`
+			UPDATE scheduled_jobs SET name = ?, user_id = ?, cron_expression = ?, payload = ?, enabled = ?,
+				next_run_at = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, job.Name, userID, job.CronExpression, string(job.Payload), job.Enabled, next, job.ID)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil, ErrJobNotFound
+		}
+	}
+	return s.GetJob(job.ID)
+}
+
+// DeleteJob removes a scheduled job; its history goes with it
+func (s *Service) DeleteJob(id int64) error {
+	if isRunning(id) {
+		return ErrAlreadyRunning
+	}
+	result, err := s.db.Exec("DELETE FROM scheduled_jobs WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+const runColumns = `id, job_id, trigger, status, COALESCE(task_id, ''), COALESCE(started_at, ''),
+	COALESCE(completed_at, ''), COALESCE(duration_seconds, 0), COALESCE(items, 0),
+	COALESCE(failed, 0), COALESCE(size, 0), COALESCE(summary, ''), COALESCE(error_message, '')`
+
+func scanRun(row rowScanner) (*Run, error) {
+	var r Run
+	err := row.Scan(&r.ID, &r.JobID, &r.Trigger, &r.Status, &r.TaskID, &r.StartedAt,
+		&r.CompletedAt, &r.DurationSeconds, &r.Items,
+		&r.Failed, &r.Size, &r.Summary, &r.ErrorMessage)
+	return &r, err
+}
+
+// ListRuns returns the run history of a job, newest first
+func (s *Service) ListRuns(jobID int64, limit int) ([]Run, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	rows, err := s.db.Query(`SELECT `+runColumns+` FROM scheduled_job_runs
+		WHERE job_id = ? ORDER BY id DESC LIMIT ?`, jobID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []Run{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			continue
+		}
+		runs = append(runs, *run)
+	}
+	return runs, nil
+}
+
+// GetRun returns a single run
+func (s *Service) GetRun(id int64) (*Run, error) {
+	run, err := scanRun(s.db.QueryRow(`SELECT `+runColumns+` FROM scheduled_job_runs WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("run not found")
+	}
+	return run, err
+}
+
+// RunNow starts a job immediately, outside its schedule. The run
+// continues in the background; its task id streams the progress.
+func (s *Service) RunNow(jobID int64) (*Run, error) {
+	job, err := s.GetJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	return s.execute(job, TriggerManual)
+}
+
+// execute records a run and starts it in the background
+func (s *Service) execute(job *Job, trigger string) (*Run, error) {
+	kind, ok := lookupKind(job.Kind)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKind, job.Kind)
+	}
+
+	runningMu.Lock()
+	if running[job.ID] {
+		runningMu.Unlock()
+		return nil, ErrAlreadyRunning
+	}
+	running[job.ID] = true
+	runningMu.Unlock()
+
+	release := func() {
+		runningMu.Lock()
+		delete(running, job.ID)
+		runningMu.Unlock()
+	}
+
+	started := time.Now()
+	result, err := s.db.Exec(`
+		INSERT INTO scheduled_job_runs (job_id, trigger, status, started_at) VALUES (?, ?, ?, ?)
+	`, job.ID, trigger, StatusRunning, started.UTC().Format(timeFormat))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	run := &Run{JobID: job.ID, Trigger: trigger, Status: StatusRunning, StartedAt: started.UTC().Format(timeFormat)}
+	run.ID, _ = result.LastInsertId()
+
+	logf := LogFunc(func(format string, args ...interface{}) {
+		log.Printf("⏰ [%s #%d] %s", job.Name, run.ID, fmt.Sprintf(format, args...))
+	})
+	done := func(bool) {}
+
+	registryMu.RLock()
+	hook := taskHook
+	registryMu.RUnlock()
+	if hook != nil {
+		run.TaskID, logf, done = hook(job, run)
+		s.db.Exec("UPDATE scheduled_job_runs SET task_id = ? WHERE id = ?", run.TaskID, run.ID)
+	}
+
+	log.Printf("⏰ Scheduled job started: %s (%s, run %d)", job.Name, trigger, run.ID)
+
+	go func() {
+		defer release()
+
+		res, err := s.safeRun(kind, job, run, logf)
+		if res == nil {
+			res = &Result{}
+		}
+
+		status := StatusCompleted
+		var errMsg string
+		switch {
+		case err != nil:
+			status = StatusFailed
+			errMsg = err.Error()
+		case res.Failed > 0 && res.Failed >= res.Items:
+			status = StatusFailed
+		case res.Failed > 0:
+			status = StatusPartial
+		}
+
+		completed := time.Now()
+		s.db.Exec(`
+			UPDATE scheduled_job_runs SET status = ?, completed_at = ?, duration_seconds = ?,
+				items = ?, failed = ?, size = ?, summary = ?, error_message = ?
+			WHERE id = ?
+		`, status, completed.UTC().Format(timeFormat), int64(completed.Sub(started).Seconds()),
+			res.Items, res.Failed, res.Size, res.Summary, errMsg, run.ID)
+		s.db.Exec(`UPDATE scheduled_jobs SET last_run_at = ?, last_status = ? WHERE id = ?`,
+			started.UTC().Format(timeFormat), status, job.ID)
+
+		if err != nil {
+			logf("❌ Hata: %s", err.Error())
+		}
+		log.Printf("⏰ Scheduled job finished: %s (run %d): %s", job.Name, run.ID, status)
+		done(status != StatusFailed)
+	}()
+
+	return run, nil
+}
+
+// safeRun keeps a panicking runner from taking the panel down
+func (s *Service) safeRun(kind Kind, job *Job, run *Run, logf LogFunc) (res *Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return kind.Run(s.db, job, run, logf)
+}
+
+// Start marks runs interrupted by a restart as failed and checks for due
+// jobs every interval. Jobs missed while the panel was down run once on
+// startup.
+func (s *Service) Start(interval time.Duration) {
+	s.db.Exec(`
+		UPDATE scheduled_job_runs SET status = ?, error_message = 'interrupted by panel restart',
+			completed_at = ?
+		WHERE status = ?
+	`, StatusFailed, time.Now().UTC().Format(timeFormat), StatusRunning)
+
+	go func() {
+		s.runDue(time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.runDue(now)
+		}
+	}()
+}
+
+// runDue starts every enabled job whose next run time has passed
+func (s *Service) runDue(now time.Time) {
+	rows, err := s.db.Query(`SELECT `+jobColumns+`
+		FROM scheduled_jobs j
+		LEFT JOIN users u ON u.id = j.user_id
+		WHERE j.enabled = 1 AND j.next_run_at IS NOT NULL AND j.next_run_at <= ?`,
+		now.UTC().Format(timeFormat))
+	if err != nil {
+		log.Printf("❌ Scheduler: %v", err)
+		return
+	}
+	var due []*Job
+	for rows.Next() {
+		if job, err := scanJob(rows); err == nil {
+			due = append(due, job)
+		}
+	}
+	rows.Close()
+
+	for _, job := range due {
+		next, err := nextRun(job, now)
+		if err != nil {
+			log.Printf("❌ Scheduler: %s: %v", job.Name, err)
+			s.db.Exec("UPDATE scheduled_jobs SET next_run_at = NULL WHERE id = ?", job.ID)
+			continue
+		}
+		// Claim the slot; a concurrent scheduler that got here first
+		// already moved next_run_at
+		result, err := s.db.Exec("UPDATE scheduled_jobs SET next_run_at = ? WHERE id = ? AND next_run_at = ?",
+			next, job.ID, job.NextRunAt)
+		if err != nil {
+			continue
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+
+		if _, err := s.execute(job, TriggerSchedule); err != nil {
+			log.Printf("⚠️ Scheduler: %s skipped: %v", job.Name, err)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 )
 
 // ApacheDriver implements the Driver interface for Apache
@@ -31,6 +30,10 @@ func (d *ApacheDriver) SupportsHtaccess() bool {
 	return true
 }
 
+func (d *ApacheDriver) ManagesCertificates() bool {
+	return false // certificates come from certbot
+}
+
 func (d *ApacheDriver) GetConfigPath() string {
 	if d.simulateMode {
 		return filepath.Join(d.basePath, "apache", "sites-available")
@@ -39,138 +42,66 @@ func (d *ApacheDriver) GetConfigPath() string {
 }
 
 func (d *ApacheDriver) CreateVhost(config VhostConfig) error {
-	vhostConfig := d.generateConfig(config)
-
-	configPath := d.GetConfigPath()
-	if err := os.MkdirAll(configPath, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
-	}
-
-	configFile := filepath.Join(configPath, config.Domain+".conf")
-	if err := os.WriteFile(configFile, []byte(vhostConfig), 0644); err != nil {
-		return fmt.Errorf("failed to write vhost config: %w", err)
+	tx := NewTransaction()
+	if err := d.StageVhost(tx, config); err != nil {
+		return err
 	}
-
-	log.Printf("📝 Apache config created: %s", configFile)
-
-	// Enable site
-	if err := d.EnableSite(config.Domain); err != nil {
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	return d.Reload()
+	log.Printf("📝 Apache config created: %s", filepath.Join(d.GetConfigPath(), config.Domain+".conf"))
+	return nil
 }
 
-func (d *ApacheDriver) generateConfig(config VhostConfig) string {
-	serverAliases := ""
-	if len(config.Aliases) > 0 {
-		serverAliases = "ServerAlias " + strings.Join(config.Aliases, " ")
-	} else {
-		serverAliases = fmt.Sprintf("ServerAlias www.%s", config.Domain)
+// StageVhost adds the rendered vhost to a transaction. A rate limited
+// vhost needs mod_ratelimit, which is enabled before the reload that
+// commits it.
+func (d *ApacheDriver) StageVhost(tx *Transaction, config VhostConfig) error {
+	vhostConfig, err := d.RenderVhost(config)
+	if err != nil {
+		return err
 	}
-
-	phpVersion := config.PHPVersion
-	if phpVersion == "" {
-		phpVersion = "8.1" // Default to 8.1 for Ubuntu 22.04
+	if config.RateLimitKB > 0 {
+		if err := d.enableModule("ratelimit"); err != nil {
+			return err
+		}
 	}
+	tx.WriteSite(d, config.Domain, vhostConfig)
+	return nil
+}
 
-	// PHP-FPM socket path
-	phpFpmSocket := fmt.Sprintf("/run/php/php%s-fpm-%s.sock", phpVersion, config.Username)
+// enableModule enables an Apache module unless it is already on, e.g.
+// mod_ratelimit for SetOutputFilter RATE_LIMIT
+func (d *ApacheDriver) enableModule(module string) error {
 	if d.simulateMode {
-		phpFpmSocket = filepath.Join(d.basePath, "php-fpm", config.Username+".sock")
+		log.Printf("🔧 [SIMÜLASYON] a2enmod %s", module)
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join("/etc/apache2/mods-enabled", module+".load")); err == nil {
+		return nil
 	}
 
-	vhost := fmt.Sprintf(`# Virtual Host for %s
-# User: %s
-# Web Server: Apache
-# .htaccess: ENABLED
-<VirtualHost *:80>
-    ServerName %s
-    %s
-    
-    DocumentRoot %s
-    
-    <Directory %s>
-        Options -Indexes +FollowSymLinks
-        AllowOverride All
-        Require all granted
-    </Directory>
-    
-    # PHP-FPM Configuration
-    <FilesMatch \.php$>
-        SetHandler "proxy:unix:%s|fcgi://localhost"
-    </FilesMatch>
-    
-    # Logging
-    ErrorLog %s/error.log
-    CustomLog %s/access.log combined
-    
-    # Security Headers
-    Header always set X-Frame-Options "SAMEORIGIN"
-    Header always set X-Content-Type-Options "nosniff"
-    Header always set X-XSS-Protection "1; mode=block"
-</VirtualHost>
-`,
-		config.Domain,
-		config.Username,
-		config.Domain,
-		serverAliases,
-		config.DocumentRoot,
-		config.DocumentRoot,
-		phpFpmSocket,
-		filepath.Join(config.HomeDir, "logs"),
-		filepath.Join(config.HomeDir, "logs"),
-	)
-
-	// Add SSL configuration if enabled
-	if config.SSLEnabled && config.SSLCertPath != "" && config.SSLKeyPath != "" {
-		vhost += fmt.Sprintf(`
-<VirtualHost *:443>
-    ServerName %s
-    %s
-    
-    DocumentRoot %s
-    
-    <Directory %s>
-        Options -Indexes +FollowSymLinks
-        AllowOverride All
-        Require all granted
-    </Directory>
-    
-    # PHP-FPM Configuration
-    <FilesMatch \.php$>
-        SetHandler "proxy:unix:%s|fcgi://localhost"
-    </FilesMatch>
-    
-    # SSL Configuration
-    SSLEngine on
-    SSLCertificateFile %s
-    SSLCertificateKeyFile %s
-    
-    # Logging
-    ErrorLog %s/error.log
-    CustomLog %s/access.log combined
-    
-    # Security Headers
-    Header always set X-Frame-Options "SAMEORIGIN"
-    Header always set X-Content-Type-Options "nosniff"
-    Header always set X-XSS-Protection "1; mode=block"
-    Header always set Strict-Transport-Security "max-age=31536000; includeSubDomains"
-</VirtualHost>
-`,
-			config.Domain,
-			serverAliases,
-			config.DocumentRoot,
-			config.DocumentRoot,
-			phpFpmSocket,
-			config.SSLCertPath,
-			config.SSLKeyPath,
-			filepath.Join(config.HomeDir, "logs"),
-			filepath.Join(config.HomeDir, "logs"),
-		)
+	if output, err := exec.Command("a2enmod", module).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to enable %s: %s - %w", module, string(output), err)
 	}
+	log.Printf("✅ Apache mod_%s enabled", module)
+	return nil
+}
+
+// RenderVhost renders the vhost config from the apache-<kind> template
+func (d *ApacheDriver) RenderVhost(config VhostConfig) (string, error) {
+	return renderTemplate("apache", config, d.phpSocket(config),
+		AccessLogPath(d.simulateMode, d.basePath, "apache2", config.Domain))
+}
 
-	return vhost
+// phpSocket returns the PHP-FPM socket of the account's pool
+func (d *ApacheDriver) phpSocket(config VhostConfig) string {
+	phpVersion := config.PHPVersion
+	if phpVersion == "" {
+		phpVersion = "8.1" // Default to 8.1 for Ubuntu 22.04
+	}
+	return PHPSocketPath(d.simulateMode, d.basePath, phpVersion, config.pool())
 }
 
 func (d *ApacheDriver) DeleteVhost(domain string) error {
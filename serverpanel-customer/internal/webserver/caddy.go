@@ -0,0 +1,197 @@
+package webserver
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CaddyDriver implements the Driver interface for Caddy. Every vhost is a
+// Caddyfile snippet imported by the main Caddyfile; Caddy obtains and
+// renews the certificates of the sites itself.
+type CaddyDriver struct {
+	simulateMode bool
+	basePath     string
+}
+
+// NewCaddyDriver creates a new Caddy driver
+func NewCaddyDriver(simulateMode bool, basePath string) *CaddyDriver {
+	return &CaddyDriver{
+		simulateMode: simulateMode,
+		basePath:     basePath,
+	}
+}
+
+func (d *CaddyDriver) Name() string {
+	return "Caddy"
+}
+
+func (d *CaddyDriver) SupportsHtaccess() bool {
+	return false // Caddy does NOT support .htaccess
+}
+
+// ManagesCertificates reports that Caddy issues the certificates itself
+func (d *CaddyDriver) ManagesCertificates() bool {
+	return true
+}
+
+func (d *CaddyDriver) caddyDir() string {
+	if d.simulateMode {
+		return filepath.Join(d.basePath, "caddy")
+	}
+	return "/etc/caddy"
+}
+
+func (d *CaddyDriver) GetConfigPath() string {
+	return filepath.Join(d.caddyDir(), "sites-available")
+}
+
+// caddyfile returns the main Caddyfile
+func (d *CaddyDriver) caddyfile() string {
+	return filepath.Join(d.caddyDir(), "Caddyfile")
+}
+
+func (d *CaddyDriver) CreateVhost(config VhostConfig) error {
+	tx := NewTransaction()
+	if err := d.StageVhost(tx, config); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("📝 Caddy config created: %s", filepath.Join(d.GetConfigPath(), config.Domain+".conf"))
+	return nil
+}
+
+// StageVhost adds the rendered vhost to a transaction
+func (d *CaddyDriver) StageVhost(tx *Transaction, config VhostConfig) error {
+	vhostConfig, err := d.RenderVhost(config)
+	if err != nil {
+		return err
+	}
+	if err := d.ensureImport(); err != nil {
+		return err
+	}
+	tx.WriteSite(d, config.Domain, vhostConfig)
+	return nil
+}
+
+// RenderVhost renders the vhost config from the caddy-<kind> template
+func (d *CaddyDriver) RenderVhost(config VhostConfig) (string, error) {
+	phpVersion := config.PHPVersion
+	if phpVersion == "" {
+		phpVersion = "8.2"
+	}
+
+	// PHP-FPM socket path
+	phpFpmSocket := PHPSocketPath(d.simulateMode, d.basePath, phpVersion, config.pool())
+
+	return renderTemplate("caddy", config, phpFpmSocket,
+		AccessLogPath(d.simulateMode, d.basePath, "caddy", config.Domain))
+}
+
+// ensureImport makes the main Caddyfile import the enabled sites
+func (d *CaddyDriver) ensureImport() error {
+	importLine := "import " + filepath.Join(d.caddyDir(), "sites-enabled", "*.conf")
+
+	content, err := os.ReadFile(d.caddyfile())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read Caddyfile: %w", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == importLine {
+			return nil
+		}
+	}
+
+	os.MkdirAll(d.caddyDir(), 0755)
+	updated := strings.TrimRight(string(content), "\n")
+	if updated != "" {
+		updated += "\n\n"
+	}
+	updated += "# Sites managed by ServerPanel\n" + importLine + "\n"
+	if err := os.WriteFile(d.caddyfile(), []byte(updated), 0644); err != nil {
+		return fmt.Errorf("failed to write Caddyfile: %w", err)
+	}
+
+	log.Printf("📝 Caddyfile imports the panel sites: %s", d.caddyfile())
+	return nil
+}
+
+func (d *CaddyDriver) DeleteVhost(domain string) error {
+	if err := d.DisableSite(domain); err != nil {
+		log.Printf("Warning: failed to disable site: %v", err)
+	}
+
+	configFile := filepath.Join(d.GetConfigPath(), domain+".conf")
+	if err := os.Remove(configFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove config file: %w", err)
+	}
+
+	log.Printf("🗑️ Caddy config deleted: %s", configFile)
+	return d.Reload()
+}
+
+func (d *CaddyDriver) EnableSite(domain string) error {
+	enabledPath := filepath.Join(d.caddyDir(), "sites-enabled")
+	src := filepath.Join(d.GetConfigPath(), domain+".conf")
+	dst := filepath.Join(enabledPath, domain+".conf")
+
+	if d.simulateMode {
+		log.Printf("🔧 [SIMÜLASYON] ln -s sites-available/%s.conf sites-enabled/", domain)
+	}
+	os.MkdirAll(enabledPath, 0755)
+
+	// Remove existing symlink if exists
+	os.Remove(dst)
+
+	if err := os.Symlink(src, dst); err != nil {
+		return fmt.Errorf("failed to enable site: %w", err)
+	}
+	return nil
+}
+
+func (d *CaddyDriver) DisableSite(domain string) error {
+	if d.simulateMode {
+		log.Printf("🔧 [SIMÜLASYON] rm sites-enabled/%s.conf", domain)
+	}
+
+	enabledPath := filepath.Join(d.caddyDir(), "sites-enabled", domain+".conf")
+	if err := os.Remove(enabledPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to disable site: %w", err)
+	}
+	return nil
+}
+
+// Reload loads the Caddyfile through the admin API, which keeps serving
+// the old config when the new one does not load
+func (d *CaddyDriver) Reload() error {
+	if d.simulateMode {
+		log.Printf("🔧 [SIMÜLASYON] caddy reload --config %s", d.caddyfile())
+		return nil
+	}
+
+	cmd := exec.Command("caddy", "reload", "--config", d.caddyfile(), "--adapter", "caddyfile")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to reload caddy: %s - %w", string(output), err)
+	}
+
+	log.Printf("✅ Caddy reloaded successfully")
+	return nil
+}
+
+func (d *CaddyDriver) TestConfig() error {
+	if d.simulateMode {
+		return nil
+	}
+
+	cmd := exec.Command("caddy", "validate", "--config", d.caddyfile(), "--adapter", "caddyfile")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("caddy config test failed: %s - %w", string(output), err)
+	}
+	return nil
+}
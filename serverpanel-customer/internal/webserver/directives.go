@@ -0,0 +1,138 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Limits of the per-domain directives a customer can add
+const (
+	MaxDirectives      = 50
+	maxDirectiveLength = 1024
+)
+
+var ErrInvalidDirective = errors.New("invalid directive")
+
+// Directives customers may add to an Apache vhost: headers, rewrites,
+// redirects, error pages, caching and content types
+var apacheDirectives = map[string]bool{
+	"header":            true,
+	"requestheader":     true,
+	"rewriteengine":     true,
+	"rewritecond":       true,
+	"rewriterule":       true,
+	"redirect":          true,
+	"redirectmatch":     true,
+	"redirectpermanent": true,
+	"redirecttemp":      true,
+	"errordocument":     true,
+	"expiresactive":     true,
+	"expiresbytype":     true,
+	"expiresdefault":    true,
+	"addtype":           true,
+	"adddefaultcharset": true,
+	"addcharset":        true,
+	"directoryindex":    true,
+	"fileetag":          true,
+}
+
+// Directives customers may add to an Nginx server block
+var nginxDirectives = map[string]bool{
+	"add_header":   true,
+	"rewrite":      true,
+	"return":       true,
+	"error_page":   true,
+	"expires":      true,
+	"charset":      true,
+	"index":        true,
+	"gzip":         true,
+	"gzip_types":   true,
+	"etag":         true,
+	"default_type": true,
+}
+
+// Directives customers may add to a Caddy site block
+var caddyDirectives = map[string]bool{
+	"header":         true,
+	"request_header": true,
+	"redir":          true,
+	"rewrite":        true,
+	"uri":            true,
+	"try_files":      true,
+	"encode":         true,
+	"respond":        true,
+}
+
+// Caddy placeholders such as {uri} or {http.request.host}
+var caddyPlaceholder = regexp.MustCompile(`\{[\w.\-:>]+\}`)
+
+// Rewrite flags that would proxy requests to arbitrary hosts
+var apacheProxyFlag = regexp.MustCompile(`(?i)\[[^\]]*\b(P|proxy)\b[^\]]*\]\s*$`)
+
+// validateDirectives checks every directive against an allow-list.
+// Blank lines and comments are allowed; blocks, continuation lines and
+// control characters are not.
+func validateDirectives(directives []string, allowed map[string]bool, check func(name, line string) error) error {
+	if len(directives) > MaxDirectives {
+		return fmt.Errorf("%w: at most %d directives", ErrInvalidDirective, MaxDirectives)
+	}
+	for i, line := range directives {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(line) > maxDirectiveLength {
+			return fmt.Errorf("%w: line %d is too long", ErrInvalidDirective, i+1)
+		}
+		if strings.ContainsAny(line, "\r\n\x00") || strings.HasSuffix(line, "\\") {
+			return fmt.Errorf("%w: line %d spans several lines", ErrInvalidDirective, i+1)
+		}
+
+		name := strings.Fields(line)[0]
+		if !allowed[strings.ToLower(name)] {
+			return fmt.Errorf("%w: %s is not allowed (line %d)", ErrInvalidDirective, name, i+1)
+		}
+		if err := check(name, line); err != nil {
+			return fmt.Errorf("%w: %v (line %d)", ErrInvalidDirective, err, i+1)
+		}
+	}
+	return nil
+}
+
+// ValidateDirectives checks per-domain Apache directives
+func (d *ApacheDriver) ValidateDirectives(directives []string) error {
+	return validateDirectives(directives, apacheDirectives, func(name, line string) error {
+		if strings.ContainsAny(line, "<>") {
+			return errors.New("sections are not allowed")
+		}
+		if strings.EqualFold(name, "RewriteRule") && apacheProxyFlag.MatchString(line) {
+			return errors.New("proxy rewrites are not allowed")
+		}
+		return nil
+	})
+}
+
+// ValidateDirectives checks per-domain Nginx directives
+func (d *NginxDriver) ValidateDirectives(directives []string) error {
+	return validateDirectives(directives, nginxDirectives, func(name, line string) error {
+		if strings.ContainsAny(line, "{}") {
+			return errors.New("blocks are not allowed")
+		}
+		if !strings.HasSuffix(line, ";") || strings.Count(line, ";") != 1 {
+			return errors.New("exactly one directive ending with ; per line")
+		}
+		return nil
+	})
+}
+
+// ValidateDirectives checks per-domain Caddy directives
+func (d *CaddyDriver) ValidateDirectives(directives []string) error {
+	return validateDirectives(directives, caddyDirectives, func(name, line string) error {
+		if strings.ContainsAny(caddyPlaceholder.ReplaceAllString(line, ""), "{}") {
+			return errors.New("blocks are not allowed")
+		}
+		return nil
+	})
+}
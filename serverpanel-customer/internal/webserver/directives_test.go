@@ -0,0 +1,97 @@
+package webserver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateDirectives(t *testing.T) {
+	allowed := map[string]bool{"header": true, "redirect": true}
+	noCheck := func(name, line string) error { return nil }
+
+	tests := []struct {
+		name       string
+		directives []string
+		ok         bool
+	}{
+		{"empty", nil, true},
+		{"allowed", []string{"Header set X-Frame-Options DENY", "Redirect /old /new"}, true},
+		{"case insensitive", []string{"HEADER set X-A b"}, true},
+		{"blank lines and comments", []string{"", "   ", "# LoadModule evil", "header set X-A b"}, true},
+		{"surrounding space", []string{"   header set X-A b   "}, true},
+		{"not allowed", []string{"LoadModule x /tmp/x.so"}, false},
+		{"prefix of an allowed name", []string{"head x"}, false},
+		{"embedded newline", []string{"header set X-A b\nLoadModule x y"}, false},
+		{"embedded carriage return", []string{"header set X-A b\rLoadModule x y"}, false},
+		{"nul byte", []string{"header set X-A \x00"}, false},
+		{"continuation", []string{"header set X-A \\"}, false},
+		{"too long", []string{"header " + strings.Repeat("a", maxDirectiveLength)}, false},
+		{"too many", make([]string, MaxDirectives+1), false},
+	}
+	for _, tt := range tests {
+		err := validateDirectives(tt.directives, allowed, noCheck)
+		if tt.ok && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidDirective) {
+			t.Errorf("%s: got %v, want ErrInvalidDirective", tt.name, err)
+		}
+	}
+
+	// The driver check sees the directive name as written and its error is wrapped
+	var seen []string
+	err := validateDirectives([]string{"Header a", "", "redirect b"}, allowed, func(name, line string) error {
+		seen = append(seen, name)
+		if name == "redirect" {
+			return errors.New("no")
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrInvalidDirective) || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("check error = %v", err)
+	}
+	if strings.Join(seen, ",") != "Header,redirect" {
+		t.Errorf("check saw %v", seen)
+	}
+}
+
+func TestDriverValidateDirectives(t *testing.T) {
+	tests := []struct {
+		driver     Driver
+		directives []string
+		ok         bool
+	}{
+		{&ApacheDriver{}, []string{`Header always set X-Frame-Options "SAMEORIGIN"`}, true},
+		{&ApacheDriver{}, []string{"RewriteEngine On", "RewriteRule ^old$ /new [R=301,L]"}, true},
+		{&ApacheDriver{}, []string{"ErrorDocument 404 /404.html"}, true},
+		{&ApacheDriver{}, []string{"<Directory />"}, false},
+		{&ApacheDriver{}, []string{"RewriteRule ^(.*)$ http://internal/$1 [P]"}, false},
+		{&ApacheDriver{}, []string{"RewriteRule ^(.*)$ http://internal/$1 [L,proxy]"}, false},
+		{&ApacheDriver{}, []string{"ProxyPass / http://127.0.0.1:8080/"}, false},
+		{&ApacheDriver{}, []string{"php_admin_value open_basedir none"}, false},
+		{&NginxDriver{}, []string{"add_header X-Frame-Options SAMEORIGIN;"}, true},
+		{&NginxDriver{}, []string{"return 301 https://example.com$request_uri;"}, true},
+		{&NginxDriver{}, []string{"add_header X-A b"}, false},
+		{&NginxDriver{}, []string{"add_header X-A b; root /;"}, false},
+		{&NginxDriver{}, []string{"error_page 404 /404.html { }"}, false},
+		{&NginxDriver{}, []string{"proxy_pass http://127.0.0.1;"}, false},
+		{&NginxDriver{}, []string{"location / { root /; }"}, false},
+		{&CaddyDriver{}, []string{"redir /old /new 301"}, true},
+		{&CaddyDriver{}, []string{"rewrite * /index.php?{query}"}, true},
+		{&CaddyDriver{}, []string{"header X-Host {http.request.host}"}, true},
+		{&CaddyDriver{}, []string{"header {"}, false},
+		{&CaddyDriver{}, []string{"respond } {"}, false},
+		{&CaddyDriver{}, []string{"reverse_proxy localhost:8080"}, false},
+		{&CaddyDriver{}, []string{"import /etc/passwd"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.driver.ValidateDirectives(tt.directives)
+		if tt.ok && err != nil {
+			t.Errorf("%s %q: %v", tt.driver.Name(), tt.directives, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidDirective) {
+			t.Errorf("%s %q: got %v, want ErrInvalidDirective", tt.driver.Name(), tt.directives, err)
+		}
+	}
+}
@@ -5,9 +5,21 @@ type Driver interface {
 	// Name returns the web server name
 	Name() string
 
-	// CreateVhost creates a virtual host configuration for a domain
+	// CreateVhost renders, tests and installs the virtual host of a domain;
+	// a config the web server rejects is rolled back
 	CreateVhost(config VhostConfig) error
 
+	// StageVhost adds the virtual host of a domain to a transaction, to be
+	// applied together with other config changes
+	StageVhost(tx *Transaction, config VhostConfig) error
+
+	// RenderVhost renders the virtual host config from its template
+	RenderVhost(config VhostConfig) (string, error)
+
+	// ValidateDirectives checks per-domain directives against the
+	// directives customers may use
+	ValidateDirectives(directives []string) error
+
 	// DeleteVhost removes the virtual host configuration
 	DeleteVhost(domain string) error
 
@@ -28,6 +40,10 @@ type Driver interface {
 
 	// SupportsHtaccess returns whether this driver supports .htaccess
 	SupportsHtaccess() bool
+
+	// ManagesCertificates returns whether the web server obtains and
+	// renews the TLS certificates of its sites itself (no certbot)
+	ManagesCertificates() bool
 }
 
 // VhostConfig contains all configuration for a virtual host
@@ -38,9 +54,14 @@ type VhostConfig struct {
 	DocumentRoot string
 	HomeDir      string
 	PHPVersion   string // e.g., "8.2"
+	PHPPool      string // PHP-FPM pool serving the site, empty = the account's pool
 	SSLEnabled   bool
 	SSLCertPath  string
 	SSLKeyPath   string
+	RateLimitKB  int      // Response rate limit in KB/s, 0 = unlimited
+	Suspended    bool     // Serve only the suspended page from DocumentRoot
+	ProxyPort    int      // Reverse proxy to a local app (Node.js) instead of PHP
+	Directives   []string // Vetted per-domain directives, see ValidateDirectives
 }
 
 // DriverType represents the type of web server
@@ -49,6 +70,8 @@ type DriverType string
 const (
 	DriverApache DriverType = "apache"
 	DriverNginx  DriverType = "nginx"
+	DriverHybrid DriverType = "nginx-apache" // Nginx frontend, Apache backend
+	DriverCaddy  DriverType = "caddy"
 )
 
 // NewDriver creates a new web server driver based on type
@@ -56,6 +79,10 @@ func NewDriver(driverType DriverType, simulateMode bool, basePath string) Driver
 	switch driverType {
 	case DriverNginx:
 		return NewNginxDriver(simulateMode, basePath)
+	case DriverHybrid:
+		return NewHybridDriver(simulateMode, basePath)
+	case DriverCaddy:
+		return NewCaddyDriver(simulateMode, basePath)
 	case DriverApache:
 		fallthrough
 	default:
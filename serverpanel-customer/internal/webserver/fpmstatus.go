@@ -0,0 +1,175 @@
+package webserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PoolStatus is the status page of a PHP-FPM pool
+type PoolStatus struct {
+	Pool               string `json:"pool"`
+	ProcessManager     string `json:"process_manager"`
+	StartTime          int64  `json:"start_time"`
+	StartSince         int64  `json:"start_since"`
+	AcceptedConn       int64  `json:"accepted_conn"`
+	ListenQueue        int    `json:"listen_queue"`
+	MaxListenQueue     int    `json:"max_listen_queue"`
+	ListenQueueLen     int    `json:"listen_queue_len"`
+	IdleProcesses      int    `json:"idle_processes"`
+	ActiveProcesses    int    `json:"active_processes"`
+	TotalProcesses     int    `json:"total_processes"`
+	MaxActiveProcesses int    `json:"max_active_processes"`
+	MaxChildrenReached int    `json:"max_children_reached"`
+	SlowRequests       int    `json:"slow_requests"`
+}
+
+var poolPMLine = regexp.MustCompile(`(?m)^pm\s*=\s*(\w+)`)
+
+// PoolStatus reads the status page of a pool straight from its socket
+func (m *PHPFPMManager) PoolStatus(pool string) (*PoolStatus, error) {
+	if m.simulateMode {
+		// Nothing listens on simulated sockets; report an idle pool
+		content, err := os.ReadFile(m.poolFile(pool))
+		if err != nil {
+			return nil, fmt.Errorf("pool %s not found", pool)
+		}
+		status := &PoolStatus{Pool: pool, ProcessManager: PMDynamic}
+		if pm := poolPMLine.FindSubmatch(content); pm != nil {
+			status.ProcessManager = string(pm[1])
+		}
+		return status, nil
+	}
+
+	body, err := fcgiGet(m.SocketPath(pool), PoolStatusPath, "json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read status of pool %s: %w", pool, err)
+	}
+
+	var raw struct {
+		Pool               string `json:"pool"`
+		ProcessManager     string `json:"process manager"`
+		StartTime          int64  `json:"start time"`
+		StartSince         int64  `json:"start since"`
+		AcceptedConn       int64  `json:"accepted conn"`
+		ListenQueue        int    `json:"listen queue"`
+		MaxListenQueue     int    `json:"max listen queue"`
+		ListenQueueLen     int    `json:"listen queue len"`
+		IdleProcesses      int    `json:"idle processes"`
+		ActiveProcesses    int    `json:"active processes"`
+		TotalProcesses     int    `json:"total processes"`
+		MaxActiveProcesses int    `json:"max active processes"`
+		MaxChildrenReached int    `json:"max children reached"`
+		SlowRequests       int    `json:"slow requests"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("invalid status of pool %s: %w", pool, err)
+	}
+
+	status := PoolStatus(raw)
+	return &status, nil
+}
+
+// FastCGI record types used by fcgiGet
+const (
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+)
+
+// fcgiGet sends a GET request for a script to a FastCGI socket and returns
+// the response body
+func fcgiGet(socket, script, query string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", socket, 3*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	params := map[string]string{
+		"REQUEST_METHOD":  "GET",
+		"SCRIPT_NAME":     script,
+		"SCRIPT_FILENAME": script,
+		"REQUEST_URI":     script + "?" + query,
+		"QUERY_STRING":    query,
+		"SERVER_PROTOCOL": "HTTP/1.1",
+	}
+	var encoded bytes.Buffer
+	for name, value := range params {
+		fcgiWriteLength(&encoded, len(name))
+		fcgiWriteLength(&encoded, len(value))
+		encoded.WriteString(name)
+		encoded.WriteString(value)
+	}
+
+	var req bytes.Buffer
+	fcgiWriteRecord(&req, fcgiBeginRequest, []byte{0, 1, 0, 0, 0, 0, 0, 0}) // responder, close the connection
+	fcgiWriteRecord(&req, fcgiParams, encoded.Bytes())
+	fcgiWriteRecord(&req, fcgiParams, nil)
+	fcgiWriteRecord(&req, fcgiStdin, nil)
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return nil, err
+		}
+		content := make([]byte, int(binary.BigEndian.Uint16(header[4:6]))+int(header[6]))
+		if _, err := io.ReadFull(conn, content); err != nil {
+			return nil, err
+		}
+		content = content[:binary.BigEndian.Uint16(header[4:6])]
+
+		switch header[1] {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		}
+		if header[1] == fcgiEndRequest {
+			break
+		}
+	}
+
+	// The response starts with CGI headers
+	response := stdout.String()
+	headers, body, found := strings.Cut(response, "\r\n\r\n")
+	if !found {
+		return nil, fmt.Errorf("malformed response: %s", strings.TrimSpace(stderr.String()))
+	}
+	for _, line := range strings.Split(headers, "\r\n") {
+		if status, ok := strings.CutPrefix(line, "Status: "); ok && !strings.HasPrefix(status, "200") {
+			return nil, fmt.Errorf("status %s", status)
+		}
+	}
+	return []byte(body), nil
+}
+
+func fcgiWriteRecord(w *bytes.Buffer, recordType byte, content []byte) {
+	w.Write([]byte{1, recordType, 0, 1}) // version 1, request 1
+	binary.Write(w, binary.BigEndian, uint16(len(content)))
+	w.Write([]byte{0, 0}) // no padding
+	w.Write(content)
+}
+
+func fcgiWriteLength(w *bytes.Buffer, n int) {
+	if n < 128 {
+		w.WriteByte(byte(n))
+		return
+	}
+	binary.Write(w, binary.BigEndian, uint32(n)|1<<31)
+}
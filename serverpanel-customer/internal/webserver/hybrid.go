@@ -0,0 +1,272 @@
+package webserver
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// HybridBackendPort is the local port Apache listens on behind Nginx
+const HybridBackendPort = 8080
+
+// HybridDriver implements the Driver interface for Nginx in front of
+// Apache. Nginx terminates TLS and serves static files, everything else is
+// proxied to Apache on a local port so .htaccess keeps working. Every vhost
+// is a pair of configs with the same name, one per web server.
+type HybridDriver struct {
+	simulateMode bool
+	basePath     string
+	frontend     *NginxDriver
+	backend      *ApacheDriver
+	backendPort  int
+	restarter    *backendRestarter
+}
+
+// NewHybridDriver creates a new Nginx + Apache driver
+func NewHybridDriver(simulateMode bool, basePath string) *HybridDriver {
+	backend := NewApacheDriver(simulateMode, basePath)
+	return &HybridDriver{
+		simulateMode: simulateMode,
+		basePath:     basePath,
+		frontend:     NewNginxDriver(simulateMode, basePath),
+		backend:      backend,
+		backendPort:  HybridBackendPort,
+		restarter:    &backendRestarter{backend: backend},
+	}
+}
+
+func (d *HybridDriver) Name() string {
+	return "Nginx + Apache"
+}
+
+func (d *HybridDriver) SupportsHtaccess() bool {
+	return true // Apache behind Nginx
+}
+
+func (d *HybridDriver) ManagesCertificates() bool {
+	return false // certificates come from certbot
+}
+
+// GetConfigPath returns the frontend config path; the frontend configs
+// are the ones holding the certificates
+func (d *HybridDriver) GetConfigPath() string {
+	return d.frontend.GetConfigPath()
+}
+
+func (d *HybridDriver) CreateVhost(config VhostConfig) error {
+	tx := NewTransaction()
+	if err := d.StageVhost(tx, config); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("📝 Nginx + Apache config created: %s", config.Domain)
+	return nil
+}
+
+// StageVhost adds the frontend and backend configs of a vhost to a
+// transaction, so the pair is applied or rolled back together
+func (d *HybridDriver) StageVhost(tx *Transaction, config VhostConfig) error {
+	frontendConfig, err := d.RenderVhost(config)
+	if err != nil {
+		return err
+	}
+
+	// The suspended page and Node.js apps are served by Nginx alone. The
+	// backend of a suspended site is kept: it is only reachable through
+	// the frontend and is needed again when the account is unsuspended.
+	if config.Suspended || config.ProxyPort > 0 {
+		if config.ProxyPort > 0 && d.hasBackend(config.Domain) {
+			tx.RemoveSite(d.backend, config.Domain)
+		}
+		tx.WriteSite(d.frontend, config.Domain, frontendConfig)
+		return nil
+	}
+
+	backendConfig, err := d.RenderBackend(config)
+	if err != nil {
+		return err
+	}
+	if err := d.ensureBackend(tx); err != nil {
+		return err
+	}
+
+	// Apache is restarted or reloaded first, so Nginx gets the public
+	// ports and never proxies to a missing site
+	tx.WriteSite(d.backend, config.Domain, backendConfig)
+	tx.WriteSite(d.frontend, config.Domain, frontendConfig)
+	return nil
+}
+
+// RenderVhost renders the Nginx frontend config; see RenderBackend for the
+// Apache side
+func (d *HybridDriver) RenderVhost(config VhostConfig) (string, error) {
+	accessLog := AccessLogPath(d.simulateMode, d.basePath, "nginx", config.Domain)
+	if config.Suspended || config.ProxyPort > 0 {
+		return renderTemplate("nginx", config, "", accessLog)
+	}
+
+	data := newTemplateData(config, "", accessLog)
+	data.BackendPort = d.backendPort
+	return executeTemplate("hybrid-"+TemplateVhost, data)
+}
+
+// RenderBackend renders the Apache backend config of a PHP site
+func (d *HybridDriver) RenderBackend(config VhostConfig) (string, error) {
+	data := newTemplateData(config, d.backend.phpSocket(config), "")
+	data.BackendPort = d.backendPort
+	return executeTemplate("hybrid-"+TemplateBackend, data)
+}
+
+// ValidateDirectives checks per-domain directives, which go into the
+// Apache backend next to .htaccess
+func (d *HybridDriver) ValidateDirectives(directives []string) error {
+	return d.backend.ValidateDirectives(directives)
+}
+
+func (d *HybridDriver) DeleteVhost(domain string) error {
+	if err := d.removeBackend(domain); err != nil {
+		log.Printf("Warning: failed to remove Apache backend: %v", err)
+	}
+	return d.frontend.DeleteVhost(domain)
+}
+
+func (d *HybridDriver) EnableSite(domain string) error {
+	if d.hasBackend(domain) {
+		if err := d.backend.EnableSite(domain); err != nil {
+			return err
+		}
+	}
+	return d.frontend.EnableSite(domain)
+}
+
+func (d *HybridDriver) DisableSite(domain string) error {
+	if err := d.frontend.DisableSite(domain); err != nil {
+		return err
+	}
+	if d.hasBackend(domain) {
+		return d.backend.DisableSite(domain)
+	}
+	return nil
+}
+
+func (d *HybridDriver) Reload() error {
+	if err := d.backend.Reload(); err != nil {
+		return err
+	}
+	return d.frontend.Reload()
+}
+
+func (d *HybridDriver) TestConfig() error {
+	if err := d.backend.TestConfig(); err != nil {
+		return err
+	}
+	return d.frontend.TestConfig()
+}
+
+// hasBackend reports whether a site has an Apache backend config
+func (d *HybridDriver) hasBackend(domain string) bool {
+	_, err := os.Stat(filepath.Join(d.backend.GetConfigPath(), domain+".conf"))
+	return err == nil
+}
+
+// removeBackend removes the Apache backend config of a site, if any
+func (d *HybridDriver) removeBackend(domain string) error {
+	if !d.hasBackend(domain) {
+		return nil
+	}
+	return d.backend.DeleteVhost(domain)
+}
+
+// ensureBackend stages moving Apache off the public ports, which belong to
+// Nginx, onto the local backend port and enables mod_remoteip so Apache
+// logs and PHP see the client address instead of the frontend's. Other
+// Listen lines of ports.conf are kept; Apache restarts when the
+// transaction commits.
+func (d *HybridDriver) ensureBackend(tx *Transaction) error {
+	if err := d.backend.enableModule("remoteip"); err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(d.portsFile())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read apache ports: %w", err)
+	}
+	ports := backendPorts(string(current), d.backendPort)
+	if ports == string(current) {
+		return nil
+	}
+	tx.WriteFile(d.restarter, d.portsFile(), ports)
+	return nil
+}
+
+func (d *HybridDriver) portsFile() string {
+	if d.simulateMode {
+		return filepath.Join(d.basePath, "apache", "ports.conf")
+	}
+	return "/etc/apache2/ports.conf"
+}
+
+// backendPorts rewrites an Apache ports.conf for the backend: Listen lines
+// on 80, 443 or the backend port are commented out and Apache listens on
+// the backend port of the loopback address instead. Everything else stays.
+func backendPorts(current string, port int) string {
+	listen := fmt.Sprintf("Listen 127.0.0.1:%d", port)
+
+	var lines []string
+	found := false
+	for _, line := range strings.Split(strings.TrimRight(current, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "Listen") {
+			lines = append(lines, line)
+			continue
+		}
+		if strings.Join(fields[:2], " ") == listen {
+			found = true
+			lines = append(lines, line)
+			continue
+		}
+		addr := fields[1]
+		switch addr[strings.LastIndex(addr, ":")+1:] {
+		case "80", "443", strconv.Itoa(port):
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			lines = append(lines, indent+"# "+strings.TrimSpace(line)+" # ServerPanel: served by the Nginx frontend")
+		default:
+			lines = append(lines, line)
+		}
+	}
+	if !found {
+		lines = append(lines, "", "# Managed by ServerPanel: Apache listens for the Nginx frontend", listen)
+	}
+	return strings.TrimLeft(strings.Join(lines, "\n"), "\n") + "\n"
+}
+
+// backendRestarter is the service of a staged ports.conf change. Listen
+// changes need a restart, a reload keeps the old sockets, so Apache is
+// restarted once the staged configs have passed their tests.
+type backendRestarter struct {
+	backend *ApacheDriver
+}
+
+func (r *backendRestarter) TestConfig() error {
+	return r.backend.TestConfig()
+}
+
+func (r *backendRestarter) Reload() error {
+	if r.backend.simulateMode {
+		log.Printf("🔧 [SIMÜLASYON] systemctl restart apache2")
+		return nil
+	}
+	if output, err := exec.Command("systemctl", "restart", "apache2").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to restart apache: %s - %w", string(output), err)
+	}
+
+	log.Printf("✅ Apache restarted on the backend port")
+	return nil
+}
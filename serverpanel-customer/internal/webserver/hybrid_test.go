@@ -0,0 +1,78 @@
+package webserver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackendPorts(t *testing.T) {
+	debian := "Listen 80\n\n<IfModule ssl_module>\n\tListen 443\n</IfModule>\n"
+	tests := []struct {
+		name    string
+		current string
+		want    string
+	}{
+		{"missing file", "",
+			"# Managed by ServerPanel: Apache listens for the Nginx frontend\nListen 127.0.0.1:8080\n"},
+		{"debian default", debian,
+			"# Listen 80 # ServerPanel: served by the Nginx frontend\n\n<IfModule ssl_module>\n" +
+				"\t# Listen 443 # ServerPanel: served by the Nginx frontend\n</IfModule>\n\n" +
+				"# Managed by ServerPanel: Apache listens for the Nginx frontend\nListen 127.0.0.1:8080\n"},
+		{"other ports are kept", "Listen 127.0.0.1:8080\nListen 8443\nListen 10.0.0.1:9000 http\n",
+			"Listen 127.0.0.1:8080\nListen 8443\nListen 10.0.0.1:9000 http\n"},
+		{"addresses on public ports", "Listen 0.0.0.0:80\nListen [::]:443 https\nlisten 8080\n",
+			"# Listen 0.0.0.0:80 # ServerPanel: served by the Nginx frontend\n" +
+				"# Listen [::]:443 https # ServerPanel: served by the Nginx frontend\n" +
+				"# listen 8080 # ServerPanel: served by the Nginx frontend\n\n" +
+				"# Managed by ServerPanel: Apache listens for the Nginx frontend\nListen 127.0.0.1:8080\n"},
+	}
+	for _, tt := range tests {
+		got := backendPorts(tt.current, 8080)
+		if got != tt.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", tt.name, got, tt.want)
+		}
+		// Rewriting again changes nothing
+		if again := backendPorts(got, 8080); again != got {
+			t.Errorf("%s: second rewrite changed the file:\n%s", tt.name, again)
+		}
+	}
+}
+
+func TestHybridPortsStaged(t *testing.T) {
+	base := t.TempDir()
+	d := NewHybridDriver(true, base)
+	ports := filepath.Join(base, "apache", "ports.conf")
+	if err := os.MkdirAll(filepath.Dir(ports), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ports, []byte("Listen 80\nListen 9000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := NewTransaction()
+	if err := d.ensureBackend(tx); err != nil {
+		t.Fatal(err)
+	}
+	// Staging does not touch the file
+	if got, _ := os.ReadFile(ports); string(got) != "Listen 80\nListen 9000\n" {
+		t.Errorf("ports.conf changed before commit: %q", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(ports)
+	if !strings.Contains(string(got), "Listen 9000\n") || !strings.Contains(string(got), "Listen 127.0.0.1:8080\n") {
+		t.Errorf("ports.conf after commit: %q", got)
+	}
+
+	// Nothing is staged once Apache is on the backend port
+	tx = NewTransaction()
+	if err := d.ensureBackend(tx); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.ops) != 0 {
+		t.Errorf("%d changes staged for a moved Apache", len(tx.ops))
+	}
+}
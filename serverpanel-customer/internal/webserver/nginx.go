@@ -6,7 +6,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 )
 
 // NginxDriver implements the Driver interface for Nginx
@@ -31,6 +30,10 @@ func (d *NginxDriver) SupportsHtaccess() bool {
 	return false // Nginx does NOT support .htaccess
 }
 
+func (d *NginxDriver) ManagesCertificates() bool {
+	return false // certificates come from certbot
+}
+
 func (d *NginxDriver) GetConfigPath() string {
 	if d.simulateMode {
 		return filepath.Join(d.basePath, "nginx", "sites-available")
@@ -39,145 +42,40 @@ func (d *NginxDriver) GetConfigPath() string {
 }
 
 func (d *NginxDriver) CreateVhost(config VhostConfig) error {
-	vhostConfig := d.generateConfig(config)
-
-	configPath := d.GetConfigPath()
-	if err := os.MkdirAll(configPath, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
-	}
-
-	configFile := filepath.Join(configPath, config.Domain+".conf")
-	if err := os.WriteFile(configFile, []byte(vhostConfig), 0644); err != nil {
-		return fmt.Errorf("failed to write vhost config: %w", err)
+	tx := NewTransaction()
+	if err := d.StageVhost(tx, config); err != nil {
+		return err
 	}
-
-	log.Printf("📝 Nginx config created: %s", configFile)
-
-	// Enable site
-	if err := d.EnableSite(config.Domain); err != nil {
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	return d.Reload()
+	log.Printf("📝 Nginx config created: %s", filepath.Join(d.GetConfigPath(), config.Domain+".conf"))
+	return nil
 }
 
-func (d *NginxDriver) generateConfig(config VhostConfig) string {
-	serverNames := config.Domain
-	if len(config.Aliases) > 0 {
-		serverNames += " " + strings.Join(config.Aliases, " ")
-	} else {
-		serverNames += fmt.Sprintf(" www.%s", config.Domain)
+// StageVhost adds the rendered vhost to a transaction
+func (d *NginxDriver) StageVhost(tx *Transaction, config VhostConfig) error {
+	vhostConfig, err := d.RenderVhost(config)
+	if err != nil {
+		return err
 	}
+	tx.WriteSite(d, config.Domain, vhostConfig)
+	return nil
+}
 
+// RenderVhost renders the vhost config from the nginx-<kind> template
+func (d *NginxDriver) RenderVhost(config VhostConfig) (string, error) {
 	phpVersion := config.PHPVersion
 	if phpVersion == "" {
 		phpVersion = "8.2"
 	}
 
 	// PHP-FPM socket path
-	phpFpmSocket := fmt.Sprintf("/run/php/php%s-fpm-%s.sock", phpVersion, config.Username)
-	if d.simulateMode {
-		phpFpmSocket = filepath.Join(d.basePath, "php-fpm", config.Username+".sock")
-	}
-
-	vhost := fmt.Sprintf(`# Virtual Host for %s
-# User: %s
-# Web Server: Nginx
-# .htaccess: NOT SUPPORTED
-server {
-    listen 80;
-    server_name %s;
-    
-    root %s;
-    index index.php index.html index.htm;
-    
-    access_log %s/access.log;
-    error_log %s/error.log;
-    
-    # Main location
-    location / {
-        try_files $uri $uri/ /index.php?$query_string;
-    }
-    
-    # PHP handling
-    location ~ \.php$ {
-        fastcgi_pass unix:%s;
-        fastcgi_index index.php;
-        fastcgi_param SCRIPT_FILENAME $document_root$fastcgi_script_name;
-        include fastcgi_params;
-    }
-    
-    # Deny access to hidden files
-    location ~ /\.ht {
-        deny all;
-    }
-    
-    # Security headers
-    add_header X-Frame-Options "SAMEORIGIN" always;
-    add_header X-Content-Type-Options "nosniff" always;
-    add_header X-XSS-Protection "1; mode=block" always;
-}
-`,
-		config.Domain,
-		config.Username,
-		serverNames,
-		config.DocumentRoot,
-		filepath.Join(config.HomeDir, "logs"),
-		filepath.Join(config.HomeDir, "logs"),
-		phpFpmSocket,
-	)
-
-	// Add SSL configuration if enabled
-	if config.SSLEnabled && config.SSLCertPath != "" && config.SSLKeyPath != "" {
-		vhost += fmt.Sprintf(`
-server {
-    listen 443 ssl http2;
-    server_name %s;
-    
-    root %s;
-    index index.php index.html index.htm;
-    
-    ssl_certificate %s;
-    ssl_certificate_key %s;
-    ssl_protocols TLSv1.2 TLSv1.3;
-    ssl_ciphers ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256;
-    ssl_prefer_server_ciphers off;
-    
-    access_log %s/access.log;
-    error_log %s/error.log;
-    
-    location / {
-        try_files $uri $uri/ /index.php?$query_string;
-    }
-    
-    location ~ \.php$ {
-        fastcgi_pass unix:%s;
-        fastcgi_index index.php;
-        fastcgi_param SCRIPT_FILENAME $document_root$fastcgi_script_name;
-        include fastcgi_params;
-    }
-    
-    location ~ /\.ht {
-        deny all;
-    }
-    
-    add_header X-Frame-Options "SAMEORIGIN" always;
-    add_header X-Content-Type-Options "nosniff" always;
-    add_header X-XSS-Protection "1; mode=block" always;
-    add_header Strict-Transport-Security "max-age=31536000; includeSubDomains" always;
-}
-`,
-			serverNames,
-			config.DocumentRoot,
-			config.SSLCertPath,
-			config.SSLKeyPath,
-			filepath.Join(config.HomeDir, "logs"),
-			filepath.Join(config.HomeDir, "logs"),
-			phpFpmSocket,
-		)
-	}
+	phpFpmSocket := PHPSocketPath(d.simulateMode, d.basePath, phpVersion, config.pool())
 
-	return vhost
+	return renderTemplate("nginx", config, phpFpmSocket,
+		AccessLogPath(d.simulateMode, d.basePath, "nginx", config.Domain))
 }
 
 func (d *NginxDriver) DeleteVhost(domain string) error {
@@ -1,13 +1,24 @@
 package webserver
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// ErrPoolSocketDown is returned when a pool does not accept connections on
+// its socket after a reload
+var ErrPoolSocketDown = errors.New("PHP-FPM pool socket is not up")
+
 // PHPFPMManager manages PHP-FPM pools for users
 type PHPFPMManager struct {
 	simulateMode bool
@@ -20,6 +31,62 @@ type PHPFPMConfig struct {
 	Username   string
 	HomeDir    string
 	PHPVersion string
+	Pool       string // pool and socket name, empty = Username
+
+	// Package limits; empty values fall back to the defaults
+	MemoryLimit      string // e.g. 256M
+	UploadMaxSize    string // upload_max_filesize and post_max_size
+	MaxExecutionTime int    // seconds, also used for max_input_time
+
+	// Package process settings; empty values fall back to the defaults
+	ProcessManager string // static, dynamic or ondemand
+	MaxChildren    int
+	RequestTimeout int // request_terminate_timeout in seconds, 0 = off
+	SlowlogTimeout int // request_slowlog_timeout in seconds, 0 = off
+
+	// Custom php.ini values of the sites in the pool, overriding the
+	// package limits above; the php.ini catalog decides how each is rendered
+	IniValues map[string]string
+}
+
+// Process managers a pool can use
+const (
+	PMStatic   = "static"
+	PMDynamic  = "dynamic"
+	PMOndemand = "ondemand"
+)
+
+// PoolStatusPath is the pm.status_path of every pool; it is only reachable
+// through the pool socket, the vhosts never pass it to PHP-FPM
+const PoolStatusPath = "/fpm-status"
+
+var poolUserLine = regexp.MustCompile(`(?m)^user\s*=\s*(\S+)`)
+
+// ValidProcessManager reports whether pm is a PHP-FPM process manager
+func ValidProcessManager(pm string) bool {
+	return pm == PMStatic || pm == PMDynamic || pm == PMOndemand
+}
+
+func (c PHPFPMConfig) pool() string {
+	if c.Pool != "" {
+		return c.Pool
+	}
+	return c.Username
+}
+
+func (c VhostConfig) pool() string {
+	if c.PHPPool != "" {
+		return c.PHPPool
+	}
+	return c.Username
+}
+
+// PHPSocketPath returns the listen socket of a PHP-FPM pool
+func PHPSocketPath(simulateMode bool, basePath, phpVersion, pool string) string {
+	if simulateMode {
+		return filepath.Join(basePath, "php-fpm", phpVersion, pool+".sock")
+	}
+	return fmt.Sprintf("/run/php/php%s-fpm-%s.sock", phpVersion, pool)
 }
 
 // NewPHPFPMManager creates a new PHP-FPM manager
@@ -36,42 +103,116 @@ func NewPHPFPMManager(simulateMode bool, basePath string, phpVersion string) *PH
 
 func (m *PHPFPMManager) GetPoolPath() string {
 	if m.simulateMode {
-		return filepath.Join(m.basePath, "php-fpm", "pool.d")
+		return filepath.Join(m.basePath, "php-fpm", m.phpVersion, "pool.d")
 	}
 	return fmt.Sprintf("/etc/php/%s/fpm/pool.d", m.phpVersion)
 }
 
 // CreatePool creates a PHP-FPM pool for a user
 func (m *PHPFPMManager) CreatePool(config PHPFPMConfig) error {
+	tx := NewTransaction()
+	m.StagePool(tx, config)
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("📝 PHP-FPM pool created: %s", m.poolFile(config.pool()))
+	return nil
+}
+
+// StagePool adds the pool of a user to a transaction
+func (m *PHPFPMManager) StagePool(tx *Transaction, config PHPFPMConfig) {
 	phpVersion := config.PHPVersion
 	if phpVersion == "" {
 		phpVersion = m.phpVersion
 	}
 
-	poolConfig := m.generatePoolConfig(config, phpVersion)
+	tx.WriteFile(m, m.poolFile(config.pool()), m.generatePoolConfig(config, phpVersion))
+}
+
+// StageRemovePool adds removing a pool to a transaction
+func (m *PHPFPMManager) StageRemovePool(tx *Transaction, pool string) {
+	tx.RemoveFile(m, m.poolFile(pool))
+}
+
+// HasPool reports whether a pool config exists
+func (m *PHPFPMManager) HasPool(pool string) bool {
+	_, err := os.Stat(m.poolFile(pool))
+	return err == nil
+}
 
-	poolPath := m.GetPoolPath()
-	if err := os.MkdirAll(poolPath, 0755); err != nil {
-		return fmt.Errorf("failed to create pool directory: %w", err)
+// UserPools returns the names of the pools running as a user
+func (m *PHPFPMManager) UserPools(username string) []string {
+	files, _ := filepath.Glob(filepath.Join(m.GetPoolPath(), "*.conf"))
+	pools := []string{}
+	for _, f := range files {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		if user := poolUserLine.FindSubmatch(content); user != nil && string(user[1]) == username {
+			pools = append(pools, strings.TrimSuffix(filepath.Base(f), ".conf"))
+		}
 	}
+	return pools
+}
 
-	poolFile := filepath.Join(poolPath, config.Username+".conf")
-	if err := os.WriteFile(poolFile, []byte(poolConfig), 0644); err != nil {
-		return fmt.Errorf("failed to write pool config: %w", err)
+// Installed reports whether PHP-FPM of the manager's version is installed
+func (m *PHPFPMManager) Installed() bool {
+	if m.simulateMode {
+		return true
 	}
+	_, err := os.Stat(fmt.Sprintf("/etc/php/%s/fpm", m.phpVersion))
+	return err == nil
+}
 
-	log.Printf("📝 PHP-FPM pool created: %s", poolFile)
+// WaitForSocket waits until a pool accepts connections on its socket
+func (m *PHPFPMManager) WaitForSocket(pool string, timeout time.Duration) error {
+	socket := m.SocketPath(pool)
+	if m.simulateMode {
+		log.Printf("🔧 [SIMÜLASYON] waiting for %s", socket)
+		return nil
+	}
 
-	return m.Reload()
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.DialTimeout("unix", socket, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s: %v", ErrPoolSocketDown, socket, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// SocketPath returns the listen socket of a pool
+func (m *PHPFPMManager) SocketPath(pool string) string {
+	return PHPSocketPath(m.simulateMode, m.basePath, m.phpVersion, pool)
+}
+
+func (m *PHPFPMManager) poolFile(pool string) string {
+	return filepath.Join(m.GetPoolPath(), pool+".conf")
 }
 
 func (m *PHPFPMManager) generatePoolConfig(config PHPFPMConfig, phpVersion string) string {
-	socketPath := fmt.Sprintf("/run/php/php%s-fpm-%s.sock", phpVersion, config.Username)
-	if m.simulateMode {
-		socketPath = filepath.Join(m.basePath, "php-fpm", config.Username+".sock")
+	if config.MemoryLimit == "" {
+		config.MemoryLimit = "256M"
+	}
+	if config.UploadMaxSize == "" {
+		config.UploadMaxSize = "64M"
 	}
+	if config.MaxExecutionTime <= 0 {
+		config.MaxExecutionTime = 300
+	}
+
+	pool := config.pool()
+	socketPath := PHPSocketPath(m.simulateMode, m.basePath, phpVersion, pool)
 
-	return fmt.Sprintf(`[%s]
+	var b strings.Builder
+	fmt.Fprintf(&b, `[%s]
 ; Pool for user %s
 
 user = %s
@@ -82,45 +223,110 @@ listen.owner = www-data
 listen.group = www-data
 listen.mode = 0660
 
-pm = dynamic
-pm.max_children = 5
-pm.start_servers = 2
-pm.min_spare_servers = 1
-pm.max_spare_servers = 3
-pm.max_requests = 500
-
+%s
 ; Logging
 php_admin_value[error_log] = %s/logs/php-error.log
 php_admin_flag[log_errors] = on
 
 ; Security
 php_admin_value[open_basedir] = %s:/tmp:/usr/share/php
-php_admin_value[disable_functions] = exec,passthru,shell_exec,system,proc_open,popen
+php_admin_value[disable_functions] = %s
 php_admin_value[upload_tmp_dir] = %s/tmp
 php_admin_value[session.save_path] = %s/tmp
-
-; Limits
-php_admin_value[memory_limit] = 256M
-php_admin_value[max_execution_time] = 300
-php_admin_value[max_input_time] = 300
-php_admin_value[post_max_size] = 64M
-php_admin_value[upload_max_filesize] = 64M
 `,
-		config.Username,
+		pool,
 		config.Username,
 		config.Username,
 		config.Username,
 		socketPath,
+		processSettings(config, pool),
 		config.HomeDir,
 		config.HomeDir,
+		disabledFunctions(config.IniValues["disable_functions"]),
 		config.HomeDir,
 		config.HomeDir,
 	)
+
+	// Package limits, overridden by the custom values of the sites
+	limits := [][2]string{
+		{"memory_limit", config.MemoryLimit},
+		{"max_execution_time", strconv.Itoa(config.MaxExecutionTime)},
+		{"max_input_time", strconv.Itoa(config.MaxExecutionTime)},
+		{"post_max_size", config.UploadMaxSize},
+		{"upload_max_filesize", config.UploadMaxSize},
+	}
+	b.WriteString("\n; Limits\n")
+	for _, l := range limits {
+		value := l[1]
+		if custom, ok := config.IniValues[l[0]]; ok {
+			value = custom
+		}
+		b.WriteString(iniLine(l[0], value))
+	}
+
+	var custom []string
+	for key := range config.IniValues {
+		isLimit := key == "disable_functions" // merged into the security section
+		for _, l := range limits {
+			isLimit = isLimit || l[0] == key
+		}
+		if !isLimit {
+			custom = append(custom, key)
+		}
+	}
+	if len(custom) > 0 {
+		sort.Strings(custom)
+		b.WriteString("\n; Custom Settings\n")
+		for _, key := range custom {
+			b.WriteString(iniLine(key, config.IniValues[key]))
+		}
+	}
+
+	return b.String()
+}
+
+// processSettings renders the process manager section of a pool
+func processSettings(config PHPFPMConfig, pool string) string {
+	pm := config.ProcessManager
+	if !ValidProcessManager(pm) {
+		pm = PMDynamic
+	}
+	maxChildren := config.MaxChildren
+	if maxChildren <= 0 {
+		maxChildren = 5
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "pm = %s\npm.max_children = %d\n", pm, maxChildren)
+	switch pm {
+	case PMDynamic:
+		// 5 children start 2 and keep 1-3 spare, as before
+		maxSpare := maxChildren * 3 / 5
+		if maxSpare < 1 {
+			maxSpare = 1
+		}
+		minSpare := 1
+		fmt.Fprintf(&b, "pm.start_servers = %d\npm.min_spare_servers = %d\npm.max_spare_servers = %d\n",
+			minSpare+(maxSpare-minSpare)/2, minSpare, maxSpare)
+	case PMOndemand:
+		b.WriteString("pm.process_idle_timeout = 10s\n")
+	}
+	b.WriteString("pm.max_requests = 500\n")
+	fmt.Fprintf(&b, "pm.status_path = %s\n", PoolStatusPath)
+
+	if config.RequestTimeout > 0 {
+		fmt.Fprintf(&b, "request_terminate_timeout = %ds\n", config.RequestTimeout)
+	}
+	if config.SlowlogTimeout > 0 {
+		fmt.Fprintf(&b, "request_slowlog_timeout = %ds\nslowlog = %s\n",
+			config.SlowlogTimeout, filepath.Join(config.HomeDir, "logs", pool+"-slow.log"))
+	}
+	return b.String()
 }
 
 // DeletePool removes a PHP-FPM pool
-func (m *PHPFPMManager) DeletePool(username string) error {
-	poolFile := filepath.Join(m.GetPoolPath(), username+".conf")
+func (m *PHPFPMManager) DeletePool(pool string) error {
+	poolFile := m.poolFile(pool)
 	if err := os.Remove(poolFile); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove pool config: %w", err)
 	}
@@ -129,6 +335,19 @@ func (m *PHPFPMManager) DeletePool(username string) error {
 	return m.Reload()
 }
 
+// TestConfig tests the PHP-FPM configuration, pools included
+func (m *PHPFPMManager) TestConfig() error {
+	if m.simulateMode {
+		return nil
+	}
+
+	cmd := exec.Command(fmt.Sprintf("php-fpm%s", m.phpVersion), "-t")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("php-fpm config test failed: %s - %w", string(output), err)
+	}
+	return nil
+}
+
 // Reload reloads or restarts PHP-FPM depending on its state
 func (m *PHPFPMManager) Reload() error {
 	if m.simulateMode {
@@ -0,0 +1,255 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidPHPIni = errors.New("invalid php.ini directive")
+
+// Value types of php.ini directives
+const (
+	IniBool     = "bool"
+	IniInt      = "int"
+	IniSize     = "size"     // 128M, 1G, 512K
+	IniString   = "string"   // checked against the directive's pattern
+	IniTimezone = "timezone" // e.g. Europe/Istanbul
+	IniList     = "list"     // comma separated function names
+)
+
+// PHPIniDirective is a php.ini directive customers may set per domain
+type PHPIniDirective struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Min         int64  `json:"min,omitempty"` // int value or size in bytes
+	Max         int64  `json:"max,omitempty"`
+	Default     string `json:"default"`
+	Admin       bool   `json:"admin"` // php_admin_value: scripts cannot change it with ini_set
+	Description string `json:"description"`
+
+	pattern *regexp.Regexp
+}
+
+// iniFunctionName is an entry of a list directive such as disable_functions
+var iniFunctionName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// phpIniCatalog lists every directive the php.ini editor supports
+var phpIniCatalog = []PHPIniDirective{
+	{Name: "memory_limit", Type: IniSize, Min: 16 << 20, Max: 2 << 30, Default: "256M", Admin: true,
+		Description: "Maximum memory a script may allocate"},
+	{Name: "max_execution_time", Type: IniInt, Min: 1, Max: 3600, Default: "300", Admin: true,
+		Description: "Maximum run time of a script in seconds"},
+	{Name: "max_input_time", Type: IniInt, Min: -1, Max: 3600, Default: "300", Admin: true,
+		Description: "Maximum time to parse request input in seconds, -1 = max_execution_time"},
+	{Name: "post_max_size", Type: IniSize, Min: 1 << 20, Max: 2 << 30, Default: "64M", Admin: true,
+		Description: "Maximum size of POST data"},
+	{Name: "upload_max_filesize", Type: IniSize, Min: 1 << 20, Max: 2 << 30, Default: "64M", Admin: true,
+		Description: "Maximum size of an uploaded file"},
+	{Name: "max_file_uploads", Type: IniInt, Min: 1, Max: 1000, Default: "20", Admin: true,
+		Description: "Maximum number of files uploaded in one request"},
+	{Name: "max_input_vars", Type: IniInt, Min: 100, Max: 100000, Default: "1000",
+		Description: "Maximum number of input variables of a request"},
+	{Name: "display_errors", Type: IniBool, Default: "off",
+		Description: "Show errors in the page output"},
+	{Name: "error_reporting", Type: IniString, Default: "E_ALL & ~E_DEPRECATED & ~E_STRICT",
+		Description: "Error levels to report",
+		pattern:     regexp.MustCompile(`^(-?\d+|[A-Z_&~|^() ]+)$`)},
+	{Name: "date.timezone", Type: IniTimezone, Default: "UTC",
+		Description: "Default timezone of date functions"},
+	{Name: "default_charset", Type: IniString, Default: "UTF-8",
+		Description: "Default character set of responses",
+		pattern:     regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)},
+	{Name: "short_open_tag", Type: IniBool, Default: "off",
+		Description: "Allow the <? short open tag"},
+	{Name: "output_buffering", Type: IniInt, Min: 0, Max: 1 << 20, Default: "4096",
+		Description: "Output buffer size in bytes, 0 = off"},
+	{Name: "zlib.output_compression", Type: IniBool, Default: "off",
+		Description: "Compress the page output with zlib"},
+	{Name: "allow_url_fopen", Type: IniBool, Default: "on", Admin: true,
+		Description: "Allow file functions to open URLs"},
+	{Name: "session.gc_maxlifetime", Type: IniInt, Min: 60, Max: 604800, Default: "1440",
+		Description: "Seconds after which session data is garbage collected"},
+	{Name: "session.cookie_lifetime", Type: IniInt, Min: 0, Max: 31536000, Default: "0",
+		Description: "Lifetime of the session cookie in seconds, 0 = until the browser closes"},
+	{Name: "session.cookie_secure", Type: IniBool, Default: "off",
+		Description: "Send the session cookie over HTTPS only"},
+	{Name: "session.cookie_httponly", Type: IniBool, Default: "off",
+		Description: "Hide the session cookie from JavaScript"},
+	{Name: "opcache.enable", Type: IniBool, Default: "on", Admin: true,
+		Description: "Cache compiled scripts in shared memory"},
+	{Name: "opcache.validate_timestamps", Type: IniBool, Default: "on",
+		Description: "Check scripts for changes"},
+	{Name: "opcache.revalidate_freq", Type: IniInt, Min: 0, Max: 86400, Default: "2",
+		Description: "Seconds between checks for changed scripts"},
+	{Name: "disable_functions", Type: IniList, Default: "", Admin: true,
+		Description: "Functions to disable, in addition to the ones the server always disables"},
+}
+
+// baseDisabledFunctions are disabled in every pool; disable_functions of a
+// domain can only add to them
+var baseDisabledFunctions = []string{"exec", "passthru", "shell_exec", "system", "proc_open", "popen"}
+
+// PHPIniCatalog returns the directives of the php.ini editor
+func PHPIniCatalog() []PHPIniDirective {
+	return append([]PHPIniDirective(nil), phpIniCatalog...)
+}
+
+// LookupPHPIni returns a directive of the catalog
+func LookupPHPIni(name string) (PHPIniDirective, bool) {
+	for _, d := range phpIniCatalog {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return PHPIniDirective{}, false
+}
+
+// ValidatePHPIni checks a value against the type and range of a directive
+// and returns it in the form written to the pool
+func ValidatePHPIni(name, value string) (string, error) {
+	d, ok := LookupPHPIni(name)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not supported", ErrInvalidPHPIni, name)
+	}
+	value = strings.TrimSpace(value)
+	if strings.ContainsAny(value, "\"\r\n;") {
+		return "", fmt.Errorf("%w: %s contains a forbidden character", ErrInvalidPHPIni, name)
+	}
+
+	switch d.Type {
+	case IniBool:
+		switch strings.ToLower(value) {
+		case "1", "on", "true", "yes":
+			return "on", nil
+		case "0", "off", "false", "no", "":
+			return "off", nil
+		}
+		return "", fmt.Errorf("%w: %s must be on or off", ErrInvalidPHPIni, name)
+
+	case IniInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s must be a number", ErrInvalidPHPIni, name)
+		}
+		if n < d.Min || n > d.Max {
+			return "", fmt.Errorf("%w: %s must be between %d and %d", ErrInvalidPHPIni, name, d.Min, d.Max)
+		}
+		return strconv.FormatInt(n, 10), nil
+
+	case IniSize:
+		value = strings.ToUpper(value)
+		n, ok := ParseIniSize(value)
+		if !ok {
+			return "", fmt.Errorf("%w: %s must be a size such as 128M", ErrInvalidPHPIni, name)
+		}
+		if n < d.Min || n > d.Max {
+			return "", fmt.Errorf("%w: %s must be between %s and %s", ErrInvalidPHPIni, name, formatIniSize(d.Min), formatIniSize(d.Max))
+		}
+		return value, nil
+
+	case IniTimezone:
+		if value == "" || strings.EqualFold(value, "local") {
+			return "", fmt.Errorf("%w: %s must be a timezone such as Europe/Istanbul", ErrInvalidPHPIni, name)
+		}
+		if _, err := time.LoadLocation(value); err != nil {
+			return "", fmt.Errorf("%w: unknown timezone %s", ErrInvalidPHPIni, value)
+		}
+		return value, nil
+
+	case IniList:
+		var names []string
+		for _, f := range strings.Split(value, ",") {
+			f = strings.ToLower(strings.TrimSpace(f))
+			if f == "" {
+				continue
+			}
+			if !iniFunctionName.MatchString(f) {
+				return "", fmt.Errorf("%w: %s is not a function name", ErrInvalidPHPIni, f)
+			}
+			names = append(names, f)
+		}
+		return strings.Join(names, ","), nil
+	}
+
+	if d.pattern != nil && !d.pattern.MatchString(value) {
+		return "", fmt.Errorf("%w: invalid value for %s", ErrInvalidPHPIni, name)
+	}
+	return value, nil
+}
+
+// ParseIniSize turns a php.ini size (128M, 1G, 512K, 1024) into bytes. It
+// is the one size parser of the panel: the value is a number with at most
+// one K, M or G suffix and must fit in an int64.
+func ParseIniSize(value string) (int64, bool) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'G':
+			multiplier = 1 << 30
+		case 'M':
+			multiplier = 1 << 20
+		case 'K':
+			multiplier = 1 << 10
+		}
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, false
+	}
+	return n * multiplier, true
+}
+
+func formatIniSize(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%dG", n>>30)
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dM", n>>20)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// iniLine renders a directive into a pool: php_admin_* for directives
+// scripts may not change, *_flag for booleans
+func iniLine(name, value string) string {
+	kind := "value"
+	admin := true // unknown directives come from the package limits
+	if d, ok := LookupPHPIni(name); ok {
+		admin = d.Admin
+		if d.Type == IniBool {
+			kind = "flag"
+		}
+	}
+	if admin {
+		return fmt.Sprintf("php_admin_%s[%s] = %s\n", kind, name, value)
+	}
+	return fmt.Sprintf("php_%s[%s] = %s\n", kind, name, value)
+}
+
+// disabledFunctions joins the base disabled functions and the ones a
+// domain adds
+func disabledFunctions(custom string) string {
+	functions := append([]string(nil), baseDisabledFunctions...)
+	for _, f := range strings.Split(custom, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		seen := false
+		for _, existing := range functions {
+			seen = seen || existing == f
+		}
+		if !seen {
+			functions = append(functions, f)
+		}
+	}
+	return strings.Join(functions, ",")
+}
@@ -0,0 +1,103 @@
+package webserver
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseIniSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"0", 0, true},
+		{"1024", 1024, true},
+		{"512K", 512 << 10, true},
+		{"128M", 128 << 20, true},
+		{"128m", 128 << 20, true},
+		{" 2G ", 2 << 30, true},
+		{"8589934591G", 8589934591 << 30, true},
+		{"8589934592G", 0, false},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"9223372036854775808", 0, false},
+		{"99999999999999999999M", 0, false},
+		{"", 0, false},
+		{"M", 0, false},
+		{"-1", 0, false},
+		{"-1M", 0, false},
+		{"128MM", 0, false},
+		{"128KM", 0, false},
+		{"128MB", 0, false},
+		{"128T", 0, false},
+		{"1.5G", 0, false},
+		{"12 8M", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseIniSize(tt.value)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseIniSize(%q) = %d, %v; want %d, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestValidatePHPIni(t *testing.T) {
+	tests := []struct {
+		name, value string
+		want        string // "" = rejected
+	}{
+		{"display_errors", "On", "on"},
+		{"display_errors", "yes", "on"},
+		{"display_errors", "0", "off"},
+		{"display_errors", "", "off"},
+		{"display_errors", "maybe", ""},
+		{"max_execution_time", " 60 ", "60"},
+		{"max_execution_time", "0", ""},
+		{"max_execution_time", "3601", ""},
+		{"max_execution_time", "1e3", ""},
+		{"max_input_time", "-1", "-1"},
+		{"memory_limit", "512m", "512M"},
+		{"memory_limit", "2G", "2G"},
+		{"memory_limit", "2049M", ""},
+		{"memory_limit", "15M", ""},
+		{"memory_limit", "128MM", ""},
+		{"memory_limit", "-1", ""},
+		{"memory_limit", "9223372036854775807G", ""},
+		{"post_max_size", "1048576", "1048576"},
+		{"date.timezone", "Europe/Istanbul", "Europe/Istanbul"},
+		{"date.timezone", "UTC", "UTC"},
+		{"date.timezone", "Local", ""},
+		{"date.timezone", "", ""},
+		{"date.timezone", "Mars/Olympus", ""},
+		{"date.timezone", "../../etc/passwd", ""},
+		{"disable_functions", " Exec, mail ,,curl_exec ", "exec,mail,curl_exec"},
+		{"disable_functions", "mail,sys-tem", ""},
+		{"error_reporting", "E_ALL & ~E_NOTICE", "E_ALL & ~E_NOTICE"},
+		{"error_reporting", "32767", "32767"},
+		{"error_reporting", "E_ALL $x", ""},
+		{"default_charset", "ISO-8859-9", "ISO-8859-9"},
+		{"default_charset", "UTF 8", ""},
+		{"memory_limit", "128M\nopen_basedir = /", ""},
+		{"default_charset", "UTF-8;", ""},
+		{"error_reporting", `"E_ALL"`, ""},
+		{"open_basedir", "/", ""},
+	}
+	for _, tt := range tests {
+		got, err := ValidatePHPIni(tt.name, tt.value)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidPHPIni) {
+				t.Errorf("ValidatePHPIni(%s, %q) = %q, %v; want ErrInvalidPHPIni", tt.name, tt.value, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ValidatePHPIni(%s, %q) = %q, %v; want %q", tt.name, tt.value, got, err, tt.want)
+		}
+	}
+
+	// disable_functions may be emptied
+	if got, err := ValidatePHPIni("disable_functions", " , "); err != nil || got != "" {
+		t.Errorf("empty disable_functions = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,217 @@
+package webserver
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Built-in vhost templates, one per driver and kind (<driver>-<kind>.tmpl)
+//
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+// Template kinds
+const (
+	TemplateVhost     = "vhost"     // PHP site
+	TemplateProxy     = "proxy"     // Node.js reverse proxy
+	TemplateSuspended = "suspended" // Suspended account page
+	TemplateBackend   = "backend"   // Apache behind the hybrid Nginx frontend
+)
+
+var ErrTemplateNotFound = errors.New("template not found")
+
+// Admin overrides of the built-in templates, set at startup
+var templateDir string
+
+// SetTemplateDir sets the directory searched for vhost template overrides
+// (<dir>/<driver>-<kind>.tmpl) before the built-in templates
+func SetTemplateDir(dir string) {
+	templateDir = dir
+}
+
+// TemplateDir returns the vhost template override directory
+func TemplateDir() string {
+	return templateDir
+}
+
+// templateData is what vhost templates are executed with
+type templateData struct {
+	VhostConfig
+	ServerAliases []string
+	PHPSocket     string
+	LogDir        string
+	AccessLog     string
+	SSL           bool
+	BackendPort   int // Local Apache port of the hybrid driver
+}
+
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// TemplateInfo describes a vhost template
+type TemplateInfo struct {
+	Name       string `json:"name"`
+	Content    string `json:"content"`
+	Customized bool   `json:"customized"`
+}
+
+// TemplateNames lists the names of the built-in templates
+func TemplateNames() []string {
+	entries, _ := builtinTemplates.ReadDir("templates")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".tmpl"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultTemplate returns the built-in text of a template
+func DefaultTemplate(name string) (string, error) {
+	content, err := builtinTemplates.ReadFile("templates/" + name + ".tmpl")
+	if err != nil {
+		return "", ErrTemplateNotFound
+	}
+	return string(content), nil
+}
+
+// GetTemplate returns the text of a template, the admin override if
+// there is one
+func GetTemplate(name string) (*TemplateInfo, error) {
+	content, err := DefaultTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	info := &TemplateInfo{Name: name, Content: content}
+	if templateDir != "" {
+		if custom, err := os.ReadFile(filepath.Join(templateDir, name+".tmpl")); err == nil {
+			info.Content = string(custom)
+			info.Customized = true
+		}
+	}
+	return info, nil
+}
+
+// SaveTemplate validates and stores an admin override of a template
+func SaveTemplate(name, content string) error {
+	if _, err := DefaultTemplate(name); err != nil {
+		return err
+	}
+	if err := ValidateTemplate(name, content); err != nil {
+		return err
+	}
+	if templateDir == "" {
+		return errors.New("template directory is not set")
+	}
+	if err := os.MkdirAll(templateDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(templateDir, name+".tmpl"), []byte(content), 0644)
+}
+
+// ResetTemplate removes the admin override of a template
+func ResetTemplate(name string) error {
+	if _, err := DefaultTemplate(name); err != nil {
+		return err
+	}
+	if templateDir == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(templateDir, name+".tmpl"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ValidateTemplate parses a template and executes it with sample data
+func ValidateTemplate(name, content string) error {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(content)
+	if err != nil {
+		return err
+	}
+	sample := newTemplateData(VhostConfig{
+		Domain:       "example.com",
+		Username:     "example",
+		DocumentRoot: "/home/example/public_html",
+		HomeDir:      "/home/example",
+		PHPVersion:   "8.2",
+		SSLEnabled:   true,
+		SSLCertPath:  "/etc/ssl/example.crt",
+		SSLKeyPath:   "/etc/ssl/example.key",
+		RateLimitKB:  256,
+		ProxyPort:    3000,
+		Directives:   []string{"# custom"},
+	}, "/run/php/php8.2-fpm-example.sock", "/var/log/apache2/example.com-access.log")
+	sample.BackendPort = HybridBackendPort
+	return tmpl.Execute(&bytes.Buffer{}, sample)
+}
+
+func newTemplateData(config VhostConfig, phpSocket, accessLog string) templateData {
+	aliases := config.Aliases
+	if len(aliases) == 0 {
+		aliases = []string{"www." + config.Domain}
+	}
+	return templateData{
+		VhostConfig:   config,
+		ServerAliases: aliases,
+		PHPSocket:     phpSocket,
+		LogDir:        filepath.Join(config.HomeDir, "logs"),
+		AccessLog:     accessLog,
+		SSL:           config.SSLEnabled && config.SSLCertPath != "" && config.SSLKeyPath != "",
+	}
+}
+
+// templateKind picks the template a vhost is rendered with
+func templateKind(config VhostConfig) string {
+	switch {
+	case config.Suspended:
+		return TemplateSuspended
+	case config.ProxyPort > 0:
+		return TemplateProxy
+	default:
+		return TemplateVhost
+	}
+}
+
+// renderTemplate renders the <driver>-<kind> template for a vhost
+func renderTemplate(driver string, config VhostConfig, phpSocket, accessLog string) (string, error) {
+	return executeTemplate(driver+"-"+templateKind(config), newTemplateData(config, phpSocket, accessLog))
+}
+
+// AccessLogPath returns the access log of a vhost. It lives in the web
+// server's own log directory (apache2, nginx or caddy), where the account
+// can not truncate what the bandwidth accounting reads; the account gets
+// a copy in ~/logs.
+func AccessLogPath(simulateMode bool, basePath, server, domain string) string {
+	if simulateMode {
+		return filepath.Join(basePath, "log", server, domain+"-access.log")
+	}
+	return filepath.Join("/var/log", server, domain+"-access.log")
+}
+
+// executeTemplate renders a template, the admin override if there is one
+func executeTemplate(name string, data templateData) (string, error) {
+	info, err := GetTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(info.Content)
+	if err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	return buf.String(), nil
+}
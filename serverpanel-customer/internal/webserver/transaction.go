@@ -0,0 +1,171 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ErrConfigTest is returned when a web server or PHP-FPM rejects the
+// staged configuration; the error text carries the test output
+var ErrConfigTest = errors.New("config test failed")
+
+// service is a web server or PHP-FPM whose config a transaction touches
+type service interface {
+	TestConfig() error
+	Reload() error
+}
+
+// Transaction stages vhost, PHP-FPM pool and SSL vhost changes and applies
+// them as one batch: every file is put in place, the config tests of all
+// touched services run, and only when they all pass are the services
+// reloaded. When a test fails every change is undone before anything is
+// reloaded, so the running servers never see a broken config.
+type Transaction struct {
+	ops      []txOp
+	services []service
+	undo     []func()
+}
+
+// txOp is a staged change; apply returns how to undo it
+type txOp func() (func(), error)
+
+// NewTransaction starts an empty transaction
+func NewTransaction() *Transaction {
+	return &Transaction{}
+}
+
+// touch records a service whose config is part of the transaction
+func (tx *Transaction) touch(s service) {
+	for _, existing := range tx.services {
+		if existing == s {
+			return
+		}
+	}
+	tx.services = append(tx.services, s)
+}
+
+// WriteFile stages writing a config file of a service
+func (tx *Transaction) WriteFile(s service, path, content string) {
+	tx.touch(s)
+	tx.ops = append(tx.ops, func() (func(), error) {
+		return replaceFile(path, []byte(content))
+	})
+}
+
+// RemoveFile stages removing a config file of a service
+func (tx *Transaction) RemoveFile(s service, path string) {
+	tx.touch(s)
+	tx.ops = append(tx.ops, func() (func(), error) {
+		return replaceFile(path, nil)
+	})
+}
+
+// WriteSite stages writing and enabling the <name>.conf vhost of a driver
+func (tx *Transaction) WriteSite(d Driver, name, content string) {
+	tx.WriteFile(d, filepath.Join(d.GetConfigPath(), name+".conf"), content)
+	tx.toggleSite(d, name, true)
+}
+
+// DisableSite stages disabling a vhost of a driver
+func (tx *Transaction) DisableSite(d Driver, name string) {
+	tx.touch(d)
+	tx.toggleSite(d, name, false)
+}
+
+// RemoveSite stages disabling and removing a vhost of a driver
+func (tx *Transaction) RemoveSite(d Driver, name string) {
+	tx.DisableSite(d, name)
+	tx.RemoveFile(d, filepath.Join(d.GetConfigPath(), name+".conf"))
+}
+
+func (tx *Transaction) toggleSite(d Driver, name string, enable bool) {
+	tx.ops = append(tx.ops, func() (func(), error) {
+		wasEnabled := siteEnabled(d, name)
+		if wasEnabled == enable {
+			return nil, nil
+		}
+		if enable {
+			return func() { d.DisableSite(name) }, d.EnableSite(name)
+		}
+		return func() { d.EnableSite(name) }, d.DisableSite(name)
+	})
+}
+
+// Commit applies the staged changes, tests the config of every touched
+// service and reloads them. When a change cannot be applied or a config
+// test fails everything is rolled back and the error is returned, wrapping
+// ErrConfigTest for a failed test.
+func (tx *Transaction) Commit() error {
+	for _, op := range tx.ops {
+		undo, err := op()
+		if undo != nil {
+			tx.undo = append(tx.undo, undo)
+		}
+		if err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+
+	for _, s := range tx.services {
+		if err := s.TestConfig(); err != nil {
+			tx.rollback()
+			return fmt.Errorf("%w: %v", ErrConfigTest, err)
+		}
+	}
+
+	for _, s := range tx.services {
+		if err := s.Reload(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback undoes the applied changes in reverse order
+func (tx *Transaction) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = nil
+	log.Printf("↩️ Config changes rolled back")
+}
+
+// replaceFile writes (or with nil content removes) a file and returns how
+// to put the previous one back
+func replaceFile(path string, content []byte) (func(), error) {
+	previous, readErr := os.ReadFile(path)
+	restore := func() {
+		if readErr == nil {
+			os.WriteFile(path, previous, 0644)
+		} else {
+			os.Remove(path)
+		}
+	}
+
+	if content == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		return restore, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return restore, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return restore, nil
+}
+
+// siteEnabled reports whether a vhost is linked into sites-enabled next to
+// the driver's sites-available
+func siteEnabled(d Driver, name string) bool {
+	enabled := filepath.Join(filepath.Dir(d.GetConfigPath()), "sites-enabled", name+".conf")
+	_, err := os.Lstat(enabled)
+	return err == nil
+}
@@ -49,6 +49,7 @@ fi
 echo -e "${YELLOW}[1/7] Servisler durduruluyor...${NC}"
 systemctl stop serverpanel 2>/dev/null || true
 systemctl stop serverpanel-queue 2>/dev/null || true
+systemctl stop serverpanel-dns 2>/dev/null || true
 echo -e "${GREEN}✓ Servisler durduruldu${NC}"
 
 # ═══════════════════════════════════════════════════════════════════════════════
@@ -210,6 +211,20 @@ if [[ -d "cmd/queue-processor" ]]; then
     echo -e "${GREEN}  ✓ Queue processor derlendi${NC}" || true
 fi
 
+# Backup aracı (verify / decrypt / rotate-key)
+if [[ -d "cmd/spbackup" ]]; then
+    CGO_ENABLED=1 /usr/local/go/bin/go build -o bin/spbackup ./cmd/spbackup 2>/dev/null && \
+    chmod +x bin/spbackup && \
+    echo -e "${GREEN}  ✓ spbackup derlendi${NC}" || true
+fi
+
+# DNS sunucusu (DNS_SERVER=native)
+if [[ -d "cmd/spdns" ]]; then
+    CGO_ENABLED=1 /usr/local/go/bin/go build -o bin/spdns ./cmd/spdns 2>/dev/null && \
+    chmod +x bin/spdns && \
+    echo -e "${GREEN}  ✓ spdns derlendi${NC}" || true
+fi
+
 # ═══════════════════════════════════════════════════════════════════════════════
 # 6. FRONTEND GÜNCELLE
 # ═══════════════════════════════════════════════════════════════════════════════
@@ -258,6 +273,37 @@ EOF
     systemctl start serverpanel-queue 2>/dev/null || true
 fi
 
+# DNS sunucusu servisi (yalnızca native modda etkinleştirilir, BIND ile 53. portu paylaşamaz)
+if [[ -f "bin/spdns" ]]; then
+    # Servis dosyası yoksa oluştur
+    if [[ ! -f "/etc/systemd/system/serverpanel-dns.service" ]]; then
+        SERVER_IP=$(curl -s ifconfig.me 2>/dev/null || hostname -I | awk '{print $1}')
+        cat > /etc/systemd/system/serverpanel-dns.service << EOF
+[Unit]
+Description=ServerPanel Native DNS Server
+After=network.target serverpanel.service
+Conflicts=bind9.service named.service
+
+[Service]
+Type=simple
+ExecStart=/opt/serverpanel/bin/spdns
+Restart=always
+RestartSec=10
+User=root
+WorkingDirectory=/opt/serverpanel
+Environment="ENVIRONMENT=production"
+Environment="SERVER_IP=${SERVER_IP}"
+
+[Install]
+WantedBy=multi-user.target
+EOF
+        systemctl daemon-reload
+    fi
+    if systemctl is-enabled --quiet serverpanel-dns 2>/dev/null; then
+        systemctl start serverpanel-dns 2>/dev/null || true
+    fi
+fi
+
 # Postfix yeniden başlat (policy daemon için)
 systemctl restart postfix 2>/dev/null || true
 
@@ -289,6 +335,10 @@ if systemctl is-active --quiet serverpanel-queue 2>/dev/null; then
     echo -e "${GREEN}✓ Queue Processor: aktif${NC}"
 fi
 
+if systemctl is-active --quiet serverpanel-dns 2>/dev/null; then
+    echo -e "${GREEN}✓ DNS Sunucusu (spdns): aktif${NC}"
+fi
+
 echo ""
 echo -e "${GREEN}════════════════════════════════════════════════════════════════════${NC}"
 echo -e "${GREEN}              Güncelleme Tamamlandı!${NC}"
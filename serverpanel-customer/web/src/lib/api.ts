@@ -137,7 +137,7 @@ export const accountsAPI = {
     package_id: number;
   }) => api.post('/accounts', data),
   delete: (id: number) => api.delete(`/accounts/${id}`),
-  suspend: (id: number) => api.post(`/accounts/${id}/suspend`),
+  suspend: (id: number, reason?: string) => api.post(`/accounts/${id}/suspend`, { reason }),
   unsuspend: (id: number) => api.post(`/accounts/${id}/unsuspend`),
 };
 
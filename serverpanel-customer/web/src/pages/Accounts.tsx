@@ -31,6 +31,8 @@ interface Account {
   disk_used: number;
   disk_quota: number;
   active: boolean;
+  suspend_reason?: string;
+  suspended_at?: string;
   created_at: string;
 }
 
@@ -223,8 +225,10 @@ export default function Accounts() {
   };
 
   const handleSuspend = async (id: number) => {
+    const reason = window.prompt('Askıya alma sebebi:');
+    if (reason === null) return;
     try {
-      await accountsAPI.suspend(id);
+      await accountsAPI.suspend(id, reason);
       fetchAccounts();
     } catch (error) {
       console.error('Failed to suspend account:', error);
@@ -409,10 +413,15 @@ export default function Accounts() {
                               Aktif
                             </span>
                           ) : (
-                            <span className="inline-flex items-center gap-1 px-2 py-1 rounded-full text-xs font-medium bg-orange-100 dark:bg-orange-500/20 text-orange-700 dark:text-orange-400">
-                              <UserX className="w-3 h-3" />
-                              Askıda
-                            </span>
+                            <div>
+                              <span className="inline-flex items-center gap-1 px-2 py-1 rounded-full text-xs font-medium bg-orange-100 dark:bg-orange-500/20 text-orange-700 dark:text-orange-400">
+                                <UserX className="w-3 h-3" />
+                                Askıda
+                              </span>
+                              {account.suspend_reason && (
+                                <p className="text-xs text-muted-foreground mt-1">{account.suspend_reason}</p>
+                              )}
+                            </div>
                           )}
                         </td>
                         <td className="py-3 px-4 text-sm text-muted-foreground">
@@ -47,7 +47,10 @@ interface ZoneInfo {
   username: string;
 }
 
-const RECORD_TYPES = ['A', 'AAAA', 'CNAME', 'MX', 'TXT', 'NS', 'SRV', 'CAA'];
+const RECORD_TYPES = ['A', 'AAAA', 'CNAME', 'MX', 'TXT', 'NS', 'SRV', 'CAA', 'PTR', 'NAPTR', 'TLSA', 'SSHFP', 'HTTPS', 'SVCB', 'DS'];
+
+// Types with the priority in its own field
+const PRIORITY_TYPES = ['MX', 'SRV', 'HTTPS', 'SVCB'];
 
 const TTL_OPTIONS = [
   { value: 300, label: '5 dakika' },
@@ -475,7 +478,7 @@ export default function DNSZoneEditorPage() {
                                   {record.ttl}s
                                 </td>
                                 <td className="px-4 py-3 text-sm text-muted-foreground">
-                                  {record.type === 'MX' || record.type === 'SRV' ? record.priority : '-'}
+                                  {PRIORITY_TYPES.includes(record.type) ? record.priority : '-'}
                                 </td>
                                 <td className="px-4 py-3 text-right">
                                   <div className="flex items-center justify-end gap-1">
@@ -558,7 +561,7 @@ export default function DNSZoneEditorPage() {
                 <label className="block text-sm font-medium mb-1">Kayıt Tipi</label>
                 <select
                   value={formData.type}
-                  onChange={(e) => setFormData({ ...formData, type: e.target.value, priority: e.target.value === 'MX' ? 10 : 0 })}
+                  onChange={(e) => setFormData({ ...formData, type: e.target.value, priority: e.target.value === 'MX' ? 10 : e.target.value === 'HTTPS' || e.target.value === 'SVCB' ? 1 : 0 })}
                   className="w-full px-3 py-2 border border-border rounded-lg bg-background focus:outline-none focus:ring-2 focus:ring-primary"
                 >
                   {RECORD_TYPES.map((type) => (
@@ -600,14 +603,21 @@ export default function DNSZoneEditorPage() {
                     formData.type === 'AAAA' ? '2001:db8::1' :
                     formData.type === 'CNAME' ? 'example.com.' :
                     formData.type === 'MX' ? 'mail.example.com.' :
-                    formData.type === 'TXT' ? 'v=spf1 include:_spf.google.com ~all' : ''
+                    formData.type === 'TXT' ? 'v=spf1 include:_spf.google.com ~all' :
+                    formData.type === 'SRV' ? '5 5060 sip.example.com.' :
+                    formData.type === 'CAA' ? '0 issue "letsencrypt.org"' :
+                    formData.type === 'NAPTR' ? '100 10 "S" "SIP+D2U" "" _sip._udp.example.com.' :
+                    formData.type === 'TLSA' ? '3 1 1 <sha256 hex>' :
+                    formData.type === 'SSHFP' ? '4 2 <sha256 hex>' :
+                    formData.type === 'HTTPS' || formData.type === 'SVCB' ? '. alpn=h2,h3' :
+                    formData.type === 'DS' ? '12345 13 2 <digest hex>' : ''
                   }
                   className="w-full px-3 py-2 border border-border rounded-lg bg-background focus:outline-none focus:ring-2 focus:ring-primary"
                 />
               </div>
 
-              {/* Priority (for MX/SRV) */}
-              {(formData.type === 'MX' || formData.type === 'SRV') && (
+              {/* Priority (for MX/SRV/HTTPS/SVCB) */}
+              {PRIORITY_TYPES.includes(formData.type) && (
                 <div>
                   <label className="block text-sm font-medium mb-1">Öncelik</label>
                   <input
@@ -709,8 +719,8 @@ export default function DNSZoneEditorPage() {
                 />
               </div>
 
-              {/* Priority (for MX/SRV) */}
-              {(formData.type === 'MX' || formData.type === 'SRV') && (
+              {/* Priority (for MX/SRV/HTTPS/SVCB) */}
+              {PRIORITY_TYPES.includes(formData.type) && (
                 <div>
                   <label className="block text-sm font-medium mb-1">Öncelik</label>
                   <input